@@ -14,8 +14,8 @@ a 1 is appended to the returned result; a 0 otherwise.
 package imagehash
 
 import (
+	"context"
 	"image"
-	"github.com/disintegration/imaging"
 )
 
 // Ahash calculates the average hash of an image. The image is first grayscaled,
@@ -23,6 +23,28 @@ import (
 // of the pixels is computed, and if a pixel is above the average, a 1 is appended
 // to the byte array; a 0 otherwise.
 func Ahash(img image.Image, hashLen int) ([]byte, error) {
+	return arrayBytes(averageHash(context.Background(), img, hashLen))
+}
+
+// AhashContext is the same as Ahash, but returns ctx.Err() as soon as the
+// context is done.
+func AhashContext(ctx context.Context, img image.Image, hashLen int) ([]byte, error) {
+	return arrayBytes(averageHash(ctx, img, hashLen))
+}
+
+// AhashVector is the same as Ahash, but returns a BitVector.
+func AhashVector(img image.Image, hashLen int) (*BitVector, error) {
+	return AhashVectorContext(context.Background(), img, hashLen)
+}
+
+// AhashVectorContext is the same as AhashVector, but returns ctx.Err() as
+// soon as the context is done.
+func AhashVectorContext(ctx context.Context, img image.Image, hashLen int) (*BitVector, error) {
+	return arrayVector(averageHash(ctx, img, hashLen))
+}
+
+// averageHash computes the average hash of an image into a BitArray.
+func averageHash(ctx context.Context, img image.Image, hashLen int) (*BitArray, error) {
 	var sum uint32                        // Sum of the pixels
 	numbits := hashLen * hashLen          // Perform the hashLen^2 operation once
 	bitArray, err := NewBitArray(numbits) // Resultant byte array init
@@ -35,8 +57,14 @@ func Ahash(img image.Image, hashLen int) ([]byte, error) {
 	var pixelArray []uint32
 
 	// Grayscale and resize
-	res := imaging.Grayscale(img)
-	res = imaging.Resize(res, hashLen, hashLen, imaging.Lanczos)
+	res, err := grayscaleContext(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+	res, err = resizeContext(ctx, res, hashLen, hashLen)
+	if err != nil {
+		return nil, err
+	}
 
 	// Iterate over every pixel to generate the sum.
 	// Additionally, store every pixel into an array for faster re-computation
@@ -51,14 +79,18 @@ func Ahash(img image.Image, hashLen int) ([]byte, error) {
 	// Compute the average
 	avg := sum / uint32(numbits)
 
+	// Record the pixels and the bits if tracing
+	grid := traceGrid(ctx, "average", AverageThreshold, func() [][]float64 { return grayMatrix(res) })
+
 	// For every pixel, check if it's below or above the average
-	for _, pix := range pixelArray {
+	for i, pix := range pixelArray {
+		bit := 0 // If not above, append 0
 		if pix > avg {
-			bitArray.AppendBit(1) // If above, append 1
-		} else {
-			bitArray.AppendBit(0) // else append 0
+			bit = 1 // If above, append 1
 		}
+		bitArray.AppendBit(bit)
+		grid.add(i/hashLen, i%hashLen, float64(pix>>8), float64(avg)/257, bit)
 	}
 
-	return bitArray.GetArray(), nil
+	return bitArray, nil
 }
@@ -26,7 +26,7 @@ func TestWhiteAhash(t *testing.T) {
 	if bytes.Compare(exp, hash) != 0 {
 		t.Errorf("white ahash test [%x] failed: [%x]", exp, hash)
 	} else if err != nil {
-		t.Errorf("white ahash test failed with error:", err)
+		t.Errorf("white ahash test failed with error: %v", err)
 	}
 }
 
@@ -50,7 +50,7 @@ func TestLenaAhash(t *testing.T) {
 	if bytes.Compare(exp, hash) != 0 {
 		t.Errorf("lena_512 ahash test [%x] failed: [%x]", exp, hash)
 	} else if err != nil {
-		t.Errorf("lena_512 ahash test failed with error:", err)
+		t.Errorf("lena_512 ahash test failed with error: %v", err)
 	}
 }
 
@@ -64,8 +64,8 @@ func TestSimilarLenaAhash(t *testing.T) {
 	if bytes.Compare(hashlena512, hashlena256) != 0 {
 		t.Errorf("similar lena ahash test [%x] failed: [%x]", hashlena512, hashlena256)
 	} else if err1 != nil {
-		t.Errorf("similar lena ahash test failed with error:", err1)
+		t.Errorf("similar lena ahash test failed with error: %v", err1)
 	} else if err2 != nil {
-		t.Errorf("similar lena ahash test failed with error:", err2)
+		t.Errorf("similar lena ahash test failed with error: %v", err2)
 	}
 }
@@ -0,0 +1,177 @@
+/*
+
+Decodes every frame of animated GIFs and APNGs, and hashes them with any
+Hasher. OpenImg only returns the first frame of an animation, so two
+animations sharing their first frame get the same hash.
+
+Frames are returned as they are displayed: each one is composited over the
+previous ones, following the disposal and blending of the file, so a frame
+which only updates part of the canvas still hashes as the full picture.
+Images which aren't animated are returned as a single frame, with no delay.
+
+Usage:
+  frames,err := imagehash.OpenFrames("animation.gif")
+  hasher,err := imagehash.NewHasher("dhash:8")
+  hashes,err := imagehash.HashFrames(frames, hasher)
+
+*/
+
+package imagehash
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// maxCanvasPixels is the largest width times height of the canvas of an
+// animation, and maxFramePixels the largest total of the pixels of its
+// frames, since each one is decoded as a copy of the canvas.
+const (
+	maxCanvasPixels = 1 << 26
+	maxFramePixels  = 1 << 27
+)
+
+// Frame is a frame of an animation, as displayed.
+type Frame struct {
+	Image image.Image
+	Delay time.Duration // Time the frame is displayed for
+}
+
+// FrameHash is the hash of a frame, along with its delay.
+type FrameHash struct {
+	Hash  Hash
+	Delay time.Duration
+}
+
+// OpenFrames opens an image file and decodes all of its frames.
+func OpenFrames(fp string) ([]Frame, error) {
+	file, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return DecodeFrames(file)
+}
+
+// DecodeFrames decodes all the frames of an animated GIF or APNG, or the
+// single frame of any other image 'imaging' decodes.
+func DecodeFrames(r io.Reader) ([]Frame, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(data, []byte("GIF8")) {
+		return decodeGIFFrames(data)
+	}
+	if bytes.HasPrefix(data, []byte(pngSignature)) {
+		frames, err := decodeAPNGFrames(data)
+		if err != errNotAnimated {
+			return frames, err
+		}
+	}
+
+	img, err := imaging.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return []Frame{{Image: img}}, nil
+}
+
+// decodeGIFFrames decodes and composites the frames of a GIF.
+func decodeGIFFrames(data []byte) ([]Frame, error) {
+	cfg, err := gif.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCanvas("gif", int64(cfg.Width), int64(cfg.Height), 1); err != nil {
+		return nil, err
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCanvas("gif", int64(cfg.Width), int64(cfg.Height), len(g.Image)); err != nil {
+		return nil, err
+	}
+
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	canvas := image.NewRGBA(bounds)
+	frames := make([]Frame, len(g.Image))
+	for i, src := range g.Image {
+		disposal := byte(0)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = copyRGBA(canvas)
+		}
+
+		draw.Draw(canvas, src.Bounds(), src, src.Bounds().Min, draw.Over)
+		frames[i] = Frame{Image: copyRGBA(canvas), Delay: time.Duration(g.Delay[i]) * 10 * time.Millisecond}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, src.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	if len(frames) == 0 {
+		return nil, errors.New("gif without frames")
+	}
+	return frames, nil
+}
+
+// checkCanvas returns an error if the canvas of an animation is empty or
+// too large, or if its n frames have too many pixels in total.
+func checkCanvas(format string, width, height int64, n int) error {
+	size := strconv.FormatInt(width, 10) + "x" + strconv.FormatInt(height, 10)
+	if width <= 0 || height <= 0 || width*height > maxCanvasPixels {
+		return errors.New(format + ": invalid " + size + " canvas")
+	}
+	if width*height*int64(n) > maxFramePixels {
+		return errors.New(format + ": " + strconv.Itoa(n) + " frames of a " + size + " canvas are too large")
+	}
+	return nil
+}
+
+// copyRGBA returns a copy of an image.
+func copyRGBA(img *image.RGBA) *image.RGBA {
+	c := image.NewRGBA(img.Rect)
+	copy(c.Pix, img.Pix)
+	return c
+}
+
+// HashFrames returns the hashes of frames computed by a hasher, along with
+// their delays.
+func HashFrames(frames []Frame, hasher Hasher) ([]FrameHash, error) {
+	return HashFramesContext(context.Background(), frames, hasher)
+}
+
+// HashFramesContext is the same as HashFrames, but returns ctx.Err() as
+// soon as the context is done.
+func HashFramesContext(ctx context.Context, frames []Frame, hasher Hasher) ([]FrameHash, error) {
+	hashes := make([]FrameHash, len(frames))
+	for i, frame := range frames {
+		h, err := HashContext(ctx, hasher, frame.Image)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = FrameHash{Hash: h, Delay: frame.Delay}
+	}
+	return hashes, nil
+}
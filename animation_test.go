@@ -0,0 +1,207 @@
+/*
+
+Testing suite for decoding and hashing the frames of animations.
+
+1. Test that the frames of a GIF are composited following their disposal
+2. Test that an image which isn't animated is a single frame
+3. Test that the frames are hashed by the hasher, with their delays
+4. Test that hashing frames stops on a cancelled context
+5. Test that two animations sharing a first frame have different frames
+6. Test that GIFs with huge canvases, or too many frames, are rejected
+
+*/
+
+package imagehash
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// fill returns an image of a rectangle filled with a color.
+func fill(r image.Rectangle, c color.Color) *image.RGBA {
+	img := image.NewRGBA(r)
+	draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
+
+// encodeGIF encodes frames as an animated GIF, with delays in hundredths of
+// a second.
+func encodeGIF(t *testing.T, frames []image.Image, delays []int, disposals []byte) []byte {
+	g := &gif.GIF{Delay: delays, Disposal: disposals}
+	for _, frame := range frames {
+		p := image.NewPaletted(frame.Bounds(), palette.Plan9)
+		draw.FloydSteinberg.Draw(p, frame.Bounds(), frame, frame.Bounds().Min)
+		g.Image = append(g.Image, p)
+	}
+	g.Config.Width, g.Config.Height = frames[0].Bounds().Dx(), frames[0].Bounds().Dy()
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// panFrames returns frames panning over an image, cropped to 'size'.
+func panFrames(src image.Image, n, step, size int) []image.Image {
+	var frames []image.Image
+	for i := 0; i < n; i++ {
+		frames = append(frames, imaging.Crop(src, image.Rect(i*step, i*step, i*step+size, i*step+size)))
+	}
+	return frames
+}
+
+// Test the composition, disposal and delays of the frames of a GIF
+func TestDecodeGIFFrames(t *testing.T) {
+	red, blue, green := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}, color.RGBA{0, 255, 0, 255}
+	frames := []image.Image{
+		fill(image.Rect(0, 0, 32, 32), red),
+		fill(image.Rect(8, 8, 16, 16), blue),
+		fill(image.Rect(16, 16, 24, 24), green),
+		fill(image.Rect(0, 0, 4, 4), blue),
+	}
+	data := encodeGIF(t, frames, []int{10, 20, 30, 40},
+		[]byte{gif.DisposalNone, gif.DisposalNone, gif.DisposalPrevious, gif.DisposalNone})
+
+	decoded, err := DecodeFrames(bytes.NewReader(data))
+	if err != nil || len(decoded) != 4 {
+		t.Fatalf("gif frames test [4] failed: [%v %d]", err, len(decoded))
+	}
+
+	for i, exp := range []time.Duration{100, 200, 300, 400} {
+		if decoded[i].Delay != exp*time.Millisecond {
+			t.Errorf("gif delay test [%v] failed: [%v]", exp*time.Millisecond, decoded[i].Delay)
+		}
+	}
+
+	// The blue square stays, while the green one is disposed of
+	pixels := []struct {
+		frame, x, y int
+		exp         color.Color
+	}{
+		{0, 10, 10, red}, {1, 10, 10, blue}, {1, 20, 20, red}, {2, 10, 10, blue},
+		{2, 20, 20, green}, {3, 20, 20, red}, {3, 10, 10, blue}, {3, 2, 2, blue},
+	}
+	for _, p := range pixels {
+		r, g, b, _ := decoded[p.frame].Image.At(p.x, p.y).RGBA()
+		er, eg, eb, _ := p.exp.RGBA()
+		if r>>8 != er>>8 || g>>8 != eg>>8 || b>>8 != eb>>8 {
+			t.Errorf("gif frame %d pixel (%d,%d) test [%v] failed: [%v]", p.frame, p.x, p.y,
+				p.exp, decoded[p.frame].Image.At(p.x, p.y))
+		}
+	}
+}
+
+// Test that a still image is returned as a single frame
+func TestDecodeStillFrames(t *testing.T) {
+	frames, err := OpenFrames("./testdata/lena_256.png")
+	if err != nil || len(frames) != 1 || frames[0].Delay != 0 {
+		t.Fatalf("still image test [1] failed: [%v %d]", err, len(frames))
+	}
+
+	src, _ := OpenImg("./testdata/lena_256.png")
+	exp, _ := Dhash(src, 8)
+	res, _ := Dhash(frames[0].Image, 8)
+	if !bytes.Equal(exp, res) {
+		t.Errorf("still image hash test [%x] failed: [%x]", exp, res)
+	}
+
+	if _, err := OpenFrames("./testdata/missing.gif"); err == nil {
+		t.Errorf("missing file test didn't fail")
+	}
+}
+
+// Test that every frame is hashed by the hasher
+func TestHashFrames(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_512.png")
+	var frames []Frame
+	for i, img := range panFrames(src, 3, 64, 256) {
+		frames = append(frames, Frame{Image: img, Delay: time.Duration(i+1) * time.Second})
+	}
+	hasher, _ := NewHasher("dhash:8")
+
+	hashes, err := HashFrames(frames, hasher)
+	if err != nil || len(hashes) != 3 {
+		t.Fatalf("hash frames test [3] failed: [%v %d]", err, len(hashes))
+	}
+	for i, h := range hashes {
+		exp, _ := hasher.Hash(frames[i].Image)
+		if !reflect.DeepEqual(h.Hash, exp) || h.Delay != frames[i].Delay {
+			t.Errorf("hash frame %d test [%x %v] failed: [%x %v]", i, exp.Value, frames[i].Delay, h.Hash.Value, h.Delay)
+		}
+	}
+}
+
+// Test that hashing frames stops on a cancelled context
+func TestHashFramesCancelled(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_256.png")
+	hasher, _ := NewHasher("ahash:8")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := HashFramesContext(ctx, []Frame{{Image: src}}, hasher); err != context.Canceled {
+		t.Errorf("cancelled hash frames test [%v] failed: [%v]", context.Canceled, err)
+	}
+}
+
+// Test that the frames after a shared first frame tell animations apart
+func TestSharedFirstFrame(t *testing.T) {
+	lena, _ := OpenImg("./testdata/lena_512.png")
+	random, _ := OpenImg("./testdata/rand_512.png")
+	frames1 := panFrames(lena, 3, 64, 256)
+	frames2 := append([]image.Image{frames1[0]}, panFrames(random, 2, 64, 256)...)
+	delays := []int{10, 10, 10}
+
+	first1, _ := gif.Decode(bytes.NewReader(encodeGIF(t, frames1, delays, nil)))
+	first2, _ := gif.Decode(bytes.NewReader(encodeGIF(t, frames2, delays, nil)))
+	hash1, _ := Dhash(first1, 8)
+	hash2, _ := Dhash(first2, 8)
+	if !bytes.Equal(hash1, hash2) {
+		t.Fatalf("shared first frame test [%x] failed: [%x]", hash1, hash2)
+	}
+
+	decoded1, _ := DecodeFrames(bytes.NewReader(encodeGIF(t, frames1, delays, nil)))
+	decoded2, _ := DecodeFrames(bytes.NewReader(encodeGIF(t, frames2, delays, nil)))
+	last1, _ := Dhash(decoded1[2].Image, 8)
+	last2, _ := Dhash(decoded2[2].Image, 8)
+	if Similarity(last1, last2) > 0.7 {
+		t.Errorf("different frames test [<0.7] failed: [%f]", Similarity(last1, last2))
+	}
+}
+
+// Test that a GIF too large to decode fails instead of allocating its frames
+func TestDecodeGIFHugeCanvas(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		frames        int
+	}{
+		{"huge canvas", 20000, 20000, 1},
+		{"too many frames", 4096, 4096, 9},
+	}
+	for _, test := range tests {
+		g := &gif.GIF{Config: image.Config{Width: test.width, Height: test.height}}
+		for i := 0; i < test.frames; i++ {
+			g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 1, 1), palette.Plan9))
+			g.Delay = append(g.Delay, 0)
+		}
+		var buf bytes.Buffer
+		if err := gif.EncodeAll(&buf, g); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := DecodeFrames(bytes.NewReader(buf.Bytes())); err == nil {
+			t.Errorf("%s test didn't fail", test.name)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+/*
+
+Decodes the frames of animated PNGs (APNG), which image/png doesn't: it
+only decodes their default image.
+
+An APNG is a PNG with an acTL chunk, where every frame is described by an
+fcTL chunk (its size, offset, delay, disposal and blending), followed by
+its data in IDAT chunks for the first frame, or fdAT chunks for the
+others. Every frame is decoded by rebuilding a standalone PNG from the
+header and palette of the file and the data of the frame, which is then
+composited over the canvas.
+
+*/
+
+package imagehash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/png"
+	"strconv"
+	"time"
+)
+
+// pngSignature starts every PNG file.
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// The dispose_op and blend_op of an fcTL chunk.
+const (
+	apngDisposeNone       = 0
+	apngDisposeBackground = 1
+	apngDisposePrevious   = 2
+	apngBlendSource       = 0
+	apngBlendOver         = 1
+)
+
+// errNotAnimated is returned by decodeAPNGFrames for a PNG without an acTL
+// chunk.
+var errNotAnimated = errors.New("not an animated png")
+
+// pngChunk is a chunk of a PNG file.
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+// apngFrame is a frame of an APNG, as described by its fcTL chunk.
+type apngFrame struct {
+	rect    image.Rectangle // Position of the frame on the canvas
+	delay   time.Duration
+	dispose byte
+	blend   byte
+	data    [][]byte // Content of its IDAT or fdAT chunks, without sequence numbers
+	hidden  bool     // The default image, when it isn't part of the animation
+}
+
+// decodeAPNGFrames decodes and composites the frames of an APNG.
+func decodeAPNGFrames(data []byte) ([]Frame, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var ihdr []byte
+	var header []pngChunk // Chunks every frame needs, such as PLTE and tRNS
+	var frames []*apngFrame
+	var current *apngFrame
+	animated, seenIDAT := false, false
+	for _, c := range chunks {
+		switch c.typ {
+		case "IHDR":
+			ihdr = c.data
+		case "acTL":
+			animated = true
+		case "fcTL":
+			if current, err = parseFcTL(c.data); err != nil {
+				return nil, err
+			}
+			frames = append(frames, current)
+		case "IDAT":
+			if current == nil && !seenIDAT {
+				// The default image isn't part of the animation
+				current = &apngFrame{hidden: true}
+				frames = append(frames, current)
+			}
+			seenIDAT = true
+			current.data = append(current.data, c.data)
+		case "fdAT":
+			if current == nil || len(c.data) < 4 {
+				return nil, errors.New("apng: fdAT chunk without a frame")
+			}
+			current.data = append(current.data, c.data[4:])
+		case "IEND":
+		default:
+			if !seenIDAT {
+				header = append(header, c)
+			}
+		}
+	}
+	if !animated {
+		return nil, errNotAnimated
+	}
+	if len(ihdr) != 13 {
+		return nil, errors.New("apng: invalid IHDR chunk")
+	}
+
+	visible := 0
+	for _, f := range frames {
+		if !f.hidden {
+			visible++
+		}
+	}
+	width, height := int64(binary.BigEndian.Uint32(ihdr[0:4])), int64(binary.BigEndian.Uint32(ihdr[4:8]))
+	if err := checkCanvas("apng", width, height, visible); err != nil {
+		return nil, err
+	}
+	bounds := image.Rect(0, 0, int(width), int(height))
+	canvas := image.NewRGBA(bounds)
+	var result []Frame
+	for _, f := range frames {
+		if f.hidden {
+			continue
+		}
+		if !f.rect.In(bounds) {
+			return nil, errors.New("apng: frame " + f.rect.String() + " outside of the canvas " + bounds.String())
+		}
+		img, err := decodeAPNGFrame(ihdr, header, f)
+		if err != nil {
+			return nil, err
+		}
+
+		var previous *image.RGBA
+		if f.dispose == apngDisposePrevious {
+			previous = copyRGBA(canvas)
+		}
+		op := draw.Over
+		if f.blend == apngBlendSource {
+			op = draw.Src
+		}
+		draw.Draw(canvas, f.rect, img, img.Bounds().Min, op)
+		result = append(result, Frame{Image: copyRGBA(canvas), Delay: f.delay})
+
+		switch f.dispose {
+		case apngDisposeBackground:
+			draw.Draw(canvas, f.rect, image.Transparent, image.Point{}, draw.Src)
+		case apngDisposePrevious:
+			canvas = previous
+		}
+	}
+	if len(result) == 0 {
+		return nil, errors.New("apng without frames")
+	}
+	return result, nil
+}
+
+// readPNGChunks splits a PNG file into its chunks.
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	data = data[len(pngSignature):]
+	var chunks []pngChunk
+	for len(data) > 0 {
+		if len(data) < 12 {
+			return nil, errors.New("apng: truncated chunk")
+		}
+		n := binary.BigEndian.Uint32(data[0:4])
+		if uint64(n) > uint64(len(data)-12) {
+			return nil, errors.New("apng: truncated chunk")
+		}
+		chunks = append(chunks, pngChunk{typ: string(data[4:8]), data: data[8 : 8+n]})
+		data = data[12+n:]
+	}
+	return chunks, nil
+}
+
+// parseFcTL parses an fcTL chunk.
+func parseFcTL(data []byte) (*apngFrame, error) {
+	if len(data) != 26 {
+		return nil, errors.New("apng: invalid fcTL chunk of " + strconv.Itoa(len(data)) + " bytes")
+	}
+	be := binary.BigEndian
+	width, height := int(be.Uint32(data[4:8])), int(be.Uint32(data[8:12]))
+	x, y := int(be.Uint32(data[12:16])), int(be.Uint32(data[16:20]))
+	num, den := be.Uint16(data[20:22]), be.Uint16(data[22:24])
+	if den == 0 {
+		den = 100
+	}
+	if data[24] > apngDisposePrevious || data[25] > apngBlendOver {
+		return nil, errors.New("apng: invalid dispose_op or blend_op")
+	}
+	return &apngFrame{
+		rect:    image.Rect(x, y, x+width, y+height),
+		delay:   time.Duration(num) * time.Second / time.Duration(den),
+		dispose: data[24],
+		blend:   data[25],
+	}, nil
+}
+
+// decodeAPNGFrame decodes the image of a frame, from a PNG with the size of
+// the frame and its data.
+func decodeAPNGFrame(ihdr []byte, header []pngChunk, f *apngFrame) (image.Image, error) {
+	frameIHDR := append([]byte(nil), ihdr...)
+	binary.BigEndian.PutUint32(frameIHDR[0:4], uint32(f.rect.Dx()))
+	binary.BigEndian.PutUint32(frameIHDR[4:8], uint32(f.rect.Dy()))
+
+	var buf bytes.Buffer
+	buf.WriteString(pngSignature)
+	writePNGChunk(&buf, "IHDR", frameIHDR)
+	for _, c := range header {
+		writePNGChunk(&buf, c.typ, c.data)
+	}
+	for _, d := range f.data {
+		writePNGChunk(&buf, "IDAT", d)
+	}
+	writePNGChunk(&buf, "IEND", nil)
+
+	return png.Decode(&buf)
+}
+
+// writePNGChunk writes a chunk, with its length and CRC.
+func writePNGChunk(buf *bytes.Buffer, typ string, data []byte) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(data)))
+	buf.Write(n[:])
+
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	buf.WriteString(typ)
+	buf.Write(data)
+	binary.BigEndian.PutUint32(n[:], crc.Sum32())
+	buf.Write(n[:])
+}
@@ -0,0 +1,263 @@
+/*
+
+Testing suite for decoding animated PNGs.
+
+1. Test the offsets, delays, blending and disposal of the frames
+2. Test that a default image which isn't part of the animation is skipped
+3. Test that a PNG without an acTL chunk is a single frame
+4. Test that invalid frames are rejected
+5. Test that headers with huge or empty canvases, or too many frames, are rejected
+
+*/
+
+package imagehash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+	"time"
+)
+
+// apngTestFrame is a frame to encode in an APNG, placed at the bounds of
+// its image.
+type apngTestFrame struct {
+	img            *image.NRGBA
+	delayNum       uint16
+	delayDen       uint16
+	dispose, blend byte
+}
+
+// encodeAPNG encodes frames as an APNG of the size of the first frame. With
+// 'hidden', the default image isn't part of the animation.
+func encodeAPNG(t *testing.T, frames []apngTestFrame, hidden bool) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(pngSignature)
+
+	seq := uint32(0)
+	be := binary.BigEndian
+	for i, f := range frames {
+		var enc bytes.Buffer
+		if err := png.Encode(&enc, f.img); err != nil {
+			t.Fatal(err)
+		}
+		chunks, err := readPNGChunks(enc.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if i == 0 {
+			writePNGChunk(&buf, "IHDR", chunks[0].data)
+			actl := make([]byte, 8)
+			be.PutUint32(actl[0:4], uint32(len(frames)))
+			writePNGChunk(&buf, "acTL", actl)
+			if hidden {
+				for _, c := range chunks[1:] {
+					if c.typ == "IDAT" {
+						writePNGChunk(&buf, "IDAT", c.data)
+					}
+				}
+			}
+		}
+
+		r := f.img.Bounds()
+		fctl := make([]byte, 26)
+		be.PutUint32(fctl[0:4], seq)
+		be.PutUint32(fctl[4:8], uint32(r.Dx()))
+		be.PutUint32(fctl[8:12], uint32(r.Dy()))
+		be.PutUint32(fctl[12:16], uint32(r.Min.X))
+		be.PutUint32(fctl[16:20], uint32(r.Min.Y))
+		be.PutUint16(fctl[20:22], f.delayNum)
+		be.PutUint16(fctl[22:24], f.delayDen)
+		fctl[24], fctl[25] = f.dispose, f.blend
+		writePNGChunk(&buf, "fcTL", fctl)
+		seq++
+
+		for _, c := range chunks[1:] {
+			if c.typ != "IDAT" {
+				continue
+			}
+			if i == 0 && !hidden {
+				writePNGChunk(&buf, "IDAT", c.data)
+				continue
+			}
+			fdat := make([]byte, 4, 4+len(c.data))
+			be.PutUint32(fdat, seq)
+			writePNGChunk(&buf, "fdAT", append(fdat, c.data...))
+			seq++
+		}
+	}
+	writePNGChunk(&buf, "IEND", nil)
+	return buf.Bytes()
+}
+
+// fillNRGBA returns an image of a rectangle filled with a color.
+func fillNRGBA(r image.Rectangle, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// The colors of the test frames, which all get a transparent corner so that
+// png.Encode encodes every one of them as RGBA.
+var (
+	apngRed   = color.NRGBA{255, 0, 0, 255}
+	apngBlue  = color.NRGBA{0, 0, 255, 255}
+	apngGreen = color.NRGBA{0, 255, 0, 255}
+	apngClear = color.NRGBA{0, 0, 0, 0}
+)
+
+// withClearCorner makes the top left pixel of an image transparent.
+func withClearCorner(img *image.NRGBA) *image.NRGBA {
+	img.SetNRGBA(img.Rect.Min.X, img.Rect.Min.Y, apngClear)
+	return img
+}
+
+// checkPixels checks the colors of pixels of frames.
+func checkPixels(t *testing.T, name string, frames []Frame, pixels []struct {
+	frame, x, y int
+	exp         color.NRGBA
+}) {
+	for _, p := range pixels {
+		got := color.NRGBAModel.Convert(frames[p.frame].Image.At(p.x, p.y)).(color.NRGBA)
+		if got != p.exp {
+			t.Errorf("%s frame %d pixel (%d,%d) test [%v] failed: [%v]", name, p.frame, p.x, p.y, p.exp, got)
+		}
+	}
+}
+
+// Test the composition of the frames of an APNG
+func TestDecodeAPNGFrames(t *testing.T) {
+	data := encodeAPNG(t, []apngTestFrame{
+		{withClearCorner(fillNRGBA(image.Rect(0, 0, 32, 32), apngRed)), 1, 10, apngDisposeNone, apngBlendSource},
+		{withClearCorner(fillNRGBA(image.Rect(8, 8, 16, 16), apngBlue)), 1, 0, apngDisposeBackground, apngBlendOver},
+		{withClearCorner(fillNRGBA(image.Rect(16, 16, 24, 24), apngGreen)), 3, 1, apngDisposePrevious, apngBlendSource},
+		{withClearCorner(fillNRGBA(image.Rect(0, 0, 4, 4), apngBlue)), 50, 1000, apngDisposeNone, apngBlendOver},
+	}, false)
+
+	frames, err := DecodeFrames(bytes.NewReader(data))
+	if err != nil || len(frames) != 4 {
+		t.Fatalf("apng frames test [4] failed: [%v %d]", err, len(frames))
+	}
+
+	delays := []time.Duration{100 * time.Millisecond, 10 * time.Millisecond, 3 * time.Second, 50 * time.Millisecond}
+	for i, exp := range delays {
+		if frames[i].Delay != exp {
+			t.Errorf("apng delay test [%v] failed: [%v]", exp, frames[i].Delay)
+		}
+	}
+
+	// The blue square is cleared, the green one restores the canvas, and
+	// the transparent corners only show with the source blending
+	checkPixels(t, "apng", frames, []struct {
+		frame, x, y int
+		exp         color.NRGBA
+	}{
+		{0, 0, 0, apngClear}, {0, 10, 10, apngRed}, {1, 10, 10, apngBlue}, {1, 8, 8, apngRed},
+		{2, 10, 10, apngClear}, {2, 20, 20, apngGreen}, {2, 16, 16, apngClear},
+		{3, 10, 10, apngClear}, {3, 20, 20, apngRed}, {3, 2, 2, apngBlue}, {3, 0, 0, apngClear},
+	})
+}
+
+// Test that a default image outside of the animation isn't a frame
+func TestDecodeAPNGHiddenDefault(t *testing.T) {
+	data := encodeAPNG(t, []apngTestFrame{
+		{withClearCorner(fillNRGBA(image.Rect(0, 0, 16, 16), apngRed)), 1, 10, apngDisposeNone, apngBlendSource},
+		{withClearCorner(fillNRGBA(image.Rect(0, 0, 16, 16), apngGreen)), 1, 10, apngDisposeNone, apngBlendSource},
+	}, true)
+
+	frames, err := DecodeFrames(bytes.NewReader(data))
+	if err != nil || len(frames) != 2 {
+		t.Fatalf("hidden default test [2] failed: [%v %d]", err, len(frames))
+	}
+	checkPixels(t, "hidden default", frames, []struct {
+		frame, x, y int
+		exp         color.NRGBA
+	}{{0, 8, 8, apngRed}, {1, 8, 8, apngGreen}})
+
+	// image/png only sees the default image
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil || color.NRGBAModel.Convert(img.At(8, 8)) != apngRed {
+		t.Errorf("default image test [%v] failed: [%v]", apngRed, err)
+	}
+}
+
+// Test that a PNG without an acTL chunk is a still image
+func TestDecodeAPNGStill(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, fillNRGBA(image.Rect(0, 0, 8, 8), apngBlue))
+
+	frames, err := DecodeFrames(&buf)
+	if err != nil || len(frames) != 1 {
+		t.Fatalf("still png test [1] failed: [%v %d]", err, len(frames))
+	}
+	if c := color.NRGBAModel.Convert(frames[0].Image.At(4, 4)); c != apngBlue {
+		t.Errorf("still png pixel test [%v] failed: [%v]", apngBlue, c)
+	}
+}
+
+// Test that frames outside of the canvas, and truncated files, fail
+func TestDecodeAPNGInvalid(t *testing.T) {
+	data := encodeAPNG(t, []apngTestFrame{
+		{withClearCorner(fillNRGBA(image.Rect(0, 0, 16, 16), apngRed)), 1, 10, apngDisposeNone, apngBlendSource},
+		{withClearCorner(fillNRGBA(image.Rect(8, 8, 24, 24), apngGreen)), 1, 10, apngDisposeNone, apngBlendSource},
+	}, false)
+	if _, err := DecodeFrames(bytes.NewReader(data)); err == nil {
+		t.Errorf("frame outside of the canvas test didn't fail")
+	}
+
+	data = encodeAPNG(t, []apngTestFrame{
+		{withClearCorner(fillNRGBA(image.Rect(0, 0, 16, 16), apngRed)), 1, 10, apngDisposeNone, apngBlendSource},
+	}, false)
+	if _, err := DecodeFrames(bytes.NewReader(data[:len(data)-20])); err == nil {
+		t.Errorf("truncated apng test didn't fail")
+	}
+}
+
+// Test that canvases too large to allocate, or empty, fail instead of panicking
+func TestDecodeAPNGHugeCanvas(t *testing.T) {
+	sizes := [][2]uint32{{0x7fffffff, 0x7fffffff}, {1 << 15, 1 << 14}, {0, 16}}
+	for _, size := range sizes {
+		var buf bytes.Buffer
+		buf.WriteString(pngSignature)
+		ihdr := make([]byte, 13)
+		binary.BigEndian.PutUint32(ihdr[0:4], size[0])
+		binary.BigEndian.PutUint32(ihdr[4:8], size[1])
+		ihdr[8], ihdr[9] = 8, 6 // 8 bit RGBA
+		writePNGChunk(&buf, "IHDR", ihdr)
+		writePNGChunk(&buf, "acTL", make([]byte, 8))
+		writePNGChunk(&buf, "IEND", nil)
+
+		if _, err := DecodeFrames(bytes.NewReader(buf.Bytes())); err == nil {
+			t.Errorf("%dx%d canvas test didn't fail", size[0], size[1])
+		}
+	}
+
+	// Nine 1x1 frames of a 4096x4096 canvas are as many canvas copies
+	var buf bytes.Buffer
+	buf.WriteString(pngSignature)
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], 4096)
+	binary.BigEndian.PutUint32(ihdr[4:8], 4096)
+	ihdr[8], ihdr[9] = 8, 6
+	writePNGChunk(&buf, "IHDR", ihdr)
+	writePNGChunk(&buf, "acTL", make([]byte, 8))
+	for i := 0; i < 9; i++ {
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:4], uint32(i))
+		binary.BigEndian.PutUint32(fctl[4:8], 1)
+		binary.BigEndian.PutUint32(fctl[8:12], 1)
+		writePNGChunk(&buf, "fcTL", fctl)
+	}
+	writePNGChunk(&buf, "IEND", nil)
+	if _, err := DecodeFrames(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Errorf("too many frames test didn't fail")
+	}
+}
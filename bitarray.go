@@ -4,6 +4,11 @@ This is an internal module to simplify adding bits one at a
 time to a byte array. It is used by the dhash algorithm, where
 the byte array can later be transformed into its hex representation.
 
+Bits are filled from the most significant bit of the first byte. When
+the number of bits isn't a multiple of 8, the last byte is padded with
+zeros on the right: 25 bits are stored in 4 bytes, and the 7 lowest bits
+of the last byte are always 0.
+
 Example usage:
   bitArray,err := NewBitArray(32)
   bitArray.AppendBit(1)
@@ -22,29 +27,24 @@ import (
 // a byte array from left to right.
 type BitArray struct {
 	byteArray []byte
-	max       int
-	mask0     byte
-	mask1     byte
-	arrayIdx  int
-	bitIdx    uint
+	max       int // Number of bits the array can hold
+	length    int // Number of bits appended so far
 }
 
 // NewBitArray is a constructor function for the BitArray struct.
 // The input, 'numBits' is the number of bits this byte array will
-// hold, so it must be a non-zero multiple of 8.
+// hold, so it must be non-zero. If it isn't a multiple of 8, the
+// last byte is padded with zeros.
 func NewBitArray(numBits int) (*BitArray, error) {
 	// If numBits is invalid
-	if (numBits == 0) || (numBits%8 != 0) {
-		return nil, errors.New("'numBits' must be a non-zero multiple of 8")
+	if numBits <= 0 {
+		return nil, errors.New("'numBits' must be non-zero")
 	}
 
 	return &BitArray{
-		byteArray: make([]byte, numBits/8),
-		max:       numBits / 8,
-		mask0:     0x00,
-		mask1:     0x01,
-		arrayIdx:  0,
-		bitIdx:    7,
+		byteArray: make([]byte, (numBits+7)/8),
+		max:       numBits,
+		length:    0,
 	}, nil
 }
 
@@ -52,37 +52,97 @@ func NewBitArray(numBits int) (*BitArray, error) {
 // Valid input is an int of '1' or '0', and this function cannot be called
 // after the byte array has filled up.
 func (ab *BitArray) AppendBit(bit int) error {
-	if ab.arrayIdx == ab.max {
+	if ab.length == ab.max {
 		return errors.New("cannot continue to append to a full byte array")
 	}
+	if bit != 0 && bit != 1 {
+		return errors.New("can only append with 1 or 0, but received: " + strconv.Itoa(bit))
+	}
+
+	ab.SetBit(ab.length, bit)
+	ab.length++
+
+	return nil
+}
+
+// GetBit returns the bit at index 'i', counting from the left. Any bit
+// within the capacity of the array can be read, and bits which haven't
+// been appended or set are 0.
+func (ab BitArray) GetBit(i int) (int, error) {
+	if i < 0 || i >= ab.max {
+		return 0, errors.New("bit index out of range: " + strconv.Itoa(i))
+	}
+
+	return int(ab.byteArray[i/8]>>(7-uint(i%8))) & 1, nil
+}
+
+// SetBit overwrites the bit at index 'i', counting from the left, with a
+// 1 or a 0. Any bit within the capacity of the array can be set, but this
+// doesn't change the number of appended bits returned by Len.
+func (ab *BitArray) SetBit(i, bit int) error {
+	if i < 0 || i >= ab.max {
+		return errors.New("bit index out of range: " + strconv.Itoa(i))
+	}
 
-	// Shift the 'mask' (bit of 1 or 0) by the proper amount to
-	// fill the byte up from left to right.
+	// Shift a mask by the proper amount to reach the bit from the left
+	mask := byte(0x80) >> uint(i%8)
 	switch bit {
 	case 0:
-		ab.byteArray[ab.arrayIdx] |= ab.mask0 << ab.bitIdx
+		ab.byteArray[i/8] &^= mask
 	case 1:
-		ab.byteArray[ab.arrayIdx] |= ab.mask1 << ab.bitIdx
+		ab.byteArray[i/8] |= mask
 	default:
-		return errors.New("can only append with 1 or 0, but received: " + strconv.Itoa(bit))
-	}
-
-	if ab.bitIdx > 0 {
-		// Decrement the index into the current byte so the next
-		// bit to set will be on the right.
-		ab.bitIdx--
-	} else {
-		// The last bit in the current byte has been set, so increment
-		// the index into the byte array, and reset the bit index.
-		ab.arrayIdx++
-		ab.bitIdx = 7
+		return errors.New("can only set a bit to 1 or 0, but received: " + strconv.Itoa(bit))
 	}
 
 	return nil
 }
 
+// Len returns the number of bits appended so far.
+func (ab BitArray) Len() int {
+	return ab.length
+}
+
+// Cap returns the number of bits the array can hold, which doesn't
+// include the padding of the last byte.
+func (ab BitArray) Cap() int {
+	return ab.max
+}
+
+// Reset clears every bit, so that the array can be filled up again.
+func (ab *BitArray) Reset() {
+	for i := range ab.byteArray {
+		ab.byteArray[i] = 0
+	}
+	ab.length = 0
+}
+
 // GetArray returns the byte array in its current state. It
 // can be called at any time.
 func (ab BitArray) GetArray() []byte {
 	return ab.byteArray
 }
+
+// GetVector returns the bits of the array as a BitVector, without the
+// padding of the last byte.
+func (ab BitArray) GetVector() *BitVector {
+	vec, _ := NewBitVectorFromBytes(ab.byteArray).Slice(0, ab.max)
+	return vec
+}
+
+// arrayBytes passes on the byte array of a BitArray returned along with an
+// error, so the hash functions can return []byte in a single statement.
+func arrayBytes(ab *BitArray, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	return ab.GetArray(), nil
+}
+
+// arrayVector is the same as arrayBytes, for a BitVector.
+func arrayVector(ab *BitArray, err error) (*BitVector, error) {
+	if err != nil {
+		return nil, err
+	}
+	return ab.GetVector(), nil
+}
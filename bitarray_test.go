@@ -5,13 +5,17 @@ The test module for the BitArray datatype.
 This tests:
 1. Initializing a new valid BitArray
 2. Incorrectly initializing a new BitArray with zero
-3. Incorrectly initializing a new BitArray with a value under 8
-4. Incorrectly initializing a new BitArray with a value above 8 but not a multiple of 8
+3. Initializing a new BitArray with a value under 8, padded to a byte
+4. Initializing a new BitArray with a value above 8 but not a multiple of 8
 5. Filling the BitArray with ones, using AppendBit
 6. Filling the BitArray with zeros, using AppendBit
 7. Failing to append an invalid bit which isn't a 0 or a 1
 8. Failing to append more bits than the BitArray can contain
 9. Appending fewer bits than the BitArray's full size
+10. Reading bits back with GetBit, and out of range
+11. Overwriting bits with SetBit
+12. Keeping track of the length and capacity
+13. Resetting the BitArray and filling it up again
 
 */
 
@@ -33,7 +37,7 @@ func TestNewBitArrayInit(t *testing.T) {
 	if bytes.Compare(exp, act) != 0 {
 		t.Errorf("init test [%x] failed: [%x]", exp, act)
 	} else if err != nil {
-		t.Errorf("init test failed with error", err)
+		t.Errorf("init test failed with error: %v", err)
 	}
 }
 
@@ -47,25 +51,48 @@ func TestFailNewBitArrayInitZero(t *testing.T) {
 	}
 }
 
-// Test initializing a bit array incorrectly with the number of bits
-// less than 8 but not zero
-func TestFailNewBitArrayLessThanEight(t *testing.T) {
+// Test initializing a bit array with the number of bits less than 8.
+// The bits fit in a single byte, and appending past them fails.
+func TestNewBitArrayLessThanEight(t *testing.T) {
 	numberBits := 5
-	_, err := NewBitArray(numberBits)
+	ba, err := NewBitArray(numberBits)
 
-	if err == nil {
-		t.Errorf("less than 8 bits init test didn't fail")
+	if err != nil {
+		t.Errorf("less than 8 bits init test failed with error: %v", err)
+		return
+	}
+
+	for i := 0; i < numberBits; i++ {
+		ba.AppendBit(1)
+	}
+
+	exp := []byte{0xF8}
+	if act := ba.GetArray(); bytes.Compare(exp, act) != 0 {
+		t.Errorf("less than 8 bits test [%x] failed: [%x]", exp, act)
+	}
+	if err = ba.AppendBit(1); err == nil {
+		t.Errorf("appending into the padding didn't fail")
 	}
 }
 
-// Test initializing a bit array incorrectly with the number of bits
-// greater than 8 but not a multiple of eight
-func TestFailNewBitArrayGreaterThanEight(t *testing.T) {
+// Test initializing a bit array with the number of bits greater
+// than 8 but not a multiple of eight. The last byte is padded with zeros.
+func TestNewBitArrayGreaterThanEight(t *testing.T) {
 	numberBits := 12
-	_, err := NewBitArray(numberBits)
+	ba, err := NewBitArray(numberBits)
 
-	if err == nil {
-		t.Errorf("greater than 8 bits init test didn't fail")
+	if err != nil {
+		t.Errorf("greater than 8 bits init test failed with error: %v", err)
+		return
+	}
+
+	for i := 0; i < numberBits; i++ {
+		ba.AppendBit(1)
+	}
+
+	exp := []byte{0xFF, 0xF0}
+	if act := ba.GetArray(); bytes.Compare(exp, act) != 0 {
+		t.Errorf("greater than 8 bits test [%x] failed: [%x]", exp, act)
 	}
 }
 
@@ -85,7 +112,7 @@ func TestAppendOne(t *testing.T) {
 	if bytes.Compare(exp, act) != 0 {
 		t.Errorf("AppendOne test [%x] failed: [%x]", exp, act)
 	} else if err != nil {
-		t.Errorf("AppendOne test failed with error", err)
+		t.Errorf("AppendOne test failed with error: %v", err)
 	}
 }
 
@@ -105,7 +132,7 @@ func TestAppendZero(t *testing.T) {
 	if bytes.Compare(exp, act) != 0 {
 		t.Errorf("AppendZero test [%x] failed: [%x]", exp, act)
 	} else if err != nil {
-		t.Errorf("AppendZero test failed with error", err)
+		t.Errorf("AppendZero test failed with error: %v", err)
 	}
 }
 
@@ -116,7 +143,7 @@ func TestAppendInvalidBit(t *testing.T) {
 
 	// Ensure that the initialization didn't fail
 	if err != nil {
-		t.Errorf("AppendInvalidBit test failed init with error", err)
+		t.Errorf("AppendInvalidBit test failed init with error: %v", err)
 		return
 	}
 
@@ -136,7 +163,7 @@ func TestOverfilledBitArray(t *testing.T) {
 
 	// Ensure that the initialization didn't fail
 	if err != nil {
-		t.Errorf("overfill test failed with error", err)
+		t.Errorf("overfill test failed with error: %v", err)
 		return
 	}
 
@@ -168,6 +195,98 @@ func TestPartlyFullBitArray(t *testing.T) {
 	if bytes.Compare(exp, act) != 0 {
 		t.Errorf("partial fill test [%x] failed: [%x]", exp, act)
 	} else if err != nil {
-		t.Errorf("partial fill test failed with error", err)
+		t.Errorf("partial fill test failed with error: %v", err)
+	}
+}
+
+// Test reading back appended bits, and reading out of range
+func TestGetBit(t *testing.T) {
+	ba, _ := NewBitArray(12)
+	bits := []int{1, 0, 1, 1, 0, 0, 1}
+
+	for _, bit := range bits {
+		ba.AppendBit(bit)
+	}
+
+	for i, exp := range bits {
+		if act, err := ba.GetBit(i); act != exp || err != nil {
+			t.Errorf("GetBit(%d) test [%d] failed: [%d] %v", i, exp, act, err)
+		}
+	}
+
+	// Bits which haven't been appended yet are 0
+	if act, _ := ba.GetBit(11); act != 0 {
+		t.Errorf("GetBit of an unset bit test [0] failed: [%d]", act)
+	}
+
+	// The padding and negative indexes are out of range
+	if _, err := ba.GetBit(12); err == nil {
+		t.Errorf("GetBit in the padding didn't fail")
+	}
+	if _, err := ba.GetBit(-1); err == nil {
+		t.Errorf("GetBit with a negative index didn't fail")
+	}
+}
+
+// Test overwriting bits, and appending over a bit which was set
+func TestSetBit(t *testing.T) {
+	ba, _ := NewBitArray(16)
+	ba.AppendBit(1)
+	ba.AppendBit(1)
+
+	ba.SetBit(0, 0)
+	ba.SetBit(15, 1)
+	ba.SetBit(2, 1)
+	ba.AppendBit(0) // overwrites the bit set at index 2
+
+	exp := []byte{0x40, 0x01}
+	if act := ba.GetArray(); bytes.Compare(exp, act) != 0 {
+		t.Errorf("SetBit test [%x] failed: [%x]", exp, act)
+	}
+	if err := ba.SetBit(3, 2); err == nil {
+		t.Errorf("SetBit with an invalid bit didn't fail")
+	}
+	if err := ba.SetBit(16, 1); err == nil {
+		t.Errorf("SetBit out of range didn't fail")
+	}
+}
+
+// Test that Len counts the appended bits, and Cap excludes the padding
+func TestLenCap(t *testing.T) {
+	ba, _ := NewBitArray(25)
+
+	for i := 0; i < 10; i++ {
+		ba.AppendBit(1)
+	}
+	ba.SetBit(20, 1)
+
+	if ba.Len() != 10 {
+		t.Errorf("Len test [10] failed: [%d]", ba.Len())
+	}
+	if ba.Cap() != 25 {
+		t.Errorf("Cap test [25] failed: [%d]", ba.Cap())
+	}
+	if len(ba.GetArray()) != 4 {
+		t.Errorf("padded length test [4] failed: [%d]", len(ba.GetArray()))
+	}
+}
+
+// Test that a reset array is empty, and can be filled up again
+func TestReset(t *testing.T) {
+	ba, _ := NewBitArray(8)
+
+	for i := 0; i < 8; i++ {
+		ba.AppendBit(1)
+	}
+	ba.Reset()
+
+	if ba.Len() != 0 || bytes.Compare([]byte{0x00}, ba.GetArray()) != 0 {
+		t.Errorf("Reset test failed: %d bits, [%x]", ba.Len(), ba.GetArray())
+	}
+	for i := 0; i < 8; i++ {
+		if err := ba.AppendBit(0); err != nil {
+			t.Errorf("appending after Reset failed with error: %v", err)
+			return
+		}
 	}
 }
@@ -0,0 +1,237 @@
+/*
+
+BitVector is a fixed-length vector of bits packed into 64 bit words, for
+indexing hashes and computing distances between them without going
+through their byte representation one byte at a time.
+
+Bits are in the same order as in a BitArray: bit 0 is the most significant
+bit of the first byte, so a vector converted from a hash and back gives the
+same byte array. Within a word, bit 0 is the most significant bit too, so
+the first word of a 64 bit vector is the hash read as a big-endian uint64.
+
+Example usage:
+  vec1,err := imagehash.DhashVector(src1, 8)
+  vec2,err := imagehash.DhashVector(src2, 8)
+  diff,err := vec1.Xor(vec2)
+  fmt.Println(diff.PopCount())
+
+*/
+
+package imagehash
+
+import (
+	"errors"
+	"math/bits"
+	"strconv"
+)
+
+// BitVector is a fixed-length vector of bits backed by uint64 words.
+type BitVector struct {
+	words []uint64
+	n     int // Number of bits in the vector
+}
+
+// NewBitVector returns a vector of 'numBits' zeros.
+func NewBitVector(numBits int) (*BitVector, error) {
+	if numBits < 0 {
+		return nil, errors.New("'numBits' can't be negative")
+	}
+
+	return &BitVector{
+		words: make([]uint64, (numBits+63)/64),
+		n:     numBits,
+	}, nil
+}
+
+// NewBitVectorFromBytes returns a vector holding the bits of a byte array,
+// such as a hash returned by Dhash or Ahash. The vector is 8 bits long per
+// byte; padding bits can be dropped using Slice.
+func NewBitVectorFromBytes(data []byte) *BitVector {
+	v, _ := NewBitVector(len(data) * 8)
+	for i, b := range data {
+		v.words[i/8] |= uint64(b) << (56 - 8*uint(i%8))
+	}
+	return v
+}
+
+// NewBitVectorFromUint64 returns a vector holding the 'numBits' lowest bits
+// of 'value', read as a big-endian number. 'numBits' must be between 1 and
+// 64.
+func NewBitVectorFromUint64(value uint64, numBits int) (*BitVector, error) {
+	if numBits <= 0 || numBits > 64 {
+		return nil, errors.New("'numBits' must be between 1 and 64")
+	}
+
+	return &BitVector{
+		words: []uint64{value << (64 - uint(numBits))},
+		n:     numBits,
+	}, nil
+}
+
+// Len returns the number of bits in the vector.
+func (v *BitVector) Len() int {
+	return v.n
+}
+
+// GetBit returns the bit at index 'i', counting from the left.
+func (v *BitVector) GetBit(i int) (int, error) {
+	if i < 0 || i >= v.n {
+		return 0, errors.New("bit index out of range: " + strconv.Itoa(i))
+	}
+
+	return int(v.words[i/64]>>(63-uint(i%64))) & 1, nil
+}
+
+// SetBit overwrites the bit at index 'i', counting from the left, with a 1
+// or a 0.
+func (v *BitVector) SetBit(i, bit int) error {
+	if i < 0 || i >= v.n {
+		return errors.New("bit index out of range: " + strconv.Itoa(i))
+	}
+
+	mask := uint64(1) << (63 - uint(i%64))
+	switch bit {
+	case 0:
+		v.words[i/64] &^= mask
+	case 1:
+		v.words[i/64] |= mask
+	default:
+		return errors.New("can only set a bit to 1 or 0, but received: " + strconv.Itoa(bit))
+	}
+
+	return nil
+}
+
+// And returns the bitwise AND of two vectors of the same length.
+func (v *BitVector) And(o *BitVector) (*BitVector, error) {
+	return v.combine(o, func(a, b uint64) uint64 { return a & b })
+}
+
+// Or returns the bitwise OR of two vectors of the same length.
+func (v *BitVector) Or(o *BitVector) (*BitVector, error) {
+	return v.combine(o, func(a, b uint64) uint64 { return a | b })
+}
+
+// Xor returns the bitwise XOR of two vectors of the same length. The
+// PopCount of the result is the Hamming distance between the vectors.
+func (v *BitVector) Xor(o *BitVector) (*BitVector, error) {
+	return v.combine(o, func(a, b uint64) uint64 { return a ^ b })
+}
+
+// combine applies 'op' to every pair of words of two vectors.
+func (v *BitVector) combine(o *BitVector, op func(a, b uint64) uint64) (*BitVector, error) {
+	if v.n != o.n {
+		return nil, errors.New("cannot combine bit vectors of different lengths: " +
+			strconv.Itoa(v.n) + " and " + strconv.Itoa(o.n))
+	}
+
+	res, _ := NewBitVector(v.n)
+	for i := range res.words {
+		res.words[i] = op(v.words[i], o.words[i])
+	}
+	return res, nil
+}
+
+// Not returns the bitwise complement of the vector.
+func (v *BitVector) Not() *BitVector {
+	res, _ := NewBitVector(v.n)
+	for i, w := range v.words {
+		res.words[i] = ^w
+	}
+	res.clearTail()
+	return res
+}
+
+// PopCount returns the number of bits set to 1.
+func (v *BitVector) PopCount() int {
+	count := 0
+	for _, w := range v.words {
+		count += bits.OnesCount64(w)
+	}
+	return count
+}
+
+// Equal reports whether two vectors have the same length and bits.
+func (v *BitVector) Equal(o *BitVector) bool {
+	if v.n != o.n {
+		return false
+	}
+	for i, w := range v.words {
+		if w != o.words[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Slice returns a new vector holding the bits from index 'from' up to, but
+// not including, index 'to'.
+func (v *BitVector) Slice(from, to int) (*BitVector, error) {
+	if from < 0 || to > v.n || from > to {
+		return nil, errors.New("invalid slice bounds: [" + strconv.Itoa(from) + ":" + strconv.Itoa(to) + "]")
+	}
+
+	res, _ := NewBitVector(to - from)
+	shift := uint(from % 64)
+	for i := range res.words {
+		k := from/64 + i
+		w := v.words[k] << shift
+		if shift > 0 && k+1 < len(v.words) {
+			w |= v.words[k+1] >> (64 - shift)
+		}
+		res.words[i] = w
+	}
+	res.clearTail()
+	return res, nil
+}
+
+// concat returns a new vector holding the bits of 'v' followed by the bits
+// of 'o'.
+func (v *BitVector) concat(o *BitVector) *BitVector {
+	res, _ := NewBitVector(v.n + o.n)
+	copy(res.words, v.words)
+	for i := 0; i < o.n; i++ {
+		bit, _ := o.GetBit(i)
+		res.SetBit(v.n+i, bit)
+	}
+	return res
+}
+
+// Bytes returns the bits of the vector as a byte array, in the same order
+// as a BitArray. If the length isn't a multiple of 8, the last byte is
+// padded with zeros.
+func (v *BitVector) Bytes() []byte {
+	data := make([]byte, (v.n+7)/8)
+	for i := range data {
+		data[i] = byte(v.words[i/8] >> (56 - 8*uint(i%8)))
+	}
+	return data
+}
+
+// Uint64 returns the bits of the vector read as a big-endian number. The
+// vector can't be longer than 64 bits.
+func (v *BitVector) Uint64() (uint64, error) {
+	if v.n > 64 {
+		return 0, errors.New("cannot convert more than 64 bits to a uint64")
+	}
+	if v.n == 0 {
+		return 0, nil
+	}
+	return v.words[0] >> (64 - uint(v.n)), nil
+}
+
+// Words returns the words backing the vector, each holding 64 bits, with
+// the first bit of the vector as the most significant bit of the first
+// word. Bits past the end of the vector are 0. The returned slice must not
+// be modified.
+func (v *BitVector) Words() []uint64 {
+	return v.words
+}
+
+// clearTail zeroes the unused bits of the last word, which operations such
+// as Not would otherwise set.
+func (v *BitVector) clearTail() {
+	if rem := uint(v.n % 64); rem > 0 {
+		v.words[len(v.words)-1] &= ^uint64(0) << (64 - rem)
+	}
+}
@@ -0,0 +1,197 @@
+/*
+
+The test module for the BitVector datatype.
+
+This tests:
+1. Converting a byte array to a BitVector and back
+2. Converting a uint64 to a BitVector and back
+3. Failing to convert more than 64 bits to a uint64
+4. The bitwise And, Or and Xor operations
+5. Failing to combine vectors of different lengths
+6. Complementing a vector whose length isn't a multiple of 64
+7. Counting bits, and comparing vectors
+8. Slicing across word boundaries, and out of range
+9. Getting and setting single bits
+10. Emitting vectors directly from the hash functions
+
+*/
+
+package imagehash
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Test that a byte array survives the round trip through a BitVector,
+// and that the words are big-endian
+func TestBitVectorBytes(t *testing.T) {
+	exp := []byte{0x76, 0x70, 0x79, 0x5b, 0x33, 0x13, 0x5a, 0x38, 0xff}
+	vec := NewBitVectorFromBytes(exp)
+
+	if act := vec.Bytes(); bytes.Compare(exp, act) != 0 {
+		t.Errorf("bytes round trip test [%x] failed: [%x]", exp, act)
+	}
+	if vec.Len() != 72 {
+		t.Errorf("bytes length test [72] failed: [%d]", vec.Len())
+	}
+	if w := vec.Words(); len(w) != 2 || w[0] != 0x7670795b33135a38 || w[1] != 0xff00000000000000 {
+		t.Errorf("bytes words test failed: [%x]", w)
+	}
+}
+
+// Test that a uint64 survives the round trip through a BitVector
+func TestBitVectorUint64(t *testing.T) {
+	vec, err := NewBitVectorFromUint64(0x2a, 7)
+	if err != nil {
+		t.Errorf("uint64 init test failed with error: %v", err)
+		return
+	}
+
+	if act, _ := vec.Uint64(); act != 0x2a {
+		t.Errorf("uint64 round trip test [2a] failed: [%x]", act)
+	}
+	if act := vec.Bytes(); bytes.Compare([]byte{0x54}, act) != 0 {
+		t.Errorf("uint64 bytes test [54] failed: [%x]", act)
+	}
+	if _, err := NewBitVectorFromUint64(1, 65); err == nil {
+		t.Errorf("uint64 init with 65 bits didn't fail")
+	}
+}
+
+// Test that a vector of more than 64 bits can't be read as a uint64
+func TestBitVectorUint64TooLong(t *testing.T) {
+	vec, _ := NewBitVector(65)
+
+	if _, err := vec.Uint64(); err == nil {
+		t.Errorf("uint64 conversion of 65 bits didn't fail")
+	}
+}
+
+// Test the bitwise operations on two vectors
+func TestBitVectorOperations(t *testing.T) {
+	a := NewBitVectorFromBytes([]byte{0xf0, 0x0f})
+	b := NewBitVectorFromBytes([]byte{0xcc, 0xcc})
+
+	and, _ := a.And(b)
+	or, _ := a.Or(b)
+	xor, _ := a.Xor(b)
+
+	if exp := []byte{0xc0, 0x0c}; bytes.Compare(exp, and.Bytes()) != 0 {
+		t.Errorf("And test [%x] failed: [%x]", exp, and.Bytes())
+	}
+	if exp := []byte{0xfc, 0xcf}; bytes.Compare(exp, or.Bytes()) != 0 {
+		t.Errorf("Or test [%x] failed: [%x]", exp, or.Bytes())
+	}
+	if exp := []byte{0x3c, 0xc3}; bytes.Compare(exp, xor.Bytes()) != 0 {
+		t.Errorf("Xor test [%x] failed: [%x]", exp, xor.Bytes())
+	}
+}
+
+// Test that vectors of different lengths can't be combined
+func TestBitVectorLengthMismatch(t *testing.T) {
+	a := NewBitVectorFromBytes([]byte{0xf0, 0x0f})
+	b := NewBitVectorFromBytes([]byte{0xf0})
+
+	if _, err := a.Xor(b); err == nil {
+		t.Errorf("Xor of different lengths didn't fail")
+	}
+}
+
+// Test that Not doesn't set the bits past the end of the vector
+func TestBitVectorNot(t *testing.T) {
+	vec, _ := NewBitVector(70)
+	not := vec.Not()
+
+	if not.PopCount() != 70 {
+		t.Errorf("Not popcount test [70] failed: [%d]", not.PopCount())
+	}
+	if not.Not().PopCount() != 0 {
+		t.Errorf("double Not popcount test [0] failed: [%d]", not.Not().PopCount())
+	}
+}
+
+// Test counting bits and comparing vectors
+func TestBitVectorPopCountEqual(t *testing.T) {
+	a := NewBitVectorFromBytes([]byte{0xf0, 0x01})
+	b := NewBitVectorFromBytes([]byte{0xf0, 0x01})
+	c := NewBitVectorFromBytes([]byte{0xf0, 0x01, 0x00})
+
+	if a.PopCount() != 5 {
+		t.Errorf("PopCount test [5] failed: [%d]", a.PopCount())
+	}
+	if !a.Equal(b) {
+		t.Errorf("equal vectors aren't Equal")
+	}
+	if a.Equal(c) {
+		t.Errorf("vectors of different lengths are Equal")
+	}
+}
+
+// Test slicing a vector across a word boundary
+func TestBitVectorSlice(t *testing.T) {
+	vec := NewBitVectorFromBytes([]byte{0, 0, 0, 0, 0, 0, 0, 0x0f, 0xf0, 0xaa})
+	slice, err := vec.Slice(60, 72)
+
+	if err != nil {
+		t.Errorf("Slice test failed with error: %v", err)
+		return
+	}
+	if slice.Len() != 12 || bytes.Compare([]byte{0xff, 0x00}, slice.Bytes()) != 0 {
+		t.Errorf("Slice test [ff00] failed: %d bits [%x]", slice.Len(), slice.Bytes())
+	}
+	if _, err := vec.Slice(10, 81); err == nil {
+		t.Errorf("Slice out of range didn't fail")
+	}
+	if _, err := vec.Slice(10, 5); err == nil {
+		t.Errorf("Slice with inverted bounds didn't fail")
+	}
+}
+
+// Test getting and setting single bits
+func TestBitVectorGetSetBit(t *testing.T) {
+	vec, _ := NewBitVector(100)
+	vec.SetBit(0, 1)
+	vec.SetBit(99, 1)
+	vec.SetBit(0, 0)
+
+	if bit, _ := vec.GetBit(99); bit != 1 {
+		t.Errorf("GetBit test [1] failed: [%d]", bit)
+	}
+	if vec.PopCount() != 1 {
+		t.Errorf("SetBit popcount test [1] failed: [%d]", vec.PopCount())
+	}
+	if err := vec.SetBit(100, 1); err == nil {
+		t.Errorf("SetBit out of range didn't fail")
+	}
+	if err := vec.SetBit(1, 3); err == nil {
+		t.Errorf("SetBit with an invalid bit didn't fail")
+	}
+}
+
+// Test that the vector hash functions match the byte array ones
+func TestHashVectors(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_512.png")
+
+	dhash, _ := Dhash(src, 8)
+	dvec, _ := DhashVector(src, 8)
+	if bytes.Compare(dhash, dvec.Bytes()) != 0 {
+		t.Errorf("dhash vector test [%x] failed: [%x]", dhash, dvec.Bytes())
+	}
+
+	ahash, _ := Ahash(src, 8)
+	avec, _ := AhashVector(src, 8)
+	if bytes.Compare(ahash, avec.Bytes()) != 0 {
+		t.Errorf("ahash vector test [%x] failed: [%x]", ahash, avec.Bytes())
+	}
+
+	// Vectors aren't padded
+	hvec, _ := DhashHorizontalVector(src, 5)
+	if hvec.Len() != 25 {
+		t.Errorf("padded vector length test [25] failed: [%d]", hvec.Len())
+	}
+	dvec, _ = DhashVector(src, 5)
+	if dvec.Len() != 50 {
+		t.Errorf("concatenated vector length test [50] failed: [%d]", dvec.Len())
+	}
+}
@@ -0,0 +1,320 @@
+/*
+
+Clusters near-duplicate hashes, such as the photos of a library which were
+resized, recompressed or slightly edited.
+
+Cluster finds the neighbours of every hash within a threshold with
+multi-index hashing: the hashes are cut into threshold+1 substrings, and
+two hashes within the threshold have at least one identical substring, so
+a hash is only compared with the hashes sharing a substring with it. This
+avoids comparing most pairs as long as the substrings are long enough to
+spread the hashes apart, about log2 of their number bits; as the threshold
+gets closer to the length of the hashes, it degrades to comparing every
+pair. Hashes and their neighbours are joined with a union-find into
+connected components, which are the clusters.
+
+A connected component can chain hashes which are far apart: A within the
+threshold of B, B within the threshold of C, but A far from C. With
+CompleteLinkage, every component is split by complete-linkage
+agglomerative clustering, so that every pair of hashes of a cluster is
+within the threshold. This computes the distances of every pair of a
+component, and is cubic in its size, so it is meant for components of up
+to a few hundred hashes.
+
+The representative of a cluster is its medoid, the hash with the smallest
+sum of distances to the others, or the hash with the highest score if a
+Scorer is given, such as the one of the image with the largest resolution.
+The medoid is found along with the largest distance of the cluster, in a
+single pass over its pairs.
+
+Usage:
+  clusters,err := imagehash.Cluster(hashes, 10, imagehash.ClusterOptions{})
+  clusters,err = imagehash.Cluster(hashes, 10, imagehash.ClusterOptions{
+    CompleteLinkage: true,
+    Scorer: func(i int) float64 { return float64(pixels[i]) },
+  })
+
+*/
+
+package imagehash
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// ClusterOptions configures Cluster.
+type ClusterOptions struct {
+	Scorer          func(i int) float64 // Score of the i-th hash; the highest scoring hash of a cluster is its representative. The medoid if nil
+	CompleteLinkage bool                // Split the clusters until every pair of their hashes is within the threshold
+}
+
+// HashCluster is a cluster of hashes, identified by their position in the
+// slice passed to Cluster.
+type HashCluster struct {
+	Members        []int `json:"members"`        // Positions of the hashes of the cluster, in increasing order
+	Representative int   `json:"representative"` // Position of the representative hash
+	MaxDistance    int   `json:"maxDistance"`    // Largest distance between two hashes of the cluster
+}
+
+// Cluster groups binary hashes within 'threshold' bits of each other. Every
+// hash is in exactly one cluster, alone if it has no neighbour. The
+// clusters are sorted by decreasing size, then by their first member. The
+// hashes must be of the same hasher, version and preprocessing.
+func Cluster(hashes []Hash, threshold int, opts ClusterOptions) ([]HashCluster, error) {
+	if threshold < 0 {
+		return nil, errors.New("the threshold must not be negative")
+	}
+	if err := checkClusterHashes(hashes); err != nil {
+		return nil, err
+	}
+
+	words := make([][]uint64, len(hashes))
+	for i, h := range hashes {
+		words[i] = NewBitVectorFromBytes(h.Value).Words()
+	}
+	uf := joinNeighbours(hashes, words, threshold)
+
+	var clusters []HashCluster
+	for _, members := range uf.components() {
+		if !opts.CompleteLinkage {
+			distance := func(i, j int) int {
+				return distanceWords(words[members[i]], words[members[j]], math.MaxInt32)
+			}
+			clusters = append(clusters, newHashCluster(members, distance, opts.Scorer))
+			continue
+		}
+		dist := clusterDistances(words, members)
+		for _, group := range completeLinkage(dist, threshold) {
+			sub := make([]int, len(group))
+			for k, g := range group {
+				sub[k] = members[g]
+			}
+			distance := func(i, j int) int { return dist[group[i]][group[j]] }
+			clusters = append(clusters, newHashCluster(sub, distance, opts.Scorer))
+		}
+	}
+
+	sort.Slice(clusters, func(i, j int) bool {
+		if len(clusters[i].Members) != len(clusters[j].Members) {
+			return len(clusters[i].Members) > len(clusters[j].Members)
+		}
+		return clusters[i].Members[0] < clusters[j].Members[0]
+	})
+	return clusters, nil
+}
+
+// checkClusterHashes checks that hashes can be compared with each other.
+func checkClusterHashes(hashes []Hash) error {
+	for _, h := range hashes {
+		first := hashes[0]
+		if h.Kind != BinaryKind {
+			return errors.New("cannot cluster the float hashes of " + h.Algorithm)
+		}
+		if h.Algorithm != first.Algorithm || h.Pipeline != first.Pipeline {
+			return errors.New("cannot cluster " + first.Algorithm + " hashes with " + h.Algorithm + " hashes")
+		}
+		if len(h.Value) == 0 || len(h.Value) != len(first.Value) {
+			return errors.New("cannot cluster hashes of " + strconv.Itoa(len(first.Value)) +
+				" and " + strconv.Itoa(len(h.Value)) + " bytes")
+		}
+		if err := CheckVersions(first, h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// joinNeighbours joins every hash with the hashes within 'threshold' bits
+// of it, found by multi-index hashing.
+func joinNeighbours(hashes []Hash, words [][]uint64, threshold int) *unionFind {
+	uf := newUnionFind(len(hashes))
+	if len(hashes) == 0 {
+		return uf
+	}
+	numBits := len(hashes[0].Value) * 8
+	if threshold >= numBits {
+		// Every pair is within the threshold
+		for i := range hashes {
+			uf.union(0, i)
+		}
+		return uf
+	}
+
+	// Substring 'k' holds the bits from k*numBits/parts up to
+	// (k+1)*numBits/parts, and maps its values to the hashes having them
+	parts := threshold + 1
+	tables := make([]map[string][]int, parts)
+	for k := range tables {
+		tables[k] = make(map[string][]int)
+	}
+	compared := make([]int, len(hashes)) // Last hash every hash was compared with, plus 1
+	for i, h := range hashes {
+		vec := NewBitVectorFromBytes(h.Value)
+		for k, table := range tables {
+			sub, _ := vec.Slice(k*numBits/parts, (k+1)*numBits/parts)
+			key := string(sub.Bytes())
+			for _, j := range table[key] {
+				if compared[j] != i+1 {
+					compared[j] = i + 1
+					if distanceWords(words[i], words[j], threshold) <= threshold {
+						uf.union(i, j)
+					}
+				}
+			}
+			table[key] = append(table[key], i)
+		}
+	}
+	return uf
+}
+
+// clusterDistances returns the distances between the hashes of a cluster,
+// indexed by their position in 'members'.
+func clusterDistances(words [][]uint64, members []int) [][]int {
+	dist := make([][]int, len(members))
+	for i := range dist {
+		dist[i] = make([]int, len(members))
+	}
+	for i := range members {
+		for j := i + 1; j < len(members); j++ {
+			d := distanceWords(words[members[i]], words[members[j]], math.MaxInt32)
+			dist[i][j], dist[j][i] = d, d
+		}
+	}
+	return dist
+}
+
+// newHashCluster returns the cluster of 'members', where 'distance' returns
+// the distance between two of them by their position in 'members', and
+// picks its representative. Every pair is visited once, without storing
+// their distances.
+func newHashCluster(members []int, distance func(i, j int) int, scorer func(i int) float64) HashCluster {
+	c := HashCluster{Members: members}
+	sums := make([]int, len(members)) // Sum of the distances of every member to the others
+	for i := range members {
+		for j := i + 1; j < len(members); j++ {
+			d := distance(i, j)
+			sums[i] += d
+			sums[j] += d
+			if d > c.MaxDistance {
+				c.MaxDistance = d
+			}
+		}
+	}
+
+	best, bestScore := 0, 0.0
+	for i := range members {
+		score := -float64(sums[i]) // The medoid has the smallest sum of distances
+		if scorer != nil {
+			score = scorer(members[i])
+		}
+		if i == 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	c.Representative = members[best]
+	return c
+}
+
+// completeLinkage splits a cluster into the groups found by merging the two
+// closest groups, starting from single hashes, as long as the largest
+// distance between their hashes is within 'threshold'. The groups hold
+// positions in 'dist', in increasing order.
+func completeLinkage(dist [][]int, threshold int) [][]int {
+	n := len(dist)
+	groups := make([][]int, n)
+	linkage := make([][]int, n) // Largest distance between the hashes of two groups
+	for i := range groups {
+		groups[i] = []int{i}
+		linkage[i] = append([]int(nil), dist[i]...)
+	}
+
+	for {
+		// Find the closest pair of groups, the first one on ties
+		a, b := -1, -1
+		for i := range groups {
+			if groups[i] == nil {
+				continue
+			}
+			for j := i + 1; j < n; j++ {
+				if groups[j] != nil && linkage[i][j] <= threshold && (a < 0 || linkage[i][j] < linkage[a][b]) {
+					a, b = i, j
+				}
+			}
+		}
+		if a < 0 {
+			break
+		}
+
+		// Merge 'b' into 'a'
+		groups[a] = append(groups[a], groups[b]...)
+		sort.Ints(groups[a])
+		groups[b] = nil
+		for k := range groups {
+			if linkage[b][k] > linkage[a][k] {
+				linkage[a][k], linkage[k][a] = linkage[b][k], linkage[b][k]
+			}
+		}
+	}
+
+	var res [][]int
+	for _, g := range groups {
+		if g != nil {
+			res = append(res, g)
+		}
+	}
+	return res
+}
+
+// unionFind is a disjoint-set forest of the positions of hashes.
+type unionFind struct {
+	parent []int
+}
+
+// newUnionFind returns a forest of 'n' single positions.
+func newUnionFind(n int) *unionFind {
+	uf := &unionFind{parent: make([]int, n)}
+	for i := range uf.parent {
+		uf.parent[i] = i
+	}
+	return uf
+}
+
+// find returns the root of the set of a position.
+func (uf *unionFind) find(i int) int {
+	for uf.parent[i] != i {
+		uf.parent[i] = uf.parent[uf.parent[i]] // Halve the path
+		i = uf.parent[i]
+	}
+	return i
+}
+
+// union joins the sets of two positions, under the smallest root.
+func (uf *unionFind) union(i, j int) {
+	ri, rj := uf.find(i), uf.find(j)
+	if ri < rj {
+		uf.parent[rj] = ri
+	} else if rj < ri {
+		uf.parent[ri] = rj
+	}
+}
+
+// components returns the positions of every set, in increasing order, the
+// sets ordered by their first position.
+func (uf *unionFind) components() [][]int {
+	byRoot := make(map[int]int)
+	var res [][]int
+	for i := range uf.parent {
+		root := uf.find(i)
+		k, ok := byRoot[root]
+		if !ok {
+			k = len(res)
+			byRoot[root] = k
+			res = append(res, nil)
+		}
+		res[k] = append(res[k], i)
+	}
+	return res
+}
@@ -0,0 +1,181 @@
+/*
+
+Testing suite for clustering hashes.
+
+1. Test that chained hashes form a single cluster, with its medoid
+2. Test splitting chained clusters with complete linkage
+3. Test picking representatives with a scorer, and invalid hashes
+4. Test clustering the hashes of images
+5. Test that the neighbours found by multi-index hashing are the ones found
+   by comparing every pair
+
+*/
+
+package imagehash
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// clusterHash returns a dhash-h:8 hash of the bits of 'v'.
+func clusterHash(v uint64) Hash {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, v)
+	return Hash{Algorithm: "dhash-h:8", Value: value, Version: DhashVersion}
+}
+
+// chainedHashes are hashes 'a', 'b' and 'c' chained by 2 bits, with 'a' and
+// 'c' 4 bits apart, and a hash far from them.
+var chainedHashes = []Hash{
+	clusterHash(0xffff0000), // Far
+	clusterHash(0x00),       // a
+	clusterHash(0x03),       // b
+	clusterHash(0x0f),       // c
+}
+
+// Test that hashes chained within the threshold form one cluster
+func TestClusterChained(t *testing.T) {
+	clusters, err := Cluster(chainedHashes, 2, ClusterOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []HashCluster{
+		{Members: []int{1, 2, 3}, Representative: 2, MaxDistance: 4},
+		{Members: []int{0}, Representative: 0, MaxDistance: 0},
+	}
+	if !reflect.DeepEqual(clusters, exp) {
+		t.Errorf("chained clusters test [%v] failed: [%v]", exp, clusters)
+	}
+
+	// Identical hashes are in the same cluster with a threshold of 0
+	same := []Hash{clusterHash(5), clusterHash(6), clusterHash(5)}
+	clusters, _ = Cluster(same, 0, ClusterOptions{})
+	if len(clusters) != 2 || !reflect.DeepEqual(clusters[0].Members, []int{0, 2}) {
+		t.Errorf("identical hashes test [[0 2] [1]] failed: [%v]", clusters)
+	}
+
+	if clusters, err := Cluster(nil, 2, ClusterOptions{}); err != nil || len(clusters) != 0 {
+		t.Errorf("no hashes test [0] failed: [%d %v]", len(clusters), err)
+	}
+}
+
+// Test that complete linkage splits chained clusters
+func TestClusterCompleteLinkage(t *testing.T) {
+	clusters, err := Cluster(chainedHashes, 2, ClusterOptions{CompleteLinkage: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []HashCluster{
+		{Members: []int{1, 2}, Representative: 1, MaxDistance: 2},
+		{Members: []int{0}, Representative: 0, MaxDistance: 0},
+		{Members: []int{3}, Representative: 3, MaxDistance: 0},
+	}
+	if !reflect.DeepEqual(clusters, exp) {
+		t.Errorf("complete linkage test [%v] failed: [%v]", exp, clusters)
+	}
+
+	// Clusters without chains aren't split
+	clusters, _ = Cluster(chainedHashes, 4, ClusterOptions{CompleteLinkage: true})
+	if len(clusters) != 2 || len(clusters[0].Members) != 3 || clusters[0].MaxDistance != 4 {
+		t.Errorf("unchained complete linkage test [3 4] failed: [%v]", clusters)
+	}
+}
+
+// Test the representatives picked by a scorer, and invalid hashes
+func TestClusterScorer(t *testing.T) {
+	resolutions := []float64{100, 200, 300, 50}
+	clusters, _ := Cluster(chainedHashes, 2, ClusterOptions{Scorer: func(i int) float64 { return resolutions[i] }})
+	if clusters[0].Representative != 2 {
+		t.Errorf("scorer representative test [2] failed: [%d]", clusters[0].Representative)
+	}
+	resolutions[1] = 1000
+	clusters, _ = Cluster(chainedHashes, 2, ClusterOptions{Scorer: func(i int) float64 { return resolutions[i] }})
+	if clusters[0].Representative != 1 {
+		t.Errorf("scorer representative test [1] failed: [%d]", clusters[0].Representative)
+	}
+
+	mixed := clusterHash(0)
+	mixed.Algorithm = "ahash:8"
+	newer := clusterHash(0)
+	newer.Version++
+	invalid := [][]Hash{
+		{clusterHash(0), mixed},
+		{clusterHash(0), newer},
+		{{Algorithm: "colormoment", Kind: FloatKind, Vector: FloatHash{1}}},
+		{clusterHash(0), {Algorithm: "dhash-h:8", Value: []byte{0}, Version: DhashVersion}},
+	}
+	for i, hashes := range invalid {
+		if _, err := Cluster(hashes, 2, ClusterOptions{}); err == nil {
+			t.Errorf("invalid hashes %d test didn't fail", i)
+		}
+	}
+	if _, err := Cluster(chainedHashes, -1, ClusterOptions{}); err == nil {
+		t.Errorf("negative threshold test didn't fail")
+	}
+}
+
+// Test that the resized copies of an image are clustered together
+func TestClusterImages(t *testing.T) {
+	hasher, _ := NewHasher("dhash:8")
+	var hashes []Hash
+	for _, name := range []string{"lena_256", "rand_512", "lena_512", "white_512"} {
+		src, _ := OpenImg("./testdata/" + name + ".png")
+		h, _ := hasher.Hash(src)
+		hashes = append(hashes, h)
+	}
+
+	clusters, err := Cluster(hashes, 10, ClusterOptions{CompleteLinkage: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 3 || !reflect.DeepEqual(clusters[0].Members, []int{0, 2}) {
+		t.Errorf("image clusters test [[0 2] [1] [3]] failed: [%v]", clusters)
+	}
+}
+
+// Test that multi-index hashing finds the same clusters as comparing every
+// pair, for several thresholds
+func TestClusterNeighbours(t *testing.T) {
+	// Near-duplicates of a few base hashes
+	rnd := rand.New(rand.NewSource(1))
+	var hashes []Hash
+	for i := 0; i < 300; i++ {
+		v := rnd.Uint64()
+		if i >= 20 {
+			v = binary.BigEndian.Uint64(hashes[i%20].Value)
+			for k := rnd.Intn(8); k > 0; k-- {
+				v ^= 1 << uint(rnd.Intn(64))
+			}
+		}
+		hashes = append(hashes, clusterHash(v))
+	}
+
+	for _, threshold := range []int{0, 1, 3, 6, 20, 64} {
+		uf := newUnionFind(len(hashes))
+		for i := range hashes {
+			for j := i + 1; j < len(hashes); j++ {
+				if GetBitDistance(hashes[i].Value, hashes[j].Value) <= threshold {
+					uf.union(i, j)
+				}
+			}
+		}
+		exp := uf.components()
+
+		clusters, err := Cluster(hashes, threshold, ClusterOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got [][]int
+		for _, c := range clusters {
+			got = append(got, c.Members)
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i][0] < got[j][0] })
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("neighbours test %d [%d clusters] failed: [%d clusters]", threshold, len(exp), len(got))
+		}
+	}
+}
@@ -0,0 +1,122 @@
+/*
+
+Command imagehash-eval measures how robust the imagehash algorithms are to
+common image modifications.
+
+It loads every image of a folder, generates attacked copies of them (JPEG
+quality sweep, resize, crop, rotate, blur, noise, gamma, watermark, flip),
+hashes the originals and the copies with Dhash, DhashHorizontal,
+DhashVertical and Ahash at several sizes, and reports the true and false
+positive rates and the ROC curves.
+
+Usage:
+  imagehash-eval -dir ./photos [-format csv|json] [-roc roc.csv] [-threshold 0.85]
+                 [-algorithms dhash:8,ahash:16]
+
+By default, every algorithm is evaluated at a 'hashLen' of 8, 16 and 32.
+The -algorithms flag picks registered hashers by name instead.
+
+The source images must be distinct, since every image is used as a negative
+example for the others.
+
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/devedge/imagehash/eval"
+)
+
+func main() {
+	dir := flag.String("dir", "", "folder of distinct source images")
+	format := flag.String("format", "csv", "format of the report: csv or json")
+	out := flag.String("out", "", "file to write the report to, instead of stdout")
+	roc := flag.String("roc", "", "file to write the ROC curves to as CSV")
+	threshold := flag.Float64("threshold", 0.85, "similarity threshold of the true and false positive rates, above 0 and up to 1")
+	algorithms := flag.String("algorithms", "", "comma-separated hashers to evaluate, such as dhash:8,ahash:16")
+	flag.Parse()
+
+	if *dir == "" {
+		fmt.Fprintln(os.Stderr, "imagehash-eval: -dir is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *format != "csv" && *format != "json" {
+		fmt.Fprintln(os.Stderr, "imagehash-eval: -format must be csv or json")
+		os.Exit(2)
+	}
+	// A threshold of 0 would count every pair as a match, and a zero
+	// eval.Config.Threshold is replaced by the default
+	if *threshold <= 0 || *threshold > 1 {
+		fmt.Fprintln(os.Stderr, "imagehash-eval: -threshold must be above 0 and up to 1")
+		os.Exit(2)
+	}
+
+	cfg := eval.Config{Threshold: *threshold}
+	if *algorithms != "" {
+		for _, name := range strings.Split(*algorithms, ",") {
+			alg, err := eval.AlgorithmFromHasher(strings.TrimSpace(name))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "imagehash-eval:", err)
+				os.Exit(2)
+			}
+			cfg.Algorithms = append(cfg.Algorithms, alg)
+		}
+	}
+
+	if err := run(*dir, *format, *out, *roc, cfg); err != nil {
+		fmt.Fprintln(os.Stderr, "imagehash-eval:", err)
+		os.Exit(1)
+	}
+}
+
+// run evaluates the images of 'dir' and writes the reports.
+func run(dir, format, out, roc string, cfg eval.Config) error {
+	sources, err := eval.LoadImages(dir)
+	if err != nil {
+		return err
+	}
+
+	report, err := eval.Run(sources, cfg)
+	if err != nil {
+		return err
+	}
+
+	write := report.WriteSummaryCSV
+	if format == "json" {
+		write = report.WriteJSON
+	}
+	if out == "" {
+		err = write(os.Stdout)
+	} else {
+		err = writeFile(out, write)
+	}
+	if err != nil {
+		return err
+	}
+
+	if roc != "" {
+		return writeFile(roc, report.WriteROCCSV)
+	}
+	return nil
+}
+
+// writeFile creates a file and writes it with 'write', returning the error
+// of closing it too, as some write errors are only reported then.
+func writeFile(path string, write func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
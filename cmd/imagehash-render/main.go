@@ -0,0 +1,108 @@
+/*
+
+Command imagehash-render writes the bit grids of the hashes of images as
+PNGs, to see which bits of two hashes differ.
+
+For every image, it writes the grid of its hash to <name>.hash.png. With
+two images, it also writes the grid of the differing bits, highlighted in
+red over the downscaled first image, to <name1>-<name2>.diff.png, and
+prints the distance of the hashes.
+
+Usage:
+  imagehash-render [-algorithm dhash:8] [-out dir] image1 [image2]
+
+The -algorithm flag takes the name of any registered hasher of binary
+hashes, with a preprocessing pipeline or not, such as "equalize|ahash:16".
+
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/devedge/imagehash"
+	"github.com/disintegration/imaging"
+)
+
+func main() {
+	algorithm := flag.String("algorithm", "dhash:8", "hasher to render the hashes of, such as dhash:8 or equalize|ahash:16")
+	out := flag.String("out", ".", "folder to write the PNGs to")
+	flag.Parse()
+
+	if flag.NArg() != 1 && flag.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "imagehash-render: one or two images are required")
+		flag.Usage()
+		os.Exit(2)
+	}
+	hasher, err := imagehash.NewHasher(*algorithm)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "imagehash-render:", err)
+		os.Exit(2)
+	}
+
+	if err := run(hasher, *out, flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "imagehash-render:", err)
+		os.Exit(1)
+	}
+}
+
+// run hashes the images of 'paths' and writes their renders to 'out'.
+func run(hasher imagehash.Hasher, out string, paths []string) error {
+	var hashes []imagehash.Hash
+	var srcs []image.Image
+	for _, path := range paths {
+		src, err := imagehash.OpenImg(path)
+		if err != nil {
+			return err
+		}
+		h, err := hasher.Hash(src)
+		if err != nil {
+			return err
+		}
+		img, err := imagehash.RenderHash(h)
+		if err != nil {
+			return err
+		}
+		if err := imaging.Save(img, filepath.Join(out, baseName(path)+".hash.png")); err != nil {
+			return err
+		}
+		fmt.Printf("%s\t%s\n", path, hex.EncodeToString(h.Value))
+		hashes, srcs = append(hashes, h), append(srcs, src)
+	}
+	if len(hashes) < 2 {
+		return nil
+	}
+
+	dist, err := hashes[0].Distance(hashes[1])
+	if err != nil {
+		return err
+	}
+	// The thumbnail shows the image the way the hasher saw it
+	thumb := srcs[0]
+	if ph, ok := hasher.(*imagehash.PipelineHasher); ok {
+		thumb = ph.Pipeline.Apply(thumb)
+	}
+	img, err := imagehash.RenderDiff(hashes[0], hashes[1], thumb)
+	if err != nil {
+		return err
+	}
+	name := baseName(paths[0]) + "-" + baseName(paths[1]) + ".diff.png"
+	if err := imaging.Save(img, filepath.Join(out, name)); err != nil {
+		return err
+	}
+	fmt.Printf("distance\t%g\n", dist)
+	return nil
+}
+
+// baseName returns the name of a file without its folder and extension.
+func baseName(path string) string {
+	name := filepath.Base(path)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
@@ -0,0 +1,62 @@
+/*
+
+Command imagehash-server serves the imagehash algorithms over HTTP, for
+programs which can't link the package. See the server package for the
+endpoints and the errors.
+
+Usage:
+  imagehash-server [-addr :8080] [-root ./photos] [-algorithms dhash:8,ahash:16]
+                   [-index dhash:8] [-max-body 33554432] [-max-pixels 50000000]
+                   [-concurrency 4] [-timeout 30s] [-slot-timeout 1s]
+
+Local files can only be hashed when -root is set, and only within it.
+
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/devedge/imagehash/server"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	root := flag.String("root", "", "folder of the local files which can be hashed; none if empty")
+	algorithms := flag.String("algorithms", "dhash:8", "comma-separated hashers used when a request names none")
+	maxAlgorithms := flag.Int("max-algorithms", 8, "largest number of hashers a request names")
+	index := flag.String("index", "dhash:8", "hasher of the in-memory index")
+	maxBody := flag.Int64("max-body", 32<<20, "largest request body or local file, in bytes")
+	maxPixels := flag.Int("max-pixels", 50000000, "largest width times height of an image")
+	concurrency := flag.Int("concurrency", 0, "requests hashing at once; the number of CPUs if 0")
+	timeout := flag.Duration("timeout", 30*time.Second, "longest time a request waits and hashes")
+	slotTimeout := flag.Duration("slot-timeout", 0, "longest time a request waits for a slot; -timeout if 0")
+	flag.Parse()
+
+	srv, err := server.New(server.Config{
+		MaxBodyBytes:  *maxBody,
+		MaxPixels:     *maxPixels,
+		MaxConcurrent: *concurrency,
+		Timeout:       *timeout,
+		SlotTimeout:   *slotTimeout,
+		Root:          *root,
+		Algorithms:    strings.Split(*algorithms, ","),
+		MaxAlgorithms: *maxAlgorithms,
+		IndexHasher:   *index,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "imagehash-server:", err)
+		os.Exit(2)
+	}
+
+	log.Printf("imagehash-server listening on %s", *addr)
+	httpSrv := &http.Server{Addr: *addr, Handler: srv, ReadHeaderTimeout: 10 * time.Second}
+	log.Fatal(httpSrv.ListenAndServe())
+}
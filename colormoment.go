@@ -0,0 +1,164 @@
+/*
+
+Implements the color moment hash from OpenCV's img_hash module.
+
+Unlike the other algorithms, this one returns a vector of floats instead of
+bits, and it is based on the colors of the image rather than only on its
+grayscale values.
+
+The image is resized to 512x512 and blurred. Then, it is converted to the
+HSV and YCrCb color spaces, and the seven Hu moments of each of the six
+resulting channels are computed, for a total of 42 values. Hu moments are
+invariant to translation, scale and rotation.
+
+The resulting FloatHash is compared with GetL2Distance.
+
+*/
+
+package imagehash
+
+import (
+	"context"
+	"errors"
+	"image"
+	"math"
+)
+
+// ColorMomentLen is the length of a color moment hash.
+const ColorMomentLen = 42
+
+// ColorMomentHash calculates the color moment hash of an image.
+func ColorMomentHash(img image.Image) (FloatHash, error) {
+	return ColorMomentHashContext(context.Background(), img)
+}
+
+// ColorMomentHashContext is the same as ColorMomentHash, but returns
+// ctx.Err() as soon as the context is done.
+func ColorMomentHashContext(ctx context.Context, img image.Image) (FloatHash, error) {
+	if img.Bounds().Empty() {
+		return nil, errors.New("cannot hash an empty image")
+	}
+
+	// Resize and blur
+	res, err := resizeContext(ctx, img, 512, 512)
+	if err != nil {
+		return nil, err
+	}
+	if res, err = blurContext(ctx, res, 0.8); err != nil {
+		return nil, err
+	}
+
+	// Split the image into its HSV and YCrCb channels
+	var channels [6][][]float64
+	for c := range channels {
+		channels[c] = make([][]float64, 512)
+		for y := range channels[c] {
+			channels[c][y] = make([]float64, 512)
+		}
+	}
+	for y := 0; y < 512; y++ {
+		for x := 0; x < 512; x++ {
+			i := res.PixOffset(x, y)
+			r, g, b := float64(res.Pix[i]), float64(res.Pix[i+1]), float64(res.Pix[i+2])
+			channels[0][y][x], channels[1][y][x], channels[2][y][x] = toHSV(r, g, b)
+			channels[3][y][x], channels[4][y][x], channels[5][y][x] = toYCrCb(r, g, b)
+		}
+	}
+
+	hash := make(FloatHash, 0, ColorMomentLen)
+	for _, channel := range channels {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		hu := huMoments(channel)
+		hash = append(hash, hu[:]...)
+	}
+	return hash, nil
+}
+
+// toHSV converts an RGB color to HSV, using the ranges OpenCV uses for 8 bit
+// images: the hue is between 0 and 180, the saturation and value between 0
+// and 255.
+func toHSV(r, g, b float64) (float64, float64, float64) {
+	v := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	delta := v - min
+
+	var h, s float64
+	if v > 0 {
+		s = 255 * delta / v
+	}
+	if delta > 0 {
+		switch v {
+		case r:
+			h = 60 * (g - b) / delta
+		case g:
+			h = 120 + 60*(b-r)/delta
+		default:
+			h = 240 + 60*(r-g)/delta
+		}
+		if h < 0 {
+			h += 360
+		}
+	}
+	return h / 2, s, v
+}
+
+// toYCrCb converts an RGB color to YCrCb, with every channel between 0 and
+// 255.
+func toYCrCb(r, g, b float64) (float64, float64, float64) {
+	y := 0.299*r + 0.587*g + 0.114*b
+	return y, (r-y)*0.713 + 128, (b-y)*0.564 + 128
+}
+
+// huMoments computes the seven Hu moment invariants of a channel.
+func huMoments(channel [][]float64) [7]float64 {
+	var hu [7]float64
+
+	// Compute the total mass and the centroid
+	var m00, m10, m01 float64
+	for y, row := range channel {
+		for x, v := range row {
+			m00 += v
+			m10 += float64(x) * v
+			m01 += float64(y) * v
+		}
+	}
+	if m00 == 0 {
+		return hu
+	}
+	xc, yc := m10/m00, m01/m00
+
+	// Compute the central moments
+	var mu20, mu11, mu02, mu30, mu21, mu12, mu03 float64
+	for y, row := range channel {
+		dy := float64(y) - yc
+		for x, v := range row {
+			dx := float64(x) - xc
+			mu20 += dx * dx * v
+			mu11 += dx * dy * v
+			mu02 += dy * dy * v
+			mu30 += dx * dx * dx * v
+			mu21 += dx * dx * dy * v
+			mu12 += dx * dy * dy * v
+			mu03 += dy * dy * dy * v
+		}
+	}
+
+	// Normalise them for scale invariance
+	s2 := math.Pow(m00, 2)
+	s3 := math.Pow(m00, 2.5)
+	n20, n11, n02 := mu20/s2, mu11/s2, mu02/s2
+	n30, n21, n12, n03 := mu30/s3, mu21/s3, mu12/s3, mu03/s3
+
+	t0, t1 := n30+n12, n21+n03
+	q0, q1 := t0*t0, t1*t1
+	hu[0] = n20 + n02
+	hu[1] = (n20-n02)*(n20-n02) + 4*n11*n11
+	hu[2] = (n30-3*n12)*(n30-3*n12) + (3*n21-n03)*(3*n21-n03)
+	hu[3] = q0 + q1
+	hu[4] = (n30-3*n12)*t0*(q0-3*q1) + (3*n21-n03)*t1*(3*q0-q1)
+	hu[5] = (n20-n02)*(q0-q1) + 4*n11*t0*t1
+	hu[6] = (3*n21-n03)*t0*(q0-3*q1) - (n30-3*n12)*t1*(3*q0-q1)
+	return hu
+}
@@ -0,0 +1,76 @@
+/*
+
+Testing suite for the color moment hash.
+
+1. Test the length of the color moment hash
+2. Test hashing an empty image
+3. Test that the hashes of a 512px image and 256px image are close
+4. Test that the hashes of unrelated images are far apart
+5. Test that the hash is invariant to a 90 degree rotation
+
+*/
+
+package imagehash
+
+import (
+	"image"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// Test that the hash holds 42 values
+func TestColorMomentHashLength(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_512.png")
+	hash, err := ColorMomentHash(src)
+
+	if err != nil {
+		t.Errorf("color moment hash length test failed with error: %v", err)
+	} else if len(hash) != ColorMomentLen {
+		t.Errorf("color moment hash length test [%d] failed: [%d]", ColorMomentLen, len(hash))
+	}
+}
+
+// Test that an empty image can't be hashed
+func TestColorMomentHashEmpty(t *testing.T) {
+	_, err := ColorMomentHash(image.NewRGBA(image.Rect(0, 0, 0, 0)))
+
+	if err == nil {
+		t.Errorf("empty color moment hash didn't fail")
+	}
+}
+
+// Test that lena_512 and lena_256 return close hashes
+func TestSimilarLenaColorMomentHash(t *testing.T) {
+	lena512, _ := OpenImg("./testdata/lena_512.png")
+	lena256, _ := OpenImg("./testdata/lena_256.png")
+	hash512, _ := ColorMomentHash(lena512)
+	hash256, _ := ColorMomentHash(lena256)
+
+	if dist := GetL2Distance(hash512, hash256); dist > 1e-4 {
+		t.Errorf("similar lena color moment distance is too large: %g", dist)
+	}
+}
+
+// Test that lena_512 and a random image return distant hashes
+func TestDifferentColorMomentHash(t *testing.T) {
+	src1, _ := OpenImg("./testdata/lena_512.png")
+	src2, _ := OpenImg("./testdata/rand_512.png")
+	hash1, _ := ColorMomentHash(src1)
+	hash2, _ := ColorMomentHash(src2)
+
+	if dist := GetL2Distance(hash1, hash2); dist < 1e-3 {
+		t.Errorf("different color moment distance is too small: %g", dist)
+	}
+}
+
+// Test that rotating the image barely changes its hash
+func TestRotatedColorMomentHash(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_512.png")
+	hash1, _ := ColorMomentHash(src)
+	hash2, _ := ColorMomentHash(imaging.Rotate90(src))
+
+	if dist := GetL2Distance(hash1, hash2); dist > 1e-4 {
+		t.Errorf("rotated color moment distance is too large: %g", dist)
+	}
+}
@@ -0,0 +1,105 @@
+/*
+
+Helpers for the *Context variants of the hash functions, which take a
+context.Context and return ctx.Err() as soon as they notice it is done.
+
+The expensive stages of the algorithms (decoding, grayscaling, blurring,
+resizing, filtering...) can't be interrupted once started, so the context
+is checked before and after every one of them. A hash of a huge image is
+then abandoned after the stage running when its deadline passes, instead of
+being computed in full. Decoding reads the image through a reader which
+fails once the context is done, so it is interrupted too.
+
+Usage:
+  ctx,cancel := context.WithTimeout(context.Background(), time.Second)
+  defer cancel()
+  img,err := imagehash.OpenImgContext(ctx, "image.jpg")
+  hash,err := imagehash.DhashContext(ctx, img, 8)
+
+*/
+
+package imagehash
+
+import (
+	"context"
+	"image"
+	"io"
+	"os"
+
+	"github.com/disintegration/imaging"
+)
+
+// HashFuncContext is the signature of the *Context variants of the hash
+// functions, such as DhashContext.
+type HashFuncContext func(ctx context.Context, img image.Image, hashLen int) ([]byte, error)
+
+// OpenImgContext is the same as OpenImg, but stops reading the file and
+// returns ctx.Err() once the context is done.
+func OpenImgContext(ctx context.Context, fp string) (image.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return DecodeContext(ctx, file)
+}
+
+// DecodeContext decodes an image from a reader, in any of the formats
+// 'imaging' supports, and returns ctx.Err() once the context is done.
+func DecodeContext(ctx context.Context, r io.Reader) (image.Image, error) {
+	img, err := imaging.Decode(ctxReader{ctx, r})
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr // The decoder may have wrapped or replaced the error
+	}
+	return img, err
+}
+
+// ctxReader is a reader which fails with ctx.Err() once its context is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr ctxReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+// stage runs an expensive stage of a hash, unless the context is already
+// done, and returns ctx.Err() if the context got done while it ran. The
+// result is recorded if the context has a Trace.
+func stage(ctx context.Context, name string, run func() *image.NRGBA) (*image.NRGBA, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	res := run()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if tr := traceFrom(ctx); tr != nil {
+		tr.addStage(name, res)
+	}
+	return res, nil
+}
+
+// grayscaleContext grayscales an image as a stage of a hash.
+func grayscaleContext(ctx context.Context, img image.Image) (*image.NRGBA, error) {
+	return stage(ctx, "grayscale", func() *image.NRGBA { return imaging.Grayscale(img) })
+}
+
+// resizeContext resizes an image with the Lanczos filter as a stage of a hash.
+func resizeContext(ctx context.Context, img image.Image, width, height int) (*image.NRGBA, error) {
+	return stage(ctx, "resize", func() *image.NRGBA { return imaging.Resize(img, width, height, imaging.Lanczos) })
+}
+
+// blurContext blurs an image as a stage of a hash.
+func blurContext(ctx context.Context, img image.Image, sigma float64) (*image.NRGBA, error) {
+	return stage(ctx, "blur", func() *image.NRGBA { return imaging.Blur(img, sigma) })
+}
@@ -0,0 +1,202 @@
+/*
+
+Testing suite for the *Context variants of decoding and hashing.
+
+1. Test that the *Context variants return the same hashes as the others
+2. Test that every hash function stops on a cancelled context
+3. Test that a deadline passing during a hash stops it
+4. Test decoding with a live and a cancelled context
+5. Test that decoding stops once the context is done mid-read
+6. Test that hashers stop on a cancelled context
+
+*/
+
+package imagehash
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// Test that a live context doesn't change the results
+func TestContextSameHashes(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_256.png")
+	ctx := context.Background()
+
+	funcs := map[string][2]HashFunc{
+		"dhash":   {Dhash, contextFunc(ctx, DhashContext)},
+		"dhash-h": {DhashHorizontal, contextFunc(ctx, DhashHorizontalContext)},
+		"dhash-v": {DhashVertical, contextFunc(ctx, DhashVerticalContext)},
+		"dhash-d": {DhashDiagonal, contextFunc(ctx, DhashDiagonalContext)},
+		"ahash":   {Ahash, contextFunc(ctx, AhashContext)},
+	}
+	for name, f := range funcs {
+		exp, _ := f[0](src, 8)
+		res, err := f[1](src, 8)
+		if err != nil || !bytes.Equal(exp, res) {
+			t.Errorf("%s context test [%x] failed: [%x] %v", name, exp, res, err)
+		}
+	}
+
+	exp, _ := MHhash(src)
+	if res, err := MHhashContext(ctx, src); err != nil || !bytes.Equal(exp, res) {
+		t.Errorf("mhhash context test [%x] failed: [%x] %v", exp, res, err)
+	}
+	exp, _ = RadialHash(src, 180)
+	if res, err := RadialHashContext(ctx, src, 180); err != nil || !bytes.Equal(exp, res) {
+		t.Errorf("radial context test [%x] failed: [%x] %v", exp, res, err)
+	}
+	expCM, _ := ColorMomentHash(src)
+	if res, err := ColorMomentHashContext(ctx, src); err != nil || !reflect.DeepEqual(expCM, res) {
+		t.Errorf("color moment context test [%v] failed: [%v] %v", expCM, res, err)
+	}
+}
+
+// Test that every hash function returns ctx.Err() on a cancelled context
+func TestContextCancelled(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_256.png")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	errs := map[string]error{}
+	for name, f := range map[string]HashFuncContext{
+		"dhash": DhashContext, "dhash-h": DhashHorizontalContext, "dhash-v": DhashVerticalContext,
+		"dhash-d": DhashDiagonalContext, "ahash": AhashContext,
+	} {
+		_, errs[name] = f(ctx, src, 8)
+	}
+	_, errs["mhhash"] = MHhashContext(ctx, src)
+	_, errs["radial"] = RadialHashContext(ctx, src, 180)
+	_, errs["colormoment"] = ColorMomentHashContext(ctx, src)
+	_, errs["dhash variants"] = DhashVariantsContext(ctx, src, 8)
+	_, errs["ahash variants"] = AhashVariantsContext(ctx, src, 8)
+	_, errs["dhash vector"] = DhashVectorContext(ctx, src, 8)
+	_, errs["dhash-h vector"] = DhashHorizontalVectorContext(ctx, src, 8)
+	_, errs["dhash-v vector"] = DhashVerticalVectorContext(ctx, src, 8)
+	_, errs["dhash-d vector"] = DhashDiagonalVectorContext(ctx, src, 8)
+	_, errs["ahash vector"] = AhashVectorContext(ctx, src, 8)
+
+	for name, err := range errs {
+		if err != context.Canceled {
+			t.Errorf("%s cancelled test [%v] failed: [%v]", name, context.Canceled, err)
+		}
+	}
+}
+
+// checksContext is a context whose deadline passes after its Err method
+// was called a number of times, so that it passes at a known point of a
+// hash rather than after a duration.
+type checksContext struct {
+	context.Context
+	mu     sync.Mutex
+	checks int
+}
+
+func (c *checksContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.checks == 0 {
+		return context.DeadlineExceeded
+	}
+	c.checks--
+	return nil
+}
+
+// Test that a deadline passing during the expensive stages stops the hash
+// before the remaining ones
+func TestContextDeadline(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_512.png")
+
+	// The deadline passes while the second stage runs
+	ctx, tr := WithTrace(&checksContext{Context: context.Background(), checks: 3})
+	if _, err := MHhashContext(ctx, src); err != context.DeadlineExceeded {
+		t.Errorf("deadline test [%v] failed: [%v]", context.DeadlineExceeded, err)
+	}
+	if len(tr.Stages) != 1 || len(tr.Grids) != 0 {
+		t.Errorf("stages before deadline test [1 0] failed: [%d %d]", len(tr.Stages), len(tr.Grids))
+	}
+}
+
+// Test decoding an image with a live and a cancelled context
+func TestDecodeContext(t *testing.T) {
+	img, err := OpenImgContext(context.Background(), "./testdata/lena_256.png")
+	if err != nil || img.Bounds().Dx() != 256 {
+		t.Errorf("open with context test failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := OpenImgContext(ctx, "./testdata/lena_256.png"); err != context.Canceled {
+		t.Errorf("cancelled open test [%v] failed: [%v]", context.Canceled, err)
+	}
+}
+
+// Test that a context done in the middle of decoding stops it
+func TestDecodeContextMidRead(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 256, 256)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &cancelReader{r: &buf, after: 64, cancel: cancel}
+	if _, err := DecodeContext(ctx, r); err != context.Canceled {
+		t.Errorf("mid-read decode test [%v] failed: [%v]", context.Canceled, err)
+	}
+}
+
+// Test that hashers stop on a cancelled context
+func TestHasherContext(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_256.png")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, name := range []string{"dhash", "ahash:16", "mhhash", "colormoment"} {
+		hasher, _ := NewHasher(name)
+		if _, ok := hasher.(ContextHasher); !ok {
+			t.Errorf("%s hasher isn't a ContextHasher", name)
+		}
+		if _, err := HashContext(ctx, hasher, src); err != context.Canceled {
+			t.Errorf("%s hasher context test [%v] failed: [%v]", name, context.Canceled, err)
+		}
+	}
+
+	// A hasher without a HashContext method still checks the context
+	if _, err := HashContext(ctx, constHasher{}, src); err != context.Canceled {
+		t.Errorf("third-party hasher context test [%v] failed: [%v]", context.Canceled, err)
+	}
+	if h, err := HashContext(context.Background(), constHasher{}, src); err != nil || h.Algorithm != "const" {
+		t.Errorf("third-party hasher test [const] failed: [%s %v]", h.Algorithm, err)
+	}
+}
+
+// contextFunc binds a context to a HashFuncContext.
+func contextFunc(ctx context.Context, f HashFuncContext) HashFunc {
+	return func(img image.Image, hashLen int) ([]byte, error) {
+		return f(ctx, img, hashLen)
+	}
+}
+
+// cancelReader cancels a context after reading 'after' bytes.
+type cancelReader struct {
+	r      io.Reader
+	after  int
+	read   int
+	cancel context.CancelFunc
+}
+
+func (cr *cancelReader) Read(p []byte) (int, error) {
+	if len(p) > 16 {
+		p = p[:16]
+	}
+	n, err := cr.r.Read(p)
+	cr.read += n
+	if cr.read >= cr.after {
+		cr.cancel()
+	}
+	return n, err
+}
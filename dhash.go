@@ -8,11 +8,17 @@ signature from an image's gradients.
 The image is first grayscaled to reduce every RGB pixel set to the same value.
 Then, it is resized down to 'hashLen' size, with one of the sides 1px
 larger than the other (the width for horizontalGradient(), and the
-height for verticalGradient()).
+height for verticalGradient()). For diagonalGradient(), both sides are 1px
+larger.
 Finally, the gradient difference is calculated. If the current pixel is
 less than the next one, a '1' is appended to the BitArray. Otherwise,
 a '0' is appended.
 
+The *Grid variants take the width and height of the grid of bits
+separately instead of a single 'hashLen', for non-square images such as
+panoramas and banners (e.g. a 16x4 grid), and the *Context variants stop
+between the grayscale and resize stages once their context is done.
+
 TODO Phash? Every new package gets a branch until testing is done
 TODO Benchmarks for every algorithm
 
@@ -21,62 +27,246 @@ TODO Benchmarks for every algorithm
 package imagehash
 
 import (
-	"github.com/disintegration/imaging"
+	"context"
+	"fmt"
 	"image"
 )
 
 // Dhash calculates the horizontal and vertical gradient hashes separately, then
 // concatenates then to return one result as: <horizontal><vertical>.
 // 'img' is an Image object returned by opening an image file using OpenImg().
-// 'hashLen' is the size that the image will be shrunk to. It must be a non-zero multiple of 8.
+// 'hashLen' is the size that the image will be shrunk to. It must be non-zero.
 func Dhash(img image.Image, hashLen int) ([]byte, error) {
-	imgGray := imaging.Grayscale(img) // Grayscale image first for performance
-
-	// Calculate both horizontal and vertical gradients
-	horiz, err1 := horizontalGradient(imgGray, hashLen)
-	vert, err2 := verticalGradient(imgGray, hashLen)
-
-	if err1 != nil {
-		return nil, err1
-	}
-	if err2 != nil {
-		return nil, err2
-	}
-
-	// Return the concatenated horizontal and vertical hash
-	return append(horiz, vert...), nil
+	return DhashGrid(img, hashLen, hashLen)
 }
 
 // DhashHorizontal returns the result of a horizontal gradient hash.
 // 'img' is an Image object returned by opening an image file using OpenImg().
-// 'hashLen' is the size that the image will be shrunk to. It must be a non-zero multiple of 8.
+// 'hashLen' is the size that the image will be shrunk to. It must be non-zero.
 func DhashHorizontal(img image.Image, hashLen int) ([]byte, error) {
-	imgGray := imaging.Grayscale(img)           // Grayscale image first
-	return horizontalGradient(imgGray, hashLen) // horizontal diff gradient
+	return DhashHorizontalGrid(img, hashLen, hashLen)
 }
 
 // DhashVertical returns the result of a vertical gradient hash.
 // 'img' is an Image object returned by opening an image file using OpenImg().
-// 'hashLen' is the size that the image will be shrunk to. It must be a non-zero multiple of 8.
+// 'hashLen' is the size that the image will be shrunk to. It must be non-zero.
 func DhashVertical(img image.Image, hashLen int) ([]byte, error) {
-	imgGray := imaging.Grayscale(img)         // Grayscale image first
-	return verticalGradient(imgGray, hashLen) // vertical diff gradient
+	return DhashVerticalGrid(img, hashLen, hashLen)
+}
+
+// DhashDiagonal returns the result of a diagonal gradient hash, which compares
+// every pixel with the one below and to the right of it.
+// 'img' is an Image object returned by opening an image file using OpenImg().
+// 'hashLen' is the size that the image will be shrunk to. It must be non-zero.
+func DhashDiagonal(img image.Image, hashLen int) ([]byte, error) {
+	return DhashDiagonalGrid(img, hashLen, hashLen)
+}
+
+// DhashGrid is the same as Dhash, on a grid of 'width' by 'height' bits.
+// 'width' and 'height' must be non-zero.
+func DhashGrid(img image.Image, width, height int) ([]byte, error) {
+	return DhashGridContext(context.Background(), img, width, height)
+}
+
+// DhashHorizontalGrid is the same as DhashHorizontal, on a grid of 'width'
+// by 'height' bits. 'width' and 'height' must be non-zero.
+func DhashHorizontalGrid(img image.Image, width, height int) ([]byte, error) {
+	return DhashHorizontalGridContext(context.Background(), img, width, height)
+}
+
+// DhashVerticalGrid is the same as DhashVertical, on a grid of 'width' by
+// 'height' bits. 'width' and 'height' must be non-zero.
+func DhashVerticalGrid(img image.Image, width, height int) ([]byte, error) {
+	return DhashVerticalGridContext(context.Background(), img, width, height)
+}
+
+// DhashDiagonalGrid is the same as DhashDiagonal, on a grid of 'width' by
+// 'height' bits. 'width' and 'height' must be non-zero.
+func DhashDiagonalGrid(img image.Image, width, height int) ([]byte, error) {
+	return DhashDiagonalGridContext(context.Background(), img, width, height)
+}
+
+// DhashContext is the same as Dhash, but returns ctx.Err() as soon as the
+// context is done.
+func DhashContext(ctx context.Context, img image.Image, hashLen int) ([]byte, error) {
+	return DhashGridContext(ctx, img, hashLen, hashLen)
+}
+
+// DhashHorizontalContext is the same as DhashHorizontal, but returns
+// ctx.Err() as soon as the context is done.
+func DhashHorizontalContext(ctx context.Context, img image.Image, hashLen int) ([]byte, error) {
+	return DhashHorizontalGridContext(ctx, img, hashLen, hashLen)
+}
+
+// DhashVerticalContext is the same as DhashVertical, but returns ctx.Err()
+// as soon as the context is done.
+func DhashVerticalContext(ctx context.Context, img image.Image, hashLen int) ([]byte, error) {
+	return DhashVerticalGridContext(ctx, img, hashLen, hashLen)
+}
+
+// DhashDiagonalContext is the same as DhashDiagonal, but returns ctx.Err()
+// as soon as the context is done.
+func DhashDiagonalContext(ctx context.Context, img image.Image, hashLen int) ([]byte, error) {
+	return DhashDiagonalGridContext(ctx, img, hashLen, hashLen)
+}
+
+// DhashGridContext is the same as DhashGrid, but returns ctx.Err() as soon
+// as the context is done.
+func DhashGridContext(ctx context.Context, img image.Image, width, height int) ([]byte, error) {
+	imgGray, err := grayscaleContext(ctx, img) // Grayscale image first for performance
+	if err != nil {
+		return nil, err
+	}
+
+	// Calculate both horizontal and vertical gradients
+	horiz, err := horizontalGradient(ctx, imgGray, width, height)
+	if err != nil {
+		return nil, err
+	}
+	vert, err := verticalGradient(ctx, imgGray, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	// Return the concatenated horizontal and vertical hash
+	return append(horiz.GetArray(), vert.GetArray()...), nil
+}
+
+// DhashHorizontalGridContext is the same as DhashHorizontalGrid, but
+// returns ctx.Err() as soon as the context is done.
+func DhashHorizontalGridContext(ctx context.Context, img image.Image, width, height int) ([]byte, error) {
+	imgGray, err := grayscaleContext(ctx, img) // Grayscale image first
+	if err != nil {
+		return nil, err
+	}
+	return arrayBytes(horizontalGradient(ctx, imgGray, width, height)) // horizontal diff gradient
+}
+
+// DhashVerticalGridContext is the same as DhashVerticalGrid, but returns
+// ctx.Err() as soon as the context is done.
+func DhashVerticalGridContext(ctx context.Context, img image.Image, width, height int) ([]byte, error) {
+	imgGray, err := grayscaleContext(ctx, img) // Grayscale image first
+	if err != nil {
+		return nil, err
+	}
+	return arrayBytes(verticalGradient(ctx, imgGray, width, height)) // vertical diff gradient
+}
+
+// DhashDiagonalGridContext is the same as DhashDiagonalGrid, but returns
+// ctx.Err() as soon as the context is done.
+func DhashDiagonalGridContext(ctx context.Context, img image.Image, width, height int) ([]byte, error) {
+	imgGray, err := grayscaleContext(ctx, img) // Grayscale image first
+	if err != nil {
+		return nil, err
+	}
+	return arrayBytes(diagonalGradient(ctx, imgGray, width, height)) // diagonal diff gradient
+}
+
+// DhashVector is the same as Dhash, but returns a BitVector. The vertical
+// gradient directly follows the horizontal one, without the padding Dhash
+// adds in between when 'hashLen * hashLen' isn't a multiple of 8.
+func DhashVector(img image.Image, hashLen int) (*BitVector, error) {
+	return DhashVectorContext(context.Background(), img, hashLen)
+}
+
+// DhashHorizontalVector is the same as DhashHorizontal, but returns a BitVector.
+func DhashHorizontalVector(img image.Image, hashLen int) (*BitVector, error) {
+	return DhashHorizontalVectorContext(context.Background(), img, hashLen)
+}
+
+// DhashVerticalVector is the same as DhashVertical, but returns a BitVector.
+func DhashVerticalVector(img image.Image, hashLen int) (*BitVector, error) {
+	return DhashVerticalVectorContext(context.Background(), img, hashLen)
+}
+
+// DhashDiagonalVector is the same as DhashDiagonal, but returns a BitVector.
+func DhashDiagonalVector(img image.Image, hashLen int) (*BitVector, error) {
+	return DhashDiagonalVectorContext(context.Background(), img, hashLen)
+}
+
+// DhashVectorContext is the same as DhashVector, but returns ctx.Err() as
+// soon as the context is done.
+func DhashVectorContext(ctx context.Context, img image.Image, hashLen int) (*BitVector, error) {
+	imgGray, err := grayscaleContext(ctx, img) // Grayscale image first for performance
+	if err != nil {
+		return nil, err
+	}
+
+	horiz, err := horizontalGradient(ctx, imgGray, hashLen, hashLen)
+	if err != nil {
+		return nil, err
+	}
+	vert, err := verticalGradient(ctx, imgGray, hashLen, hashLen)
+	if err != nil {
+		return nil, err
+	}
+
+	return horiz.GetVector().concat(vert.GetVector()), nil
+}
+
+// DhashHorizontalVectorContext is the same as DhashHorizontalVector, but
+// returns ctx.Err() as soon as the context is done.
+func DhashHorizontalVectorContext(ctx context.Context, img image.Image, hashLen int) (*BitVector, error) {
+	imgGray, err := grayscaleContext(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+	return arrayVector(horizontalGradient(ctx, imgGray, hashLen, hashLen))
+}
+
+// DhashVerticalVectorContext is the same as DhashVerticalVector, but
+// returns ctx.Err() as soon as the context is done.
+func DhashVerticalVectorContext(ctx context.Context, img image.Image, hashLen int) (*BitVector, error) {
+	imgGray, err := grayscaleContext(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+	return arrayVector(verticalGradient(ctx, imgGray, hashLen, hashLen))
+}
+
+// DhashDiagonalVectorContext is the same as DhashDiagonalVector, but
+// returns ctx.Err() as soon as the context is done.
+func DhashDiagonalVectorContext(ctx context.Context, img image.Image, hashLen int) (*BitVector, error) {
+	imgGray, err := grayscaleContext(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+	return arrayVector(diagonalGradient(ctx, imgGray, hashLen, hashLen))
+}
+
+// validateGrid checks that a grid of 'width' by 'height' bits isn't empty.
+// Grids which don't fill up whole bytes are padded by the BitArray.
+func validateGrid(width, height int) error {
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("invalid %dx%d grid: the width and height must be non-zero", width, height)
+	}
+	return nil
 }
 
 // horizontalGradient performs a horizontal gradient diff on a grayscaled image
-func horizontalGradient(img image.Image, hashLen int) ([]byte, error) {
+func horizontalGradient(ctx context.Context, img image.Image, gridWidth, gridHeight int) (*BitArray, error) {
+	if err := validateGrid(gridWidth, gridHeight); err != nil {
+		return nil, err
+	}
+
 	// Width and height of the scaled-down image
-	width, height := hashLen+1, hashLen
+	width, height := gridWidth+1, gridHeight
 
-	// Downscale the image by 'hashLen' amount for a horizonal diff.
-	res := imaging.Resize(img, width, height, imaging.Lanczos)
+	// Downscale the image to the grid, plus a column, for a horizonal diff.
+	res, err := resizeContext(ctx, img, width, height)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create a new bitArray
-	bitArray, err := NewBitArray(hashLen * hashLen)
+	bitArray, err := NewBitArray(gridWidth * gridHeight)
 	if err != nil {
 		return nil, err
 	}
 
+	// Record the pixels and the bits if tracing
+	grid := traceGrid(ctx, "horizontal", NoThreshold, func() [][]float64 { return grayMatrix(res) })
+
 	var prev uint32 // Variable to store the previous pixel value
 
 	// Calculate the horizonal gradient difference
@@ -88,32 +278,43 @@ func horizontalGradient(img image.Image, hashLen int) ([]byte, error) {
 			// If this is not the first value of the current row, then
 			// compare the gradient difference from the previous one
 			if x > 0 {
+				bit := 0 // if it's not smaller, append '0'
 				if prev < r {
-					bitArray.AppendBit(1) // if it's smaller, append '1'
-				} else {
-					bitArray.AppendBit(0) // else append '0'
+					bit = 1 // if it's smaller, append '1'
 				}
+				bitArray.AppendBit(bit)
+				grid.add(x-1, y, float64(prev>>8), float64(r>>8), bit)
 			}
 			prev = r // Set this current pixel value as the previous one
 		}
 	}
-	return bitArray.GetArray(), nil
+	return bitArray, nil
 }
 
 // verticalGradient performs a vertical gradient diff on a grayscaled image
-func verticalGradient(img image.Image, hashLen int) ([]byte, error) {
+func verticalGradient(ctx context.Context, img image.Image, gridWidth, gridHeight int) (*BitArray, error) {
+	if err := validateGrid(gridWidth, gridHeight); err != nil {
+		return nil, err
+	}
+
 	// Width and height of the scaled-down image
-	width, height := hashLen, hashLen+1
+	width, height := gridWidth, gridHeight+1
 
-	// Downscale the image by 'hashLen' amount for a vertical diff.
-	res := imaging.Resize(img, width, height, imaging.Lanczos)
+	// Downscale the image to the grid, plus a row, for a vertical diff.
+	res, err := resizeContext(ctx, img, width, height)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create a new bitArray
-	bitArray, err := NewBitArray(hashLen * hashLen)
+	bitArray, err := NewBitArray(gridWidth * gridHeight)
 	if err != nil {
 		return nil, err
 	}
 
+	// Record the pixels and the bits if tracing
+	grid := traceGrid(ctx, "vertical", NoThreshold, func() [][]float64 { return grayMatrix(res) })
+
 	var prev uint32 // Variable to store the previous pixel value
 
 	// Calculate the vertical gradient difference
@@ -125,14 +326,57 @@ func verticalGradient(img image.Image, hashLen int) ([]byte, error) {
 			// If this is not the first value of the current column, then
 			// compare the gradient difference from the previous one
 			if y > 0 {
+				bit := 0 // if it's not smaller, append '0'
 				if prev < r {
-					bitArray.AppendBit(1) // if it's smaller, append '1'
-				} else {
-					bitArray.AppendBit(0) // else append '0'
+					bit = 1 // if it's smaller, append '1'
 				}
+				bitArray.AppendBit(bit)
+				grid.add(x, y-1, float64(prev>>8), float64(r>>8), bit)
 			}
 			prev = r // Set this current pixel value as the previous one
 		}
 	}
-	return bitArray.GetArray(), nil
+	return bitArray, nil
+}
+
+// diagonalGradient performs a diagonal gradient diff on a grayscaled image
+func diagonalGradient(ctx context.Context, img image.Image, gridWidth, gridHeight int) (*BitArray, error) {
+	if err := validateGrid(gridWidth, gridHeight); err != nil {
+		return nil, err
+	}
+
+	// Width and height of the scaled-down image
+	width, height := gridWidth+1, gridHeight+1
+
+	// Downscale the image to the grid, plus a row and a column, for a diagonal diff.
+	res, err := resizeContext(ctx, img, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a new bitArray
+	bitArray, err := NewBitArray(gridWidth * gridHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	// Record the pixels and the bits if tracing
+	grid := traceGrid(ctx, "diagonal", NoThreshold, func() [][]float64 { return grayMatrix(res) })
+
+	// Calculate the diagonal gradient difference, row by row
+	for y := 0; y < gridHeight; y++ {
+		for x := 0; x < gridWidth; x++ {
+			// Since the image is grayscaled, r = g = b
+			cur, _, _, _ := res.At(x, y).RGBA()      // Get the pixel at (x,y)
+			next, _, _, _ := res.At(x+1, y+1).RGBA() // and the one diagonal to it
+
+			bit := 0 // if it's not smaller, append '0'
+			if cur < next {
+				bit = 1 // if it's smaller, append '1'
+			}
+			bitArray.AppendBit(bit)
+			grid.add(x, y, float64(cur>>8), float64(next>>8), bit)
+		}
+	}
+	return bitArray, nil
 }
@@ -10,6 +10,11 @@ Testing suite for the dhash algorithm.
 6. Test an invalid vertical dhash length
 7. Test that the dhash of lena_512 matches the precomputed one
 8. Test that the dhash of a 512px image and 256px image are identical
+9. Test the white diagonal dhash
+10. Test that the diagonal dhash of a 512px image and 256px image are close
+11. Test the length of a non-square grid dhash
+12. Test an invalid non-square grid
+13. Test a dhash whose length isn't a multiple of 8
 
 */
 
@@ -17,6 +22,7 @@ package imagehash
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 )
 
@@ -33,7 +39,7 @@ func TestWhiteDhash(t *testing.T) {
 	if bytes.Compare(exp, hash) != 0 {
 		t.Errorf("white dhash test [%x] failed: [%x]", exp, hash)
 	} else if err != nil {
-		t.Errorf("white dhash test failed with error:", err)
+		t.Errorf("white dhash test failed with error: %v", err)
 	}
 }
 
@@ -47,7 +53,7 @@ func TestWhiteDhashHorizontal(t *testing.T) {
 	if bytes.Compare(exp, hash) != 0 {
 		t.Errorf("white horizontal dhash test [%x] failed: [%x]", exp, hash)
 	} else if err != nil {
-		t.Errorf("white horizontal dhash test failed with error:", err)
+		t.Errorf("white horizontal dhash test failed with error: %v", err)
 	}
 }
 
@@ -61,12 +67,11 @@ func TestWhiteDhashVertical(t *testing.T) {
 	if bytes.Compare(exp, hash) != 0 {
 		t.Errorf("white vertical dhash test [%x] failed: [%x]", exp, hash)
 	} else if err != nil {
-		t.Errorf("white vertical dhash test failed with error:", err)
+		t.Errorf("white vertical dhash test failed with error: %v", err)
 	}
 }
 
-// Test passing in an invalid hashLen. The result of hashLen * hashLen must
-// be a multiple of 8, and non-zero.
+// Test passing in an invalid hashLen. It must be non-zero.
 // (These three following tests only check 0, since bitarray_test.go cover
 // all other cases)
 func TestDhashZeroHashLen(t *testing.T) {
@@ -105,7 +110,7 @@ func TestLenaDhashHorizontal(t *testing.T) {
 	if bytes.Compare(exp, hash) != 0 {
 		t.Errorf("basic lena_512 dhash test [%x] failed: [%x]", exp, hash)
 	} else if err != nil {
-		t.Errorf("basic lena_512 dhash test failed with error:", err)
+		t.Errorf("basic lena_512 dhash test failed with error: %v", err)
 	}
 }
 
@@ -119,8 +124,72 @@ func TestSimilarLenaDhash(t *testing.T) {
 	if bytes.Compare(hashlena512, hashlena256) != 0 {
 		t.Errorf("similar lena dhash test [%x] failed: [%x]", hashlena512, hashlena256)
 	} else if err1 != nil {
-		t.Errorf("similar lena dhash test failed with error:", err1)
+		t.Errorf("similar lena dhash test failed with error: %v", err1)
 	} else if err2 != nil {
-		t.Errorf("similar lena dhash test failed with error:", err2)
+		t.Errorf("similar lena dhash test failed with error: %v", err2)
+	}
+}
+
+// Test the diagonal dhash wrapper function using a white image. The
+// resultant byte array should be all zeros
+func TestWhiteDhashDiagonal(t *testing.T) {
+	src, _ := OpenImg("./testdata/white_512.png")
+	hash, err := DhashDiagonal(src, 8)
+	exp := make([]byte, 8) // initialize a byte array full of zeros
+
+	if bytes.Compare(exp, hash) != 0 {
+		t.Errorf("white diagonal dhash test [%x] failed: [%x]", exp, hash)
+	} else if err != nil {
+		t.Errorf("white diagonal dhash test failed with error: %v", err)
+	}
+}
+
+// Test that lena_512.png and lena_256.png return close diagonal dhash results.
+func TestSimilarLenaDhashDiagonal(t *testing.T) {
+	lena512, _ := OpenImg("./testdata/lena_512.png")
+	lena256, _ := OpenImg("./testdata/lena_256.png")
+	hashlena512, _ := DhashDiagonal(lena512, 8)
+	hashlena256, _ := DhashDiagonal(lena256, 8)
+
+	if dist := GetDistance(hashlena512, hashlena256); dist > 1 {
+		t.Errorf("similar lena diagonal dhash distance is too large: %d", dist)
+	}
+}
+
+// Test that a 16x4 grid returns 64 bits per gradient
+func TestDhashGridLength(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_512.png")
+	hash, err := DhashGrid(src, 16, 4)
+
+	if err != nil {
+		t.Errorf("grid dhash test failed with error: %v", err)
+	} else if len(hash) != 16 {
+		t.Errorf("grid dhash test [16] failed: [%d]", len(hash))
+	}
+}
+
+// Test that an empty grid is rejected with an error naming the grid
+func TestDhashInvalidGrid(t *testing.T) {
+	src, _ := OpenImg("./testdata/white_512.png")
+	_, err := DhashDiagonalGrid(src, 16, 0)
+
+	if err == nil {
+		t.Errorf("invalid 16x0 grid didn't fail")
+	} else if !strings.Contains(err.Error(), "16x0") {
+		t.Errorf("invalid grid error doesn't name the grid: %v", err)
+	}
+}
+
+// Test that a hashLen of 5 returns 25 bits, padded to 4 bytes with zeros
+func TestDhashPaddedHashLen(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_512.png")
+	hash, err := DhashHorizontal(src, 5)
+
+	if err != nil {
+		t.Errorf("padded dhash test failed with error: %v", err)
+	} else if len(hash) != 4 {
+		t.Errorf("padded dhash test [4] failed: [%d]", len(hash))
+	} else if hash[3]&0x7f != 0 {
+		t.Errorf("padded dhash test has non-zero padding: [%x]", hash)
 	}
 }
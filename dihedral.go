@@ -0,0 +1,212 @@
+/*
+
+Implements rotation- and flip-invariant matching by hashing the eight
+dihedral variants of an image: the original, its rotations by 90, 180 and
+270 degrees, its horizontal and vertical mirrors, and its transpose and
+transverse.
+
+For most algorithms every variant is produced by transforming the image and
+hashing it again. Ahash is computed on a square grid whose bits are a plain
+function of the pixel positions, so its variants are instead obtained by
+permuting the bits of the original hash.
+
+Usage:
+  variants,err := imagehash.DhashVariants(src, 8)
+  dist,orientation := imagehash.GetDistanceVariants(variants, hash)
+
+*/
+
+package imagehash
+
+import (
+	"context"
+	"errors"
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// Orientation is one of the eight dihedral transforms of an image.
+type Orientation int
+
+// The eight dihedral transforms. Rotations are counter-clockwise, matching
+// the rotation functions from 'imaging'.
+const (
+	Identity   Orientation = iota // Unchanged
+	Rotate90                      // Rotated 90 degrees counter-clockwise
+	Rotate180                     // Rotated 180 degrees
+	Rotate270                     // Rotated 270 degrees counter-clockwise
+	FlipH                         // Mirrored left to right
+	FlipV                         // Mirrored top to bottom
+	Transpose                     // Flipped along the top-left to bottom-right diagonal
+	Transverse                    // Flipped along the bottom-left to top-right diagonal
+)
+
+// NumOrientations is the number of dihedral variants returned by the
+// *Variants functions.
+const NumOrientations = 8
+
+var orientationNames = [NumOrientations]string{
+	"identity", "rotate90", "rotate180", "rotate270",
+	"fliph", "flipv", "transpose", "transverse",
+}
+
+// String returns the lowercase name of the orientation.
+func (o Orientation) String() string {
+	if o < 0 || o >= NumOrientations {
+		return "unknown"
+	}
+	return orientationNames[o]
+}
+
+// Apply returns a copy of 'img' transformed by the orientation.
+func (o Orientation) Apply(img image.Image) image.Image {
+	switch o {
+	case Rotate90:
+		return imaging.Rotate90(img)
+	case Rotate180:
+		return imaging.Rotate180(img)
+	case Rotate270:
+		return imaging.Rotate270(img)
+	case FlipH:
+		return imaging.FlipH(img)
+	case FlipV:
+		return imaging.FlipV(img)
+	case Transpose:
+		return imaging.Transpose(img)
+	case Transverse:
+		return imaging.Transverse(img)
+	}
+	return img
+}
+
+// source returns the coordinates in an untransformed n*n grid of the cell
+// that ends up at (x,y) once the orientation is applied.
+func (o Orientation) source(x, y, n int) (int, int) {
+	switch o {
+	case Rotate90:
+		return n - 1 - y, x
+	case Rotate180:
+		return n - 1 - x, n - 1 - y
+	case Rotate270:
+		return y, n - 1 - x
+	case FlipH:
+		return n - 1 - x, y
+	case FlipV:
+		return x, n - 1 - y
+	case Transpose:
+		return y, x
+	case Transverse:
+		return n - 1 - y, n - 1 - x
+	}
+	return x, y
+}
+
+// HashFunc is the signature shared by the package's hashing functions, such
+// as Dhash and Ahash.
+type HashFunc func(img image.Image, hashLen int) ([]byte, error)
+
+// HashVariants hashes all eight dihedral variants of an image using 'hash'.
+// The returned slice is indexed by Orientation, so variants[Rotate90] is the
+// hash of the image rotated by 90 degrees.
+func HashVariants(img image.Image, hashLen int, hash HashFunc) ([][]byte, error) {
+	return HashVariantsContext(context.Background(), img, hashLen,
+		func(ctx context.Context, img image.Image, hashLen int) ([]byte, error) { return hash(img, hashLen) })
+}
+
+// HashVariantsContext is the same as HashVariants, but returns ctx.Err() as
+// soon as the context is done.
+func HashVariantsContext(ctx context.Context, img image.Image, hashLen int, hash HashFuncContext) ([][]byte, error) {
+	variants := make([][]byte, NumOrientations)
+	for o := Identity; o < NumOrientations; o++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		h, err := hash(ctx, o.Apply(img), hashLen)
+		if err != nil {
+			return nil, err
+		}
+		variants[o] = h
+	}
+	return variants, nil
+}
+
+// DhashVariants returns the Dhash of all eight dihedral variants of an image,
+// indexed by Orientation.
+func DhashVariants(img image.Image, hashLen int) ([][]byte, error) {
+	return HashVariants(img, hashLen, Dhash)
+}
+
+// DhashVariantsContext is the same as DhashVariants, but returns ctx.Err()
+// as soon as the context is done.
+func DhashVariantsContext(ctx context.Context, img image.Image, hashLen int) ([][]byte, error) {
+	return HashVariantsContext(ctx, img, hashLen, DhashContext)
+}
+
+// AhashVariants returns the Ahash of all eight dihedral variants of an image,
+// indexed by Orientation. The image is only hashed once; every other variant
+// is a permutation of the bits of the original hash.
+func AhashVariants(img image.Image, hashLen int) ([][]byte, error) {
+	return AhashVariantsContext(context.Background(), img, hashLen)
+}
+
+// AhashVariantsContext is the same as AhashVariants, but returns ctx.Err()
+// as soon as the context is done.
+func AhashVariantsContext(ctx context.Context, img image.Image, hashLen int) ([][]byte, error) {
+	hash, err := AhashContext(ctx, img, hashLen)
+	if err != nil {
+		return nil, err
+	}
+
+	variants := make([][]byte, NumOrientations)
+	for o := Identity; o < NumOrientations; o++ {
+		variants[o], err = permuteAhash(hash, hashLen, o)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return variants, nil
+}
+
+// permuteAhash rearranges the bits of an Ahash so that it matches the hash of
+// the transformed image. Ahash visits the grid column by column, so the bit
+// for the pixel at (x,y) is at index x*hashLen + y.
+func permuteAhash(hash []byte, hashLen int, o Orientation) ([]byte, error) {
+	if len(hash)*8 < hashLen*hashLen {
+		return nil, errors.New("hash is too short for a grid of the given 'hashLen'")
+	}
+
+	bitArray, err := NewBitArray(hashLen * hashLen)
+	if err != nil {
+		return nil, err
+	}
+
+	for x := 0; x < hashLen; x++ {
+		for y := 0; y < hashLen; y++ {
+			sx, sy := o.source(x, y, hashLen)
+			bitArray.AppendBit(hashBit(hash, sx*hashLen+sy))
+		}
+	}
+	return bitArray.GetArray(), nil
+}
+
+// hashBit returns the i'th bit of a hash, counting from the most significant
+// bit of the first byte.
+func hashBit(hash []byte, i int) int {
+	return int(hash[i/8]>>(7-uint(i%8))) & 1
+}
+
+// GetDistanceVariants compares 'hash' against every dihedral variant
+// returned by one of the *Variants functions. It returns the smallest
+// distance found, along with the orientation that produced it. If the
+// distance is small, 'hash' is likely of the original image transformed by
+// that orientation. If 'variants' is empty, the distance is -1.
+func GetDistanceVariants(variants [][]byte, hash []byte) (int, Orientation) {
+	best, bestOrientation := -1, Identity
+	for o, variant := range variants {
+		if dist := GetDistance(variant, hash); best < 0 || dist < best {
+			best, bestOrientation = dist, Orientation(o)
+		}
+	}
+	return best, bestOrientation
+}
@@ -0,0 +1,110 @@
+/*
+
+Testing suite for the dihedral hash variants.
+
+1. Test that the Ahash bit permutations match re-hashing the transformed image
+2. Test that a rotated image is matched by its Dhash variants
+3. Test that a mirrored image is matched by its Ahash variants
+4. Test that unrelated images are not matched by any variant
+5. Test an invalid hashLen for the variants
+
+*/
+
+package imagehash
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// randomGray returns a random grayscale image of size n*n. Since no resizing
+// happens when hashing it with a hashLen of n, its hashes are exact.
+func randomGray(n int, seed int64) image.Image {
+	rnd := rand.New(rand.NewSource(seed))
+	img := image.NewGray(image.Rect(0, 0, n, n))
+	for x := 0; x < n; x++ {
+		for y := 0; y < n; y++ {
+			img.SetGray(x, y, color.Gray{uint8(rnd.Intn(256))})
+		}
+	}
+	return img
+}
+
+// Test that every permuted Ahash variant is identical to the Ahash
+// of the transformed image
+func TestAhashVariantsPermutation(t *testing.T) {
+	src := randomGray(8, 1)
+	variants, err := AhashVariants(src, 8)
+	if err != nil {
+		t.Errorf("ahash variants test failed with error: %v", err)
+		return
+	}
+
+	for o := Identity; o < NumOrientations; o++ {
+		exp, _ := Ahash(o.Apply(src), 8)
+		if bytes.Compare(exp, variants[o]) != 0 {
+			t.Errorf("ahash %s variant [%x] failed: [%x]", o, exp, variants[o])
+		}
+	}
+}
+
+// Test that the Dhash of a rotated image matches the Rotate90 variant
+func TestDhashVariantsRotated(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_512.png")
+	variants, err := DhashVariants(src, 8)
+	if err != nil {
+		t.Errorf("dhash variants test failed with error: %v", err)
+		return
+	}
+
+	hash, _ := Dhash(imaging.Rotate90(src), 8)
+	dist, o := GetDistanceVariants(variants, hash)
+
+	if dist != 0 || o != Rotate90 {
+		t.Errorf("rotated dhash matched %s with distance %d", o, dist)
+	}
+}
+
+// Test that the Ahash of a mirrored image matches the FlipH variant
+func TestAhashVariantsMirrored(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_512.png")
+	variants, _ := AhashVariants(src, 8)
+
+	hash, _ := Ahash(imaging.FlipH(src), 8)
+	dist, o := GetDistanceVariants(variants, hash)
+
+	if dist > 1 || o != FlipH {
+		t.Errorf("mirrored ahash matched %s with distance %d", o, dist)
+	}
+}
+
+// Test that an unrelated image stays far from every variant
+func TestDhashVariantsUnrelated(t *testing.T) {
+	src1, _ := OpenImg("./testdata/lena_512.png")
+	src2, _ := OpenImg("./testdata/rand_512.png")
+	variants, _ := DhashVariants(src1, 8)
+
+	hash, _ := Dhash(src2, 8)
+	dist, _ := GetDistanceVariants(variants, hash)
+
+	if dist < 10 {
+		t.Errorf("unrelated images are too close: %d", dist)
+	}
+}
+
+// Test that errors from the underlying hash are passed up
+func TestVariantsZeroHashLen(t *testing.T) {
+	src, _ := OpenImg("./testdata/white_512.png")
+
+	if _, err := DhashVariants(src, 0); err == nil {
+		t.Errorf("zero dhash variants hashLen didn't fail")
+	}
+	if _, err := AhashVariants(src, 0); err == nil {
+		t.Errorf("zero ahash variants hashLen didn't fail")
+	}
+}
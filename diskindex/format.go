@@ -0,0 +1,260 @@
+/*
+
+Package diskindex is a compact on-disk index of image hashes, which can be
+memory-mapped and searched without first loading it onto the heap.
+
+An index holds the hashes of a single algorithm (such as "dhash-h:16"),
+each of the same number of bits, along with a 64 bit id per hash. Records
+are appended in segments by a Writer, and Compact merges the segments back
+into one. A Reader maps the file, and finds the hashes within a Hamming
+distance of a query, either by scanning every record or by only visiting
+the buckets which can hold a match. The version of the algorithm can be
+stored in the header, and SearchHash rejects the queries hashed by another
+version of it, as imagehash.Hash.Distance does.
+
+Usage:
+  w,err := diskindex.Create("photos.idx", "dhash:8", 128, nil)
+  w.Add(42, hash)
+  err = w.Close()
+
+  r,err := diskindex.Open("photos.idx")
+  results,err := r.SearchHash(query, 10)
+  r.Close()
+
+
+File format, version 2
+
+Every integer is little-endian. The file starts with a 64 byte header:
+
+  offset  size  field
+  0       4     magic "IHIX"
+  4       2     version, 2
+  6       2     bucket bits B, from 0 to 24
+  8       4     bits per hash N, from 1 to 65536
+  12      4     number of segments
+  16      8     number of records, over every segment
+  24      32    algorithm name, padded with NUL bytes
+  56      4     version of the algorithm (imagehash.Hash.Version), 0 if unknown
+  60      4     CRC-32C of bytes 0 to 59
+
+It is followed by the segments, one after the other. Bytes past the last
+segment counted in the header are ignored; they are left by an append which
+didn't complete, and are overwritten by the next one. Every segment starts
+with a 48 byte segment header:
+
+  offset  size  field
+  0       4     magic "IHSG"
+  4       4     reserved, 0
+  8       8     number of records R
+  16      4     CRC-32C of the hashes section
+  20      4     CRC-32C of the ids section
+  24      4     CRC-32C of the buckets section
+  28      16    reserved, 0
+  44      4     CRC-32C of bytes 0 to 43
+
+followed by three sections:
+
+  hashes   R * W * 8 bytes, W = ceil(N / 64). Every hash is W 64 bit words,
+           the first bit of the hash being the most significant bit of the
+           first word. The bits past N are 0.
+  ids      R * 8 bytes, the id of every hash, in the same order.
+  buckets  (2^S + 1) * 8 bytes, S = min(B, bit length of R), so that the
+           buckets section of a small segment stays small. The bucket of a
+           hash is its first S bits, and the records of a segment are
+           sorted by bucket. Entry k is the index of the first record of
+           bucket k, and entry 2^S is R.
+
+Version 1 is the same, except that every buckets section has 2^B + 1
+entries. Version 1 files are still read, and appended to as version 1.
+
+A new version of the format gets a new version number; readers reject the
+versions they don't know.
+
+*/
+
+package diskindex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Version is the version of the file format written by this package.
+const Version = 2
+
+// Limits of the file format.
+const (
+	MaxBucketBits   = 24    // Largest number of bucket bits
+	MaxHashBits     = 65536 // Largest number of bits per hash
+	MaxAlgorithmLen = 32    // Longest algorithm name, in bytes
+)
+
+// DefaultBucketBits is the number of bucket bits used when the Options
+// don't give one, or fewer if the hashes are shorter.
+const DefaultBucketBits = 16
+
+const (
+	fileMagic         = "IHIX"
+	segmentMagic      = "IHSG"
+	headerSize        = 64
+	segmentHeaderSize = 48
+)
+
+// castagnoli is the CRC-32C table, which most CPUs compute in hardware.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// CorruptError is returned when an index file doesn't follow the format,
+// or doesn't match its checksums.
+type CorruptError struct {
+	Path   string
+	Reason string
+}
+
+func (e *CorruptError) Error() string {
+	return "corrupt index " + e.Path + ": " + e.Reason
+}
+
+// header is the file header.
+type header struct {
+	format     int // Version of the file format
+	bucketBits int
+	bits       int
+	segments   int
+	records    uint64
+	algorithm  string
+	version    uint32 // Version of the algorithm
+}
+
+// words returns the number of 64 bit words per hash.
+func (h header) words() int {
+	return (h.bits + 63) / 64
+}
+
+// segmentSize returns the size in bytes of a segment of 'records' records,
+// including its header.
+func (h header) segmentSize(records uint64) int64 {
+	return segmentHeaderSize + int64(records)*int64(h.words()*8+8) + bucketsSize(h.segmentBucketBits(records))
+}
+
+// segmentBucketBits returns the number of bucket bits of a segment of
+// 'records' records, which is at most the bit length of 'records'.
+func (h header) segmentBucketBits(records uint64) int {
+	if b := bits.Len64(records); h.format != 1 && b < h.bucketBits {
+		return b
+	}
+	return h.bucketBits
+}
+
+// bucketsSize returns the size in bytes of the buckets section.
+func bucketsSize(bucketBits int) int64 {
+	return ((1 << uint(bucketBits)) + 1) * 8
+}
+
+// validate checks the fields which are set by the user.
+func (h header) validate() error {
+	if h.bits <= 0 || h.bits > MaxHashBits {
+		return errors.New("the bits per hash must be between 1 and " + strconv.Itoa(MaxHashBits))
+	}
+	if h.bucketBits < 0 || h.bucketBits > MaxBucketBits || h.bucketBits > h.bits {
+		return errors.New("the bucket bits must be between 0 and " + strconv.Itoa(MaxBucketBits) +
+			", and at most the bits per hash")
+	}
+	if len(h.algorithm) > MaxAlgorithmLen || strings.IndexByte(h.algorithm, 0) >= 0 {
+		return errors.New("the algorithm name must be at most " + strconv.Itoa(MaxAlgorithmLen) +
+			" bytes, without NUL bytes")
+	}
+	return nil
+}
+
+// marshal encodes the file header.
+func (h header) marshal() []byte {
+	b := make([]byte, headerSize)
+	copy(b, fileMagic)
+	binary.LittleEndian.PutUint16(b[4:], uint16(h.format))
+	binary.LittleEndian.PutUint16(b[6:], uint16(h.bucketBits))
+	binary.LittleEndian.PutUint32(b[8:], uint32(h.bits))
+	binary.LittleEndian.PutUint32(b[12:], uint32(h.segments))
+	binary.LittleEndian.PutUint64(b[16:], h.records)
+	copy(b[24:56], h.algorithm)
+	binary.LittleEndian.PutUint32(b[56:], h.version)
+	binary.LittleEndian.PutUint32(b[60:], crc32.Checksum(b[:60], castagnoli))
+	return b
+}
+
+// parseHeader decodes and checks a file header.
+func parseHeader(b []byte) (header, error) {
+	if len(b) < headerSize || string(b[:4]) != fileMagic {
+		return header{}, errors.New("not an index file")
+	}
+	if crc32.Checksum(b[:60], castagnoli) != binary.LittleEndian.Uint32(b[60:]) {
+		return header{}, errors.New("header checksum mismatch")
+	}
+	v := int(binary.LittleEndian.Uint16(b[4:]))
+	if v != 1 && v != Version {
+		return header{}, errors.New("unsupported format version " + strconv.Itoa(v))
+	}
+
+	h := header{
+		format:     v,
+		bucketBits: int(binary.LittleEndian.Uint16(b[6:])),
+		bits:       int(binary.LittleEndian.Uint32(b[8:])),
+		segments:   int(binary.LittleEndian.Uint32(b[12:])),
+		records:    binary.LittleEndian.Uint64(b[16:]),
+		algorithm:  string(bytes.TrimRight(b[24:56], "\x00")),
+		version:    binary.LittleEndian.Uint32(b[56:]),
+	}
+	if err := h.validate(); err != nil {
+		return header{}, err
+	}
+	return h, nil
+}
+
+// segmentHeader is the header of a segment.
+type segmentHeader struct {
+	records    uint64
+	hashesCRC  uint32
+	idsCRC     uint32
+	bucketsCRC uint32
+}
+
+// marshal encodes the segment header.
+func (s segmentHeader) marshal() []byte {
+	b := make([]byte, segmentHeaderSize)
+	copy(b, segmentMagic)
+	binary.LittleEndian.PutUint64(b[8:], s.records)
+	binary.LittleEndian.PutUint32(b[16:], s.hashesCRC)
+	binary.LittleEndian.PutUint32(b[20:], s.idsCRC)
+	binary.LittleEndian.PutUint32(b[24:], s.bucketsCRC)
+	binary.LittleEndian.PutUint32(b[44:], crc32.Checksum(b[:44], castagnoli))
+	return b
+}
+
+// parseSegmentHeader decodes and checks a segment header.
+func parseSegmentHeader(b []byte) (segmentHeader, error) {
+	if len(b) < segmentHeaderSize || string(b[:4]) != segmentMagic {
+		return segmentHeader{}, errors.New("missing segment")
+	}
+	if crc32.Checksum(b[:44], castagnoli) != binary.LittleEndian.Uint32(b[44:]) {
+		return segmentHeader{}, errors.New("segment header checksum mismatch")
+	}
+
+	return segmentHeader{
+		records:    binary.LittleEndian.Uint64(b[8:]),
+		hashesCRC:  binary.LittleEndian.Uint32(b[16:]),
+		idsCRC:     binary.LittleEndian.Uint32(b[20:]),
+		bucketsCRC: binary.LittleEndian.Uint32(b[24:]),
+	}, nil
+}
+
+// bucketOf returns the bucket of a hash: its first 'bucketBits' bits.
+func bucketOf(firstWord uint64, bucketBits int) int {
+	if bucketBits == 0 {
+		return 0
+	}
+	return int(firstWord >> (64 - uint(bucketBits)))
+}
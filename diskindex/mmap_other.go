@@ -0,0 +1,27 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+/*
+
+On the platforms without mmap support in the syscall package, index files
+are read in memory instead, so the package still works, but the whole index
+ends up on the heap.
+
+*/
+
+package diskindex
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the first 'size' bytes of a file, and returns a function
+// releasing them.
+func mapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
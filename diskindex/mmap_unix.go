@@ -0,0 +1,35 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+/*
+
+Maps index files in memory with mmap, so that the pages of an index are
+only read from disk when a search visits them, and are shared between the
+processes reading the same index.
+
+*/
+
+package diskindex
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// mapFile maps the first 'size' bytes of a file read-only, and returns the
+// function unmapping them.
+func mapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	if size == 0 {
+		return nil, func() error { return nil }, nil
+	}
+	if int64(int(size)) != size {
+		return nil, nil, errors.New("index file too large to map")
+	}
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
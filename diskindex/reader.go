@@ -0,0 +1,378 @@
+/*
+
+The Reader of an index file. The file is memory-mapped, and only its
+headers are verified when it is opened, so that opening a large index
+doesn't read all of it. Verify checks the sections of the segments against
+their checksums; searches only check the bucket offsets they use, so a
+corrupt index fails a search rather than crash it. Searches read the
+hashes straight from the mapping: only the results are allocated.
+
+A range query returns every record within a Hamming distance of a query.
+SearchLinear compares the query with every record. SearchBucketed relies on
+the records being sorted by bucket: a hash within a distance 'r' of the
+query has a bucket within a distance 'r' of the query's bucket, so only the
+buckets differing from the query's one by at most 'r' bits are visited.
+Search picks whichever visits fewer records, segment by segment, as every
+segment has its own number of bucket bits.
+
+*/
+
+package diskindex
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math/bits"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/devedge/imagehash"
+)
+
+// Reader searches a memory-mapped index file.
+type Reader struct {
+	path     string
+	data     []byte
+	unmap    func() error
+	hdr      header
+	segments []segment
+}
+
+// segment holds the sections of a segment, as slices of the mapping.
+type segment struct {
+	header     segmentHeader
+	records    int
+	bucketBits int
+	hashes     []byte
+	ids        []byte
+	buckets    []byte
+}
+
+// Result is a record matching a query.
+type Result struct {
+	ID       uint64
+	Distance int // Number of bits differing from the query
+}
+
+// Open maps an index file and verifies its headers. The Reader must be
+// closed to unmap the file.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, unmap, err := mapFile(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reader{path: path, data: data, unmap: unmap}
+	if reason := r.load(); reason != "" {
+		unmap()
+		return nil, &CorruptError{path, reason}
+	}
+	return r, nil
+}
+
+// load parses the mapped file, and returns the reason it is corrupt, if it
+// is.
+func (r *Reader) load() string {
+	hdr, err := parseHeader(r.data)
+	if err != nil {
+		return err.Error()
+	}
+	r.hdr = hdr
+
+	stride := int64(hdr.words() * 8)
+	off, size := int64(headerSize), int64(len(r.data))
+	var total uint64
+	for i := 0; i < hdr.segments; i++ {
+		if off+segmentHeaderSize > size {
+			return "truncated segment " + strconv.Itoa(i)
+		}
+		sh, err := parseSegmentHeader(r.data[off:])
+		if err != nil {
+			return err.Error()
+		}
+		// Check the number of records before it is used in any product
+		if sh.records > uint64(size)/uint64(stride+8) || off+hdr.segmentSize(sh.records) > size {
+			return "truncated segment " + strconv.Itoa(i)
+		}
+
+		n := int64(sh.records)
+		start := off + segmentHeaderSize
+		seg := segment{
+			header:     sh,
+			records:    int(n),
+			bucketBits: hdr.segmentBucketBits(sh.records),
+			hashes:     r.data[start : start+n*stride],
+			ids:        r.data[start+n*stride : start+n*(stride+8)],
+			buckets:    r.data[start+n*(stride+8) : off+hdr.segmentSize(sh.records)],
+		}
+		r.segments = append(r.segments, seg)
+		total += sh.records
+		off += hdr.segmentSize(sh.records)
+	}
+
+	if total != hdr.records {
+		return "the header counts " + strconv.FormatUint(hdr.records, 10) +
+			" records, but the segments hold " + strconv.FormatUint(total, 10)
+	}
+	return ""
+}
+
+// Verify checks the sections of every segment against their checksums, and
+// that their bucket offsets are in order, reading the whole file.
+func (r *Reader) Verify() error {
+	for i, seg := range r.segments {
+		if crc32.Checksum(seg.hashes, castagnoli) != seg.header.hashesCRC ||
+			crc32.Checksum(seg.ids, castagnoli) != seg.header.idsCRC ||
+			crc32.Checksum(seg.buckets, castagnoli) != seg.header.bucketsCRC {
+			return &CorruptError{r.path, "checksum mismatch in segment " + strconv.Itoa(i)}
+		}
+
+		// Bucket 0 starts at the first record, and the last entry is the
+		// number of records
+		prev := 0
+		for k := 0; k <= 1<<uint(seg.bucketBits); k++ {
+			next := seg.bucket(k)
+			if next < prev || next > seg.records || (k == 0 && next != 0) {
+				return invalidBuckets(r.path, i)
+			}
+			prev = next
+		}
+		if prev != seg.records {
+			return invalidBuckets(r.path, i)
+		}
+	}
+	return nil
+}
+
+// Close unmaps the file. The Reader can't be used afterwards.
+func (r *Reader) Close() error {
+	r.segments = nil
+	return r.unmap()
+}
+
+// Algorithm returns the name of the algorithm of the hashes.
+func (r *Reader) Algorithm() string {
+	return r.hdr.algorithm
+}
+
+// AlgorithmVersion returns the version of the algorithm of the hashes, as
+// in imagehash.Hash.Version, or 0 if unknown.
+func (r *Reader) AlgorithmVersion() int {
+	return int(r.hdr.version)
+}
+
+// Bits returns the number of bits per hash.
+func (r *Reader) Bits() int {
+	return r.hdr.bits
+}
+
+// Len returns the number of records.
+func (r *Reader) Len() int {
+	return int(r.hdr.records)
+}
+
+// Each calls 'fn' with the id and the hash of every record, until it
+// returns false. The hash is a new byte array, as it was given to
+// Writer.Add.
+func (r *Reader) Each(fn func(id uint64, hash []byte) bool) {
+	numBytes := (r.hdr.bits + 7) / 8
+	r.each(func(id uint64, words []uint64) bool {
+		hash := make([]byte, numBytes)
+		for i := range hash {
+			hash[i] = byte(words[i/8] >> (56 - 8*uint(i%8)))
+		}
+		return fn(id, hash)
+	})
+}
+
+// each calls 'fn' with the id and the words of every record, copied from
+// the mapping, until it returns false.
+func (r *Reader) each(fn func(id uint64, words []uint64) bool) {
+	numWords := r.hdr.words()
+	for _, seg := range r.segments {
+		for i := 0; i < seg.records; i++ {
+			words := make([]uint64, numWords)
+			for k := range words {
+				words[k] = seg.word(i, k, numWords)
+			}
+			if !fn(seg.id(i), words) {
+				return
+			}
+		}
+	}
+}
+
+// Search returns the records within 'radius' bits of a query, sorted by
+// distance then id. The query is a hash in the same format as the ones
+// given to Writer.Add.
+func (r *Reader) Search(query []byte, radius int) ([]Result, error) {
+	return r.search(query, radius, func(s segment) bool {
+		return s.bucketBits > 0 && neighbours(s.bucketBits, radius)*4 <= 1<<uint(s.bucketBits)
+	})
+}
+
+// SearchHash is the same as Search, for a hash computed by a Hasher. It
+// fails if the hash isn't of the algorithm of the index, and with the error
+// of the imagehash.VersionPolicy if its version isn't the one stored in the
+// header.
+func (r *Reader) SearchHash(h imagehash.Hash, radius int) ([]Result, error) {
+	name := h.Algorithm
+	if h.Pipeline != "" {
+		name = h.Pipeline + "|" + h.Algorithm
+	}
+	if h.Kind != imagehash.BinaryKind || name != r.hdr.algorithm {
+		return nil, errors.New("cannot search " + name + " hashes in an index of " + r.hdr.algorithm + " hashes")
+	}
+	stored := imagehash.Hash{Algorithm: h.Algorithm, Pipeline: h.Pipeline, Version: int(r.hdr.version)}
+	if err := imagehash.CheckVersions(stored, h); err != nil {
+		return nil, err
+	}
+	return r.Search(h.Value, radius)
+}
+
+// SearchLinear is the same as Search, comparing the query with every
+// record.
+func (r *Reader) SearchLinear(query []byte, radius int) ([]Result, error) {
+	return r.search(query, radius, func(segment) bool { return false })
+}
+
+// SearchBucketed is the same as Search, only visiting the buckets which
+// can hold a match.
+func (r *Reader) SearchBucketed(query []byte, radius int) ([]Result, error) {
+	return r.search(query, radius, func(segment) bool { return true })
+}
+
+// search searches the segments, visiting only the buckets which can hold a
+// match of the segments 'bucketed' returns true for.
+func (r *Reader) search(query []byte, radius int, bucketed func(s segment) bool) ([]Result, error) {
+	q, err := r.queryWords(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Result
+	for i, seg := range r.segments {
+		if !bucketed(seg) {
+			results = seg.scan(q, 0, seg.records, radius, results)
+			continue
+		}
+		b, valid := seg.bucketBits, true
+		eachNeighbour(bucketOf(q[0], b), b, radius, func(bucket int) {
+			from, to := seg.bucket(bucket), seg.bucket(bucket+1)
+			if from < 0 || from > to || to > seg.records {
+				valid = false
+				return
+			}
+			results = seg.scan(q, from, to, radius, results)
+		})
+		if !valid {
+			return nil, invalidBuckets(r.path, i)
+		}
+	}
+	sortResults(results)
+	return results, nil
+}
+
+// queryWords converts a query into words, after checking its length.
+func (r *Reader) queryWords(query []byte) ([]uint64, error) {
+	if len(query) != (r.hdr.bits+7)/8 {
+		return nil, errors.New("expected a query of " + strconv.Itoa((r.hdr.bits+7)/8) +
+			" bytes, but received " + strconv.Itoa(len(query)))
+	}
+
+	// Drop the padding bits, which the stored hashes don't have
+	vec, _ := imagehash.NewBitVectorFromBytes(query).Slice(0, r.hdr.bits)
+	return vec.Words(), nil
+}
+
+// scan appends the records from index 'from' up to index 'to' which are
+// within 'radius' bits of the query to 'results'.
+func (s segment) scan(q []uint64, from, to, radius int, results []Result) []Result {
+	numWords := len(q)
+	for i := from; i < to; i++ {
+		dist := 0
+		for k := 0; k < numWords && dist <= radius; k++ {
+			dist += bits.OnesCount64(q[k] ^ s.word(i, k, numWords))
+		}
+		if dist <= radius {
+			results = append(results, Result{s.id(i), dist})
+		}
+	}
+	return results
+}
+
+// word returns word 'k' of the hash of record 'i'.
+func (s segment) word(i, k, numWords int) uint64 {
+	return binary.LittleEndian.Uint64(s.hashes[(i*numWords+k)*8:])
+}
+
+// id returns the id of record 'i'.
+func (s segment) id(i int) uint64 {
+	return binary.LittleEndian.Uint64(s.ids[i*8:])
+}
+
+// bucket returns the index of the first record of a bucket.
+func (s segment) bucket(k int) int {
+	return int(binary.LittleEndian.Uint64(s.buckets[k*8:]))
+}
+
+// invalidBuckets returns the error of a segment whose bucket offsets are
+// out of order, or past its records.
+func invalidBuckets(path string, segment int) error {
+	return &CorruptError{path, "invalid buckets in segment " + strconv.Itoa(segment)}
+}
+
+// neighbours returns the number of buckets of 'b' bits within 'radius' bits
+// of a bucket.
+func neighbours(b, radius int) int {
+	count, c := 0, 1 // c is the binomial coefficient (b, i)
+	for i := 0; i <= radius && i <= b; i++ {
+		count += c
+		c = c * (b - i) / (i + 1)
+	}
+	return count
+}
+
+// eachNeighbour calls 'fn' once with every bucket of 'b' bits within
+// 'radius' bits of 'bucket', including itself.
+func eachNeighbour(bucket, b, radius int, fn func(bucket int)) {
+	fn(bucket)
+	if radius <= 0 {
+		return
+	}
+	// Only flip the bits after the last flipped one, so that every
+	// neighbour is visited once
+	var flip func(bucket, from, radius int)
+	flip = func(bucket, from, radius int) {
+		for i := from; i < b; i++ {
+			next := bucket ^ 1<<uint(i)
+			fn(next)
+			if radius > 1 {
+				flip(next, i+1, radius-1)
+			}
+		}
+	}
+	flip(bucket, 0, radius)
+}
+
+// sortResults sorts results by distance, then id.
+func sortResults(results []Result) {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Distance != results[j].Distance {
+			return results[i].Distance < results[j].Distance
+		}
+		return results[i].ID < results[j].ID
+	})
+}
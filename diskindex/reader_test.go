@@ -0,0 +1,253 @@
+/*
+
+Testing suite for the Reader of index files.
+
+1. Test that linear and bucketed searches find the same records
+2. Test that the results are sorted, with their distances
+3. Test searching an index without buckets, and an empty index
+4. Test counting and enumerating the neighbouring buckets
+5. Test that corrupt files, checksums, bucket offsets and versions are
+   detected
+6. Test that padding bits are ignored
+7. Test that searching a Hash checks its algorithm and version
+
+*/
+
+package diskindex
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/devedge/imagehash"
+)
+
+// Test that both kinds of searches return the same results, for several
+// radiuses
+func TestSearchEquivalence(t *testing.T) {
+	path, cleanup := tempIndex(t)
+	defer cleanup()
+
+	// Near-duplicates of a few base hashes, so that some queries match
+	hashes := randomHashes(2000, 16, 5)
+	for i := 1000; i < 2000; i++ {
+		copy(hashes[i], hashes[i%50])
+		hashes[i][i%16] ^= byte(1 << uint(i%8))
+		hashes[i][(i/16)%16] ^= byte(1 << uint((i/8)%8))
+	}
+	writeIndex(t, path, 128, hashes, &Options{BucketBits: 12})
+
+	r, _ := Open(path)
+	defer r.Close()
+
+	for _, radius := range []int{0, 1, 2, 3, 40} {
+		for q := 0; q < 50; q += 7 {
+			linear, err1 := r.SearchLinear(hashes[q], radius)
+			bucketed, err2 := r.SearchBucketed(hashes[q], radius)
+			auto, err3 := r.Search(hashes[q], radius)
+			if err1 != nil || err2 != nil || err3 != nil {
+				t.Fatalf("search test failed with errors: %v %v %v", err1, err2, err3)
+			}
+			if !reflect.DeepEqual(linear, bucketed) || !reflect.DeepEqual(linear, auto) {
+				t.Errorf("search equivalence test %d:%d failed: [%v] [%v] [%v]", q, radius, linear, bucketed, auto)
+			}
+			if len(linear) == 0 || linear[0].ID != uint64(q) || linear[0].Distance != 0 {
+				t.Errorf("search test %d:%d didn't find its own record: [%v]", q, radius, linear)
+			}
+		}
+	}
+}
+
+// Test the order and distances of the results
+func TestSearchResults(t *testing.T) {
+	path, cleanup := tempIndex(t)
+	defer cleanup()
+
+	hashes := [][]byte{{0xff, 0x00}, {0xff, 0x01}, {0xfe, 0x00}, {0xff, 0x03}, {0x00, 0xff}}
+	writeIndex(t, path, 16, hashes, nil)
+
+	r, _ := Open(path)
+	defer r.Close()
+
+	res, _ := r.Search([]byte{0xff, 0x00}, 2)
+	exp := []Result{{0, 0}, {1, 1}, {2, 1}, {3, 2}}
+	if !reflect.DeepEqual(res, exp) {
+		t.Errorf("search results test [%v] failed: [%v]", exp, res)
+	}
+
+	if _, err := r.Search([]byte{0xff}, 2); err == nil {
+		t.Errorf("searching with a short query didn't fail")
+	}
+}
+
+// Test an index with a single bucket, and an index without records
+func TestSearchWithoutBuckets(t *testing.T) {
+	path, cleanup := tempIndex(t)
+	defer cleanup()
+
+	hashes := randomHashes(100, 8, 6)
+	writeIndex(t, path, 64, hashes, &Options{BucketBits: -1})
+
+	r, _ := Open(path)
+	res, _ := r.SearchBucketed(hashes[42], 0)
+	if len(res) != 1 || res[0].ID != 42 {
+		t.Errorf("search without buckets test [42] failed: [%v]", res)
+	}
+	r.Close()
+
+	writeIndex(t, path, 64, nil, nil)
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("open empty index test failed with error: %v", err)
+	}
+	if res, _ := r.Search(hashes[0], 10); r.Len() != 0 || len(res) != 0 {
+		t.Errorf("empty index test failed: [%d %v]", r.Len(), res)
+	}
+	r.Close()
+}
+
+// Test the number of neighbouring buckets, and that each is visited once
+func TestNeighbours(t *testing.T) {
+	if n := neighbours(16, 2); n != 1+16+120 {
+		t.Errorf("neighbours test [137] failed: [%d]", n)
+	}
+
+	visited := make(map[int]int)
+	eachNeighbour(0x5, 4, 2, func(bucket int) { visited[bucket]++ })
+	if len(visited) != neighbours(4, 2) {
+		t.Errorf("each neighbour test [%d] failed: [%d]", neighbours(4, 2), len(visited))
+	}
+	for bucket, count := range visited {
+		if count != 1 {
+			t.Errorf("neighbour %x visited %d times", bucket, count)
+		}
+	}
+}
+
+// Test that flipped bytes, truncations, invalid buckets and unknown
+// versions are detected, by Open or Verify
+func TestCorruption(t *testing.T) {
+	path, cleanup := tempIndex(t)
+	defer cleanup()
+
+	writeIndex(t, path, 64, randomHashes(100, 8, 7), nil)
+	valid, _ := ioutil.ReadFile(path)
+
+	corruptions := map[string]func(b []byte) []byte{
+		"flipped hash bit": func(b []byte) []byte {
+			b[headerSize+segmentHeaderSize+10] ^= 0x04
+			return b
+		},
+		"flipped header bit": func(b []byte) []byte {
+			b[17] ^= 0x01
+			return b
+		},
+		"truncated": func(b []byte) []byte {
+			return b[:len(b)-100]
+		},
+		"invalid buckets": corruptBuckets,
+		"unknown version": func(b []byte) []byte {
+			binary.LittleEndian.PutUint16(b[4:], Version+1)
+			binary.LittleEndian.PutUint32(b[60:], crc32.Checksum(b[:60], castagnoli))
+			return b
+		},
+	}
+	for name, corrupt := range corruptions {
+		b := corrupt(append([]byte(nil), valid...))
+		ioutil.WriteFile(path, b, 0644)
+
+		r, err := Open(path)
+		if err == nil {
+			err = r.Verify()
+			r.Close()
+		}
+		if err == nil {
+			t.Errorf("%s corruption wasn't detected", name)
+		} else if _, ok := err.(*CorruptError); !ok {
+			t.Errorf("%s corruption test [CorruptError] failed: [%T %v]", name, err, err)
+		}
+	}
+
+	// Searches check the bucket offsets they use, rather than crash
+	ioutil.WriteFile(path, corruptBuckets(append([]byte(nil), valid...)), 0644)
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("open with invalid buckets test failed with error: %v", err)
+	}
+	defer r.Close()
+	if _, err := r.SearchBucketed(make([]byte, 8), 7); err == nil {
+		t.Errorf("search with invalid buckets test didn't fail")
+	}
+	if err := Compact(path, nil); err == nil {
+		t.Errorf("compact with invalid buckets test didn't fail")
+	}
+}
+
+// corruptBuckets moves a bucket offset of the first segment of an index of
+// 100 hashes of 64 bits past its records, with valid checksums.
+func corruptBuckets(b []byte) []byte {
+	seg := b[headerSize:]
+	buckets := seg[segmentHeaderSize+100*16 : segmentHeaderSize+100*16+bucketsSize(7)]
+	binary.LittleEndian.PutUint64(buckets[5*8:], 1000)
+	binary.LittleEndian.PutUint32(seg[24:], crc32.Checksum(buckets, castagnoli))
+	binary.LittleEndian.PutUint32(seg[44:], crc32.Checksum(seg[:44], castagnoli))
+	return b
+}
+
+// Test that the padding bits of hashes and queries are ignored
+func TestPaddingBits(t *testing.T) {
+	path, cleanup := tempIndex(t)
+	defer cleanup()
+
+	writeIndex(t, path, 12, [][]byte{{0xab, 0xcf}}, nil)
+	r, _ := Open(path)
+	defer r.Close()
+
+	res, _ := r.Search([]byte{0xab, 0xc0}, 0)
+	if len(res) != 1 {
+		t.Errorf("padding bits search test [1] failed: [%d]", len(res))
+	}
+	r.Each(func(id uint64, hash []byte) bool {
+		if !reflect.DeepEqual(hash, []byte{0xab, 0xc0}) {
+			t.Errorf("padding bits record test [abc0] failed: [%x]", hash)
+		}
+		return true
+	})
+}
+
+// Test that the queries of another algorithm or version are rejected
+func TestSearchHash(t *testing.T) {
+	path, cleanup := tempIndex(t)
+	defer cleanup()
+
+	hashes := randomHashes(20, 16, 9)
+	writeIndex(t, path, 128, hashes, &Options{AlgorithmVersion: imagehash.DhashVersion})
+	r, _ := Open(path)
+	defer r.Close()
+
+	query := imagehash.Hash{Algorithm: "dhash:8", Kind: imagehash.BinaryKind, Value: hashes[3], Version: imagehash.DhashVersion}
+	if res, err := r.SearchHash(query, 0); err != nil || len(res) != 1 || res[0].ID != 3 {
+		t.Errorf("search hash test [3] failed: [%v %v]", res, err)
+	}
+
+	newer := query
+	newer.Version++
+	if _, err := r.SearchHash(newer, 0); err == nil {
+		t.Errorf("search hash of another version didn't fail")
+	} else if _, ok := err.(*imagehash.VersionError); !ok {
+		t.Errorf("search hash version test [VersionError] failed: [%T %v]", err, err)
+	}
+	other := query
+	other.Algorithm = "ahash:8"
+	if _, err := r.SearchHash(other, 0); err == nil {
+		t.Errorf("search hash of another algorithm didn't fail")
+	}
+	piped := query
+	piped.Pipeline = "equalize"
+	if _, err := r.SearchHash(piped, 0); err == nil {
+		t.Errorf("search hash with another pipeline didn't fail")
+	}
+}
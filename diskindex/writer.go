@@ -0,0 +1,315 @@
+/*
+
+The Writer of an index file. Records are added in memory, and written as a
+new segment by Flush or Close: the segment is written past the last one and
+synced to disk first, then the file header is updated to count it. A crash
+in between leaves the index as it was before the Flush.
+
+Every Flush adds a segment, along with a buckets section sized by its
+number of records, so an index appended to in many small batches should
+still be compacted from time to time, for its searches to visit fewer
+segments.
+
+*/
+
+package diskindex
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/devedge/imagehash"
+)
+
+// Options configures a new index.
+type Options struct {
+	// BucketBits is the number of leading bits of a hash its bucket is
+	// made of. If 0, DefaultBucketBits is used, or the bits per hash if
+	// there are fewer. If negative, the index has a single bucket, and
+	// every search is a linear scan.
+	BucketBits int
+
+	// AlgorithmVersion is the version of the algorithm of the hashes, as
+	// in imagehash.Hash.Version, so that readers can check it matches the
+	// version of their queries. 0 if unknown.
+	AlgorithmVersion int
+}
+
+// Writer adds records to an index file.
+type Writer struct {
+	f       *os.File
+	hdr     header
+	end     int64 // Offset past the last segment
+	pending []record
+}
+
+// record is a hash and its id, waiting to be written.
+type record struct {
+	bucket int
+	id     uint64
+	words  []uint64
+}
+
+// Create creates an empty index file for hashes of 'bits' bits computed by
+// 'algorithm', such as "dhash:8", replacing any existing file. 'opts' can
+// be nil. The padding in the middle of a hash counts: a dhash:5 has the 50
+// Bits of its Hasher, but two grids of 25 bits each padded to 32, so it is
+// indexed with 64 bits, 8 times the length of its Value.
+func Create(path, algorithm string, bits int, opts *Options) (*Writer, error) {
+	hdr := header{format: Version, bits: bits, algorithm: algorithm, bucketBits: DefaultBucketBits}
+	if bits < DefaultBucketBits {
+		hdr.bucketBits = bits
+	}
+	if opts != nil {
+		hdr.version = uint32(opts.AlgorithmVersion)
+	}
+	if opts != nil && opts.BucketBits > 0 {
+		hdr.bucketBits = opts.BucketBits
+	} else if opts != nil && opts.BucketBits < 0 {
+		hdr.bucketBits = 0
+	}
+	if err := hdr.validate(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(hdr.marshal()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Writer{f: f, hdr: hdr, end: headerSize}, nil
+}
+
+// OpenWriter opens an existing index file to append records to it.
+func OpenWriter(path string) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := openWriter(f, path)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// openWriter reads the file header and walks the segments, without
+// checking their sections, to find where the next segment goes.
+func openWriter(f *os.File, path string) (*Writer, error) {
+	buf := make([]byte, headerSize)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return nil, &CorruptError{path, "truncated header"}
+	}
+	hdr, err := parseHeader(buf)
+	if err != nil {
+		return nil, &CorruptError{path, err.Error()}
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	end := int64(headerSize)
+	buf = buf[:segmentHeaderSize]
+	for i := 0; i < hdr.segments; i++ {
+		if _, err := f.ReadAt(buf, end); err != nil {
+			return nil, &CorruptError{path, "truncated segment " + strconv.Itoa(i)}
+		}
+		sh, err := parseSegmentHeader(buf)
+		if err != nil {
+			return nil, &CorruptError{path, err.Error()}
+		}
+		end += hdr.segmentSize(sh.records)
+	}
+	if end > info.Size() {
+		return nil, &CorruptError{path, "truncated segment " + strconv.Itoa(hdr.segments-1)}
+	}
+
+	return &Writer{f: f, hdr: hdr, end: end}, nil
+}
+
+// Add adds the hash of a record, as returned by the hash functions, to the
+// next segment. Its length must be the bits per hash, rounded up to whole
+// bytes; padding bits are ignored.
+func (w *Writer) Add(id uint64, hash []byte) error {
+	if len(hash) != (w.hdr.bits+7)/8 {
+		return errors.New("expected a hash of " + strconv.Itoa((w.hdr.bits+7)/8) +
+			" bytes, but received " + strconv.Itoa(len(hash)))
+	}
+
+	vec, _ := imagehash.NewBitVectorFromBytes(hash).Slice(0, w.hdr.bits)
+	w.add(id, vec.Words())
+	return nil
+}
+
+// add adds the words of a hash to the next segment.
+func (w *Writer) add(id uint64, words []uint64) {
+	w.pending = append(w.pending, record{bucketOf(words[0], w.hdr.bucketBits), id, words})
+}
+
+// Flush writes the records added since the last Flush as a new segment.
+// Records which are exact duplicates of each other are written once.
+func (w *Writer) Flush() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	records := sortRecords(w.pending)
+
+	// Encode the sections
+	// The buckets of the segment are prefixes of the ones of the records
+	segBits := w.hdr.segmentBucketBits(uint64(len(records)))
+	shift := uint(w.hdr.bucketBits - segBits)
+
+	numWords := w.hdr.words()
+	hashes := make([]byte, len(records)*numWords*8)
+	ids := make([]byte, len(records)*8)
+	buckets := make([]byte, bucketsSize(segBits))
+	bucket := 0
+	for i, rec := range records {
+		for k, word := range rec.words {
+			binary.LittleEndian.PutUint64(hashes[(i*numWords+k)*8:], word)
+		}
+		binary.LittleEndian.PutUint64(ids[i*8:], rec.id)
+
+		// Every bucket up to this record's one starts at this record
+		for ; bucket <= rec.bucket>>shift; bucket++ {
+			binary.LittleEndian.PutUint64(buckets[bucket*8:], uint64(i))
+		}
+	}
+	for ; bucket*8 < len(buckets); bucket++ {
+		binary.LittleEndian.PutUint64(buckets[bucket*8:], uint64(len(records)))
+	}
+
+	sh := segmentHeader{
+		records:    uint64(len(records)),
+		hashesCRC:  crc32.Checksum(hashes, castagnoli),
+		idsCRC:     crc32.Checksum(ids, castagnoli),
+		bucketsCRC: crc32.Checksum(buckets, castagnoli),
+	}
+
+	// Write the segment, and only then count it in the header
+	off := w.end
+	for _, section := range [][]byte{sh.marshal(), hashes, ids, buckets} {
+		if _, err := w.f.WriteAt(section, off); err != nil {
+			return err
+		}
+		off += int64(len(section))
+	}
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+
+	hdr := w.hdr
+	hdr.segments++
+	hdr.records += sh.records
+	if _, err := w.f.WriteAt(hdr.marshal(), 0); err != nil {
+		return err
+	}
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+
+	w.hdr, w.end, w.pending = hdr, off, nil
+	return nil
+}
+
+// Close flushes the pending records, and closes the file.
+func (w *Writer) Close() error {
+	err := w.Flush()
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// sortRecords sorts records by bucket, id and hash, and drops the exact
+// duplicates.
+func sortRecords(records []record) []record {
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if a.bucket != b.bucket {
+			return a.bucket < b.bucket
+		}
+		if a.id != b.id {
+			return a.id < b.id
+		}
+		return compareWords(a.words, b.words) < 0
+	})
+
+	res := records[:0]
+	for _, rec := range records {
+		if n := len(res); n > 0 && rec.id == res[n-1].id && compareWords(rec.words, res[n-1].words) == 0 {
+			continue
+		}
+		res = append(res, rec)
+	}
+	return res
+}
+
+// compareWords compares two hashes of the same length.
+func compareWords(a, b []uint64) int {
+	for k := range a {
+		if a[k] != b[k] {
+			if a[k] < b[k] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// Compact rewrites an index file as a single segment, dropping the exact
+// duplicate records, and the records whose id 'keep' returns false for.
+// 'keep' can be nil to keep every record. The index is verified first, and
+// its records are loaded in memory while the new file is written next to
+// the old one, which it then replaces.
+func Compact(path string, keep func(id uint64) bool) error {
+	r, err := Open(path)
+	if err != nil {
+		return err
+	}
+	if err := r.Verify(); err != nil {
+		r.Close()
+		return err
+	}
+
+	opts := &Options{BucketBits: r.hdr.bucketBits, AlgorithmVersion: int(r.hdr.version)}
+	if r.hdr.bucketBits == 0 {
+		opts.BucketBits = -1
+	}
+	tmp := path + ".compact"
+	w, err := Create(tmp, r.hdr.algorithm, r.hdr.bits, opts)
+	if err != nil {
+		r.Close()
+		return err
+	}
+
+	r.each(func(id uint64, words []uint64) bool {
+		if keep == nil || keep(id) {
+			w.add(id, words)
+		}
+		return true
+	})
+
+	// The old file can't be replaced while it is mapped on some systems
+	err = w.Close()
+	if rerr := r.Close(); err == nil {
+		err = rerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,285 @@
+/*
+
+Testing suite for the Writer of index files.
+
+1. Test writing an index, and reading its records back
+2. Test appending segments to an existing index
+3. Test that an append which didn't complete is ignored and overwritten
+4. Test compacting the segments, dropping duplicates and deleted ids
+5. Test invalid options and hash lengths
+6. Test reading and appending to an index of version 1 of the format
+
+*/
+
+package diskindex
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// tempIndex returns the path of an index file in a new temporary folder,
+// and a function removing the folder.
+func tempIndex(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "diskindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "test.idx"), func() { os.RemoveAll(dir) }
+}
+
+// randomHashes returns 'n' random hashes of 'numBytes' bytes.
+func randomHashes(n, numBytes int, seed int64) [][]byte {
+	rnd := rand.New(rand.NewSource(seed))
+	hashes := make([][]byte, n)
+	for i := range hashes {
+		hashes[i] = make([]byte, numBytes)
+		rnd.Read(hashes[i])
+	}
+	return hashes
+}
+
+// writeIndex writes an index of hashes, with their position as their id.
+func writeIndex(t *testing.T, path string, bits int, hashes [][]byte, opts *Options) {
+	w, err := Create(path, "dhash:8", bits, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, h := range hashes {
+		if err := w.Add(uint64(i), h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readIndex returns the records of an index, by id.
+func readIndex(t *testing.T, path string) map[uint64][]byte {
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	records := make(map[uint64][]byte)
+	r.Each(func(id uint64, hash []byte) bool {
+		records[id] = hash
+		return true
+	})
+	return records
+}
+
+// Test that the written records are read back, with the header fields
+func TestWriteIndex(t *testing.T) {
+	path, cleanup := tempIndex(t)
+	defer cleanup()
+
+	hashes := randomHashes(500, 16, 1)
+	writeIndex(t, path, 128, hashes, nil)
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("open index test failed with error: %v", err)
+	}
+	if r.Algorithm() != "dhash:8" || r.Bits() != 128 || r.Len() != 500 || r.AlgorithmVersion() != 0 {
+		t.Errorf("index header test failed: [%s %d %d %d]", r.Algorithm(), r.Bits(), r.Len(), r.AlgorithmVersion())
+	}
+	if err := r.Verify(); err != nil {
+		t.Errorf("verify index test failed with error: %v", err)
+	}
+	r.Close()
+
+	records := readIndex(t, path)
+	for i, h := range hashes {
+		if !reflect.DeepEqual(records[uint64(i)], h) {
+			t.Errorf("index record test [%x] failed: [%x]", h, records[uint64(i)])
+		}
+	}
+}
+
+// Test that appended records are added to the existing ones
+func TestAppendIndex(t *testing.T) {
+	path, cleanup := tempIndex(t)
+	defer cleanup()
+
+	hashes := randomHashes(300, 8, 2)
+	writeIndex(t, path, 64, hashes[:100], nil)
+
+	// Two more segments, one per Flush
+	w, err := OpenWriter(path)
+	if err != nil {
+		t.Fatalf("open writer test failed with error: %v", err)
+	}
+	for i := 100; i < 300; i++ {
+		w.Add(uint64(i), hashes[i])
+		if i == 199 {
+			w.Flush()
+		}
+	}
+	w.Close()
+
+	// The buckets sections are sized by the records of their segment
+	r, _ := Open(path)
+	for i, seg := range r.segments {
+		if seg.bucketBits != 7 || int64(len(seg.buckets)) != bucketsSize(7) {
+			t.Errorf("segment %d buckets test [7 %d] failed: [%d %d]", i, bucketsSize(7), seg.bucketBits, len(seg.buckets))
+		}
+	}
+	r.Close()
+
+	records := readIndex(t, path)
+	if len(records) != 300 {
+		t.Errorf("append index test [300] failed: [%d]", len(records))
+	}
+	for i, h := range hashes {
+		if !reflect.DeepEqual(records[uint64(i)], h) {
+			t.Errorf("appended record test [%x] failed: [%x]", h, records[uint64(i)])
+		}
+	}
+}
+
+// Test that bytes left by an incomplete append are ignored, then replaced
+func TestIncompleteAppend(t *testing.T) {
+	path, cleanup := tempIndex(t)
+	defer cleanup()
+
+	hashes := randomHashes(20, 8, 3)
+	writeIndex(t, path, 64, hashes[:10], nil)
+
+	// A segment written without its header update
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.Write([]byte("IHSG half written segment"))
+	f.Close()
+
+	if records := readIndex(t, path); len(records) != 10 {
+		t.Errorf("incomplete append test [10] failed: [%d]", len(records))
+	}
+
+	w, err := OpenWriter(path)
+	if err != nil {
+		t.Fatalf("open writer after incomplete append failed with error: %v", err)
+	}
+	for i := 10; i < 20; i++ {
+		w.Add(uint64(i), hashes[i])
+	}
+	w.Close()
+
+	if records := readIndex(t, path); len(records) != 20 {
+		t.Errorf("append after incomplete append test [20] failed: [%d]", len(records))
+	}
+}
+
+// Test that compaction merges the segments, and drops duplicates and the
+// records which aren't kept
+func TestCompact(t *testing.T) {
+	path, cleanup := tempIndex(t)
+	defer cleanup()
+
+	hashes := randomHashes(100, 8, 4)
+	writeIndex(t, path, 64, hashes, &Options{AlgorithmVersion: 3})
+
+	// Append the same records again, as another segment
+	w, _ := OpenWriter(path)
+	for i, h := range hashes {
+		w.Add(uint64(i), h)
+	}
+	w.Close()
+
+	err := Compact(path, func(id uint64) bool { return id%2 == 0 })
+	if err != nil {
+		t.Fatalf("compact test failed with error: %v", err)
+	}
+
+	r, _ := Open(path)
+	if r.Len() != 50 || len(r.segments) != 1 || r.AlgorithmVersion() != 3 {
+		t.Errorf("compact test [50 1 3] failed: [%d %d %d]", r.Len(), len(r.segments), r.AlgorithmVersion())
+	}
+	r.Close()
+
+	records := readIndex(t, path)
+	for i, h := range hashes {
+		if rec, ok := records[uint64(i)]; ok != (i%2 == 0) || (ok && !reflect.DeepEqual(rec, h)) {
+			t.Errorf("compacted record %d test failed: [%x %v]", i, rec, ok)
+		}
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("compaction left its temporary file behind")
+	}
+}
+
+// Test that invalid indexes and hashes are rejected
+func TestInvalidWriter(t *testing.T) {
+	path, cleanup := tempIndex(t)
+	defer cleanup()
+
+	if _, err := Create(path, "dhash:8", 0, nil); err == nil {
+		t.Errorf("creating an index of 0 bit hashes didn't fail")
+	}
+	if _, err := Create(path, "dhash:8", 8, &Options{BucketBits: 9}); err == nil {
+		t.Errorf("creating an index with more bucket bits than bits didn't fail")
+	}
+	if _, err := Create(path, "a very long algorithm name, over 32 bytes", 8, nil); err == nil {
+		t.Errorf("creating an index with a long algorithm name didn't fail")
+	}
+
+	w, _ := Create(path, "dhash:5", 25, nil)
+	if err := w.Add(1, make([]byte, 3)); err == nil {
+		t.Errorf("adding a short hash didn't fail")
+	}
+	if err := w.Add(1, make([]byte, 4)); err != nil {
+		t.Errorf("adding a padded hash failed with error: %v", err)
+	}
+	w.Close()
+
+	ioutil.WriteFile(path, []byte("not an index"), 0644)
+	if _, err := OpenWriter(path); err == nil {
+		t.Errorf("opening a writer on a non-index file didn't fail")
+	} else if _, ok := err.(*CorruptError); !ok {
+		t.Errorf("opening a writer on a non-index file test [CorruptError] failed: [%T]", err)
+	}
+}
+
+// Test that an index of version 1 of the format, whose buckets sections
+// all have the bucket bits of the file, is still searched and appended to
+func TestVersion1Index(t *testing.T) {
+	path, cleanup := tempIndex(t)
+	defer cleanup()
+
+	hashes := randomHashes(200, 8, 8)
+	w, _ := Create(path, "dhash:8", 64, &Options{BucketBits: 10})
+	w.hdr.format = 1
+	for i, h := range hashes[:100] {
+		w.Add(uint64(i), h)
+	}
+	w.Close()
+
+	w, err := OpenWriter(path)
+	if err != nil {
+		t.Fatalf("open version 1 writer test failed with error: %v", err)
+	}
+	for i := 100; i < 200; i++ {
+		w.Add(uint64(i), hashes[i])
+	}
+	w.Close()
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("open version 1 index test failed with error: %v", err)
+	}
+	defer r.Close()
+	if r.hdr.format != 1 || len(r.segments) != 2 || r.segments[1].bucketBits != 10 {
+		t.Fatalf("version 1 index test [1 2] failed: [%d %d]", r.hdr.format, len(r.segments))
+	}
+	for _, q := range []int{3, 150} {
+		res, _ := r.SearchBucketed(hashes[q], 1)
+		if len(res) != 1 || res[0].ID != uint64(q) {
+			t.Errorf("version 1 search %d test failed: [%v]", q, res)
+		}
+	}
+}
@@ -1,5 +1,15 @@
 package imagehash
 
+import (
+	"math"
+	"math/bits"
+)
+
+// FloatHash is a hash made of a vector of floats rather than of bits, such
+// as the one returned by ColorMomentHash. Since it isn't a byte array, it
+// can't be compared using GetDistance; GetL2Distance is used instead.
+type FloatHash []float64
+
 // GetDistance returns the hamming distance between two hashs
 func GetDistance(hash1, hash2 []byte) int {
 	distance := 0
@@ -36,3 +46,33 @@ func GetDistanceMaxRange(hash1, hash2 []byte) int {
 	}
 	return len(hash2)
 }
+
+// GetL2Distance returns the euclidean distance between two float hashes.
+// Hashes of different lengths can't be compared, and are infinitely distant.
+func GetL2Distance(hash1, hash2 FloatHash) float64 {
+	if len(hash1) != len(hash2) {
+		return math.Inf(1)
+	}
+
+	var sum float64
+	for i := range hash1 {
+		diff := hash1[i] - hash2[i]
+		sum += diff * diff
+	}
+	return math.Sqrt(sum)
+}
+
+// GetBitDistance returns the number of bits which differ between two hashes.
+// As with GetDistance, every bit of the longer hash which has no counterpart
+// in the shorter one counts as different.
+func GetBitDistance(hash1, hash2 []byte) int {
+	if len(hash1) > len(hash2) {
+		hash1, hash2 = hash2, hash1
+	}
+
+	distance := 8 * (len(hash2) - len(hash1))
+	for i := range hash1 {
+		distance += bits.OnesCount8(hash1[i] ^ hash2[i])
+	}
+	return distance
+}
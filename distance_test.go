@@ -7,12 +7,15 @@ It starts with Ahash and finish with Dhash.
 2. Test that distance between a image and inverted image is not too close from zero but not to far either
 3. Test that distance between a image and white image is not close from zero
 4. Test maximum distance between a images
+5. Test the L2 distance between float hashes
+6. Test the bit distance between hashes
 
 */
 
 package imagehash
 
 import (
+	"math"
 	"testing"
 )
 
@@ -138,3 +141,26 @@ func TestMaximuimDistance(t *testing.T) {
 		t.Errorf("the maximum distance is not good. We have %d and it should be %d", distMax, dist)
 	}
 }
+
+// Test the L2 distance between float hashes, including ones of different lengths.
+func TestL2Distance(t *testing.T) {
+	hash1 := FloatHash{1, 2, 3}
+	hash2 := FloatHash{4, 6, 3}
+
+	if dist := GetL2Distance(hash1, hash2); dist != 5 {
+		t.Errorf("the L2 distance is not good. We have %f and it should be 5", dist)
+	}
+	if dist := GetL2Distance(hash1, hash2[:2]); !math.IsInf(dist, 1) {
+		t.Errorf("the L2 distance between different lengths should be infinite: %f", dist)
+	}
+}
+
+// Test the number of differing bits, including the bits of missing bytes.
+func TestBitDistance(t *testing.T) {
+	if dist := GetBitDistance([]byte{0x0f, 0xff}, []byte{0x0e, 0x7f}); dist != 2 {
+		t.Errorf("the bit distance is not good. We have %d and it should be 2", dist)
+	}
+	if dist := GetBitDistance([]byte{0x0f}, []byte{0x0f, 0x00, 0x00}); dist != 16 {
+		t.Errorf("the bit distance is not good. We have %d and it should be 16", dist)
+	}
+}
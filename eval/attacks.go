@@ -0,0 +1,172 @@
+/*
+
+The attacks applied to the source images by the evaluation harness. Each one
+simulates a modification an image commonly goes through before being seen
+again: re-encoding, resizing, cropping, rotating, filtering and so on.
+
+Every attack is deterministic, so that two runs of the harness over the
+same images give the same report.
+
+*/
+
+package eval
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+
+	"github.com/disintegration/imaging"
+)
+
+// Attack is a named transform applied to a source image.
+type Attack struct {
+	Name  string
+	Apply func(img image.Image) image.Image
+}
+
+// DefaultAttacks returns the attacks used when none are configured: a JPEG
+// quality sweep, resizing, cropping, rotating, blurring, noise, gamma
+// changes, a watermark and a horizontal flip.
+func DefaultAttacks() []Attack {
+	var attacks []Attack
+	for _, quality := range []int{90, 70, 50, 30, 10} {
+		attacks = append(attacks, JPEG(quality))
+	}
+	return append(attacks,
+		Resize(0.5), Resize(0.25),
+		Crop(0.9), Crop(0.75),
+		Rotate(2), Rotate(5), Rotate(15),
+		Blur(1), Blur(3),
+		Noise(10), Noise(25),
+		Gamma(0.7), Gamma(1.5),
+		Watermark(0.5),
+		FlipH(),
+	)
+}
+
+// JPEG re-encodes the image as a JPEG of the given quality.
+func JPEG(quality int) Attack {
+	return Attack{
+		Name: fmt.Sprintf("jpeg-%d", quality),
+		Apply: func(img image.Image) image.Image {
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+				return img
+			}
+			res, err := jpeg.Decode(&buf)
+			if err != nil {
+				return img
+			}
+			return res
+		},
+	}
+}
+
+// Resize scales the image by 'factor', keeping its aspect ratio.
+func Resize(factor float64) Attack {
+	return Attack{
+		Name: fmt.Sprintf("resize-%g", factor),
+		Apply: func(img image.Image) image.Image {
+			width := int(float64(img.Bounds().Dx())*factor + 0.5)
+			if width < 1 {
+				width = 1
+			}
+			return imaging.Resize(img, width, 0, imaging.Lanczos)
+		},
+	}
+}
+
+// Crop keeps the centre of the image, 'fraction' of its width and height.
+func Crop(fraction float64) Attack {
+	return Attack{
+		Name: fmt.Sprintf("crop-%g", fraction),
+		Apply: func(img image.Image) image.Image {
+			b := img.Bounds()
+			return imaging.CropCenter(img, int(float64(b.Dx())*fraction), int(float64(b.Dy())*fraction))
+		},
+	}
+}
+
+// Rotate rotates the image counter-clockwise by 'angle' degrees, and crops
+// it back to its original size.
+func Rotate(angle float64) Attack {
+	return Attack{
+		Name: fmt.Sprintf("rotate-%g", angle),
+		Apply: func(img image.Image) image.Image {
+			b := img.Bounds()
+			return imaging.CropCenter(imaging.Rotate(img, angle, color.Black), b.Dx(), b.Dy())
+		},
+	}
+}
+
+// Blur applies a gaussian blur of the given sigma.
+func Blur(sigma float64) Attack {
+	return Attack{
+		Name: fmt.Sprintf("blur-%g", sigma),
+		Apply: func(img image.Image) image.Image {
+			return imaging.Blur(img, sigma)
+		},
+	}
+}
+
+// Noise adds uniform noise of up to 'amount' to every channel of every
+// pixel. The noise is seeded, so it is the same on every run.
+func Noise(amount int) Attack {
+	return Attack{
+		Name: fmt.Sprintf("noise-%d", amount),
+		Apply: func(img image.Image) image.Image {
+			res := imaging.Clone(img)
+			rnd := rand.New(rand.NewSource(int64(amount)))
+			for i := range res.Pix {
+				if i%4 == 3 {
+					continue // Leave the alpha channel alone
+				}
+				v := int(res.Pix[i]) + rnd.Intn(2*amount+1) - amount
+				if v < 0 {
+					v = 0
+				} else if v > 255 {
+					v = 255
+				}
+				res.Pix[i] = uint8(v)
+			}
+			return res
+		},
+	}
+}
+
+// Gamma applies a gamma correction.
+func Gamma(gamma float64) Attack {
+	return Attack{
+		Name: fmt.Sprintf("gamma-%g", gamma),
+		Apply: func(img image.Image) image.Image {
+			return imaging.AdjustGamma(img, gamma)
+		},
+	}
+}
+
+// Watermark overlays a white banner across the bottom of the image, with
+// the given opacity.
+func Watermark(opacity float64) Attack {
+	return Attack{
+		Name: fmt.Sprintf("watermark-%g", opacity),
+		Apply: func(img image.Image) image.Image {
+			b := img.Bounds()
+			mark := imaging.New(b.Dx()*3/4, b.Dy()/10, color.White)
+			return imaging.Overlay(img, mark, image.Pt(b.Dx()/8, b.Dy()*4/5), opacity)
+		},
+	}
+}
+
+// FlipH mirrors the image left to right.
+func FlipH() Attack {
+	return Attack{
+		Name: "flip-h",
+		Apply: func(img image.Image) image.Image {
+			return imaging.FlipH(img)
+		},
+	}
+}
@@ -0,0 +1,398 @@
+/*
+
+Package eval is a robustness evaluation harness for the imagehash
+algorithms.
+
+It takes a set of distinct source images, applies attacks to each of them
+(re-encoding, resizing, cropping, rotating, filtering...), and hashes the
+originals and the attacked copies with every configured algorithm. An
+attacked copy compared with its own original is a positive pair, and
+compared with any other original a negative pair. From the similarities of
+these pairs, it computes, for every algorithm and attack, the true positive
+and false positive rates at a sweep of similarity thresholds (the ROC
+curve), and the area under that curve.
+
+This makes it possible to tell whether a change to an algorithm, or a new
+algorithm, makes matching better or worse.
+
+Usage:
+  sources,err := eval.LoadImages("./photos")
+  report,err := eval.Run(sources, eval.Config{})
+  report.WriteSummaryCSV(os.Stdout)
+
+*/
+
+package eval
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/devedge/imagehash"
+)
+
+// Algorithm is a hash function evaluated at a given 'hashLen'.
+type Algorithm struct {
+	Name    string
+	HashLen int
+	Hash    imagehash.HashFunc
+}
+
+// DefaultAlgorithms returns Dhash, DhashHorizontal, DhashVertical and Ahash,
+// each with a 'hashLen' of 8, 16 and 32.
+func DefaultAlgorithms() []Algorithm {
+	funcs := []struct {
+		name string
+		hash imagehash.HashFunc
+	}{
+		{"dhash", imagehash.Dhash},
+		{"dhash-h", imagehash.DhashHorizontal},
+		{"dhash-v", imagehash.DhashVertical},
+		{"ahash", imagehash.Ahash},
+	}
+
+	var algorithms []Algorithm
+	for _, f := range funcs {
+		for _, hashLen := range []int{8, 16, 32} {
+			algorithms = append(algorithms, Algorithm{f.name, hashLen, f.hash})
+		}
+	}
+	return algorithms
+}
+
+// AlgorithmFromHasher returns an Algorithm which hashes with a registered
+// hasher, picked by name such as "dhash-h:16". Only hashers returning
+// binary hashes can be evaluated.
+func AlgorithmFromHasher(name string) (Algorithm, error) {
+	hasher, err := imagehash.NewHasher(name)
+	if err != nil {
+		return Algorithm{}, err
+	}
+
+	// Split "dhash-h:16" back into the name and 'hashLen' of the report
+	alg := Algorithm{Name: hasher.Name()}
+	if i := strings.Index(alg.Name, ":"); i >= 0 {
+		alg.HashLen, _ = strconv.Atoi(alg.Name[i+1:])
+		alg.Name = alg.Name[:i]
+	}
+
+	alg.Hash = func(img image.Image, hashLen int) ([]byte, error) {
+		h, err := hasher.Hash(img)
+		if err != nil {
+			return nil, err
+		}
+		if h.Kind != imagehash.BinaryKind {
+			return nil, errors.New("only binary hashes can be evaluated")
+		}
+		return h.Value, nil
+	}
+	return alg, nil
+}
+
+// Config selects what is evaluated. Zero values are replaced by defaults.
+type Config struct {
+	Algorithms []Algorithm // DefaultAlgorithms() if empty
+	Attacks    []Attack    // DefaultAttacks() if empty
+	Thresholds []float64   // Similarities from 0 to 1 in steps of 0.01 if empty
+	Threshold  float64     // Operating point of the summary; 0.85 if zero
+	Workers    int         // Images processed concurrently; the number of CPUs if zero
+}
+
+// Point is a point of a ROC curve: the rates of positive and negative pairs
+// with a similarity of at least 'Threshold'.
+type Point struct {
+	Threshold float64 `json:"threshold"`
+	TPR       float64 `json:"tpr"`
+	FPR       float64 `json:"fpr"`
+}
+
+// Curve is the evaluation of an algorithm against an attack.
+type Curve struct {
+	Algorithm string  `json:"algorithm"`
+	HashLen   int     `json:"hashLen"`
+	Attack    string  `json:"attack"`
+	AUC       float64 `json:"auc"` // Area under the ROC curve
+	TPR       float64 `json:"tpr"` // True positive rate at the report's threshold
+	FPR       float64 `json:"fpr"` // False positive rate at the report's threshold
+	Points    []Point `json:"points"`
+}
+
+// Report holds the evaluation of every algorithm against every attack.
+type Report struct {
+	Sources   int     `json:"sources"`   // Number of source images
+	Threshold float64 `json:"threshold"` // Operating point of the TPR and FPR of the curves
+	Curves    []Curve `json:"curves"`
+}
+
+// imageExts are the file extensions LoadImages decodes.
+var imageExts = map[string]bool{
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true,
+	".bmp": true, ".tif": true, ".tiff": true,
+}
+
+// LoadImages opens every image in a directory, in the order of their names.
+// Files without an image extension are skipped, and subdirectories aren't
+// visited. Every source should be a distinct image, since they are used as
+// each other's negatives.
+func LoadImages(dir string) ([]image.Image, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []image.Image
+	for _, f := range files {
+		if f.IsDir() || !imageExts[strings.ToLower(filepath.Ext(f.Name()))] {
+			continue
+		}
+		img, err := imagehash.OpenImg(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Name(), err)
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// Run evaluates the configured algorithms against the configured attacks on
+// the source images. At least two sources are needed, so that there are
+// negative pairs.
+func Run(sources []image.Image, cfg Config) (*Report, error) {
+	if len(sources) < 2 {
+		return nil, errors.New("at least 2 source images are needed")
+	}
+	cfg = withDefaults(cfg)
+
+	// Hash the originals
+	originals, err := hashAll(sources, cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Sources: len(sources), Threshold: cfg.Threshold}
+	for _, attack := range cfg.Attacks {
+		attacked, err := hashAll(sources, cfg, attack.Apply)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", attack.Name, err)
+		}
+
+		for a, alg := range cfg.Algorithms {
+			pos, neg := scores(originals[a], attacked[a], bits(alg))
+			report.Curves = append(report.Curves, curve(alg, attack, pos, neg, cfg))
+		}
+	}
+	return report, nil
+}
+
+// withDefaults fills in the zero values of a Config.
+func withDefaults(cfg Config) Config {
+	if len(cfg.Algorithms) == 0 {
+		cfg.Algorithms = DefaultAlgorithms()
+	}
+	if len(cfg.Attacks) == 0 {
+		cfg.Attacks = DefaultAttacks()
+	}
+	if len(cfg.Thresholds) == 0 {
+		for i := 0; i <= 100; i++ {
+			cfg.Thresholds = append(cfg.Thresholds, float64(i)/100)
+		}
+	}
+	if cfg.Threshold == 0 {
+		cfg.Threshold = 0.85
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = runtime.NumCPU()
+	}
+	return cfg
+}
+
+// hashAll hashes every source, after applying 'attack' to it if it isn't
+// nil, with every algorithm. The result is indexed by [algorithm][source].
+func hashAll(sources []image.Image, cfg Config, attack func(image.Image) image.Image) ([][][]byte, error) {
+	hashes := make([][][]byte, len(cfg.Algorithms))
+	for a := range hashes {
+		hashes[a] = make([][]byte, len(sources))
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	sem := make(chan struct{}, cfg.Workers)
+
+	for s := range sources {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(s int) {
+			defer func() { <-sem; wg.Done() }()
+
+			img := sources[s]
+			if attack != nil {
+				img = attack(img)
+			}
+			for a, alg := range cfg.Algorithms {
+				h, err := alg.Hash(img, alg.HashLen)
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("%s:%d: %v", alg.Name, alg.HashLen, err)
+					}
+					mu.Unlock()
+					return
+				}
+				hashes[a][s] = h
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	return hashes, firstErr
+}
+
+// scores returns the similarities of the positive pairs (an attacked source
+// and its original), and of the negative pairs (an attacked source and any
+// other original), for hashes of 'numBits' bits.
+func scores(originals, attacked [][]byte, numBits int) ([]float64, []float64) {
+	var pos, neg []float64
+	for i, h := range attacked {
+		for j, orig := range originals {
+			if i == j {
+				pos = append(pos, imagehash.SimilarityBits(h, orig, numBits))
+			} else {
+				neg = append(neg, imagehash.SimilarityBits(h, orig, numBits))
+			}
+		}
+	}
+	return pos, neg
+}
+
+// bits returns the number of bits of the hashes of an algorithm, without
+// their padding, or 0 if it isn't a registered hasher and the padding is
+// counted.
+func bits(alg Algorithm) int {
+	hasher, err := imagehash.NewHasher(alg.Name + ":" + strconv.Itoa(alg.HashLen))
+	if err != nil {
+		return 0
+	}
+	return hasher.Bits()
+}
+
+// curve computes the ROC curve of an algorithm against an attack.
+func curve(alg Algorithm, attack Attack, pos, neg []float64, cfg Config) Curve {
+	c := Curve{
+		Algorithm: alg.Name,
+		HashLen:   alg.HashLen,
+		Attack:    attack.Name,
+		AUC:       AUC(pos, neg),
+		TPR:       rate(pos, cfg.Threshold),
+		FPR:       rate(neg, cfg.Threshold),
+	}
+	for _, t := range cfg.Thresholds {
+		c.Points = append(c.Points, Point{t, rate(pos, t), rate(neg, t)})
+	}
+	return c
+}
+
+// rate returns the fraction of scores of at least 'threshold'.
+func rate(scores []float64, threshold float64) float64 {
+	if len(scores) == 0 {
+		return 0
+	}
+	count := 0
+	for _, s := range scores {
+		if s >= threshold {
+			count++
+		}
+	}
+	return float64(count) / float64(len(scores))
+}
+
+// AUC returns the area under the ROC curve of positive and negative scores:
+// the probability that a random positive scores higher than a random
+// negative, with ties counting for half.
+func AUC(pos, neg []float64) float64 {
+	if len(pos) == 0 || len(neg) == 0 {
+		return 0
+	}
+
+	type labeled struct {
+		score    float64
+		positive bool
+	}
+	all := make([]labeled, 0, len(pos)+len(neg))
+	for _, s := range pos {
+		all = append(all, labeled{s, true})
+	}
+	for _, s := range neg {
+		all = append(all, labeled{s, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].score < all[j].score })
+
+	// Sum up the ranks of the positives, giving tied scores their average rank
+	var rankSum float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].score == all[i].score {
+			j++
+		}
+		avgRank := float64(i+j+1) / 2 // Ranks start at 1
+		for k := i; k < j; k++ {
+			if all[k].positive {
+				rankSum += avgRank
+			}
+		}
+		i = j
+	}
+
+	np, nn := float64(len(pos)), float64(len(neg))
+	return (rankSum - np*(np+1)/2) / (np * nn)
+}
+
+// WriteSummaryCSV writes one row per algorithm and attack, with the AUC and
+// the true and false positive rates at the report's threshold.
+func (r *Report) WriteSummaryCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"algorithm", "hash_len", "attack", "auc", "tpr", "fpr", "threshold"})
+	for _, c := range r.Curves {
+		cw.Write([]string{c.Algorithm, strconv.Itoa(c.HashLen), c.Attack,
+			formatRate(c.AUC), formatRate(c.TPR), formatRate(c.FPR), formatRate(r.Threshold)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteROCCSV writes one row per point of every ROC curve.
+func (r *Report) WriteROCCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"algorithm", "hash_len", "attack", "threshold", "tpr", "fpr"})
+	for _, c := range r.Curves {
+		for _, p := range c.Points {
+			cw.Write([]string{c.Algorithm, strconv.Itoa(c.HashLen), c.Attack,
+				formatRate(p.Threshold), formatRate(p.TPR), formatRate(p.FPR)})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the whole report, including the ROC curves, as JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// formatRate formats a rate or a threshold for the CSV tables.
+func formatRate(v float64) string {
+	return strconv.FormatFloat(v, 'f', 4, 64)
+}
@@ -0,0 +1,141 @@
+/*
+
+Testing suite for the evaluation harness.
+
+1. Test loading the test images, skipping other files
+2. Test that a single source image is rejected
+3. Test the AUC of separated, inverted and tied scores
+4. Test a small evaluation, and its CSV and JSON reports
+5. Test that the attacks keep the images non-empty
+6. Test evaluating a registered hasher
+
+*/
+
+package eval
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"strings"
+	"testing"
+
+	"github.com/devedge/imagehash"
+)
+
+// Test loading the images of the testdata folder
+func TestLoadImages(t *testing.T) {
+	images, err := LoadImages("../testdata")
+
+	if err != nil {
+		t.Errorf("load images test failed with error: %v", err)
+	} else if len(images) != 6 {
+		t.Errorf("load images test [6] failed: [%d]", len(images))
+	}
+}
+
+// Test that at least two sources are needed
+func TestRunSingleSource(t *testing.T) {
+	src, _ := imagehash.OpenImg("../testdata/lena_512.png")
+
+	if _, err := Run([]image.Image{src}, Config{}); err == nil {
+		t.Errorf("evaluation with a single source didn't fail")
+	}
+}
+
+// Test the AUC of perfectly separated, inverted and tied scores
+func TestAUC(t *testing.T) {
+	if auc := AUC([]float64{0.9, 1}, []float64{0.1, 0.5}); auc != 1 {
+		t.Errorf("separated AUC test [1] failed: [%f]", auc)
+	}
+	if auc := AUC([]float64{0.1, 0.5}, []float64{0.9, 1}); auc != 0 {
+		t.Errorf("inverted AUC test [0] failed: [%f]", auc)
+	}
+	if auc := AUC([]float64{0.5, 0.5}, []float64{0.5}); auc != 0.5 {
+		t.Errorf("tied AUC test [0.5] failed: [%f]", auc)
+	}
+}
+
+// Test a small evaluation of two algorithms against two attacks
+func TestRun(t *testing.T) {
+	var sources []image.Image
+	for _, name := range []string{"lena_512", "lena_inverted_512", "rand_512"} {
+		src, _ := imagehash.OpenImg("../testdata/" + name + ".png")
+		sources = append(sources, src)
+	}
+
+	cfg := Config{
+		Algorithms: []Algorithm{{"dhash", 8, imagehash.Dhash}, {"ahash", 8, imagehash.Ahash}},
+		Attacks:    []Attack{Resize(0.5), JPEG(90)},
+		Thresholds: []float64{0, 0.5, 1},
+	}
+	report, err := Run(sources, cfg)
+	if err != nil {
+		t.Errorf("evaluation test failed with error: %v", err)
+		return
+	}
+
+	if len(report.Curves) != 4 {
+		t.Errorf("evaluation curves test [4] failed: [%d]", len(report.Curves))
+		return
+	}
+	for _, c := range report.Curves {
+		if c.AUC < 0.9 {
+			t.Errorf("%s:%d %s AUC is too low: %f", c.Algorithm, c.HashLen, c.Attack, c.AUC)
+		}
+		if len(c.Points) != 3 || c.Points[0].TPR != 1 || c.Points[0].FPR != 1 {
+			t.Errorf("%s:%d %s ROC points are wrong: %v", c.Algorithm, c.HashLen, c.Attack, c.Points)
+		}
+	}
+
+	var summary, roc, js bytes.Buffer
+	report.WriteSummaryCSV(&summary)
+	report.WriteROCCSV(&roc)
+	report.WriteJSON(&js)
+
+	if lines := strings.Count(summary.String(), "\n"); lines != 5 {
+		t.Errorf("summary CSV lines test [5] failed: [%d]", lines)
+	}
+	if lines := strings.Count(roc.String(), "\n"); lines != 13 {
+		t.Errorf("ROC CSV lines test [13] failed: [%d]", lines)
+	}
+
+	var decoded Report
+	if err := json.Unmarshal(js.Bytes(), &decoded); err != nil || len(decoded.Curves) != 4 {
+		t.Errorf("JSON report test failed: %v", err)
+	}
+}
+
+// Test that every default attack returns a non-empty image
+func TestDefaultAttacks(t *testing.T) {
+	src, _ := imagehash.OpenImg("../testdata/lena_256.png")
+
+	for _, attack := range DefaultAttacks() {
+		if res := attack.Apply(src); res.Bounds().Empty() {
+			t.Errorf("attack %s returned an empty image", attack.Name)
+		}
+	}
+}
+
+// Test that registered hashers can be evaluated, but not float ones
+func TestAlgorithmFromHasher(t *testing.T) {
+	src, _ := imagehash.OpenImg("../testdata/lena_256.png")
+
+	alg, err := AlgorithmFromHasher("dhash-h:16")
+	if err != nil {
+		t.Errorf("algorithm from hasher test failed with error: %v", err)
+		return
+	}
+	exp, _ := imagehash.DhashHorizontal(src, 16)
+	if hash, _ := alg.Hash(src, alg.HashLen); !bytes.Equal(exp, hash) || alg.Name != "dhash-h" || alg.HashLen != 16 {
+		t.Errorf("algorithm from hasher test [%x] failed: [%s %x]", exp, alg.Name, hash)
+	}
+
+	alg, _ = AlgorithmFromHasher("colormoment")
+	if _, err := alg.Hash(src, alg.HashLen); err == nil {
+		t.Errorf("evaluating a float hasher didn't fail")
+	}
+	if _, err := AlgorithmFromHasher("unknown"); err == nil {
+		t.Errorf("algorithm from an unknown hasher didn't fail")
+	}
+}
@@ -0,0 +1,264 @@
+/*
+
+Implements GridHash, which hashes the tiles of a grid laid over an image,
+to find an image embedded in a larger one, such as a collage or a
+screenshot, which whole-image hashes can't: the hash of the collage
+depends on everything around the embedded image.
+
+The grid can be laid at several scales, each with twice the tiles per side
+of the previous one, and its tiles can overlap, so that some tiles of the
+larger image line up with the tiles of the embedded one. MatchGrids then
+compares every tile of a query with every tile of a candidate, and fits the
+offset and scale placing the query in the candidate that the most matching
+tiles agree on. Flat tiles, whose hash has all its bits equal, match any
+other flat area, so they are left out of the matching.
+
+Usage:
+  hasher,err := imagehash.NewHasher("dhash:8")
+  query,err := imagehash.NewGridHash(photo, hasher, imagehash.GridOptions{})
+  candidate,err := imagehash.NewGridHash(collage, hasher, imagehash.GridOptions{Scales: 3, Overlap: 0.5})
+  match,err := imagehash.MatchGrids(query, candidate, 12)
+
+*/
+
+package imagehash
+
+import (
+	"context"
+	"errors"
+	"image"
+	"math"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+)
+
+// MinTileSize is the smallest width or height of a tile, in pixels.
+const MinTileSize = 8
+
+// GridOptions configures NewGridHash. Zero values are replaced by defaults.
+type GridOptions struct {
+	Cols, Rows int     // Tiles per row and per column at the first scale; 4 if zero
+	Scales     int     // Number of scales, each with twice the tiles per side of the previous one; 1 if zero
+	Overlap    float64 // Fraction of a tile covered by the next one, from 0 to 0.9
+}
+
+// Tile is a tile of a GridHash.
+type Tile struct {
+	Rect  image.Rectangle // Position of the tile in the image
+	Scale int             // Index of the scale of the tile, from 0
+	Hash  Hash
+}
+
+// GridHash is the hashes of the tiles of an image.
+type GridHash struct {
+	Algorithm string
+	Bounds    image.Rectangle // Bounds of the image
+	Tiles     []Tile
+}
+
+// TileMatch is a tile of a query matching a tile of a candidate.
+type TileMatch struct {
+	Query, Candidate int // Indexes of the tiles
+	Distance         int // Number of differing bits
+}
+
+// GridMatch is the result of matching a query GridHash with a candidate.
+type GridMatch struct {
+	Matches  []TileMatch     // Every pair of tiles within the maximum distance
+	Inliers  []TileMatch     // The matches agreeing with Scale and Offset
+	Scale    float64         // Size of the query in the candidate, relative to its own
+	Offset   image.Point     // Position of the top left corner of the query in the candidate
+	Rect     image.Rectangle // Area of the candidate covered by the query
+	Coverage float64         // Fraction of the tiles of the query among the inliers, from 0 to 1
+}
+
+// NewGridHash hashes the tiles of an image with a hasher.
+func NewGridHash(img image.Image, hasher Hasher, opts GridOptions) (GridHash, error) {
+	return NewGridHashContext(context.Background(), img, hasher, opts)
+}
+
+// NewGridHashContext is the same as NewGridHash, but returns ctx.Err() as
+// soon as the context is done.
+func NewGridHashContext(ctx context.Context, img image.Image, hasher Hasher, opts GridOptions) (GridHash, error) {
+	if opts.Cols == 0 {
+		opts.Cols = 4
+	}
+	if opts.Rows == 0 {
+		opts.Rows = 4
+	}
+	if opts.Scales == 0 {
+		opts.Scales = 1
+	}
+	if opts.Cols < 0 || opts.Rows < 0 || opts.Scales < 0 || opts.Scales > 8 {
+		return GridHash{}, errors.New("invalid grid of " + strconv.Itoa(opts.Cols) + "x" + strconv.Itoa(opts.Rows) +
+			" tiles at " + strconv.Itoa(opts.Scales) + " scales")
+	}
+	if opts.Overlap < 0 || opts.Overlap > 0.9 {
+		return GridHash{}, errors.New("overlap must be between 0 and 0.9")
+	}
+
+	bounds := img.Bounds()
+	grid := GridHash{Algorithm: hasher.Name(), Bounds: bounds}
+	for scale := 0; scale < opts.Scales; scale++ {
+		xs := tileSpans(bounds.Min.X, bounds.Dx(), opts.Cols<<uint(scale), opts.Overlap)
+		ys := tileSpans(bounds.Min.Y, bounds.Dy(), opts.Rows<<uint(scale), opts.Overlap)
+		if xs == nil || ys == nil {
+			return GridHash{}, errors.New("tiles of the scale " + strconv.Itoa(scale) + " are smaller than " +
+				strconv.Itoa(MinTileSize) + " pixels")
+		}
+
+		for _, y := range ys {
+			for _, x := range xs {
+				rect := image.Rect(x[0], y[0], x[1], y[1])
+				if err := ctx.Err(); err != nil {
+					return GridHash{}, err
+				}
+				h, err := HashContext(ctx, hasher, imaging.Crop(img, rect))
+				if err != nil {
+					return GridHash{}, err
+				}
+				grid.Tiles = append(grid.Tiles, Tile{Rect: rect, Scale: scale, Hash: h})
+			}
+		}
+	}
+	return grid, nil
+}
+
+// tileSpans splits 'length' pixels from 'start' into 'n' spans, each
+// overlapping the next one by 'overlap' of its length. It returns nil if
+// the spans are shorter than MinTileSize.
+func tileSpans(start, length, n int, overlap float64) [][2]int {
+	// n tiles of size s, each starting s*(1-overlap) after the previous
+	// one, end at s + (n-1)*s*(1-overlap) = length
+	size := float64(length) / (1 + float64(n-1)*(1-overlap))
+	if size < MinTileSize {
+		return nil
+	}
+	step := size * (1 - overlap)
+
+	spans := make([][2]int, n)
+	for i := range spans {
+		from := start + int(round(float64(i)*step))
+		spans[i] = [2]int{from, from + int(round(size))}
+	}
+	spans[n-1][1] = start + length // Absorb the rounding in the last tile
+	return spans
+}
+
+// MatchGrids compares every tile of a query with every tile of a candidate,
+// keeping the pairs within 'maxDistance' bits, and fits the placement of the
+// query in the candidate which the most pairs agree on. The hashes must be
+// binary. When no tiles match, the GridMatch is empty.
+func MatchGrids(query, candidate GridHash, maxDistance int) (GridMatch, error) {
+	if query.Algorithm != candidate.Algorithm {
+		return GridMatch{}, errors.New("cannot match a " + query.Algorithm + " grid with a " +
+			candidate.Algorithm + " grid")
+	}
+	if len(query.Tiles) > 0 && len(candidate.Tiles) > 0 {
+		if err := CheckVersions(query.Tiles[0].Hash, candidate.Tiles[0].Hash); err != nil {
+			return GridMatch{}, err
+		}
+	}
+	for _, tiles := range [][]Tile{query.Tiles, candidate.Tiles} {
+		for _, tile := range tiles {
+			if tile.Hash.Kind != BinaryKind {
+				return GridMatch{}, errors.New("grids need binary hashes, not " + tile.Hash.Algorithm)
+			}
+		}
+	}
+
+	var match GridMatch
+	for i, q := range query.Tiles {
+		if isFlat(q.Hash.Value) {
+			continue
+		}
+		for j, c := range candidate.Tiles {
+			if isFlat(c.Hash.Value) {
+				continue
+			}
+			if dist := GetBitDistance(q.Hash.Value, c.Hash.Value); dist <= maxDistance {
+				match.Matches = append(match.Matches, TileMatch{i, j, dist})
+			}
+		}
+	}
+
+	// Every match suggests a placement: keep the one the most matches agree
+	// with, then average the placements of those matches
+	bestInliers, bestDist := []TileMatch(nil), 0
+	tried := make(map[[3]float64]bool)
+	for _, m := range match.Matches {
+		scale, x, y := matchPlacement(query, candidate, m)
+		if tried[[3]float64{scale, x, y}] {
+			continue
+		}
+		tried[[3]float64{scale, x, y}] = true
+		inliers, dist := placementInliers(query, candidate, match.Matches, scale, x, y)
+		if len(inliers) > len(bestInliers) || (len(inliers) == len(bestInliers) && dist < bestDist) {
+			bestInliers, bestDist = inliers, dist
+		}
+	}
+	if len(bestInliers) == 0 {
+		return match, nil
+	}
+
+	var sumScale, sumX, sumY float64
+	queryTiles := make(map[int]bool)
+	for _, m := range bestInliers {
+		scale, x, y := matchPlacement(query, candidate, m)
+		sumScale, sumX, sumY = sumScale+scale, sumX+x, sumY+y
+		queryTiles[m.Query] = true
+	}
+	n := float64(len(bestInliers))
+	match.Inliers = bestInliers
+	match.Scale = sumScale / n
+	match.Offset = image.Pt(int(round(sumX/n)), int(round(sumY/n)))
+	match.Rect = image.Rect(0, 0, int(round(float64(query.Bounds.Dx())*match.Scale)),
+		int(round(float64(query.Bounds.Dy())*match.Scale))).Add(match.Offset)
+	match.Coverage = float64(len(queryTiles)) / float64(len(query.Tiles))
+	return match, nil
+}
+
+// isFlat reports whether all the bits of a hash are equal.
+func isFlat(hash []byte) bool {
+	for _, b := range hash {
+		if b != hash[0] || (b != 0 && b != 0xff) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchPlacement returns the placement of the query suggested by a match.
+func matchPlacement(query, candidate GridHash, m TileMatch) (scale, x, y float64) {
+	return tilePlacement(query.Tiles[m.Query].Rect.Sub(query.Bounds.Min), candidate.Tiles[m.Candidate].Rect)
+}
+
+// tilePlacement returns the scale and the position of the top left corner
+// of the query in the candidate, if the query tile 'q', relative to the
+// corner, is the candidate tile 'c'. The scale is the average of the
+// horizontal and vertical ones.
+func tilePlacement(q, c image.Rectangle) (scale, x, y float64) {
+	scale = (float64(c.Dx())/float64(q.Dx()) + float64(c.Dy())/float64(q.Dy())) / 2
+	return scale, float64(c.Min.X) - scale*float64(q.Min.X), float64(c.Min.Y) - scale*float64(q.Min.Y)
+}
+
+// placementInliers returns the matches agreeing with a placement of the
+// query, along with their total distance. A match agrees if the query tile,
+// once placed, is within a quarter of its size of the candidate tile.
+func placementInliers(query, candidate GridHash, matches []TileMatch, scale, x, y float64) ([]TileMatch, int) {
+	var inliers []TileMatch
+	dist := 0
+	for _, m := range matches {
+		q, c := query.Tiles[m.Query].Rect.Sub(query.Bounds.Min), candidate.Tiles[m.Candidate].Rect
+		w, h := scale*float64(q.Dx()), scale*float64(q.Dy())
+		dx := x + scale*float64(q.Min.X) - float64(c.Min.X)
+		dy := y + scale*float64(q.Min.Y) - float64(c.Min.Y)
+		if math.Abs(dx) <= w/4 && math.Abs(dy) <= h/4 &&
+			math.Abs(w-float64(c.Dx())) <= w/4 && math.Abs(h-float64(c.Dy())) <= h/4 {
+			inliers = append(inliers, m)
+			dist += m.Distance
+		}
+	}
+	return inliers, dist
+}
@@ -0,0 +1,175 @@
+/*
+
+Testing suite for GridHash and the matching of grids.
+
+1. Test the layout of the tiles, with scales and overlap
+2. Test that the tiles are hashed by the hasher
+3. Test finding an image embedded in a collage, at its size and scaled
+4. Test that an image which isn't in the collage doesn't match
+5. Test invalid grids and matches
+6. Test that hashing a grid stops on a cancelled context
+
+*/
+
+package imagehash
+
+import (
+	"context"
+	"image"
+	"image/draw"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// collage returns the random test image resized to 768x768, with 'img'
+// resized to 'size' and drawn at 'at'.
+func collage(t *testing.T, img image.Image, size int, at image.Point) image.Image {
+	random, err := OpenImg("./testdata/rand_512.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := imaging.Resize(random, 768, 768, imaging.Lanczos)
+	embedded := imaging.Resize(img, size, size, imaging.Lanczos)
+	draw.Draw(dst, embedded.Bounds().Add(at), embedded, image.Point{}, draw.Src)
+	return dst
+}
+
+// Test the rectangles of the tiles
+func TestGridHashLayout(t *testing.T) {
+	hasher, _ := NewHasher("ahash:8")
+	img := image.NewGray(image.Rect(0, 0, 256, 128))
+
+	grid, err := NewGridHash(img, hasher, GridOptions{Scales: 2})
+	if err != nil || len(grid.Tiles) != 16+64 {
+		t.Fatalf("grid tiles test [80] failed: [%v %d]", err, len(grid.Tiles))
+	}
+	if grid.Tiles[5].Rect != image.Rect(64, 32, 128, 64) || grid.Tiles[5].Scale != 0 {
+		t.Errorf("grid tile test [%v 0] failed: [%v %d]", image.Rect(64, 32, 128, 64), grid.Tiles[5].Rect, grid.Tiles[5].Scale)
+	}
+	if last := grid.Tiles[79]; last.Rect != image.Rect(224, 112, 256, 128) || last.Scale != 1 {
+		t.Errorf("grid last tile test [%v 1] failed: [%v %d]", image.Rect(224, 112, 256, 128), last.Rect, last.Scale)
+	}
+
+	// Four tiles of 40 pixels, every one starting 20 pixels after the last
+	spans := tileSpans(10, 100, 4, 0.5)
+	if exp := [][2]int{{10, 50}, {30, 70}, {50, 90}, {70, 110}}; !reflect.DeepEqual(spans, exp) {
+		t.Errorf("overlap spans test [%v] failed: [%v]", exp, spans)
+	}
+}
+
+// Test that every tile is hashed by the hasher
+func TestGridHashTiles(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_256.png")
+	hasher, _ := NewHasher("dhash:8")
+
+	grid, err := NewGridHash(src, hasher, GridOptions{Cols: 2, Rows: 3, Overlap: 0.25})
+	if err != nil || len(grid.Tiles) != 6 || grid.Algorithm != "dhash:8" || grid.Bounds != src.Bounds() {
+		t.Fatalf("grid hash test [6 dhash:8] failed: [%v %d %s]", err, len(grid.Tiles), grid.Algorithm)
+	}
+	for _, tile := range grid.Tiles {
+		exp, _ := hasher.Hash(imaging.Crop(src, tile.Rect))
+		if !reflect.DeepEqual(tile.Hash, exp) {
+			t.Errorf("tile %v hash test [%x] failed: [%x]", tile.Rect, exp.Value, tile.Hash.Value)
+		}
+	}
+}
+
+// Test finding an image in collages, at its size and scaled down
+func TestMatchGrids(t *testing.T) {
+	lena, _ := OpenImg("./testdata/lena_256.png")
+	hasher, _ := NewHasher("dhash:8")
+	query, _ := NewGridHash(lena, hasher, GridOptions{})
+
+	tests := []struct {
+		size  int
+		at    image.Point
+		cols  int // Tiles per side of the candidate grid at its first scale
+		scale float64
+	}{
+		{256, image.Pt(256, 512), 3, 1},
+		{192, image.Pt(384, 192), 4, 0.75},
+	}
+	for _, test := range tests {
+		img := collage(t, lena, test.size, test.at)
+		candidate, err := NewGridHash(img, hasher, GridOptions{Cols: test.cols, Rows: test.cols, Scales: 3})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		match, err := MatchGrids(query, candidate, 12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(match.Scale-test.scale) > 0.01 || match.Offset != test.at {
+			t.Errorf("%d embedded placement test [%f %v] failed: [%f %v]", test.size, test.scale, test.at,
+				match.Scale, match.Offset)
+		}
+		if exp := image.Rect(0, 0, test.size, test.size).Add(test.at); match.Rect != exp {
+			t.Errorf("%d embedded rect test [%v] failed: [%v]", test.size, exp, match.Rect)
+		}
+		if match.Coverage < 0.75 || len(match.Inliers) > len(match.Matches) {
+			t.Errorf("%d embedded coverage test [>0.75] failed: [%f]", test.size, match.Coverage)
+		}
+	}
+}
+
+// Test that an image which isn't in the collage barely matches
+func TestMatchGridsMissing(t *testing.T) {
+	lena, _ := OpenImg("./testdata/lena_256.png")
+	inverted, _ := OpenImg("./testdata/lena_inverted_512.png")
+	hasher, _ := NewHasher("dhash:8")
+
+	query, _ := NewGridHash(inverted, hasher, GridOptions{})
+	candidate, _ := NewGridHash(collage(t, lena, 256, image.Pt(256, 512)), hasher, GridOptions{Cols: 3, Rows: 3, Scales: 3})
+	match, err := MatchGrids(query, candidate, 12)
+	if err != nil || match.Coverage > 0.2 {
+		t.Errorf("missing image coverage test [<0.2] failed: [%v %f]", err, match.Coverage)
+	}
+
+	// Flat tiles are left out, so a blank image matches nothing
+	white, _ := OpenImg("./testdata/white_512.png")
+	query, _ = NewGridHash(white, hasher, GridOptions{})
+	candidate, _ = NewGridHash(white, hasher, GridOptions{})
+	if match, _ := MatchGrids(query, candidate, 12); len(match.Matches) != 0 || match.Coverage != 0 {
+		t.Errorf("flat tiles test [0 0] failed: [%d %f]", len(match.Matches), match.Coverage)
+	}
+}
+
+// Test invalid options, tiles too small, and incompatible grids
+func TestGridHashInvalid(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_256.png")
+	dhash, _ := NewHasher("dhash:8")
+	ahash, _ := NewHasher("ahash:8")
+	colormoment, _ := NewHasher("colormoment")
+
+	for _, opts := range []GridOptions{{Cols: -1}, {Scales: 9}, {Overlap: 0.95}, {Overlap: -0.1}, {Scales: 5}} {
+		if _, err := NewGridHash(src, dhash, opts); err == nil {
+			t.Errorf("invalid options %v test didn't fail", opts)
+		}
+	}
+
+	grid1, _ := NewGridHash(src, dhash, GridOptions{})
+	grid2, _ := NewGridHash(src, ahash, GridOptions{})
+	if _, err := MatchGrids(grid1, grid2, 12); err == nil {
+		t.Errorf("mixed grids test didn't fail")
+	}
+	floats, _ := NewGridHash(src, colormoment, GridOptions{Cols: 1, Rows: 1})
+	if _, err := MatchGrids(floats, floats, 12); err == nil {
+		t.Errorf("float grids test didn't fail")
+	}
+}
+
+// Test that hashing a grid stops on a cancelled context
+func TestGridHashCancelled(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_256.png")
+	hasher, _ := NewHasher("dhash:8")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := NewGridHashContext(ctx, src, hasher, GridOptions{}); err != context.Canceled {
+		t.Errorf("cancelled grid test [%v] failed: [%v]", context.Canceled, err)
+	}
+}
@@ -0,0 +1,377 @@
+/*
+
+Package hashcache caches the hashes of image files, so that scans over a
+large library only decode the files which changed since the last scan.
+
+Hashes are keyed by the path of the file and the name of the hasher which
+computed them, such as "dhash:8". Every entry records the size and the
+modification time of its file, and is only used while they are unchanged,
+which a single stat checks. With the Digest option, the SHA-256 of every
+file is stored too: a file whose modification time changed, but not its
+size, is then digested, and keeps its hashes if its content is the same.
+Digesting is still much cheaper than decoding.
+
+The cache is stored in a single JSON file, which is only written by Save,
+or Close, as a new file replacing the old one. A Cache is safe for
+concurrent use.
+
+Usage:
+  cache,err := hashcache.Open("hashes.json", nil)
+  defer cache.Close()
+
+  hasher,err := imagehash.NewHasher("dhash:8")
+  hash,err := cache.Hash("photos/img_0001.jpg", hasher)
+
+*/
+
+package hashcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/devedge/imagehash"
+)
+
+// Version is the version of the cache file format.
+const Version = 1
+
+// Options configures a Cache.
+type Options struct {
+	// Digest stores the SHA-256 of every file, so that a file whose
+	// modification time changed keeps its hashes if its content didn't.
+	Digest bool
+}
+
+// Stats counts the lookups of a Cache since it was opened.
+type Stats struct {
+	Hits    int // Hashes found in the cache
+	Misses  int // Hashes computed, because they were missing or stale
+	Entries int // Files in the cache
+}
+
+// Cache is a file-based cache of the hashes of image files.
+type Cache struct {
+	path   string
+	digest bool
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	dirty   bool
+	stats   Stats
+}
+
+// entry holds the hashes of a file, along with what they are valid for.
+type entry struct {
+	Path    string                    `json:"path"`
+	Size    int64                     `json:"size"`
+	ModTime int64                     `json:"mtime"` // In nanoseconds since the epoch
+	Digest  string                    `json:"sha256,omitempty"`
+	Hashes  map[string]imagehash.Hash `json:"hashes"`
+}
+
+// cacheFile is the content of the cache file.
+type cacheFile struct {
+	Version int      `json:"version"`
+	Entries []*entry `json:"entries"`
+}
+
+// Open loads the cache stored at 'path', or returns an empty cache if the
+// file doesn't exist yet. 'opts' can be nil.
+func Open(path string, opts *Options) (*Cache, error) {
+	c := &Cache{path: path, entries: make(map[string]*entry)}
+	if opts != nil {
+		c.digest = opts.Digest
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file cacheFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, errors.New("invalid cache file " + path + ": " + err.Error())
+	}
+	if file.Version != Version {
+		return nil, errors.New("unsupported cache file version " + strconv.Itoa(file.Version))
+	}
+	for _, e := range file.Entries {
+		c.entries[e.Path] = e
+	}
+	return c, nil
+}
+
+// Hash returns the hash of an image file, computing it with 'hasher' only
+// if the cache doesn't hold a valid one.
+func (c *Cache) Hash(file string, hasher imagehash.Hasher) (imagehash.Hash, error) {
+	return c.HashContext(context.Background(), file, hasher)
+}
+
+// HashContext is the same as Hash, but returns ctx.Err() as soon as the
+// context is done.
+func (c *Cache) HashContext(ctx context.Context, file string, hasher imagehash.Hasher) (imagehash.Hash, error) {
+	hashes, err := c.Hashes(ctx, file, hasher)
+	if err != nil {
+		return imagehash.Hash{}, err
+	}
+	return hashes[0], nil
+}
+
+// Hashes returns the hashes of an image file computed by every hasher, in
+// the same order. The file is decoded at most once, and only if some of
+// the hashes aren't cached.
+func (c *Cache) Hashes(ctx context.Context, file string, hashers ...imagehash.Hasher) ([]imagehash.Hash, error) {
+	path, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes, missing := c.lookup(path, info, hashers)
+	if len(missing) == 0 {
+		return hashes, nil
+	}
+
+	// Decode the file, digesting it along the way if needed
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	var digest hash.Hash
+	if c.digest {
+		digest = sha256.New()
+		r = io.TeeReader(f, digest)
+	}
+	img, err := imagehash.DecodeContext(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range missing {
+		if hashes[i], err = imagehash.HashContext(ctx, hashers[i], img); err != nil {
+			return nil, err
+		}
+	}
+
+	sum := ""
+	if digest != nil {
+		// The decoder may stop before the end of the file
+		if _, err := io.Copy(digest, f); err != nil {
+			return nil, err
+		}
+		sum = hex.EncodeToString(digest.Sum(nil))
+	}
+	c.store(path, info, sum, hashers, hashes, missing)
+	return hashes, nil
+}
+
+// lookup returns the cached hashes of a file, and the indexes of the
+// hashers whose hashes are missing.
+func (c *Cache) lookup(path string, info os.FileInfo, hashers []imagehash.Hasher) ([]imagehash.Hash, []int) {
+	c.mu.Lock()
+	e, ok := c.entries[path]
+	valid := ok && e.matches(info)
+	digest := ""
+	if ok && !valid && c.digest && e.Size == info.Size() {
+		digest = e.Digest
+	}
+	c.mu.Unlock()
+
+	// The file was touched without changing its size: check its content
+	if digest != "" {
+		if sum, err := digestFile(path); err == nil && sum == digest {
+			valid = true
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if valid && !e.matches(info) {
+		e.ModTime = info.ModTime().UnixNano()
+		c.dirty = true
+	}
+
+	hashes := make([]imagehash.Hash, len(hashers))
+	var missing []int
+	for i, hasher := range hashers {
+		var h imagehash.Hash
+		found := false
+		if valid {
+			h, found = e.Hashes[hasher.Name()]
+			// Hashes of another version of the algorithm are stale too
+			found = found && h.Version == imagehash.HasherVersion(hasher)
+		}
+		if found {
+			hashes[i] = h
+			c.stats.Hits++
+		} else {
+			missing = append(missing, i)
+			c.stats.Misses++
+		}
+	}
+	return hashes, missing
+}
+
+// store adds the computed hashes of a file to its entry, or replaces its
+// entry if it is stale.
+func (c *Cache) store(path string, info os.FileInfo, digest string, hashers []imagehash.Hasher,
+	hashes []imagehash.Hash, computed []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[path]
+	if !ok || !e.matches(info) {
+		e = &entry{
+			Path:    path,
+			Size:    info.Size(),
+			ModTime: info.ModTime().UnixNano(),
+			Hashes:  make(map[string]imagehash.Hash),
+		}
+		c.entries[path] = e
+	}
+	if digest != "" {
+		e.Digest = digest
+	}
+	for _, i := range computed {
+		e.Hashes[hashers[i].Name()] = hashes[i]
+	}
+	c.dirty = true
+}
+
+// matches reports whether an entry is still valid for a file.
+func (e *entry) matches(info os.FileInfo) bool {
+	return e.Size == info.Size() && e.ModTime == info.ModTime().UnixNano()
+}
+
+// digestFile returns the hex SHA-256 of a file.
+func digestFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	digest := sha256.New()
+	if _, err := io.Copy(digest, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// Invalidate drops the hashes of a file.
+func (c *Cache) Invalidate(file string) error {
+	path, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[path]; ok {
+		delete(c.entries, path)
+		c.dirty = true
+	}
+	return nil
+}
+
+// Prune drops the entries of the files which were removed or modified, and
+// returns how many were dropped.
+func (c *Cache) Prune() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pruned := 0
+	for path, e := range c.entries {
+		if info, err := os.Stat(path); err != nil || !e.matches(info) {
+			delete(c.entries, path)
+			pruned++
+		}
+	}
+	if pruned > 0 {
+		c.dirty = true
+	}
+	return pruned
+}
+
+// Stats returns the number of hits and misses since the cache was opened,
+// and the number of files it holds.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	return stats
+}
+
+// Save writes the cache to its file, if it changed since it was opened or
+// last saved.
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+
+	file := cacheFile{Version: Version, Entries: make([]*entry, 0, len(c.entries))}
+	for _, e := range c.entries {
+		file.Entries = append(file.Entries, e)
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	// Replace the file at once, so that it is never left half written, from
+	// a temporary file of its own synced to disk
+	f, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0644) // TempFile creates the file readable by its owner only
+	}
+	if err == nil {
+		err = os.Rename(tmp, c.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// Close saves the cache.
+func (c *Cache) Close() error {
+	return c.Save()
+}
@@ -0,0 +1,264 @@
+/*
+
+Testing suite for the hash cache.
+
+1. Test that a hash is only computed on the first lookup
+2. Test that several hashers share a single decoding
+3. Test that modified files, and hashes of other versions, are hashed again
+4. Test that touched files keep their hashes with the Digest option
+5. Test saving and reloading the cache, through a temporary file
+6. Test pruning and invalidating entries
+7. Test concurrent lookups
+8. Test invalid cache files and images
+
+*/
+
+package hashcache
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devedge/imagehash"
+)
+
+// tempLibrary returns a temporary folder holding copies of two test
+// images, and a function removing it.
+func tempLibrary(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "hashcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"lena_256.png", "rand_512.png"} {
+		copyFile(t, "../testdata/"+name, filepath.Join(dir, name))
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// copyFile copies a file, replacing the destination.
+func copyFile(t *testing.T, src, dst string) {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dst, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// mustHasher returns a registered hasher.
+func mustHasher(t *testing.T, name string) imagehash.Hasher {
+	hasher, err := imagehash.NewHasher(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hasher
+}
+
+// Test that the second lookup of a hash is a hit, and the same hash
+func TestCacheHit(t *testing.T) {
+	dir, cleanup := tempLibrary(t)
+	defer cleanup()
+
+	cache, _ := Open(filepath.Join(dir, "cache.json"), nil)
+	hasher := mustHasher(t, "dhash:8")
+	file := filepath.Join(dir, "lena_256.png")
+
+	first, err := cache.Hash(file, hasher)
+	if err != nil {
+		t.Fatalf("cache hash test failed with error: %v", err)
+	}
+	second, _ := cache.Hash(file, hasher)
+
+	img, _ := imagehash.OpenImg(file)
+	exp, _ := imagehash.Dhash(img, 8)
+	if !bytes.Equal(first.Value, exp) || !bytes.Equal(second.Value, exp) {
+		t.Errorf("cache hash test [%x] failed: [%x %x]", exp, first.Value, second.Value)
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Errorf("cache hit test [1 1 1] failed: [%d %d %d]", stats.Hits, stats.Misses, stats.Entries)
+	}
+}
+
+// Test that only the missing hashes are computed
+func TestCacheHashes(t *testing.T) {
+	dir, cleanup := tempLibrary(t)
+	defer cleanup()
+
+	cache, _ := Open(filepath.Join(dir, "cache.json"), nil)
+	file := filepath.Join(dir, "lena_256.png")
+	dhash, ahash := mustHasher(t, "dhash:8"), mustHasher(t, "ahash:16")
+
+	cache.Hash(file, dhash)
+	hashes, err := cache.Hashes(context.Background(), file, dhash, ahash)
+	if err != nil || len(hashes) != 2 || hashes[1].Algorithm != "ahash:16" {
+		t.Errorf("cache hashes test failed: [%v] %v", hashes, err)
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("cache hashes test [1 2] failed: [%d %d]", stats.Hits, stats.Misses)
+	}
+}
+
+// Test that a file replaced by another image is hashed again
+func TestCacheStale(t *testing.T) {
+	dir, cleanup := tempLibrary(t)
+	defer cleanup()
+
+	cache, _ := Open(filepath.Join(dir, "cache.json"), nil)
+	hasher := mustHasher(t, "dhash:8")
+	file := filepath.Join(dir, "lena_256.png")
+
+	before, _ := cache.Hash(file, hasher)
+	copyFile(t, "../testdata/lena_inverted_512.png", file)
+	after, _ := cache.Hash(file, hasher)
+
+	if bytes.Equal(before.Value, after.Value) || cache.Stats().Misses != 2 {
+		t.Errorf("stale cache test failed: [%x %x %d]", before.Value, after.Value, cache.Stats().Misses)
+	}
+
+	// A hash stored before versions existed has an unknown version
+	for _, e := range cache.entries {
+		h := e.Hashes["dhash:8"]
+		h.Version = 0
+		e.Hashes["dhash:8"] = h
+	}
+	if h, _ := cache.Hash(file, hasher); h.Version != imagehash.DhashVersion || cache.Stats().Misses != 3 {
+		t.Errorf("stale version test [%d 3] failed: [%d %d]", imagehash.DhashVersion, h.Version, cache.Stats().Misses)
+	}
+}
+
+// Test that with the Digest option, only the modification time changing
+// doesn't invalidate the hashes
+func TestCacheDigest(t *testing.T) {
+	dir, cleanup := tempLibrary(t)
+	defer cleanup()
+
+	hasher := mustHasher(t, "dhash:8")
+	file := filepath.Join(dir, "lena_256.png")
+	later := time.Now().Add(time.Hour)
+
+	for _, digest := range []bool{true, false} {
+		cache, _ := Open(filepath.Join(dir, "cache.json"), &Options{Digest: digest})
+		cache.Hash(file, hasher)
+		later = later.Add(time.Minute)
+		os.Chtimes(file, later, later)
+		cache.Hash(file, hasher)
+
+		if hits := cache.Stats().Hits; (hits == 1) != digest {
+			t.Errorf("digest %v cache test failed: [%d hits]", digest, hits)
+		}
+		os.Remove(filepath.Join(dir, "cache.json"))
+	}
+}
+
+// Test that a saved cache is reloaded with its hashes
+func TestCacheSave(t *testing.T) {
+	dir, cleanup := tempLibrary(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, "cache.json")
+	cache, _ := Open(path, &Options{Digest: true})
+	hasher := mustHasher(t, "colormoment")
+	file := filepath.Join(dir, "rand_512.png")
+	exp, _ := cache.Hash(file, hasher)
+	if err := cache.Close(); err != nil {
+		t.Fatalf("saving the cache failed with error: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("saved cache mode test [-rw-r--r--] failed: [%v]", err)
+	}
+	if tmps, _ := filepath.Glob(path + ".tmp*"); len(tmps) != 0 {
+		t.Errorf("saved cache temporary files test [0] failed: [%d]", len(tmps))
+	}
+
+	cache, err := Open(path, nil)
+	if err != nil {
+		t.Fatalf("reloading the cache failed with error: %v", err)
+	}
+	res, _ := cache.Hash(file, hasher)
+	if dist, _ := exp.Distance(res); dist != 0 || cache.Stats().Hits != 1 {
+		t.Errorf("reloaded cache test failed: [%v %d hits]", dist, cache.Stats().Hits)
+	}
+}
+
+// Test that pruning drops the entries of modified and removed files, and
+// that invalidating drops a single entry
+func TestCachePrune(t *testing.T) {
+	dir, cleanup := tempLibrary(t)
+	defer cleanup()
+
+	cache, _ := Open(filepath.Join(dir, "cache.json"), nil)
+	hasher := mustHasher(t, "ahash:8")
+	lena, random := filepath.Join(dir, "lena_256.png"), filepath.Join(dir, "rand_512.png")
+	cache.Hash(lena, hasher)
+	cache.Hash(random, hasher)
+
+	if pruned := cache.Prune(); pruned != 0 {
+		t.Errorf("prune unchanged test [0] failed: [%d]", pruned)
+	}
+	os.Remove(random)
+	if pruned := cache.Prune(); pruned != 1 || cache.Stats().Entries != 1 {
+		t.Errorf("prune removed test [1 1] failed: [%d %d]", pruned, cache.Stats().Entries)
+	}
+
+	cache.Invalidate(lena)
+	if entries := cache.Stats().Entries; entries != 0 {
+		t.Errorf("invalidate test [0] failed: [%d]", entries)
+	}
+}
+
+// Test that concurrent lookups of the same files are safe
+func TestCacheConcurrent(t *testing.T) {
+	dir, cleanup := tempLibrary(t)
+	defer cleanup()
+
+	cache, _ := Open(filepath.Join(dir, "cache.json"), &Options{Digest: true})
+	hasher := mustHasher(t, "dhash:8")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			file := filepath.Join(dir, []string{"lena_256.png", "rand_512.png"}[i%2])
+			if _, err := cache.Hash(file, hasher); err != nil {
+				t.Errorf("concurrent cache test failed with error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if stats := cache.Stats(); stats.Hits+stats.Misses != 8 || stats.Entries != 2 {
+		t.Errorf("concurrent cache test [8 2] failed: [%d %d]", stats.Hits+stats.Misses, stats.Entries)
+	}
+}
+
+// Test that invalid cache files and images are reported
+func TestCacheErrors(t *testing.T) {
+	dir, cleanup := tempLibrary(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, "cache.json")
+	ioutil.WriteFile(path, []byte("{not json"), 0644)
+	if _, err := Open(path, nil); err == nil {
+		t.Errorf("opening an invalid cache file didn't fail")
+	}
+	ioutil.WriteFile(path, []byte(`{"version": 2}`), 0644)
+	if _, err := Open(path, nil); err == nil {
+		t.Errorf("opening a cache file of an unknown version didn't fail")
+	}
+
+	cache, _ := Open(filepath.Join(dir, "other.json"), nil)
+	if _, err := cache.Hash(path, mustHasher(t, "dhash")); err == nil {
+		t.Errorf("hashing a file which isn't an image didn't fail")
+	}
+	if _, err := cache.Hash(filepath.Join(dir, "missing.png"), mustHasher(t, "dhash")); err == nil {
+		t.Errorf("hashing a missing file didn't fail")
+	}
+}
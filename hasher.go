@@ -0,0 +1,319 @@
+/*
+
+Implements the Hasher interface and a registry of hashing algorithms, so
+that algorithms can be picked by name from config files, command lines and
+indexes, instead of by calling a hardcoded function.
+
+A hasher is named by the name of its algorithm, optionally followed by a
+colon and its 'hashLen': "dhash-h:16" is a horizontal dhash with a 'hashLen'
+of 16. When the 'hashLen' is omitted, the algorithm's default is used. The
+name can be preceded by the description of a preprocessing pipeline, such
+as "equalize|dhash-h:16" (see Pipeline).
+
+The package registers:
+  dhash, dhash-h, dhash-v, dhash-d  Dhash and its variants (default 8)
+  ahash                             Ahash (default 8)
+  mhhash                            MHhash, which takes no 'hashLen'
+  colormoment                       ColorMomentHash, which takes no 'hashLen'
+
+Other packages can register their own algorithms with Register.
+
+Usage:
+  hasher,err := imagehash.NewHasher("dhash-h:16")
+  hash,err := hasher.Hash(img)
+
+*/
+
+package imagehash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Kind tells how the value of a Hash is stored and compared.
+type Kind int
+
+// The kinds of hashes.
+const (
+	BinaryKind Kind = iota // Bits stored in Hash.Value, compared by Hamming distance
+	FloatKind              // Floats stored in Hash.Vector, compared by euclidean distance
+)
+
+// Hash is the result of a Hasher: the value of the hash, along with the name
+// of the hasher which computed it.
+type Hash struct {
+	Algorithm string    // Name of the hasher, such as "dhash-h:16"
+	Kind      Kind      // Whether the hash is stored in Value or Vector
+	Value     []byte    // Bits of a BinaryKind hash
+	Vector    FloatHash // Floats of a FloatKind hash
+	Pipeline  string    // Description of the preprocessing of the image, such as "equalize|blur(1.5)"; empty if none
+	Version   int       // Version of the implementation of the algorithm; 0 if unknown
+}
+
+// Distance returns the distance between two hashes computed by the same
+// hasher, after the same preprocessing: the number of differing bits for
+// binary hashes, and the euclidean distance for float hashes. Hashes of
+// different versions are compared according to the VersionPolicy.
+func (h Hash) Distance(o Hash) (float64, error) {
+	if h.Algorithm != o.Algorithm || h.Kind != o.Kind {
+		return 0, fmt.Errorf("cannot compare a %s hash with a %s hash", h.Algorithm, o.Algorithm)
+	}
+	if h.Pipeline != o.Pipeline {
+		return 0, fmt.Errorf("cannot compare hashes preprocessed by '%s' and '%s'", h.Pipeline, o.Pipeline)
+	}
+	if err := CheckVersions(h, o); err != nil {
+		return 0, err
+	}
+
+	if h.Kind == FloatKind {
+		return GetL2Distance(h.Vector, o.Vector), nil
+	}
+	return float64(GetBitDistance(h.Value, o.Value)), nil
+}
+
+// Hasher is a hashing algorithm with its parameters. A Hasher can also have
+// a 'Version() int' method, returning the Version of its hashes (see
+// HasherVersion), and a HashContext method (see ContextHasher).
+type Hasher interface {
+	// Hash computes the hash of an image.
+	Hash(img image.Image) (Hash, error)
+	// Name returns the name the hasher can be created from with NewHasher,
+	// such as "dhash-h:16".
+	Name() string
+	// Bits returns the number of bits of the hashes. Binary hashes are
+	// padded to whole bytes, so their Value can hold a few more bits,
+	// which are always the same.
+	Bits() int
+}
+
+// ContextHasher is a Hasher which can stop hashing once a context is done.
+// Every hasher of the package is one.
+type ContextHasher interface {
+	Hasher
+	// HashContext is the same as Hash, but returns ctx.Err() as soon as
+	// the context is done.
+	HashContext(ctx context.Context, img image.Image) (Hash, error)
+}
+
+// HashContext hashes an image with a hasher, and returns ctx.Err() as soon
+// as the context is done. A hasher which isn't a ContextHasher can't be
+// interrupted: the context is only checked before and after its Hash.
+func HashContext(ctx context.Context, hasher Hasher, img image.Image) (Hash, error) {
+	if ch, ok := hasher.(ContextHasher); ok {
+		return ch.HashContext(ctx, img)
+	}
+	if err := ctx.Err(); err != nil {
+		return Hash{}, err
+	}
+	h, err := hasher.Hash(img)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return Hash{}, ctxErr
+	}
+	return h, err
+}
+
+// HasherFactory creates a Hasher from the 'hashLen' following the colon in
+// its name. 'hashLen' is 0 when the name has none.
+type HasherFactory func(hashLen int) (Hasher, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]HasherFactory)
+)
+
+// Register makes an algorithm available to NewHasher under a name. It
+// returns an error if the name is already taken, or contains a colon or a
+// '|'.
+func Register(name string, factory HasherFactory) error {
+	if name == "" || strings.ContainsAny(name, ":|") {
+		return errors.New("invalid hasher name: '" + name + "'")
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[name]; ok {
+		return errors.New("hasher already registered: " + name)
+	}
+	registry[name] = factory
+	return nil
+}
+
+// NewHasher creates a registered hasher from its name, optionally followed
+// by a colon and its 'hashLen', such as "dhash-h:16". When the name is
+// preceded by the description of a pipeline, such as "equalize|dhash-h:16",
+// a PipelineHasher is returned.
+func NewHasher(name string) (Hasher, error) {
+	if i := strings.LastIndex(name, "|"); i >= 0 {
+		pipeline, err := ParsePipeline(name[:i])
+		if err != nil {
+			return nil, err
+		}
+		hasher, err := NewHasher(name[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		return NewPipelineHasher(hasher, pipeline), nil
+	}
+
+	algorithm, hashLen := name, 0
+	if i := strings.Index(name, ":"); i >= 0 {
+		var err error
+		algorithm = name[:i]
+		hashLen, err = strconv.Atoi(name[i+1:])
+		if err != nil || hashLen <= 0 {
+			return nil, errors.New("invalid 'hashLen' in hasher name: '" + name + "'")
+		}
+	}
+
+	registryMu.RLock()
+	factory, ok := registry[algorithm]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, errors.New("unknown hasher: " + algorithm)
+	}
+	return factory(hashLen)
+}
+
+// Hashers returns the names of the registered algorithms, sorted.
+func Hashers() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// funcHasher wraps a HashFuncContext, such as DhashContext, into a Hasher.
+type funcHasher struct {
+	name    string
+	hashLen int
+	bits    int
+	version int
+	fn      HashFuncContext
+}
+
+func (fh funcHasher) Hash(img image.Image) (Hash, error) {
+	return fh.HashContext(context.Background(), img)
+}
+
+func (fh funcHasher) HashContext(ctx context.Context, img image.Image) (Hash, error) {
+	value, err := fh.fn(ctx, img, fh.hashLen)
+	if err != nil {
+		return Hash{}, err
+	}
+	return Hash{Algorithm: fh.name, Kind: BinaryKind, Value: value, Version: fh.version}, nil
+}
+
+func (fh funcHasher) Name() string {
+	return fh.name
+}
+
+func (fh funcHasher) Bits() int {
+	return fh.bits
+}
+
+func (fh funcHasher) Version() int {
+	return fh.version
+}
+
+// funcFactory returns a factory of funcHashers for a HashFuncContext.
+// 'gradients' is the number of hashLen*hashLen grids the hash is made of,
+// each padded to whole bytes; the padding isn't counted in the bits.
+func funcFactory(algorithm string, version int, fn HashFuncContext, gradients int) HasherFactory {
+	return func(hashLen int) (Hasher, error) {
+		if hashLen == 0 {
+			hashLen = 8
+		}
+		return funcHasher{
+			name:    algorithm + ":" + strconv.Itoa(hashLen),
+			hashLen: hashLen,
+			bits:    gradients * hashLen * hashLen,
+			version: version,
+			fn:      fn,
+		}, nil
+	}
+}
+
+// fixedHasher wraps an algorithm which takes no 'hashLen' into a Hasher.
+type fixedHasher struct {
+	name    string
+	bits    int
+	version int
+	fn      func(ctx context.Context, img image.Image) (Hash, error)
+}
+
+func (fh fixedHasher) Hash(img image.Image) (Hash, error) {
+	return fh.fn(context.Background(), img)
+}
+
+func (fh fixedHasher) HashContext(ctx context.Context, img image.Image) (Hash, error) {
+	return fh.fn(ctx, img)
+}
+
+func (fh fixedHasher) Name() string {
+	return fh.name
+}
+
+func (fh fixedHasher) Bits() int {
+	return fh.bits
+}
+
+func (fh fixedHasher) Version() int {
+	return fh.version
+}
+
+// fixedFactory returns a factory of fixedHashers, which rejects any 'hashLen'.
+func fixedFactory(hasher fixedHasher) HasherFactory {
+	return func(hashLen int) (Hasher, error) {
+		if hashLen != 0 {
+			return nil, errors.New(hasher.name + " doesn't take a 'hashLen'")
+		}
+		return hasher, nil
+	}
+}
+
+func init() {
+	Register("dhash", funcFactory("dhash", DhashVersion, DhashContext, 2))
+	Register("dhash-h", funcFactory("dhash-h", DhashVersion, DhashHorizontalContext, 1))
+	Register("dhash-v", funcFactory("dhash-v", DhashVersion, DhashVerticalContext, 1))
+	Register("dhash-d", funcFactory("dhash-d", DhashVersion, DhashDiagonalContext, 1))
+	Register("ahash", funcFactory("ahash", AhashVersion, AhashContext, 1))
+
+	Register("mhhash", fixedFactory(fixedHasher{
+		name:    "mhhash",
+		bits:    MHBits,
+		version: MHhashVersion,
+		fn: func(ctx context.Context, img image.Image) (Hash, error) {
+			value, err := MHhashContext(ctx, img)
+			if err != nil {
+				return Hash{}, err
+			}
+			return Hash{Algorithm: "mhhash", Kind: BinaryKind, Value: value, Version: MHhashVersion}, nil
+		},
+	}))
+	Register("colormoment", fixedFactory(fixedHasher{
+		name:    "colormoment",
+		bits:    ColorMomentLen * 64,
+		version: ColorMomentVersion,
+		fn: func(ctx context.Context, img image.Image) (Hash, error) {
+			vector, err := ColorMomentHashContext(ctx, img)
+			if err != nil {
+				return Hash{}, err
+			}
+			return Hash{Algorithm: "colormoment", Kind: FloatKind, Vector: vector, Version: ColorMomentVersion}, nil
+		},
+	}))
+}
@@ -0,0 +1,140 @@
+/*
+
+Testing suite for the Hasher interface and the registry.
+
+1. Test that the built-in hashers are registered
+2. Test creating hashers from their names, with and without a 'hashLen'
+3. Test that the hashers return the same hashes as the functions they wrap
+4. Test invalid and unknown hasher names
+5. Test registering a third-party hasher, and registering a name twice
+6. Test the distance between hashes of the same and different hashers
+
+*/
+
+package imagehash
+
+import (
+	"bytes"
+	"image"
+	"strconv"
+	"testing"
+)
+
+// Test that the wrapped algorithms are all registered
+func TestBuiltinHashers(t *testing.T) {
+	registered := make(map[string]bool)
+	for _, name := range Hashers() {
+		registered[name] = true
+	}
+
+	for _, name := range []string{"dhash", "dhash-h", "dhash-v", "dhash-d", "ahash", "mhhash", "colormoment"} {
+		if !registered[name] {
+			t.Errorf("hasher %s isn't registered", name)
+		}
+	}
+}
+
+// Test the names and lengths of created hashers
+func TestNewHasher(t *testing.T) {
+	tests := []struct {
+		name, canonical string
+		bits            int
+	}{
+		{"dhash-h:16", "dhash-h:16", 256},
+		{"dhash", "dhash:8", 128},
+		{"ahash:5", "ahash:5", 25},
+		{"dhash:5", "dhash:5", 50},
+		{"mhhash", "mhhash", 576},
+	}
+
+	for _, test := range tests {
+		hasher, err := NewHasher(test.name)
+		if err != nil {
+			t.Errorf("NewHasher(%s) failed with error: %v", test.name, err)
+			continue
+		}
+		if hasher.Name() != test.canonical || hasher.Bits() != test.bits {
+			t.Errorf("NewHasher(%s) test [%s %d] failed: [%s %d]", test.name,
+				test.canonical, test.bits, hasher.Name(), hasher.Bits())
+		}
+	}
+}
+
+// Test that the hashers wrap the hash functions
+func TestHasherHash(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_512.png")
+	exp, _ := DhashVertical(src, 16)
+
+	hasher, _ := NewHasher("dhash-v:16")
+	hash, err := hasher.Hash(src)
+
+	if err != nil {
+		t.Errorf("hasher test failed with error: %v", err)
+	} else if bytes.Compare(exp, hash.Value) != 0 || hash.Algorithm != "dhash-v:16" {
+		t.Errorf("hasher test [%x] failed: [%s %x]", exp, hash.Algorithm, hash.Value)
+	} else if len(hash.Value)*8 != hasher.Bits() {
+		t.Errorf("hasher bits test [%d] failed: [%d]", len(hash.Value)*8, hasher.Bits())
+	}
+}
+
+// Test that invalid names are rejected
+func TestInvalidHasherNames(t *testing.T) {
+	for _, name := range []string{"phash", "dhash:", "dhash:x", "dhash:-1", "mhhash:8"} {
+		if _, err := NewHasher(name); err == nil {
+			t.Errorf("NewHasher(%s) didn't fail", name)
+		}
+	}
+}
+
+// constHasher is a third-party hasher which always returns the same hash.
+type constHasher struct{}
+
+func (constHasher) Hash(img image.Image) (Hash, error) {
+	return Hash{Algorithm: "const", Value: []byte{0x2a}}, nil
+}
+func (constHasher) Name() string { return "const" }
+func (constHasher) Bits() int    { return 8 }
+
+// registerRuns makes the registered name unique when the tests are run
+// several times in the same process.
+var registerRuns int
+
+// Test registering a new algorithm
+func TestRegisterHasher(t *testing.T) {
+	factory := func(hashLen int) (Hasher, error) { return constHasher{}, nil }
+	registerRuns++
+	name := "const" + strconv.Itoa(registerRuns)
+
+	if err := Register(name, factory); err != nil {
+		t.Errorf("registering a hasher failed with error: %v", err)
+	}
+	if err := Register(name, factory); err == nil {
+		t.Errorf("registering a hasher twice didn't fail")
+	}
+	if err := Register("const:8", factory); err == nil {
+		t.Errorf("registering a name with a colon didn't fail")
+	}
+
+	hasher, err := NewHasher(name)
+	if err != nil || hasher.Name() != "const" {
+		t.Errorf("creating a registered hasher failed: %v", err)
+	}
+}
+
+// Test the distance between binary and float hashes
+func TestHashDistance(t *testing.T) {
+	h1 := Hash{Algorithm: "ahash:8", Value: []byte{0x0f}}
+	h2 := Hash{Algorithm: "ahash:8", Value: []byte{0x0e}}
+	f1 := Hash{Algorithm: "colormoment", Kind: FloatKind, Vector: FloatHash{0, 0}}
+	f2 := Hash{Algorithm: "colormoment", Kind: FloatKind, Vector: FloatHash{3, 4}}
+
+	if dist, err := h1.Distance(h2); dist != 1 || err != nil {
+		t.Errorf("binary hash distance test [1] failed: [%f] %v", dist, err)
+	}
+	if dist, err := f1.Distance(f2); dist != 5 || err != nil {
+		t.Errorf("float hash distance test [5] failed: [%f] %v", dist, err)
+	}
+	if _, err := h1.Distance(f1); err == nil {
+		t.Errorf("comparing hashes of different hashers didn't fail")
+	}
+}
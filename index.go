@@ -0,0 +1,249 @@
+/*
+
+Implements an in-memory index of binary hashes, searched by Hamming
+distance. Every hash is stored as a BitVector, so a search is a linear scan
+computing one popcount per word of every hash, which is fast enough for up
+to a few million hashes. For larger, persistent collections, see the
+diskindex package.
+
+Hashes computed by a Hasher are added with AddHash and searched with
+SearchHash. The index keeps the algorithm, preprocessing and version of the
+first hash added, refuses hashes of another algorithm or preprocessing, and
+compares versions following the VersionPolicy; the matches carry the
+version of the indexed hashes. Add and Search take bare bytes, which are of
+no algorithm and of an unknown version, so an index holds either bare bytes
+or hashes of a Hasher, not both.
+
+Example usage:
+  index := imagehash.NewIndex()
+  index.AddHash("lena", hash1)
+  matches,err := index.SearchHash(hash2, 10)
+
+*/
+
+package imagehash
+
+import (
+	"errors"
+	"math/bits"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Match is an entry of an Index matching a query.
+type Match struct {
+	ID       string `json:"id"`
+	Distance int    `json:"distance"` // Number of bits differing from the query
+	Version  int    `json:"version"`  // Version of the algorithm of the indexed hash; 0 if unknown
+}
+
+// Index is an in-memory index of hashes of the same length, each with a
+// unique id. It is safe for concurrent use.
+type Index struct {
+	mu      sync.RWMutex
+	ids     []string
+	hashes  []*BitVector
+	byID    map[string]int // Position of every id in 'ids' and 'hashes'
+	hashLen int            // Length in bytes of the hashes, once one is added
+	first   Hash           // Algorithm, preprocessing and version of the hashes, once one is added
+}
+
+// NewIndex returns an empty index.
+func NewIndex() *Index {
+	return &Index{byID: make(map[string]int)}
+}
+
+// Add adds a hash to the index, replacing the hash of 'id' if it was
+// already added. Every hash must have the same length as the first one.
+func (ix *Index) Add(id string, hash []byte) error {
+	return ix.AddHash(id, Hash{Value: hash})
+}
+
+// AddHash is the same as Add, for a binary hash computed by a Hasher, which
+// must be of the algorithm, preprocessing and version of the first one.
+func (ix *Index) AddHash(id string, h Hash) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if err := ix.check(h); err != nil {
+		return err
+	}
+	ix.hashLen = len(h.Value)
+	ix.first = Hash{Algorithm: h.Algorithm, Pipeline: h.Pipeline, Version: h.Version}
+
+	vec := NewBitVectorFromBytes(h.Value)
+	if i, ok := ix.byID[id]; ok {
+		ix.hashes[i] = vec
+		return nil
+	}
+	ix.byID[id] = len(ix.ids)
+	ix.ids = append(ix.ids, id)
+	ix.hashes = append(ix.hashes, vec)
+	return nil
+}
+
+// Remove removes the hash of 'id', and reports whether it was in the index.
+func (ix *Index) Remove(id string) bool {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	i, ok := ix.byID[id]
+	if !ok {
+		return false
+	}
+
+	// Move the last entry in place of the removed one
+	last := len(ix.ids) - 1
+	ix.ids[i], ix.hashes[i] = ix.ids[last], ix.hashes[last]
+	ix.byID[ix.ids[i]] = i
+	ix.ids, ix.hashes = ix.ids[:last], ix.hashes[:last]
+	delete(ix.byID, id)
+	return true
+}
+
+// Get returns the hash of 'id', if it is in the index.
+func (ix *Index) Get(id string) ([]byte, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	i, ok := ix.byID[id]
+	if !ok {
+		return nil, false
+	}
+	return ix.hashes[i].Bytes(), true
+}
+
+// Len returns the number of hashes in the index.
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.ids)
+}
+
+// Search returns the entries within 'maxDistance' bits of a hash, sorted by
+// distance then id.
+func (ix *Index) Search(hash []byte, maxDistance int) ([]Match, error) {
+	return ix.SearchHash(Hash{Value: hash}, maxDistance)
+}
+
+// SearchHash is the same as Search, for a binary hash computed by a Hasher,
+// which must be of the algorithm, preprocessing and version of the indexed
+// ones.
+func (ix *Index) SearchHash(h Hash, maxDistance int) ([]Match, error) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	if err := ix.check(h); err != nil {
+		return nil, err
+	}
+
+	query := NewBitVectorFromBytes(h.Value).Words()
+	var matches []Match
+	for i, vec := range ix.hashes {
+		if dist := distanceWords(query, vec.Words(), maxDistance); dist <= maxDistance {
+			matches = append(matches, Match{ix.ids[i], dist, ix.first.Version})
+		}
+	}
+	sortMatches(matches)
+	return matches, nil
+}
+
+// Within reports whether an entry is within 'maxDistance' bits of a hash,
+// which is checked as SearchHash does. It stops at the first such entry,
+// without collecting and sorting the matches.
+func (ix *Index) Within(h Hash, maxDistance int) (bool, error) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	if err := ix.check(h); err != nil {
+		return false, err
+	}
+
+	query := NewBitVectorFromBytes(h.Value).Words()
+	for _, vec := range ix.hashes {
+		if distanceWords(query, vec.Words(), maxDistance) <= maxDistance {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Nearest returns the 'k' entries closest to a hash, sorted by distance
+// then id. 'k' must not be negative.
+func (ix *Index) Nearest(hash []byte, k int) ([]Match, error) {
+	return ix.NearestHash(Hash{Value: hash}, k)
+}
+
+// NearestHash is the same as Nearest, for a binary hash computed by a
+// Hasher, which is checked as SearchHash does.
+func (ix *Index) NearestHash(h Hash, k int) ([]Match, error) {
+	if k < 0 {
+		return nil, errors.New("the number of entries must not be negative")
+	}
+	matches, err := ix.SearchHash(h, len(h.Value)*8)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) > k {
+		matches = matches[:k]
+	}
+	return matches, nil
+}
+
+// check checks that a hash has the length, algorithm, preprocessing and
+// version of the indexed ones.
+func (ix *Index) check(h Hash) error {
+	if h.Kind != BinaryKind {
+		return errors.New("cannot index the float hashes of " + h.Algorithm)
+	}
+	if len(h.Value) == 0 {
+		return errors.New("cannot index an empty hash")
+	}
+	if len(ix.ids) == 0 {
+		return nil
+	}
+	if len(h.Value) != ix.hashLen {
+		return errors.New("expected a hash of " + strconv.Itoa(ix.hashLen) +
+			" bytes, but received " + strconv.Itoa(len(h.Value)))
+	}
+	if h.Algorithm != ix.first.Algorithm || h.Pipeline != ix.first.Pipeline {
+		return errors.New("cannot compare " + hashName(h) + " hashes with the " + hashName(ix.first) +
+			" hashes of the index")
+	}
+	return CheckVersions(ix.first, h)
+}
+
+// hashName returns the name of the hasher of a hash, along with its
+// preprocessing, or "bare" for bytes of no algorithm.
+func hashName(h Hash) string {
+	switch {
+	case h.Algorithm == "":
+		return "bare"
+	case h.Pipeline != "":
+		return h.Pipeline + "|" + h.Algorithm
+	}
+	return h.Algorithm
+}
+
+// distanceWords returns the number of differing bits between two hashes of
+// the same length, or a number above 'max' as soon as it goes over it.
+func distanceWords(a, b []uint64, max int) int {
+	dist := 0
+	for i := range a {
+		if dist += bits.OnesCount64(a[i] ^ b[i]); dist > max {
+			return dist
+		}
+	}
+	return dist
+}
+
+// sortMatches sorts matches by distance, then id.
+func sortMatches(matches []Match) {
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].ID < matches[j].ID
+	})
+}
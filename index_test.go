@@ -0,0 +1,164 @@
+/*
+
+Testing suite for the in-memory Index.
+
+1. Test searching an index within a distance, and whether an entry is
+2. Test the nearest entries of a hash
+3. Test replacing and removing entries
+4. Test that hashes of another length are rejected
+5. Test concurrent additions and searches
+6. Test that the algorithm and version of hashes are checked and matched
+
+*/
+
+package imagehash
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// Test that a search returns the entries within its distance, sorted
+func TestIndexSearch(t *testing.T) {
+	index := NewIndex()
+	index.Add("a", []byte{0xff, 0x00})
+	index.Add("b", []byte{0xff, 0x01})
+	index.Add("c", []byte{0xfe, 0x00})
+	index.Add("d", []byte{0x00, 0xff})
+
+	matches, err := index.Search([]byte{0xff, 0x00}, 1)
+	exp := []Match{{"a", 0, 0}, {"b", 1, 0}, {"c", 1, 0}}
+	if err != nil || !reflect.DeepEqual(matches, exp) {
+		t.Errorf("index search test [%v] failed: [%v] %v", exp, matches, err)
+	}
+
+	if found, err := index.Within(Hash{Value: []byte{0xff, 0x03}}, 1); err != nil || !found {
+		t.Errorf("index within test [true] failed: [%v %v]", found, err)
+	}
+	if found, err := index.Within(Hash{Value: []byte{0x0f, 0x0f}}, 6); err != nil || found {
+		t.Errorf("index not within test [false] failed: [%v %v]", found, err)
+	}
+}
+
+// Test the nearest entries of a hash
+func TestIndexNearest(t *testing.T) {
+	index := NewIndex()
+	index.Add("far", []byte{0x00, 0xff})
+	index.Add("near", []byte{0xff, 0x03})
+
+	matches, _ := index.Nearest([]byte{0xff, 0x00}, 1)
+	if len(matches) != 1 || matches[0] != (Match{"near", 2, 0}) {
+		t.Errorf("index nearest test [near 2] failed: [%v]", matches)
+	}
+	if matches, _ := index.Nearest([]byte{0xff, 0x00}, 5); len(matches) != 2 {
+		t.Errorf("index nearest test [2] failed: [%d]", len(matches))
+	}
+	if matches, err := index.Nearest([]byte{0xff, 0x00}, 0); err != nil || len(matches) != 0 {
+		t.Errorf("index nearest test [0] failed: [%d %v]", len(matches), err)
+	}
+	if _, err := index.Nearest([]byte{0xff, 0x00}, -1); err == nil {
+		t.Errorf("negative nearest test didn't fail")
+	}
+}
+
+// Test that adding an id again replaces its hash, and removing entries
+func TestIndexReplaceRemove(t *testing.T) {
+	index := NewIndex()
+	index.Add("a", []byte{0x01})
+	index.Add("b", []byte{0x02})
+	index.Add("a", []byte{0x03})
+
+	if hash, _ := index.Get("a"); index.Len() != 2 || hash[0] != 0x03 {
+		t.Errorf("index replace test [2 03] failed: [%d %x]", index.Len(), hash)
+	}
+	if !index.Remove("a") || index.Remove("a") || index.Len() != 1 {
+		t.Errorf("index remove test failed")
+	}
+	if hash, ok := index.Get("b"); !ok || hash[0] != 0x02 {
+		t.Errorf("index remove test [02] failed: [%x]", hash)
+	}
+}
+
+// Test that hashes of a different length are rejected
+func TestIndexLength(t *testing.T) {
+	index := NewIndex()
+	index.Add("a", []byte{0x01, 0x02})
+
+	if err := index.Add("b", []byte{0x01}); err == nil {
+		t.Errorf("adding a hash of another length didn't fail")
+	}
+	if _, err := index.Search([]byte{0x01}, 8); err == nil {
+		t.Errorf("searching a hash of another length didn't fail")
+	}
+	if err := NewIndex().Add("a", nil); err == nil {
+		t.Errorf("adding an empty hash didn't fail")
+	}
+}
+
+// Test additions and searches from several goroutines
+func TestIndexConcurrent(t *testing.T) {
+	index := NewIndex()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				index.Add(strconv.Itoa(i*100+j), []byte{byte(j), byte(i)})
+				index.Search([]byte{byte(j), 0}, 4)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if index.Len() != 800 {
+		t.Errorf("concurrent index test [800] failed: [%d]", index.Len())
+	}
+}
+
+// Test that hashes of another algorithm or version are refused, and that
+// the matches carry the version of the indexed hashes
+func TestIndexVersions(t *testing.T) {
+	dhash := func(value []byte, version int) Hash {
+		return Hash{Algorithm: "dhash:4", Kind: BinaryKind, Value: value, Version: version}
+	}
+	index := NewIndex()
+	if err := index.AddHash("a", dhash([]byte{0xf0, 0x0f}, DhashVersion)); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := index.SearchHash(dhash([]byte{0xf0, 0x0e}, DhashVersion), 1)
+	exp := []Match{{"a", 1, DhashVersion}}
+	if err != nil || !reflect.DeepEqual(matches, exp) {
+		t.Errorf("index version search test [%v] failed: [%v] %v", exp, matches, err)
+	}
+	if _, err := index.SearchHash(dhash([]byte{0xf0, 0x0f}, DhashVersion+1), 1); err == nil {
+		t.Errorf("searching a hash of another version didn't fail")
+	} else if _, ok := err.(*VersionError); !ok {
+		t.Errorf("index version error test [*VersionError] failed: [%T]", err)
+	}
+	if err := index.AddHash("b", dhash([]byte{0xf0, 0x0f}, 0)); err == nil {
+		t.Errorf("adding a hash of an unknown version didn't fail")
+	}
+	if err := index.Add("b", []byte{0xf0, 0x0f}); err == nil {
+		t.Errorf("adding bare bytes to an index of hashes didn't fail")
+	}
+	if _, err := index.Search([]byte{0xf0, 0x0f}, 1); err == nil {
+		t.Errorf("searching bare bytes in an index of hashes didn't fail")
+	}
+	ahash := Hash{Algorithm: "ahash:4", Kind: BinaryKind, Value: []byte{0xf0, 0x0f}, Version: AhashVersion}
+	if _, err := index.NearestHash(ahash, 1); err == nil {
+		t.Errorf("searching a hash of another algorithm didn't fail")
+	}
+	if err := index.AddHash("c", Hash{Algorithm: "colormoment", Kind: FloatKind}); err == nil {
+		t.Errorf("adding a float hash didn't fail")
+	}
+
+	// An emptied index takes hashes of any algorithm again
+	index.Remove("a")
+	if err := index.AddHash("b", ahash); err != nil {
+		t.Errorf("adding to an emptied index test failed: [%v]", err)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+
+Package service holds what the HTTP service of the server package and the
+gRPC service of the rpc package share: checking their hashers, decoding the
+images of their requests within a pixel limit, and classifying the errors
+of decoding and hashing, which each service then maps to its own codes.
+
+Usage:
+  indexer,err := service.CheckHashers(cfg.Algorithms, cfg.IndexHasher)
+  img,err := service.Decode(ctx, data, cfg.MaxPixels)
+  switch service.Classify(err) {
+  case service.TooLarge:
+    ...
+  }
+
+*/
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"strconv"
+
+	"github.com/devedge/imagehash"
+)
+
+// blank is the image hashed to check the kind of the index hasher.
+var blank = image.NewGray(image.Rect(0, 0, 8, 8))
+
+// CheckHashers checks that the algorithms exist, and returns the hasher of
+// the index, which must return binary hashes.
+func CheckHashers(algorithms []string, indexHasher string) (imagehash.Hasher, error) {
+	for _, name := range algorithms {
+		if _, err := imagehash.NewHasher(name); err != nil {
+			return nil, err
+		}
+	}
+	indexer, err := imagehash.NewHasher(indexHasher)
+	if err != nil {
+		return nil, err
+	}
+	if h, err := indexer.Hash(blank); err != nil || h.Kind != imagehash.BinaryKind {
+		return nil, errors.New("the index hasher must return binary hashes: " + indexHasher)
+	}
+	return indexer, nil
+}
+
+// TooLargeError is returned by Decode for an image over the pixel limit.
+type TooLargeError struct {
+	Width, Height int
+	MaxPixels     int
+}
+
+func (e *TooLargeError) Error() string {
+	return strconv.Itoa(e.Width) + "x" + strconv.Itoa(e.Height) + " image over " +
+		strconv.Itoa(e.MaxPixels) + " pixels"
+}
+
+// Decode decodes an image, after checking its format and that it has at
+// most 'maxPixels' pixels. It returns image.ErrFormat for an unsupported
+// format, and a *TooLargeError for an image over the limit.
+func Decode(ctx context.Context, data []byte, maxPixels int) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > int64(maxPixels) {
+		return nil, &TooLargeError{cfg.Width, cfg.Height, maxPixels}
+	}
+	return imagehash.DecodeContext(ctx, bytes.NewReader(data))
+}
+
+// Failure is the class of an error decoding or hashing an image.
+type Failure int
+
+// The classes of errors.
+const (
+	InvalidImage      Failure = iota // Corrupt image, or one which can't be hashed
+	UnsupportedFormat                // Not an image format the service decodes
+	TooLarge                         // Image over the pixel limit
+	Timeout                          // The deadline passed while decoding or hashing
+	Canceled                         // The request was canceled while decoding or hashing
+)
+
+// Classify returns the class of an error returned by Decode or by hashing.
+func Classify(err error) Failure {
+	if _, ok := err.(*TooLargeError); ok {
+		return TooLarge
+	}
+	switch err {
+	case image.ErrFormat:
+		return UnsupportedFormat
+	case context.DeadlineExceeded:
+		return Timeout
+	case context.Canceled:
+		return Canceled
+	}
+	return InvalidImage
+}
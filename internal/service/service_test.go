@@ -0,0 +1,67 @@
+/*
+
+Testing suite for the helpers shared by the services.
+
+1. Test checking the hashers of a configuration
+2. Test decoding within a pixel limit, and classifying the errors
+
+*/
+
+package service
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// Test that unknown algorithms and float index hashers are rejected
+func TestCheckHashers(t *testing.T) {
+	indexer, err := CheckHashers([]string{"dhash:8", "colormoment"}, "ahash:16")
+	if err != nil || indexer.Name() != "ahash:16" {
+		t.Errorf("check hashers test [ahash:16] failed: [%v]", err)
+	}
+	if _, err := CheckHashers([]string{"phash"}, "dhash:8"); err == nil {
+		t.Errorf("unknown algorithm test didn't fail")
+	}
+	if _, err := CheckHashers(nil, "colormoment"); err == nil {
+		t.Errorf("float index hasher test didn't fail")
+	}
+}
+
+// Test the errors of decoding, and their classes
+func TestDecode(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 100, 50)))
+	data := buf.Bytes()
+
+	if img, err := Decode(context.Background(), data, 5000); err != nil || img.Bounds().Dx() != 100 {
+		t.Errorf("decode test [100] failed: [%v]", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		data      []byte
+		maxPixels int
+		exp       Failure
+	}{
+		{"too large", context.Background(), data, 4999, TooLarge},
+		{"unsupported", context.Background(), []byte("not an image"), 5000, UnsupportedFormat},
+		{"truncated", context.Background(), data[:len(data)/2], 5000, InvalidImage},
+		{"canceled", ctx, data, 5000, Canceled},
+	}
+	for _, test := range tests {
+		_, err := Decode(test.ctx, test.data, test.maxPixels)
+		if err == nil || Classify(err) != test.exp {
+			t.Errorf("%s decode test [%d] failed: [%d %v]", test.name, test.exp, Classify(err), err)
+		}
+	}
+	if Classify(context.DeadlineExceeded) != Timeout {
+		t.Errorf("timeout class test [%d] failed: [%d]", Timeout, Classify(context.DeadlineExceeded))
+	}
+}
@@ -0,0 +1,146 @@
+/*
+
+Implements the Marr-Hildreth hash from pHash's ph_mh_imagehash.
+
+This algorithm returns a hash based on the edges of the image, which makes
+it better suited to line art, diagrams and screenshots than the gradients
+of a small thumbnail.
+
+The image is grayscaled, blurred, resized to 512x512 and equalised. Then, it
+is correlated with a Marr-Hildreth (Laplacian of Gaussian) kernel, which
+responds to edges, and the responses are summed over blocks of 16x16 pixels.
+Finally, the blocks are visited in 64 overlapping windows of 3x3 blocks, and
+for every block of a window a 1 is appended if it is greater than the
+window's average, a 0 otherwise. This results in 576 bits, or 72 bytes.
+
+*/
+
+package imagehash
+
+import (
+	"context"
+	"errors"
+	"image"
+	"math"
+)
+
+// The constants used by pHash's Marr-Hildreth hash.
+const (
+	mhSize      = 512 // Width and height the image is resized to
+	mhBlockSize = 16  // Width and height of a block of responses
+	mhBlocks    = 31  // Number of blocks along each side
+	mhStep      = 4   // Distance between two windows, in blocks
+	mhWindow    = 3   // Width and height of a window, in blocks
+	mhAlpha     = 2.0 // Scale of the kernel
+	mhLevel     = 1.0 // Level of the kernel
+)
+
+// MHBits is the length in bits of a Marr-Hildreth hash.
+const MHBits = 576
+
+// MHhash calculates the Marr-Hildreth hash of an image. The result is
+// always MHBits long, and can be compared with GetDistance.
+func MHhash(img image.Image) ([]byte, error) {
+	return MHhashContext(context.Background(), img)
+}
+
+// MHhashContext is the same as MHhash, but returns ctx.Err() as soon as the
+// context is done.
+func MHhashContext(ctx context.Context, img image.Image) ([]byte, error) {
+	if img.Bounds().Empty() {
+		return nil, errors.New("cannot hash an empty image")
+	}
+
+	bitArray, err := NewBitArray(MHBits)
+	if err != nil {
+		return nil, err
+	}
+
+	// Grayscale, blur, resize, then equalise
+	res, err := grayscaleContext(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+	if res, err = blurContext(ctx, res, 1.0); err != nil {
+		return nil, err
+	}
+	if res, err = resizeContext(ctx, res, mhSize, mhSize); err != nil {
+		return nil, err
+	}
+	pixels := grayMatrix(res)
+	equalize(pixels, 256)
+	tr := traceFrom(ctx)
+	if tr != nil {
+		tr.addStage("equalize", matrixImage(pixels))
+	}
+
+	// Find the edges, and sum them up over every block
+	resp := correlate(pixels, mhKernel(mhAlpha, mhLevel))
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var blocks [mhBlocks][mhBlocks]float64
+	for by := 0; by < mhBlocks; by++ {
+		for bx := 0; bx < mhBlocks; bx++ {
+			for y := by * mhBlockSize; y < (by+1)*mhBlockSize; y++ {
+				for x := bx * mhBlockSize; x < (bx+1)*mhBlockSize; x++ {
+					blocks[by][bx] += resp[y][x]
+				}
+			}
+		}
+	}
+
+	// Record the blocks and the bits if tracing
+	var grid *TraceGrid
+	if tr != nil {
+		matrix := make([][]float64, mhBlocks)
+		for y := range matrix {
+			matrix[y] = append([]float64(nil), blocks[y][:]...)
+		}
+		grid = tr.newGrid("marr-hildreth", AverageThreshold, matrix)
+	}
+
+	// For every window, compare its blocks against their average
+	for wy := 0; wy < mhBlocks-2; wy += mhStep {
+		for wx := 0; wx < mhBlocks-2; wx += mhStep {
+			var sum float64
+			for y := wy; y < wy+mhWindow; y++ {
+				for x := wx; x < wx+mhWindow; x++ {
+					sum += blocks[y][x]
+				}
+			}
+			avg := sum / (mhWindow * mhWindow)
+
+			for y := wy; y < wy+mhWindow; y++ {
+				for x := wx; x < wx+mhWindow; x++ {
+					bit := 0 // If not above, append 0
+					if blocks[y][x] > avg {
+						bit = 1 // If above, append 1
+					}
+					bitArray.AppendBit(bit)
+					grid.add(x, y, blocks[y][x], avg, bit)
+				}
+			}
+		}
+	}
+
+	return bitArray.GetArray(), nil
+}
+
+// mhKernel returns the Marr-Hildreth kernel for a given scale and level.
+func mhKernel(alpha, level float64) [][]float64 {
+	sigma := int(4 * math.Pow(alpha, level))
+	scale := math.Pow(alpha, -level)
+
+	kernel := make([][]float64, 2*sigma+1)
+	for y := range kernel {
+		kernel[y] = make([]float64, 2*sigma+1)
+		for x := range kernel[y] {
+			xpos := scale * float64(x-sigma)
+			ypos := scale * float64(y-sigma)
+			a := xpos*xpos + ypos*ypos
+			kernel[y][x] = (2 - a) * math.Exp(-a/2)
+		}
+	}
+	return kernel
+}
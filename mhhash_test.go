@@ -0,0 +1,62 @@
+/*
+
+Testing suite for the Marr-Hildreth hash.
+
+1. Test the length of the Marr-Hildreth hash
+2. Test hashing an empty image
+3. Test that the hashes of a 512px image and 256px image are close
+4. Test that the hashes of unrelated images are far apart
+
+*/
+
+package imagehash
+
+import (
+	"image"
+	"testing"
+)
+
+// Test that the hash is always 576 bits long
+func TestMHhashLength(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_512.png")
+	hash, err := MHhash(src)
+
+	if err != nil {
+		t.Errorf("mh hash length test failed with error: %v", err)
+	} else if len(hash)*8 != MHBits {
+		t.Errorf("mh hash length test [%d] failed: [%d]", MHBits, len(hash)*8)
+	}
+}
+
+// Test that an empty image can't be hashed
+func TestMHhashEmpty(t *testing.T) {
+	_, err := MHhash(image.NewGray(image.Rect(0, 0, 0, 0)))
+
+	if err == nil {
+		t.Errorf("empty mh hash didn't fail")
+	}
+}
+
+// Test that lena_512 and lena_256 return close hashes
+func TestSimilarLenaMHhash(t *testing.T) {
+	lena512, _ := OpenImg("./testdata/lena_512.png")
+	lena256, _ := OpenImg("./testdata/lena_256.png")
+	hash512, _ := MHhash(lena512)
+	hash256, _ := MHhash(lena256)
+
+	if dist := GetDistance(hash512, hash256); dist > 30 {
+		t.Errorf("similar lena mh hash distance is too large: %d", dist)
+	}
+}
+
+// Test that lena_512 and a random image return distant hashes
+func TestDifferentMHhash(t *testing.T) {
+	src1, _ := OpenImg("./testdata/lena_512.png")
+	src2, _ := OpenImg("./testdata/rand_512.png")
+	hash1, _ := MHhash(src1)
+	hash2, _ := MHhash(src2)
+
+	if dist := GetDistance(hash1, hash2); dist < 50 {
+		t.Errorf("different mh hash distance is too small: %d", dist)
+	}
+}
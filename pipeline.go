@@ -0,0 +1,350 @@
+/*
+
+Implements preprocessing pipelines, which normalise images before they are
+hashed: histogram equalisation, gamma correction, blurring, cropping the
+centre, or downscaling huge images before the slower resize of the hashes.
+
+A Pipeline is a list of Stages, each a function of an image with a name
+describing it, such as "blur(1.5)". The description of a pipeline joins
+the names of its stages with '|', and is stored in the Pipeline field of
+the hashes computed through it, so that it is serialised along with them.
+
+A PipelineHasher attaches a pipeline to any Hasher. Its name is the
+description of its pipeline followed by the name of the hasher, such as
+"equalize|blur(1.5)|dhash:8", so that NewHasher creates it back from its
+name when all its stages are registered. The package registers:
+  equalize           Equalize
+  gamma(g)           Gamma
+  blur(sigma)        Blur
+  centercrop(f)      CenterCrop
+  downscale(max)     Downscale
+  trim, trim(t)      Trim
+
+Other packages can register their own stages with RegisterStage.
+
+Usage:
+  pipeline := imagehash.Pipeline{imagehash.Downscale(1024), imagehash.Equalize()}
+  hasher := imagehash.NewPipelineHasher(dhasher, pipeline)
+
+  hasher,err = imagehash.NewHasher("downscale(1024)|equalize|dhash:8")
+
+*/
+
+package imagehash
+
+import (
+	"context"
+	"errors"
+	"image"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/disintegration/imaging"
+)
+
+// Stage is a preprocessing step of a Pipeline.
+type Stage struct {
+	Name string                        // Description of the stage and its arguments, such as "blur(1.5)"
+	Fn   func(image.Image) image.Image // Returns the processed image
+}
+
+// Pipeline is a list of stages, applied in order.
+type Pipeline []Stage
+
+// String returns the description of the pipeline: the names of its stages,
+// joined with '|'.
+func (p Pipeline) String() string {
+	names := make([]string, len(p))
+	for i, stage := range p {
+		names[i] = stage.Name
+	}
+	return strings.Join(names, "|")
+}
+
+// Apply returns an image processed by every stage of the pipeline.
+func (p Pipeline) Apply(img image.Image) image.Image {
+	img, _ = p.ApplyContext(context.Background(), img)
+	return img
+}
+
+// ApplyContext is the same as Apply, but returns ctx.Err() as soon as the
+// context is done, which is checked before every stage. The result of
+// every stage is recorded if the context has a Trace.
+func (p Pipeline) ApplyContext(ctx context.Context, img image.Image) (image.Image, error) {
+	tr := traceFrom(ctx)
+	for _, stage := range p {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		img = stage.Fn(img)
+		if tr != nil {
+			tr.addStage(stage.Name, img)
+		}
+	}
+	return img, ctx.Err()
+}
+
+// PipelineHasher is a Hasher which processes images with a pipeline before
+// hashing them with another Hasher.
+type PipelineHasher struct {
+	Hasher   Hasher
+	Pipeline Pipeline
+}
+
+// NewPipelineHasher returns a PipelineHasher processing images with a
+// pipeline for a hasher.
+func NewPipelineHasher(hasher Hasher, pipeline Pipeline) *PipelineHasher {
+	return &PipelineHasher{Hasher: hasher, Pipeline: pipeline}
+}
+
+// Hash processes an image with the pipeline, and hashes it.
+func (ph *PipelineHasher) Hash(img image.Image) (Hash, error) {
+	return ph.HashContext(context.Background(), img)
+}
+
+// HashContext is the same as Hash, but returns ctx.Err() as soon as the
+// context is done. The hash has the name of the other Hasher, and the
+// description of the pipeline.
+func (ph *PipelineHasher) HashContext(ctx context.Context, img image.Image) (Hash, error) {
+	img, err := ph.Pipeline.ApplyContext(ctx, img)
+	if err != nil {
+		return Hash{}, err
+	}
+	h, err := HashContext(ctx, ph.Hasher, img)
+	if err != nil {
+		return Hash{}, err
+	}
+	h.Pipeline = ph.Pipeline.String()
+	return h, nil
+}
+
+// Name returns the description of the pipeline followed by the name of the
+// other Hasher, such as "equalize|dhash:8".
+func (ph *PipelineHasher) Name() string {
+	if len(ph.Pipeline) == 0 {
+		return ph.Hasher.Name()
+	}
+	return ph.Pipeline.String() + "|" + ph.Hasher.Name()
+}
+
+// Bits returns the length of the hashes of the other Hasher.
+func (ph *PipelineHasher) Bits() int {
+	return ph.Hasher.Bits()
+}
+
+// Version returns the version of the other Hasher.
+func (ph *PipelineHasher) Version() int {
+	return HasherVersion(ph.Hasher)
+}
+
+// StageFactory creates a Stage from the arguments between the parentheses
+// following its name, which are nil when it has none.
+type StageFactory func(args []float64) (Stage, error)
+
+var (
+	stagesMu sync.RWMutex
+	stages   = make(map[string]StageFactory)
+)
+
+// RegisterStage makes a stage available to ParsePipeline under a name. It
+// returns an error if the name is already taken, or contains any of the
+// characters '|', ':', '(' or ')'.
+func RegisterStage(name string, factory StageFactory) error {
+	if name == "" || strings.ContainsAny(name, "|:()") {
+		return errors.New("invalid stage name: '" + name + "'")
+	}
+
+	stagesMu.Lock()
+	defer stagesMu.Unlock()
+
+	if _, ok := stages[name]; ok {
+		return errors.New("stage already registered: " + name)
+	}
+	stages[name] = factory
+	return nil
+}
+
+// ParsePipeline creates a pipeline of registered stages from its
+// description, such as "equalize|blur(1.5)". An empty description is an
+// empty pipeline.
+func ParsePipeline(desc string) (Pipeline, error) {
+	if desc == "" {
+		return nil, nil
+	}
+
+	var pipeline Pipeline
+	for _, part := range strings.Split(desc, "|") {
+		name, args, err := parseStage(part)
+		if err != nil {
+			return nil, err
+		}
+
+		stagesMu.RLock()
+		factory, ok := stages[name]
+		stagesMu.RUnlock()
+
+		if !ok {
+			return nil, errors.New("unknown stage: " + name)
+		}
+		stage, err := factory(args)
+		if err != nil {
+			return nil, err
+		}
+		pipeline = append(pipeline, stage)
+	}
+	return pipeline, nil
+}
+
+// parseStage splits the description of a stage into its name and arguments.
+func parseStage(desc string) (string, []float64, error) {
+	desc = strings.TrimSpace(desc)
+	open := strings.Index(desc, "(")
+	if open < 0 {
+		return desc, nil, nil
+	}
+	if !strings.HasSuffix(desc, ")") {
+		return "", nil, errors.New("invalid stage: '" + desc + "'")
+	}
+
+	var args []float64
+	for _, arg := range strings.Split(desc[open+1:len(desc)-1], ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(arg), 64)
+		if err != nil {
+			return "", nil, errors.New("invalid argument in stage: '" + desc + "'")
+		}
+		args = append(args, v)
+	}
+	return strings.TrimSpace(desc[:open]), args, nil
+}
+
+// formatArg formats the argument of a stage in its name.
+func formatArg(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Equalize returns a stage grayscaling an image, and spreading its levels
+// evenly over the range from 0 to 255 with its cumulative histogram, so that
+// copies with different contrasts or exposures get the same levels.
+func Equalize() Stage {
+	return Stage{Name: "equalize", Fn: func(img image.Image) image.Image {
+		gray := imaging.Grayscale(img)
+		var histogram [256]int
+		for i := 0; i < len(gray.Pix); i += 4 {
+			histogram[gray.Pix[i]]++
+		}
+
+		// Map every level to its rank in the cumulative histogram, the
+		// darkest level present becoming 0
+		total, cdfMin, cdf := len(gray.Pix)/4, 0, 0
+		var lut [256]uint8
+		for v, n := range histogram {
+			if cdf == 0 {
+				cdfMin = n
+			}
+			cdf += n
+			if total > cdfMin {
+				lut[v] = uint8(255 * (cdf - cdfMin) / (total - cdfMin))
+			} else {
+				lut[v] = uint8(v) // A uniform image keeps its level
+			}
+		}
+		for i := 0; i < len(gray.Pix); i += 4 {
+			v := lut[gray.Pix[i]]
+			gray.Pix[i], gray.Pix[i+1], gray.Pix[i+2] = v, v, v
+		}
+		return gray
+	}}
+}
+
+// Gamma returns a stage correcting the gamma of an image: a gamma above 1
+// brightens it, and below 1 darkens it. 'gamma' must be above 0.
+func Gamma(gamma float64) Stage {
+	return Stage{Name: "gamma(" + formatArg(gamma) + ")", Fn: func(img image.Image) image.Image {
+		return imaging.AdjustGamma(img, gamma)
+	}}
+}
+
+// Blur returns a stage blurring an image with a gaussian of standard
+// deviation 'sigma', in pixels, which smooths out noise and compression
+// artifacts.
+func Blur(sigma float64) Stage {
+	return Stage{Name: "blur(" + formatArg(sigma) + ")", Fn: func(img image.Image) image.Image {
+		return imaging.Blur(img, sigma)
+	}}
+}
+
+// CenterCrop returns a stage cropping the centre of an image, keeping a
+// 'fraction' of its width and height, from 0 to 1, so that captions,
+// watermarks and borders near the edges are left out.
+func CenterCrop(fraction float64) Stage {
+	return Stage{Name: "centercrop(" + formatArg(fraction) + ")", Fn: func(img image.Image) image.Image {
+		b := img.Bounds()
+		w, h := int(float64(b.Dx())*fraction+0.5), int(float64(b.Dy())*fraction+0.5)
+		if w < 1 {
+			w = 1
+		}
+		if h < 1 {
+			h = 1
+		}
+		return imaging.CropCenter(img, w, h)
+	}}
+}
+
+// Downscale returns a stage shrinking images wider or higher than 'max'
+// pixels to fit in a 'max' square, with a box filter, which is much faster
+// than the Lanczos filter of the hashes on huge images. Smaller images are
+// left as they are.
+func Downscale(max int) Stage {
+	return Stage{Name: "downscale(" + strconv.Itoa(max) + ")", Fn: func(img image.Image) image.Image {
+		if b := img.Bounds(); b.Dx() <= max && b.Dy() <= max {
+			return img
+		}
+		return imaging.Fit(img, max, max, imaging.Box)
+	}}
+}
+
+// Trim returns a stage trimming the uniform borders of an image with
+// TrimBorders, within a 'tolerance'; the default one if zero.
+func Trim(tolerance int) Stage {
+	name := "trim"
+	if tolerance != 0 {
+		name += "(" + strconv.Itoa(tolerance) + ")"
+	}
+	return Stage{Name: name, Fn: func(img image.Image) image.Image {
+		trimmed, _ := TrimBorders(img, TrimOptions{Tolerance: tolerance})
+		return trimmed
+	}}
+}
+
+// stageFactory returns a factory of stages taking one argument, which must
+// be between 'min' and 'max', or no argument if 'optional'.
+func stageFactory(name string, min, max float64, optional bool, fn func(float64) Stage) StageFactory {
+	return func(args []float64) (Stage, error) {
+		if len(args) == 0 && optional {
+			return fn(0), nil
+		}
+		if len(args) != 1 || args[0] < min || args[0] > max {
+			return Stage{}, errors.New(name + " takes one argument from " + formatArg(min) + " to " + formatArg(max))
+		}
+		return fn(args[0]), nil
+	}
+}
+
+func init() {
+	RegisterStage("equalize", func(args []float64) (Stage, error) {
+		if len(args) != 0 {
+			return Stage{}, errors.New("equalize takes no arguments")
+		}
+		return Equalize(), nil
+	})
+	RegisterStage("gamma", stageFactory("gamma", 0.01, 100, false, Gamma))
+	RegisterStage("blur", stageFactory("blur", 0.01, 100, false, Blur))
+	RegisterStage("centercrop", stageFactory("centercrop", 0.01, 1, false, CenterCrop))
+	RegisterStage("downscale", stageFactory("downscale", 8, 1<<16, false, func(v float64) Stage {
+		return Downscale(int(v))
+	}))
+	RegisterStage("trim", stageFactory("trim", 1, 255, true, func(v float64) Stage {
+		return Trim(int(v))
+	}))
+}
@@ -0,0 +1,209 @@
+/*
+
+Testing suite for preprocessing pipelines.
+
+1. Test the descriptions of pipelines, and the order of their stages
+2. Test the images of the built-in stages
+3. Test parsing descriptions, and invalid ones
+4. Test registering a third-party stage, and registering a name twice
+5. Test hashing through a pipeline, and creating pipeline hashers by name
+6. Test that applying a pipeline stops on a cancelled context
+
+*/
+
+package imagehash
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// Test the description of a pipeline, and that its stages run in order
+func TestPipelineString(t *testing.T) {
+	var order []string
+	stage := func(name string) Stage {
+		return Stage{Name: name, Fn: func(img image.Image) image.Image {
+			order = append(order, name)
+			return img
+		}}
+	}
+	pipeline := Pipeline{stage("a"), stage("b"), stage("c")}
+	pipeline.Apply(image.NewGray(image.Rect(0, 0, 1, 1)))
+	if pipeline.String() != "a|b|c" || !reflect.DeepEqual(order, []string{"a", "b", "c"}) {
+		t.Errorf("pipeline order test [a|b|c] failed: [%s %v]", pipeline.String(), order)
+	}
+
+	names := map[string]Stage{
+		"equalize":         Equalize(),
+		"gamma(2.2)":       Gamma(2.2),
+		"blur(1.5)":        Blur(1.5),
+		"centercrop(0.8)":  CenterCrop(0.8),
+		"downscale(1024)":  Downscale(1024),
+		"trim":             Trim(0),
+		"trim(24)":         Trim(24),
+		"equalize|blur(1)": {Name: Pipeline{Equalize(), Blur(1)}.String()},
+	}
+	for exp, stage := range names {
+		if stage.Name != exp {
+			t.Errorf("stage name test [%s] failed: [%s]", exp, stage.Name)
+		}
+	}
+}
+
+// Test the sizes and levels of the images of the built-in stages
+func TestBuiltinStages(t *testing.T) {
+	lena, _ := OpenImg("./testdata/lena_512.png")
+
+	// Levels between 100 and 150 are spread from 0 to 255
+	dull := image.NewGray(image.Rect(0, 0, 51, 4))
+	for x := 0; x < 51; x++ {
+		for y := 0; y < 4; y++ {
+			dull.SetGray(x, y, color.Gray{uint8(100 + x)})
+		}
+	}
+	eq := Equalize().Fn(dull)
+	if r0, _, _, _ := eq.At(0, 0).RGBA(); r0 != 0 {
+		t.Errorf("equalize darkest test [0] failed: [%d]", r0>>8)
+	}
+	if r1, _, _, _ := eq.At(50, 0).RGBA(); r1>>8 != 255 {
+		t.Errorf("equalize lightest test [255] failed: [%d]", r1>>8)
+	}
+	white, _ := OpenImg("./testdata/white_512.png")
+	if r, _, _, _ := Equalize().Fn(white).At(3, 3).RGBA(); r>>8 != 255 {
+		t.Errorf("equalize uniform test [255] failed: [%d]", r>>8)
+	}
+
+	gray := image.NewGray(image.Rect(0, 0, 1, 1))
+	gray.SetGray(0, 0, color.Gray{64})
+	if r, _, _, _ := Gamma(2).Fn(gray).At(0, 0).RGBA(); r>>8 <= 64 {
+		t.Errorf("gamma test [>64] failed: [%d]", r>>8)
+	}
+	if !reflect.DeepEqual(Blur(2).Fn(lena), imaging.Blur(lena, 2)) {
+		t.Errorf("blur test failed")
+	}
+
+	sizes := []struct {
+		stage Stage
+		exp   image.Point
+	}{
+		{CenterCrop(0.5), image.Pt(256, 256)},
+		{CenterCrop(0.001), image.Pt(1, 1)},
+		{Downscale(128), image.Pt(128, 128)},
+		{Downscale(1024), image.Pt(512, 512)},
+		{Trim(0), image.Pt(512, 512)},
+	}
+	for _, test := range sizes {
+		if size := test.stage.Fn(lena).Bounds().Size(); size != test.exp {
+			t.Errorf("%s size test [%v] failed: [%v]", test.stage.Name, test.exp, size)
+		}
+	}
+	if Downscale(1024).Fn(lena) != lena {
+		t.Errorf("downscale of a small image test didn't return it")
+	}
+}
+
+// Test parsing descriptions back into pipelines, and invalid descriptions
+func TestParsePipeline(t *testing.T) {
+	for _, desc := range []string{"", "equalize", "downscale(1024)|gamma(0.5)|blur(1.5)|centercrop(0.9)|trim|trim(8)"} {
+		pipeline, err := ParsePipeline(desc)
+		if err != nil || pipeline.String() != desc {
+			t.Errorf("parse pipeline test [%s] failed: [%v %s]", desc, err, pipeline.String())
+		}
+	}
+	if pipeline, _ := ParsePipeline(" blur( 1.5 ) "); pipeline.String() != "blur(1.5)" {
+		t.Errorf("parse spaces test [blur(1.5)] failed: [%s]", pipeline.String())
+	}
+
+	invalid := []string{"unknown", "blur", "blur(x)", "blur(1", "blur(1,2)", "equalize(1)",
+		"gamma(0)", "centercrop(2)", "downscale(2)", "trim(300)", "equalize||blur(1)"}
+	for _, desc := range invalid {
+		if _, err := ParsePipeline(desc); err == nil {
+			t.Errorf("invalid pipeline '%s' test didn't fail", desc)
+		}
+	}
+}
+
+// Test that a registered stage can be parsed, and that names are unique
+func TestRegisterStage(t *testing.T) {
+	invert := func(args []float64) (Stage, error) {
+		return Stage{Name: "test-invert", Fn: func(img image.Image) image.Image {
+			return imaging.Invert(img)
+		}}, nil
+	}
+	if err := RegisterStage("test-invert", invert); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterStage("test-invert", invert); err == nil {
+		t.Errorf("registering a stage twice test didn't fail")
+	}
+	for _, name := range []string{"", "a|b", "a:b", "a(1)"} {
+		if err := RegisterStage(name, invert); err == nil {
+			t.Errorf("invalid stage name '%s' test didn't fail", name)
+		}
+	}
+
+	if pipeline, err := ParsePipeline("test-invert|equalize"); err != nil || len(pipeline) != 2 {
+		t.Errorf("registered stage test [2] failed: [%v %d]", err, len(pipeline))
+	}
+}
+
+// Test the hashes and names of pipeline hashers
+func TestPipelineHasher(t *testing.T) {
+	lena, _ := OpenImg("./testdata/lena_256.png")
+	dhasher, _ := NewHasher("dhash:8")
+	pipeline := Pipeline{Blur(1.5), Equalize()}
+	hasher := NewPipelineHasher(dhasher, pipeline)
+
+	exp, _ := dhasher.Hash(pipeline.Apply(lena))
+	h, err := hasher.Hash(lena)
+	if err != nil || !reflect.DeepEqual(h.Value, exp.Value) || h.Algorithm != "dhash:8" || h.Pipeline != "blur(1.5)|equalize" {
+		t.Errorf("pipeline hash test [%x dhash:8 blur(1.5)|equalize] failed: [%v %x %s %s]", exp.Value,
+			err, h.Value, h.Algorithm, h.Pipeline)
+	}
+	if hasher.Name() != "blur(1.5)|equalize|dhash:8" || hasher.Bits() != 128 {
+		t.Errorf("pipeline hasher name test [blur(1.5)|equalize|dhash:8 128] failed: [%s %d]", hasher.Name(), hasher.Bits())
+	}
+
+	// The hasher is created back from its name
+	named, err := NewHasher(hasher.Name())
+	if err != nil || named.Name() != hasher.Name() {
+		t.Fatalf("named pipeline hasher test [%s] failed: [%v]", hasher.Name(), err)
+	}
+	if h2, _ := named.Hash(lena); !reflect.DeepEqual(h, h2) {
+		t.Errorf("named pipeline hash test [%x] failed: [%x]", h.Value, h2.Value)
+	}
+	for _, name := range []string{"unknown|dhash:8", "equalize|unknown", "equalize|"} {
+		if _, err := NewHasher(name); err == nil {
+			t.Errorf("invalid pipeline hasher '%s' test didn't fail", name)
+		}
+	}
+
+	// Hashes are only compared after the same preprocessing
+	plain, _ := dhasher.Hash(lena)
+	if _, err := h.Distance(plain); err == nil {
+		t.Errorf("different pipelines distance test didn't fail")
+	}
+	if dist, err := h.Distance(h); err != nil || dist != 0 {
+		t.Errorf("same pipeline distance test [0] failed: [%v %f]", err, dist)
+	}
+}
+
+// Test that applying a pipeline stops on a cancelled context
+func TestPipelineCancelled(t *testing.T) {
+	lena, _ := OpenImg("./testdata/lena_256.png")
+	dhasher, _ := NewHasher("dhash:8")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := (Pipeline{Equalize()}).ApplyContext(ctx, lena); err != context.Canceled {
+		t.Errorf("cancelled pipeline test [%v] failed: [%v]", context.Canceled, err)
+	}
+	if _, err := NewPipelineHasher(dhasher, Pipeline{Equalize()}).HashContext(ctx, lena); err != context.Canceled {
+		t.Errorf("cancelled pipeline hasher test [%v] failed: [%v]", context.Canceled, err)
+	}
+}
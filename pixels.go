@@ -0,0 +1,106 @@
+/*
+
+This is an internal module to read the pixels of an image into a matrix
+of floats, and to process that matrix (equalisation, correlation with a
+kernel), for the algorithms that need to do arithmetic on the pixels rather
+than compare them one at a time.
+
+*/
+
+package imagehash
+
+import (
+	"image"
+)
+
+// grayMatrix returns the pixels of a grayscaled image as a matrix indexed
+// by [y][x], with values between 0 and 255. Since the image is grayscaled,
+// only the red channel is read.
+func grayMatrix(img image.Image) [][]float64 {
+	bounds := img.Bounds()
+	matrix := make([][]float64, bounds.Dy())
+	for y := range matrix {
+		matrix[y] = make([]float64, bounds.Dx())
+		for x := range matrix[y] {
+			r, _, _, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			matrix[y][x] = float64(r >> 8)
+		}
+	}
+	return matrix
+}
+
+// equalize spreads the values of a matrix over 'levels' evenly populated
+// levels between 0 and 255, using its cumulative histogram.
+func equalize(matrix [][]float64, levels int) {
+	histogram := make([]int, levels)
+	total := 0
+	for _, row := range matrix {
+		for _, v := range row {
+			histogram[level(v, levels)]++
+			total++
+		}
+	}
+	if total == 0 {
+		return
+	}
+
+	// Turn the histogram into a cumulative one
+	for i := 1; i < levels; i++ {
+		histogram[i] += histogram[i-1]
+	}
+
+	for _, row := range matrix {
+		for x, v := range row {
+			row[x] = 255 * float64(histogram[level(v, levels)]) / float64(total)
+		}
+	}
+}
+
+// level returns the histogram bin of a value between 0 and 255.
+func level(v float64, levels int) int {
+	l := int(v * float64(levels) / 256)
+	if l < 0 {
+		return 0
+	} else if l >= levels {
+		return levels - 1
+	}
+	return l
+}
+
+// correlate returns the correlation of a matrix with a square kernel of odd
+// size, centred on every value. Values outside the matrix are taken from its
+// nearest edge.
+func correlate(matrix, kernel [][]float64) [][]float64 {
+	height := len(matrix)
+	width := 0
+	if height > 0 {
+		width = len(matrix[0])
+	}
+	half := len(kernel) / 2
+
+	res := make([][]float64, height)
+	for y := range res {
+		res[y] = make([]float64, width)
+		for x := range res[y] {
+			var sum float64
+			for ky, krow := range kernel {
+				row := matrix[clamp(y+ky-half, height)]
+				for kx, k := range krow {
+					sum += k * row[clamp(x+kx-half, width)]
+				}
+			}
+			res[y][x] = sum
+		}
+	}
+	return res
+}
+
+// clamp restricts an index to [0, n).
+func clamp(i, n int) int {
+	if i < 0 {
+		return 0
+	} else if i >= n {
+		return n - 1
+	}
+	return i
+}
@@ -0,0 +1,197 @@
+/*
+
+Implements the radial variance hash (RADISH) from pHash's ph_image_digest,
+described in "Robust image hashing based on radial variance of pixels"
+(De Roover et al.).
+
+The image is grayscaled and blurred. Then, for every one of 'numAngles'
+angles evenly spread over 180 degrees, the pixels on the line crossing the
+centre of the image at that angle are collected (a Radon projection), and
+their variance is computed. The low frequencies of these variances are kept
+by taking their 1D DCT, and the coefficients following the DC one are
+quantised to bytes.
+
+Hashes are compared with RadialSimilarity, which looks for the best
+cross-correlation over every circular shift, and tolerates skews of a few
+degrees. Larger rotations are better handled by the dihedral variants. The
+Hamming distance from GetDistance isn't meaningful for these hashes. Their
+version is RadialVersion: hashes stored with EncodeVersioned are checked
+with CheckVersioned before RadialSimilarity compares their bytes.
+
+*/
+
+package imagehash
+
+import (
+	"context"
+	"errors"
+	"image"
+	"math"
+)
+
+// RadialCoefficients is the number of DCT coefficients kept, and so the
+// length in bytes of a radial variance hash.
+const RadialCoefficients = 40
+
+// RadialThreshold is the similarity above which two radial variance hashes
+// are considered to be of the same image, as used by pHash.
+const RadialThreshold = 0.9
+
+// RadialHash calculates the radial variance hash of an image. 'numAngles' is
+// the number of projections taken over 180 degrees; pHash uses 180. It must
+// be greater than RadialCoefficients.
+func RadialHash(img image.Image, numAngles int) ([]byte, error) {
+	return RadialHashContext(context.Background(), img, numAngles)
+}
+
+// RadialHashContext is the same as RadialHash, but returns ctx.Err() as soon
+// as the context is done.
+func RadialHashContext(ctx context.Context, img image.Image, numAngles int) ([]byte, error) {
+	if numAngles <= RadialCoefficients {
+		return nil, errors.New("'numAngles' must be greater than 40")
+	}
+
+	// Grayscale and blur to reduce noise
+	res, err := grayscaleContext(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+	if res, err = blurContext(ctx, res, 1.0); err != nil {
+		return nil, err
+	}
+	pixels := grayMatrix(res)
+
+	// The DC coefficient only measures the overall contrast of the image, and
+	// would dominate the correlation after quantisation, so it is dropped.
+	features := radialVariances(pixels, numAngles)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	coeffs := dct(features, RadialCoefficients+1)[1:]
+
+	// Quantise the coefficients to the full range of a byte
+	min, max := coeffs[0], coeffs[0]
+	for _, c := range coeffs {
+		min = math.Min(min, c)
+		max = math.Max(max, c)
+	}
+
+	hash := make([]byte, RadialCoefficients)
+	if max > min {
+		for i, c := range coeffs {
+			hash[i] = byte(255 * (c - min) / (max - min))
+		}
+	}
+	return hash, nil
+}
+
+// radialVariances computes the variance of the pixels on the lines crossing
+// the centre of the image, at 'numAngles' angles evenly spread over 180
+// degrees.
+func radialVariances(pixels [][]float64, numAngles int) []float64 {
+	height := len(pixels)
+	width := 0
+	if height > 0 {
+		width = len(pixels[0])
+	}
+
+	// Only the pixels within the inscribed circle are used, since they are
+	// the ones that stay inside the frame when the image is rotated.
+	cx, cy := float64(width)/2, float64(height)/2
+	radius := int(math.Min(cx, cy))
+
+	variances := make([]float64, numAngles)
+	for k := range variances {
+		theta := float64(k) * math.Pi / float64(numAngles)
+		cos, sin := math.Cos(theta), math.Sin(theta)
+
+		var sum, sumSqd float64 // Sum and sum of squares of the line's pixels
+		var count int           // Number of pixels on the line
+		for s := -radius; s <= radius; s++ {
+			x := int(math.Floor(cx + float64(s)*cos))
+			y := int(math.Floor(cy + float64(s)*sin))
+			if x < 0 || x >= width || y < 0 || y >= height {
+				continue
+			}
+			sum += pixels[y][x]
+			sumSqd += pixels[y][x] * pixels[y][x]
+			count++
+		}
+
+		if count > 0 {
+			mean := sum / float64(count)
+			variances[k] = sumSqd/float64(count) - mean*mean
+		}
+	}
+	return variances
+}
+
+// dct returns the first 'numCoeffs' coefficients of the orthonormal DCT-II
+// of 'values'.
+func dct(values []float64, numCoeffs int) []float64 {
+	n := float64(len(values))
+	coeffs := make([]float64, numCoeffs)
+	for k := range coeffs {
+		var sum float64
+		for i, v := range values {
+			sum += v * math.Cos(math.Pi*float64(2*i+1)*float64(k)/(2*n))
+		}
+		if k == 0 {
+			sum *= math.Sqrt(1 / n)
+		} else {
+			sum *= math.Sqrt(2 / n)
+		}
+		coeffs[k] = sum
+	}
+	return coeffs
+}
+
+// RadialSimilarity returns the peak cross-correlation between two radial
+// variance hashes, over every circular shift of the second one. The result
+// is between -1 and 1, and two hashes of the same image score above
+// RadialThreshold. Hashes of different lengths have a similarity of 0.
+func RadialSimilarity(hash1, hash2 []byte) float64 {
+	n := len(hash1)
+	if n == 0 || n != len(hash2) {
+		return 0
+	}
+
+	// Compute the means and centre both hashes on them
+	var mean1, mean2 float64
+	for i := 0; i < n; i++ {
+		mean1 += float64(hash1[i])
+		mean2 += float64(hash2[i])
+	}
+	mean1 /= float64(n)
+	mean2 /= float64(n)
+
+	x := make([]float64, n)
+	y := make([]float64, n)
+	var sumSqd1, sumSqd2 float64
+	for i := 0; i < n; i++ {
+		x[i] = float64(hash1[i]) - mean1
+		y[i] = float64(hash2[i]) - mean2
+		sumSqd1 += x[i] * x[i]
+		sumSqd2 += y[i] * y[i]
+	}
+
+	// Constant hashes have no variance to correlate
+	den := math.Sqrt(sumSqd1 * sumSqd2)
+	if den == 0 {
+		if GetDistance(hash1, hash2) == 0 {
+			return 1
+		}
+		return 0
+	}
+
+	// Find the shift with the best correlation
+	peak := math.Inf(-1)
+	for d := 0; d < n; d++ {
+		var num float64
+		for i := 0; i < n; i++ {
+			num += x[i] * y[(n+i-d)%n]
+		}
+		peak = math.Max(peak, num/den)
+	}
+	return peak
+}
@@ -0,0 +1,95 @@
+/*
+
+Testing suite for the radial variance hash.
+
+1. Test the length of the radial variance hash
+2. Test an invalid number of angles
+3. Test that the hashes of a 512px image and 256px image are similar
+4. Test that the hashes of an image and a slightly skewed copy are similar
+5. Test that the hashes of unrelated images are not similar
+6. Test that the similarity of a hash with itself is 1
+
+*/
+
+package imagehash
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// Test that the hash has one byte per kept coefficient
+func TestRadialHashLength(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_512.png")
+	hash, err := RadialHash(src, 180)
+
+	if err != nil {
+		t.Errorf("radial hash length test failed with error: %v", err)
+	} else if len(hash) != RadialCoefficients {
+		t.Errorf("radial hash length test [%d] failed: [%d]", RadialCoefficients, len(hash))
+	}
+}
+
+// Test that fewer angles than coefficients is rejected
+func TestRadialHashFewAngles(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_512.png")
+	_, err := RadialHash(src, 20)
+
+	if err == nil {
+		t.Errorf("radial hash with too few angles didn't fail")
+	}
+}
+
+// Test that lena_512 and lena_256 are similar
+func TestSimilarLenaRadialHash(t *testing.T) {
+	lena512, _ := OpenImg("./testdata/lena_512.png")
+	lena256, _ := OpenImg("./testdata/lena_256.png")
+	hash512, _ := RadialHash(lena512, 180)
+	hash256, _ := RadialHash(lena256, 180)
+
+	if sim := RadialSimilarity(hash512, hash256); sim < RadialThreshold {
+		t.Errorf("similar lena radial hash test failed: %f", sim)
+	}
+}
+
+// Test that a skewed copy of lena_512 is similar to the original
+func TestRotatedLenaRadialHash(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_512.png")
+	rotated := imaging.Rotate(src, 3, color.Black)
+	rotated = imaging.CropCenter(rotated, 512, 512)
+	hash1, _ := RadialHash(src, 180)
+	hash2, _ := RadialHash(rotated, 180)
+
+	if sim := RadialSimilarity(hash1, hash2); sim < RadialThreshold {
+		t.Errorf("rotated lena radial hash test failed: %f", sim)
+	}
+}
+
+// Test that lena_512 and a random image are not similar
+func TestDifferentRadialHash(t *testing.T) {
+	src1, _ := OpenImg("./testdata/lena_512.png")
+	src2, _ := OpenImg("./testdata/rand_512.png")
+	hash1, _ := RadialHash(src1, 180)
+	hash2, _ := RadialHash(src2, 180)
+
+	if sim := RadialSimilarity(hash1, hash2); sim >= RadialThreshold {
+		t.Errorf("different radial hash test failed: %f", sim)
+	}
+}
+
+// Test that a hash is perfectly similar to itself, even when it's constant
+func TestSelfRadialSimilarity(t *testing.T) {
+	src1, _ := OpenImg("./testdata/lena_512.png")
+	src2, _ := OpenImg("./testdata/white_512.png")
+	hash1, _ := RadialHash(src1, 180)
+	hash2, _ := RadialHash(src2, 180)
+
+	if sim := RadialSimilarity(hash1, hash1); sim < 0.999 {
+		t.Errorf("lena self similarity test failed: %f", sim)
+	}
+	if sim := RadialSimilarity(hash2, hash2); sim != 1 {
+		t.Errorf("white self similarity test failed: %f", sim)
+	}
+}
@@ -0,0 +1,195 @@
+/*
+
+Renders hashes as images, to see which bits of two hashes differ instead of
+comparing their hex.
+
+RenderHash draws the bits of a hash as a grid of cells, white for a 1 and
+black for a 0, laid out the way the hash functions append them: dhash-h
+and dhash-d row by row, dhash-v and ahash column by column, so that every
+cell is where its pixels are in the image. A dhash is drawn as its
+horizontal grid next to its vertical one, and a Marr-Hildreth hash as its
+8x8 windows of 3x3 blocks. Other binary hashes are drawn row by row, in
+the smallest square holding their bits.
+
+RenderDiff draws the grid of two hashes over the downscaled image they
+were computed from, the differing bits highlighted in red.
+
+Usage:
+  img,err := imagehash.RenderHash(hash)
+  img,err = imagehash.RenderDiff(hash1, hash2, src1)
+
+*/
+
+package imagehash
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// RenderCellSize is the width and height of the cell of a bit, in pixels.
+const RenderCellSize = 16
+
+// The colours of the rendered cells.
+var (
+	renderBackground = color.NRGBA{128, 128, 128, 255} // Lines between the cells, and gaps between grids
+	renderDiff       = color.NRGBA{255, 0, 0, 160}     // Overlay of the differing bits
+)
+
+// bitGrid is a grid of the bits of a hash.
+type bitGrid struct {
+	cols, rows int
+	offset     int                    // Index of the first bit of the grid in the hash
+	cell       func(i int) (x, y int) // Position of the i-th bit of the grid
+}
+
+// hashGrids returns the grids of the bits of a binary hash, from the name
+// of its algorithm.
+func hashGrids(h Hash) ([]bitGrid, error) {
+	if h.Kind != BinaryKind {
+		return nil, errors.New("cannot render the float hash of " + h.Algorithm)
+	}
+	if len(h.Value) == 0 {
+		return nil, errors.New("cannot render an empty hash")
+	}
+
+	algorithm, hashLen := h.Algorithm, 8
+	if i := strings.Index(algorithm, ":"); i >= 0 {
+		n, err := strconv.Atoi(algorithm[i+1:])
+		if err != nil || n <= 0 {
+			return nil, errors.New("invalid 'hashLen' in algorithm: '" + h.Algorithm + "'")
+		}
+		algorithm, hashLen = algorithm[:i], n
+	}
+
+	n := hashLen
+	rowMajor := func(i int) (int, int) { return i % n, i / n }
+	colMajor := func(i int) (int, int) { return i / n, i % n }
+
+	var grids []bitGrid
+	switch algorithm {
+	case "dhash":
+		grids = []bitGrid{{n, n, 0, rowMajor}, {n, n, 8 * ((n*n + 7) / 8), colMajor}}
+	case "dhash-h", "dhash-d":
+		grids = []bitGrid{{n, n, 0, rowMajor}}
+	case "dhash-v", "ahash":
+		grids = []bitGrid{{n, n, 0, colMajor}}
+	case "mhhash":
+		// 8x8 windows of 3x3 blocks, window by window
+		grids = []bitGrid{{24, 24, 0, func(i int) (int, int) {
+			window, block := i/9, i%9
+			return window%8*3 + block%3, window/8*3 + block/3
+		}}}
+	default:
+		bits := 8 * len(h.Value)
+		side := int(math.Ceil(math.Sqrt(float64(bits))))
+		return []bitGrid{{side, (bits + side - 1) / side, 0, func(i int) (int, int) { return i % side, i / side }}}, nil
+	}
+
+	last := grids[len(grids)-1]
+	if need := last.offset + last.cols*last.rows; len(h.Value)*8 < need {
+		return nil, errors.New("a " + h.Algorithm + " hash needs " + strconv.Itoa(need) + " bits, but has " +
+			strconv.Itoa(len(h.Value)*8))
+	}
+	return grids, nil
+}
+
+// gridsRect returns the rectangle of the grids, drawn side by side with a
+// cell between them, and the horizontal position of every grid.
+func gridsRect(grids []bitGrid) (image.Rectangle, []int) {
+	xs := make([]int, len(grids))
+	width, height := 0, 0
+	for i, g := range grids {
+		if i > 0 {
+			width += RenderCellSize
+		}
+		xs[i] = width
+		width += g.cols * RenderCellSize
+		if g.rows*RenderCellSize > height {
+			height = g.rows * RenderCellSize
+		}
+	}
+	return image.Rect(0, 0, width, height), xs
+}
+
+// cellRect returns the rectangle of a cell, inside the lines between cells.
+func cellRect(gridX, x, y int) image.Rectangle {
+	return image.Rect(gridX+x*RenderCellSize+1, y*RenderCellSize+1,
+		gridX+(x+1)*RenderCellSize, (y+1)*RenderCellSize)
+}
+
+// RenderHash draws the bits of a binary hash as a grid of RenderCellSize
+// pixel cells, white for a 1 and black for a 0.
+func RenderHash(h Hash) (image.Image, error) {
+	grids, err := hashGrids(h)
+	if err != nil {
+		return nil, err
+	}
+
+	rect, xs := gridsRect(grids)
+	dst := image.NewNRGBA(rect)
+	draw.Draw(dst, rect, image.NewUniform(renderBackground), image.Point{}, draw.Src)
+	for g, grid := range grids {
+		for i := 0; i < grid.cols*grid.rows && grid.offset+i < len(h.Value)*8; i++ {
+			c := color.Black
+			if hashBit(h.Value, grid.offset+i) == 1 {
+				c = color.White
+			}
+			x, y := grid.cell(i)
+			draw.Draw(dst, cellRect(xs[g], x, y), image.NewUniform(c), image.Point{}, draw.Src)
+		}
+	}
+	return dst, nil
+}
+
+// RenderDiff draws the grid of two hashes of the same hasher, the bits
+// which differ highlighted in red. The cells show the image the first hash
+// was computed from, grayscaled and downscaled to the grid, or the bits of
+// the first hash in grays if 'img' is nil.
+func RenderDiff(a, b Hash, img image.Image) (image.Image, error) {
+	if a.Algorithm != b.Algorithm || a.Kind != b.Kind || len(a.Value) != len(b.Value) {
+		return nil, errors.New("cannot render the difference of a " + a.Algorithm + " hash and a " +
+			b.Algorithm + " hash")
+	}
+	grids, err := hashGrids(a)
+	if err != nil {
+		return nil, err
+	}
+
+	rect, xs := gridsRect(grids)
+	dst := image.NewNRGBA(rect)
+	draw.Draw(dst, rect, image.NewUniform(renderBackground), image.Point{}, draw.Src)
+	var gray *image.NRGBA
+	if img != nil {
+		gray = imaging.Grayscale(img)
+	}
+	for g, grid := range grids {
+		var thumb *image.NRGBA
+		if gray != nil {
+			thumb = imaging.Resize(gray, grid.cols, grid.rows, imaging.Lanczos)
+		}
+
+		for i := 0; i < grid.cols*grid.rows && grid.offset+i < len(a.Value)*8; i++ {
+			x, y := grid.cell(i)
+			var c color.Color = color.Gray{55}
+			if thumb != nil {
+				c = thumb.At(x, y)
+			} else if hashBit(a.Value, grid.offset+i) == 1 {
+				c = color.Gray{200}
+			}
+			cell := cellRect(xs[g], x, y)
+			draw.Draw(dst, cell, image.NewUniform(c), image.Point{}, draw.Src)
+			if hashBit(a.Value, grid.offset+i) != hashBit(b.Value, grid.offset+i) {
+				draw.Draw(dst, cell, image.NewUniform(renderDiff), image.Point{}, draw.Over)
+			}
+		}
+	}
+	return dst, nil
+}
@@ -0,0 +1,138 @@
+/*
+
+Testing suite for rendering hashes.
+
+1. Test that the cells follow the order of the bits of every algorithm
+2. Test the sizes of the renders, and hashes which can't be rendered
+3. Test that RenderDiff highlights the differing bits only
+
+*/
+
+package imagehash
+
+import (
+	"image"
+	"testing"
+)
+
+// cellColor returns the red channel, from 0 to 255, at the centre of a cell.
+func cellColor(img image.Image, gridX, x, y int) uint32 {
+	r, _, _, _ := img.At(gridX+x*RenderCellSize+RenderCellSize/2, y*RenderCellSize+RenderCellSize/2).RGBA()
+	return r >> 8
+}
+
+// Test that the cells follow the gradients of the hash functions
+func TestRenderHashOrder(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_256.png")
+	tests := []struct {
+		name     string
+		colMajor bool
+	}{
+		{"dhash-h:8", false},
+		{"dhash-d:8", false},
+		{"dhash-v:8", true},
+		{"ahash:8", true},
+	}
+	for _, test := range tests {
+		hasher, _ := NewHasher(test.name)
+		h, _ := hasher.Hash(src)
+		img, err := RenderHash(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 64; i++ {
+			x, y := i%8, i/8
+			if test.colMajor {
+				x, y = i/8, i%8
+			}
+			exp := uint32(0)
+			if hashBit(h.Value, i) == 1 {
+				exp = 255
+			}
+			if c := cellColor(img, 0, x, y); c != exp {
+				t.Errorf("%s bit %d cell (%d,%d) test [%d] failed: [%d]", test.name, i, x, y, exp, c)
+			}
+		}
+	}
+
+	// The vertical grid of a dhash is the one of dhash-v
+	dhasher, _ := NewHasher("dhash:8")
+	vhasher, _ := NewHasher("dhash-v:8")
+	dh, _ := dhasher.Hash(src)
+	vh, _ := vhasher.Hash(src)
+	dimg, _ := RenderHash(dh)
+	vimg, _ := RenderHash(vh)
+	for x := 0; x < 8; x++ {
+		for y := 0; y < 8; y++ {
+			if a, b := cellColor(dimg, 9*RenderCellSize, x, y), cellColor(vimg, 0, x, y); a != b {
+				t.Errorf("dhash vertical cell (%d,%d) test [%d] failed: [%d]", x, y, b, a)
+			}
+		}
+	}
+}
+
+// Test the sizes of the renders, and the hashes which can't be rendered
+func TestRenderHashSizes(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_256.png")
+	sizes := map[string]image.Point{
+		"dhash:8":   image.Pt(17*RenderCellSize, 8*RenderCellSize),
+		"dhash-h:5": image.Pt(5*RenderCellSize, 5*RenderCellSize),
+		"ahash:16":  image.Pt(16*RenderCellSize, 16*RenderCellSize),
+		"mhhash":    image.Pt(24*RenderCellSize, 24*RenderCellSize),
+	}
+	for name, exp := range sizes {
+		hasher, _ := NewHasher(name)
+		h, _ := hasher.Hash(src)
+		img, err := RenderHash(h)
+		if err != nil || img.Bounds().Size() != exp {
+			t.Errorf("%s render size test [%v] failed: [%v]", name, exp, err)
+		}
+	}
+
+	// Unknown algorithms are drawn in the smallest square
+	img, err := RenderHash(Hash{Algorithm: "third-party", Value: make([]byte, 5)})
+	if err != nil || img.Bounds().Size() != image.Pt(7*RenderCellSize, 6*RenderCellSize) {
+		t.Errorf("unknown render size test [7x6] failed: [%v]", err)
+	}
+
+	colormoment, _ := NewHasher("colormoment")
+	floats, _ := colormoment.Hash(src)
+	invalid := []Hash{
+		floats,
+		{Algorithm: "dhash:8", Value: make([]byte, 8)},
+		{Algorithm: "dhash:x", Value: make([]byte, 16)},
+		{Algorithm: "third-party"},
+	}
+	for _, h := range invalid {
+		if _, err := RenderHash(h); err == nil {
+			t.Errorf("invalid %s render test didn't fail", h.Algorithm)
+		}
+	}
+}
+
+// Test that only the differing bits are red, over the thumbnail or not
+func TestRenderDiff(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_256.png")
+	a := Hash{Algorithm: "dhash-h:8", Value: []byte{0xff, 0, 0, 0, 0, 0, 0, 0x0f}}
+	b := Hash{Algorithm: "dhash-h:8", Value: []byte{0xfe, 0, 0, 0, 0, 0, 0x80, 0x0f}}
+
+	for _, img := range []image.Image{src, nil} {
+		diff, err := RenderDiff(a, b, img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 64; i++ {
+			cx, cy := i%8*RenderCellSize+RenderCellSize/2, i/8*RenderCellSize+RenderCellSize/2
+			r, g, _, _ := diff.At(cx, cy).RGBA()
+			red := r>>8 > 150 && g>>8 < 100
+			if exp := i == 7 || i == 48; red != exp {
+				t.Errorf("diff bit %d test [%v] failed: [%v %d %d]", i, exp, red, r>>8, g>>8)
+			}
+		}
+	}
+
+	if _, err := RenderDiff(a, Hash{Algorithm: "ahash:8", Value: a.Value}, nil); err == nil {
+		t.Errorf("mixed diff test didn't fail")
+	}
+}
@@ -0,0 +1,364 @@
+// The gRPC service of the imagehash algorithms. The Go bindings in this
+// folder are generated with:
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//          --go-grpc_out=. --go-grpc_opt=paths=source_relative imagehash.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: imagehash.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	ImageHash_Hash_FullMethodName      = "/imagehash.ImageHash/Hash"
+	ImageHash_Compare_FullMethodName   = "/imagehash.ImageHash/Compare"
+	ImageHash_HashBatch_FullMethodName = "/imagehash.ImageHash/HashBatch"
+)
+
+// ImageHashClient is the client API for ImageHash service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ImageHashClient interface {
+	// Hash returns the hashes of an image.
+	Hash(ctx context.Context, in *HashRequest, opts ...grpc.CallOption) (*HashResponse, error)
+	// Compare returns the distance between the hashes of two images.
+	Compare(ctx context.Context, in *CompareRequest, opts ...grpc.CallOption) (*CompareResponse, error)
+	// HashBatch hashes a stream of images, returning one response per request,
+	// in the same order. An image which can't be hashed doesn't end the
+	// stream: its response holds the error instead.
+	HashBatch(ctx context.Context, opts ...grpc.CallOption) (ImageHash_HashBatchClient, error)
+}
+
+type imageHashClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewImageHashClient(cc grpc.ClientConnInterface) ImageHashClient {
+	return &imageHashClient{cc}
+}
+
+func (c *imageHashClient) Hash(ctx context.Context, in *HashRequest, opts ...grpc.CallOption) (*HashResponse, error) {
+	out := new(HashResponse)
+	err := c.cc.Invoke(ctx, ImageHash_Hash_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageHashClient) Compare(ctx context.Context, in *CompareRequest, opts ...grpc.CallOption) (*CompareResponse, error) {
+	out := new(CompareResponse)
+	err := c.cc.Invoke(ctx, ImageHash_Compare_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageHashClient) HashBatch(ctx context.Context, opts ...grpc.CallOption) (ImageHash_HashBatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ImageHash_ServiceDesc.Streams[0], ImageHash_HashBatch_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &imageHashHashBatchClient{stream}
+	return x, nil
+}
+
+type ImageHash_HashBatchClient interface {
+	Send(*HashRequest) error
+	Recv() (*HashBatchResponse, error)
+	grpc.ClientStream
+}
+
+type imageHashHashBatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *imageHashHashBatchClient) Send(m *HashRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *imageHashHashBatchClient) Recv() (*HashBatchResponse, error) {
+	m := new(HashBatchResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ImageHashServer is the server API for ImageHash service.
+// All implementations must embed UnimplementedImageHashServer
+// for forward compatibility
+type ImageHashServer interface {
+	// Hash returns the hashes of an image.
+	Hash(context.Context, *HashRequest) (*HashResponse, error)
+	// Compare returns the distance between the hashes of two images.
+	Compare(context.Context, *CompareRequest) (*CompareResponse, error)
+	// HashBatch hashes a stream of images, returning one response per request,
+	// in the same order. An image which can't be hashed doesn't end the
+	// stream: its response holds the error instead.
+	HashBatch(ImageHash_HashBatchServer) error
+	mustEmbedUnimplementedImageHashServer()
+}
+
+// UnimplementedImageHashServer must be embedded to have forward compatible implementations.
+type UnimplementedImageHashServer struct {
+}
+
+func (UnimplementedImageHashServer) Hash(context.Context, *HashRequest) (*HashResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Hash not implemented")
+}
+func (UnimplementedImageHashServer) Compare(context.Context, *CompareRequest) (*CompareResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Compare not implemented")
+}
+func (UnimplementedImageHashServer) HashBatch(ImageHash_HashBatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method HashBatch not implemented")
+}
+func (UnimplementedImageHashServer) mustEmbedUnimplementedImageHashServer() {}
+
+// UnsafeImageHashServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ImageHashServer will
+// result in compilation errors.
+type UnsafeImageHashServer interface {
+	mustEmbedUnimplementedImageHashServer()
+}
+
+func RegisterImageHashServer(s grpc.ServiceRegistrar, srv ImageHashServer) {
+	s.RegisterService(&ImageHash_ServiceDesc, srv)
+}
+
+func _ImageHash_Hash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HashRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageHashServer).Hash(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ImageHash_Hash_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageHashServer).Hash(ctx, req.(*HashRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageHash_Compare_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CompareRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageHashServer).Compare(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ImageHash_Compare_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageHashServer).Compare(ctx, req.(*CompareRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageHash_HashBatch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ImageHashServer).HashBatch(&imageHashHashBatchServer{stream})
+}
+
+type ImageHash_HashBatchServer interface {
+	Send(*HashBatchResponse) error
+	Recv() (*HashRequest, error)
+	grpc.ServerStream
+}
+
+type imageHashHashBatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *imageHashHashBatchServer) Send(m *HashBatchResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *imageHashHashBatchServer) Recv() (*HashRequest, error) {
+	m := new(HashRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ImageHash_ServiceDesc is the grpc.ServiceDesc for ImageHash service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ImageHash_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "imagehash.ImageHash",
+	HandlerType: (*ImageHashServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Hash",
+			Handler:    _ImageHash_Hash_Handler,
+		},
+		{
+			MethodName: "Compare",
+			Handler:    _ImageHash_Compare_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "HashBatch",
+			Handler:       _ImageHash_HashBatch_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "imagehash.proto",
+}
+
+const (
+	Index_Add_FullMethodName    = "/imagehash.Index/Add"
+	Index_Search_FullMethodName = "/imagehash.Index/Search"
+)
+
+// IndexClient is the client API for Index service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type IndexClient interface {
+	// Add adds the hash of an image, replacing the hash of its id if it was
+	// already added.
+	Add(ctx context.Context, in *AddRequest, opts ...grpc.CallOption) (*AddResponse, error)
+	// Search returns the indexed images within a distance of an image.
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+}
+
+type indexClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewIndexClient(cc grpc.ClientConnInterface) IndexClient {
+	return &indexClient{cc}
+}
+
+func (c *indexClient) Add(ctx context.Context, in *AddRequest, opts ...grpc.CallOption) (*AddResponse, error) {
+	out := new(AddResponse)
+	err := c.cc.Invoke(ctx, Index_Add_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *indexClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	out := new(SearchResponse)
+	err := c.cc.Invoke(ctx, Index_Search_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// IndexServer is the server API for Index service.
+// All implementations must embed UnimplementedIndexServer
+// for forward compatibility
+type IndexServer interface {
+	// Add adds the hash of an image, replacing the hash of its id if it was
+	// already added.
+	Add(context.Context, *AddRequest) (*AddResponse, error)
+	// Search returns the indexed images within a distance of an image.
+	Search(context.Context, *SearchRequest) (*SearchResponse, error)
+	mustEmbedUnimplementedIndexServer()
+}
+
+// UnimplementedIndexServer must be embedded to have forward compatible implementations.
+type UnimplementedIndexServer struct {
+}
+
+func (UnimplementedIndexServer) Add(context.Context, *AddRequest) (*AddResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Add not implemented")
+}
+func (UnimplementedIndexServer) Search(context.Context, *SearchRequest) (*SearchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Search not implemented")
+}
+func (UnimplementedIndexServer) mustEmbedUnimplementedIndexServer() {}
+
+// UnsafeIndexServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to IndexServer will
+// result in compilation errors.
+type UnsafeIndexServer interface {
+	mustEmbedUnimplementedIndexServer()
+}
+
+func RegisterIndexServer(s grpc.ServiceRegistrar, srv IndexServer) {
+	s.RegisterService(&Index_ServiceDesc, srv)
+}
+
+func _Index_Add_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IndexServer).Add(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Index_Add_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IndexServer).Add(ctx, req.(*AddRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Index_Search_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IndexServer).Search(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Index_Search_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IndexServer).Search(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Index_ServiceDesc is the grpc.ServiceDesc for Index service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Index_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "imagehash.Index",
+	HandlerType: (*IndexServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Add",
+			Handler:    _Index_Add_Handler,
+		},
+		{
+			MethodName: "Search",
+			Handler:    _Index_Search_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "imagehash.proto",
+}
@@ -0,0 +1,344 @@
+/*
+
+Package rpc is a gRPC service computing and comparing image hashes, the
+counterpart of the HTTP service of the server package. The service is
+defined in imagehash.proto, from which the Go bindings of this package are
+generated.
+
+Images are sent as encoded bytes, in any format the server decodes. Their
+dimensions are checked against MaxPixels before they are decoded; the size
+of the messages is bounded by the grpc.Server itself (4 MiB by default, see
+grpc.MaxRecvMsgSize).
+
+At most MaxConcurrent images are decoded and hashed at once, over all the
+calls; the image of a call waits for a slot for SlotTimeout at most, within
+the deadline of the call. The images of a HashBatch stream each take a slot
+in turn.
+
+Errors are returned as gRPC statuses:
+  InvalidArgument     missing id, unknown or too many algorithms, or
+                      undecodable image
+  ResourceExhausted   image over MaxPixels, or no slot free in time
+  DeadlineExceeded    the deadline of the call passed while hashing
+  Canceled            the call was canceled while hashing
+
+Usage:
+  srv,err := rpc.New(rpc.Config{})
+  gs := grpc.NewServer()
+  srv.Register(gs)
+  gs.Serve(listener)
+
+*/
+
+package rpc
+
+import (
+	"context"
+	"image"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/devedge/imagehash"
+	"github.com/devedge/imagehash/internal/service"
+)
+
+// Config configures a Server. Zero values are replaced by defaults.
+type Config struct {
+	MaxPixels     int           // Largest width times height of an image; 50 million if zero
+	MaxConcurrent int           // Images decoding and hashing at once; the number of CPUs if zero
+	SlotTimeout   time.Duration // Longest time an image waits for a slot, within the deadline of its call; 30s if zero
+	Algorithms    []string      // Hashers used when a request names none; "dhash:8" if empty
+	MaxAlgorithms int           // Largest number of hashers a request names; 8 if zero
+	IndexHasher   string        // Hasher of the in-memory index; "dhash:8" if empty
+}
+
+// Server implements both the ImageHash and the Index services.
+type Server struct {
+	UnimplementedImageHashServer
+	UnimplementedIndexServer
+
+	cfg     Config
+	slots   chan struct{}
+	index   *imagehash.Index
+	indexer imagehash.Hasher
+}
+
+// New returns a Server, after checking that its hashers exist.
+func New(cfg Config) (*Server, error) {
+	if cfg.MaxPixels <= 0 {
+		cfg.MaxPixels = 50000000
+	}
+	if cfg.MaxConcurrent <= 0 {
+		cfg.MaxConcurrent = runtime.NumCPU()
+	}
+	if cfg.SlotTimeout <= 0 {
+		cfg.SlotTimeout = 30 * time.Second
+	}
+	if len(cfg.Algorithms) == 0 {
+		cfg.Algorithms = []string{"dhash:8"}
+	}
+	if cfg.MaxAlgorithms <= 0 {
+		cfg.MaxAlgorithms = 8
+	}
+	if cfg.IndexHasher == "" {
+		cfg.IndexHasher = "dhash:8"
+	}
+
+	indexer, err := service.CheckHashers(cfg.Algorithms, cfg.IndexHasher)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{cfg: cfg, slots: make(chan struct{}, cfg.MaxConcurrent), index: imagehash.NewIndex(),
+		indexer: indexer}, nil
+}
+
+// Register registers both services of the server on a grpc.Server.
+func (s *Server) Register(gs *grpc.Server) {
+	RegisterImageHashServer(gs, s)
+	RegisterIndexServer(gs, s)
+}
+
+// Index returns the in-memory index of the server, so that it can be
+// filled or inspected by the program embedding it.
+func (s *Server) Index() *imagehash.Index {
+	return s.index
+}
+
+// Hash implements ImageHashServer.
+func (s *Server) Hash(ctx context.Context, req *HashRequest) (*HashResponse, error) {
+	hashes, err := s.hash(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &HashResponse{Hashes: hashes}, nil
+}
+
+// HashBatch implements ImageHashServer. The images are hashed one at a
+// time, in the order they are received.
+func (s *Server) HashBatch(stream ImageHash_HashBatchServer) error {
+	ctx := stream.Context()
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		res := &HashBatchResponse{Id: req.Id}
+		if res.Hashes, err = s.hash(ctx, req); err != nil {
+			// The whole call is over if its context is done
+			if ctx.Err() != nil {
+				return err
+			}
+			st := status.Convert(err)
+			res.Error = &Error{Code: uint32(st.Code()), Message: st.Message()}
+		}
+		if err := stream.Send(res); err != nil {
+			return err
+		}
+	}
+}
+
+// Compare implements ImageHashServer.
+func (s *Server) Compare(ctx context.Context, req *CompareRequest) (*CompareResponse, error) {
+	name := req.Algorithm
+	if name == "" {
+		name = s.cfg.Algorithms[0]
+	}
+	hasher, err := imagehash.NewHasher(name)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	release, err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var hashes [2]imagehash.Hash
+	for i, data := range [][]byte{req.Image1, req.Image2} {
+		img, err := s.decode(ctx, data, "image"+strconv.Itoa(i+1))
+		if err != nil {
+			return nil, err
+		}
+		if hashes[i], err = imagehash.HashContext(ctx, hasher, img); err != nil {
+			return nil, hashError(err, "")
+		}
+	}
+
+	dist, _ := hashes[0].Distance(hashes[1])
+	res := &CompareResponse{Algorithm: hasher.Name(), Distance: dist}
+	if hashes[0].Kind == imagehash.BinaryKind {
+		sim := imagehash.SimilarityBits(hashes[0].Value, hashes[1].Value, hasher.Bits())
+		res.Similarity = &sim
+	}
+	return res, nil
+}
+
+// Add implements IndexServer.
+func (s *Server) Add(ctx context.Context, req *AddRequest) (*AddResponse, error) {
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing id")
+	}
+
+	h, err := s.indexHash(ctx, req.Image)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.index.AddHash(req.Id, h); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &AddResponse{Id: req.Id, Hash: toProto(h), Size: int32(s.index.Len())}, nil
+}
+
+// Search implements IndexServer.
+func (s *Server) Search(ctx context.Context, req *SearchRequest) (*SearchResponse, error) {
+	maxDistance := s.indexer.Bits() / 8
+	if req.MaxDistance != nil {
+		if maxDistance = int(*req.MaxDistance); maxDistance < 0 {
+			return nil, status.Error(codes.InvalidArgument, "negative max_distance: "+strconv.Itoa(maxDistance))
+		}
+	}
+
+	h, err := s.indexHash(ctx, req.Image)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := s.index.SearchHash(h, maxDistance)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	res := &SearchResponse{}
+	for _, m := range matches {
+		res.Matches = append(res.Matches, &Match{Id: m.ID, Distance: int32(m.Distance), Version: uint32(m.Version)})
+	}
+	return res, nil
+}
+
+// hash returns the hashes of the image of a request.
+func (s *Server) hash(ctx context.Context, req *HashRequest) ([]*Hash, error) {
+	names := req.Algorithms
+	if len(names) == 0 {
+		names = s.cfg.Algorithms
+	}
+	if len(names) > s.cfg.MaxAlgorithms {
+		// The image is hashed once per name, within a single slot
+		return nil, status.Error(codes.InvalidArgument, "at most "+strconv.Itoa(s.cfg.MaxAlgorithms)+
+			" algorithms can be named")
+	}
+	var hashers []imagehash.Hasher
+	for _, name := range names {
+		hasher, err := imagehash.NewHasher(strings.TrimSpace(name))
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		hashers = append(hashers, hasher)
+	}
+
+	release, err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	img, err := s.decode(ctx, req.Image, "image")
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []*Hash
+	for _, hasher := range hashers {
+		h, err := imagehash.HashContext(ctx, hasher, img)
+		if err != nil {
+			return nil, hashError(err, "")
+		}
+		hashes = append(hashes, toProto(h))
+	}
+	return hashes, nil
+}
+
+// indexHash returns the hash of an image computed by the hasher of the
+// index.
+func (s *Server) indexHash(ctx context.Context, data []byte) (imagehash.Hash, error) {
+	release, err := s.acquire(ctx)
+	if err != nil {
+		return imagehash.Hash{}, err
+	}
+	defer release()
+
+	img, err := s.decode(ctx, data, "image")
+	if err != nil {
+		return imagehash.Hash{}, err
+	}
+	h, err := imagehash.HashContext(ctx, s.indexer, img)
+	if err != nil {
+		return imagehash.Hash{}, hashError(err, "")
+	}
+	return h, nil
+}
+
+// acquire waits for a slot, so that at most MaxConcurrent images are in
+// memory, and returns the function releasing it.
+func (s *Server) acquire(ctx context.Context) (func(), error) {
+	timer := time.NewTimer(s.cfg.SlotTimeout)
+	defer timer.Stop()
+	select {
+	case s.slots <- struct{}{}:
+		return func() { <-s.slots }, nil
+	case <-timer.C:
+		return nil, status.Error(codes.ResourceExhausted, "too many concurrent calls")
+	case <-ctx.Done():
+		return nil, hashError(ctx.Err(), "")
+	}
+}
+
+// decode decodes an image, after checking its format and dimensions. 'field'
+// names the image in the errors.
+func (s *Server) decode(ctx context.Context, data []byte, field string) (image.Image, error) {
+	if len(data) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "missing "+field)
+	}
+	img, err := service.Decode(ctx, data, s.cfg.MaxPixels)
+	if err != nil {
+		return nil, hashError(err, field)
+	}
+	return img, nil
+}
+
+// hashError maps an error decoding or hashing an image to a status. 'field'
+// names the image in the message, if not empty.
+func hashError(err error, field string) error {
+	message := err.Error()
+	if field != "" {
+		message = field + ": " + message
+	}
+	switch service.Classify(err) {
+	case service.TooLarge:
+		return status.Error(codes.ResourceExhausted, message)
+	case service.Timeout:
+		return status.Error(codes.DeadlineExceeded, "the image couldn't be hashed in time")
+	case service.Canceled:
+		return status.Error(codes.Canceled, "the call was canceled")
+	}
+	return status.Error(codes.InvalidArgument, message)
+}
+
+// toProto converts a hash for a response.
+func toProto(h imagehash.Hash) *Hash {
+	if h.Kind == imagehash.FloatKind {
+		return &Hash{Algorithm: h.Algorithm, Vector: h.Vector, Pipeline: h.Pipeline, Version: uint32(h.Version)}
+	}
+	return &Hash{Algorithm: h.Algorithm, Value: h.Value, Pipeline: h.Pipeline, Version: uint32(h.Version)}
+}
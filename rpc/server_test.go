@@ -0,0 +1,349 @@
+/*
+
+Testing suite for the gRPC service, called over an in-process listener.
+
+1. Test hashing an image
+2. Test comparing two images
+3. Test adding to and searching the index
+4. Test hashing a stream of images, including undecodable ones
+5. Test that the errors have the right status codes
+6. Test invalid configurations
+7. Test that the images waiting too long for a slot are refused
+
+*/
+
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/devedge/imagehash"
+)
+
+// testClients are the clients of a server served over bufconn.
+type testClients struct {
+	hash  ImageHashClient
+	index IndexClient
+	srv   *Server
+}
+
+// newTestClients serves a new server over an in-process listener, and
+// returns clients connected to it. Everything is stopped by the cleanup of
+// the test.
+func newTestClients(t *testing.T, cfg Config) testClients {
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	srv.Register(gs)
+	go gs.Serve(lis)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		gs.Stop()
+	})
+	return testClients{NewImageHashClient(conn), NewIndexClient(conn), srv}
+}
+
+// testImage reads a test image.
+func testImage(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile("../testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// Test that the hashes match the ones of the package
+func TestHash(t *testing.T) {
+	c := newTestClients(t, Config{})
+	img, _ := imagehash.OpenImg("../testdata/lena_256.png")
+	dhash, _ := imagehash.Dhash(img, 8)
+	ahash, _ := imagehash.Ahash(img, 16)
+
+	res, err := c.hash.Hash(context.Background(), &HashRequest{
+		Image:      testImage(t, "lena_256.png"),
+		Algorithms: []string{"dhash:8", "ahash:16", "colormoment"},
+	})
+	if err != nil || len(res.Hashes) != 3 {
+		t.Fatalf("hash test [3] failed: [%v %v]", err, res)
+	}
+	if !bytes.Equal(res.Hashes[0].Value, dhash) || !bytes.Equal(res.Hashes[1].Value, ahash) {
+		t.Errorf("hash test [%x %x] failed: [%x %x]", dhash, ahash, res.Hashes[0].Value, res.Hashes[1].Value)
+	}
+	if res.Hashes[2].Algorithm != "colormoment" || len(res.Hashes[2].Vector) != imagehash.ColorMomentLen {
+		t.Errorf("float hash test failed: [%v]", res.Hashes[2])
+	}
+
+	res, err = c.hash.Hash(context.Background(), &HashRequest{Image: testImage(t, "lena_256.png")})
+	if err != nil || len(res.Hashes) != 1 || res.Hashes[0].Algorithm != "dhash:8" ||
+		res.Hashes[0].Version != imagehash.DhashVersion {
+		t.Errorf("default hash test [dhash:8 %d] failed: [%v %v]", imagehash.DhashVersion, err, res)
+	}
+
+	res, err = c.hash.Hash(context.Background(), &HashRequest{
+		Image:      testImage(t, "lena_256.png"),
+		Algorithms: []string{"equalize|blur(1.5)|dhash:8"},
+	})
+	if err != nil || len(res.Hashes) != 1 || res.Hashes[0].Algorithm != "dhash:8" ||
+		res.Hashes[0].Pipeline != "equalize|blur(1.5)" {
+		t.Errorf("pipeline hash test [dhash:8 equalize|blur(1.5)] failed: [%v %v]", err, res)
+	}
+}
+
+// Test comparing the same and different images
+func TestCompare(t *testing.T) {
+	c := newTestClients(t, Config{})
+
+	res, err := c.hash.Compare(context.Background(), &CompareRequest{
+		Image1: testImage(t, "lena_256.png"),
+		Image2: testImage(t, "lena_512.png"),
+	})
+	if err != nil || res.Similarity == nil || *res.Similarity < 0.9 {
+		t.Errorf("compare similar test failed: [%v %v]", err, res)
+	}
+
+	res, err = c.hash.Compare(context.Background(), &CompareRequest{
+		Image1:    testImage(t, "lena_512.png"),
+		Image2:    testImage(t, "rand_512.png"),
+		Algorithm: "dhash:16",
+	})
+	if err != nil || res.Algorithm != "dhash:16" || res.Similarity == nil || *res.Similarity > 0.7 {
+		t.Errorf("compare different test failed: [%v %v]", err, res)
+	}
+
+	res, err = c.hash.Compare(context.Background(), &CompareRequest{
+		Image1:    testImage(t, "lena_512.png"),
+		Image2:    testImage(t, "lena_512.png"),
+		Algorithm: "colormoment",
+	})
+	if err != nil || res.Similarity != nil || res.Distance != 0 {
+		t.Errorf("compare float test [0] failed: [%v %v]", err, res)
+	}
+}
+
+// Test adding images to the index, and searching it
+func TestIndex(t *testing.T) {
+	c := newTestClients(t, Config{})
+
+	for i, name := range []string{"lena_512", "lena_inverted_512", "rand_512"} {
+		res, err := c.index.Add(context.Background(), &AddRequest{Id: name, Image: testImage(t, name+".png")})
+		if err != nil || res.Id != name || res.Size != int32(i+1) || len(res.Hash.Value) != 16 {
+			t.Errorf("index add test [%s %d] failed: [%v %v]", name, i+1, err, res)
+		}
+	}
+	if c.srv.Index().Len() != 3 {
+		t.Errorf("index add test [3] failed: [%d]", c.srv.Index().Len())
+	}
+
+	maxDistance := int32(10)
+	res, err := c.index.Search(context.Background(), &SearchRequest{
+		Image:       testImage(t, "lena_256.png"),
+		MaxDistance: &maxDistance,
+	})
+	if err != nil || len(res.Matches) != 1 || res.Matches[0].Id != "lena_512" ||
+		res.Matches[0].Version != imagehash.DhashVersion {
+		t.Errorf("index search test [lena_512 %d] failed: [%v %v]", imagehash.DhashVersion, err, res)
+	}
+
+	maxDistance = 128
+	res, err = c.index.Search(context.Background(), &SearchRequest{
+		Image:       testImage(t, "lena_256.png"),
+		MaxDistance: &maxDistance,
+	})
+	if err != nil || len(res.Matches) != 3 {
+		t.Errorf("index search all test [3] failed: [%v %v]", err, res)
+	}
+}
+
+// Test that a batch returns one response per image, in order, and keeps
+// going after an undecodable image
+func TestHashBatch(t *testing.T) {
+	c := newTestClients(t, Config{})
+	stream, err := c.hash.HashBatch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := []*HashRequest{
+		{Id: "lena", Image: testImage(t, "lena_256.png")},
+		{Id: "text", Image: []byte("not an image")},
+		{Id: "rand", Image: testImage(t, "rand_512.png"), Algorithms: []string{"ahash:8", "dhash:8"}},
+		{Id: "phash", Image: testImage(t, "rand_512.png"), Algorithms: []string{"phash"}},
+	}
+	go func() {
+		for _, req := range requests {
+			stream.Send(req)
+		}
+		stream.CloseSend()
+	}()
+
+	var responses []*HashBatchResponse
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		responses = append(responses, res)
+	}
+
+	if len(responses) != len(requests) {
+		t.Fatalf("batch test [%d] failed: [%d]", len(requests), len(responses))
+	}
+	for i, res := range responses {
+		if res.Id != requests[i].Id {
+			t.Errorf("batch order test [%s] failed: [%s]", requests[i].Id, res.Id)
+		}
+	}
+	if responses[0].Error != nil || len(responses[0].Hashes) != 1 {
+		t.Errorf("batch hash test failed: [%v]", responses[0])
+	}
+	if responses[1].Error == nil || codes.Code(responses[1].Error.Code) != codes.InvalidArgument {
+		t.Errorf("batch invalid image test [%v] failed: [%v]", codes.InvalidArgument, responses[1].Error)
+	}
+	if responses[2].Error != nil || len(responses[2].Hashes) != 2 {
+		t.Errorf("batch algorithms test [2] failed: [%v]", responses[2])
+	}
+	if responses[3].Error == nil || codes.Code(responses[3].Error.Code) != codes.InvalidArgument {
+		t.Errorf("batch unknown algorithm test [%v] failed: [%v]", codes.InvalidArgument, responses[3].Error)
+	}
+}
+
+// Test the status codes of the errors
+func TestErrors(t *testing.T) {
+	c := newTestClients(t, Config{MaxPixels: 256 * 256})
+
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4)))
+	truncated := buf.Bytes()[:buf.Len()/2]
+	negative := int32(-1)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{"missing image", func() error {
+			_, err := c.hash.Hash(ctx, &HashRequest{})
+			return err
+		}, codes.InvalidArgument},
+		{"too many algorithms", func() error {
+			names := make([]string, 9)
+			for i := range names {
+				names[i] = "dhash:8"
+			}
+			_, err := c.hash.Hash(ctx, &HashRequest{Image: testImage(t, "lena_256.png"), Algorithms: names})
+			return err
+		}, codes.InvalidArgument},
+		{"unknown algorithm", func() error {
+			_, err := c.hash.Hash(ctx, &HashRequest{Image: truncated, Algorithms: []string{"phash"}})
+			return err
+		}, codes.InvalidArgument},
+		{"truncated image", func() error {
+			_, err := c.hash.Hash(ctx, &HashRequest{Image: truncated})
+			return err
+		}, codes.InvalidArgument},
+		{"pixel limit", func() error {
+			_, err := c.hash.Hash(ctx, &HashRequest{Image: testImage(t, "lena_512.png")})
+			return err
+		}, codes.ResourceExhausted},
+		{"missing second image", func() error {
+			_, err := c.hash.Compare(ctx, &CompareRequest{Image1: testImage(t, "lena_256.png")})
+			return err
+		}, codes.InvalidArgument},
+		{"missing id", func() error {
+			_, err := c.index.Add(ctx, &AddRequest{Image: testImage(t, "lena_256.png")})
+			return err
+		}, codes.InvalidArgument},
+		{"negative distance", func() error {
+			_, err := c.index.Search(ctx, &SearchRequest{Image: testImage(t, "lena_256.png"), MaxDistance: &negative})
+			return err
+		}, codes.InvalidArgument},
+	}
+	for _, test := range tests {
+		if code := status.Code(test.call()); code != test.code {
+			t.Errorf("%s error test [%v] failed: [%v]", test.name, test.code, code)
+		}
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := c.hash.Hash(canceled, &HashRequest{Image: testImage(t, "lena_256.png")})
+	if code := status.Code(err); code != codes.Canceled {
+		t.Errorf("canceled error test [%v] failed: [%v]", codes.Canceled, code)
+	}
+}
+
+// Test that unknown hashers, and float index hashers, are rejected
+func TestInvalidConfig(t *testing.T) {
+	if _, err := New(Config{Algorithms: []string{"phash"}}); err == nil {
+		t.Errorf("unknown default hasher didn't fail")
+	}
+	if _, err := New(Config{IndexHasher: "colormoment"}); err == nil {
+		t.Errorf("float index hasher didn't fail")
+	}
+}
+
+// Test that calls are refused once the slots are taken for too long
+func TestConcurrencyLimit(t *testing.T) {
+	c := newTestClients(t, Config{MaxConcurrent: 1, SlotTimeout: 50 * time.Millisecond})
+	c.srv.slots <- struct{}{} // Take the only slot
+	ctx := context.Background()
+
+	_, err := c.hash.Hash(ctx, &HashRequest{Image: testImage(t, "lena_256.png")})
+	if code := status.Code(err); code != codes.ResourceExhausted {
+		t.Errorf("hash concurrency limit test [%v] failed: [%v]", codes.ResourceExhausted, code)
+	}
+	_, err = c.index.Search(ctx, &SearchRequest{Image: testImage(t, "lena_256.png")})
+	if code := status.Code(err); code != codes.ResourceExhausted {
+		t.Errorf("search concurrency limit test [%v] failed: [%v]", codes.ResourceExhausted, code)
+	}
+
+	stream, err := c.hash.HashBatch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Send(&HashRequest{Id: "a", Image: testImage(t, "lena_256.png")})
+	res, err := stream.Recv()
+	if err != nil || res.Error == nil || codes.Code(res.Error.Code) != codes.ResourceExhausted {
+		t.Errorf("batch concurrency limit test [%v] failed: [%v %v]", codes.ResourceExhausted, err, res)
+	}
+
+	<-c.srv.slots
+	stream.Send(&HashRequest{Id: "b", Image: testImage(t, "lena_256.png")})
+	if res, err := stream.Recv(); err != nil || res.Error != nil || len(res.Hashes) != 1 {
+		t.Errorf("batch free slot test [1] failed: [%v %v]", err, res)
+	}
+	stream.CloseSend()
+	if _, err := c.hash.Hash(ctx, &HashRequest{Image: testImage(t, "lena_256.png")}); err != nil {
+		t.Errorf("free slot test failed: [%v]", err)
+	}
+}
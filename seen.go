@@ -0,0 +1,335 @@
+/*
+
+Implements SeenFilter, which answers "was an image like this one seen
+recently?" for a continuous stream of images, such as the ones of a
+crawler, with a memory bounded by how long hashes are remembered.
+
+The hashes are added to the Index of the time window they were added in.
+The windows are Window long, and a window rolls off once it ended more than
+Retention ago, dropping its hashes at once, so a hash is remembered for at
+least Retention, and at most Retention plus Window. A query searches the
+index of every window which hasn't rolled off.
+
+The filter takes the binary hashes of any hasher, but all of them must be
+of the same hasher, version and preprocessing as the first one added.
+
+Its state can be saved to a JSON file, which is replaced at once, and
+loaded back when the process restarts. A SeenFilter is safe for concurrent
+use.
+
+Usage:
+  filter,err := imagehash.LoadSeenFilter("seen.json", imagehash.SeenOptions{
+    Retention: 30 * 24 * time.Hour,
+    Window:    24 * time.Hour,
+  })
+  seen,err := filter.SeenOrAdd(hash, 6)
+  err = filter.Save("seen.json")
+
+*/
+
+package imagehash
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// seenFileVersion is the version of the file format of a SeenFilter.
+const seenFileVersion = 1
+
+// SeenOptions configures a SeenFilter. Zero values are replaced by
+// defaults.
+type SeenOptions struct {
+	Retention time.Duration    // How long hashes are remembered for, at least; 7 days if zero
+	Window    time.Duration    // Length of a time window, which rolls off at once; a day if zero
+	Now       func() time.Time // Clock of the filter; time.Now if nil
+}
+
+// SeenFilter remembers the hashes added to it during a retention period,
+// and answers whether a hash is within a distance of one of them.
+type SeenFilter struct {
+	opts SeenOptions
+
+	mu      sync.RWMutex
+	first   Hash // Hasher, version and preprocessing of the hashes; Algorithm is empty until a hash is added
+	windows []*seenWindow
+}
+
+// seenWindow is a time window of a SeenFilter.
+type seenWindow struct {
+	start, end time.Time
+	index      *Index
+}
+
+// seenFile is the content of the file of a SeenFilter.
+type seenFile struct {
+	Version     int              `json:"version"`
+	Algorithm   string           `json:"algorithm"`
+	Pipeline    string           `json:"pipeline,omitempty"`
+	HashVersion int              `json:"hashVersion"`
+	Windows     []seenFileWindow `json:"windows"`
+}
+
+// seenFileWindow is a time window in the file of a SeenFilter.
+type seenFileWindow struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Hashes [][]byte  `json:"hashes"`
+}
+
+// NewSeenFilter returns an empty SeenFilter.
+func NewSeenFilter(opts SeenOptions) (*SeenFilter, error) {
+	if opts.Retention == 0 {
+		opts.Retention = 7 * 24 * time.Hour
+	}
+	if opts.Window == 0 {
+		opts.Window = 24 * time.Hour
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.Retention < 0 || opts.Window < 0 {
+		return nil, errors.New("the retention and the window must not be negative")
+	}
+	return &SeenFilter{opts: opts}, nil
+}
+
+// LoadSeenFilter loads the SeenFilter saved at 'path', or returns an empty
+// one if the file doesn't exist yet. The windows which rolled off since it
+// was saved are dropped.
+func LoadSeenFilter(path string, opts SeenOptions) (*SeenFilter, error) {
+	sf, err := NewSeenFilter(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return sf, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file seenFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, errors.New("invalid seen filter file " + path + ": " + err.Error())
+	}
+	if file.Version != seenFileVersion {
+		return nil, errors.New("unsupported seen filter file version " + strconv.Itoa(file.Version))
+	}
+
+	// The searches and roll-offs rely on the windows being in time order
+	sort.Slice(file.Windows, func(i, j int) bool { return file.Windows[i].Start.Before(file.Windows[j].Start) })
+	for i, fw := range file.Windows {
+		if !fw.Start.Before(fw.End) || (i > 0 && fw.Start.Before(file.Windows[i-1].End)) {
+			return nil, errors.New("invalid seen filter file " + path + ": overlapping or empty window at " +
+				fw.Start.Format(time.RFC3339))
+		}
+	}
+
+	sf.first = Hash{Algorithm: file.Algorithm, Pipeline: file.Pipeline, Version: file.HashVersion}
+	for _, fw := range file.Windows {
+		w := &seenWindow{start: fw.Start, end: fw.End, index: NewIndex()}
+		for i, h := range fw.Hashes {
+			if err := w.index.Add(strconv.Itoa(i), h); err != nil {
+				return nil, errors.New("invalid seen filter file " + path + ": " + err.Error())
+			}
+		}
+		sf.windows = append(sf.windows, w)
+	}
+	sf.roll(sf.opts.Now())
+	return sf, nil
+}
+
+// Add remembers a hash, in the current time window.
+func (sf *SeenFilter) Add(h Hash) error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	if err := sf.check(h); err != nil {
+		return err
+	}
+	return sf.add(h, sf.opts.Now())
+}
+
+// Seen reports whether a hash is within 'maxDistance' bits of a hash added
+// during the retention period. The windows which rolled off are dropped
+// first, so that a filter which is only queried frees them too.
+func (sf *SeenFilter) Seen(h Hash, maxDistance int) (bool, error) {
+	now := sf.opts.Now()
+	sf.rollExpired(now)
+
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+
+	if err := sf.check(h); err != nil {
+		return false, err
+	}
+	return sf.seen(h, maxDistance, now)
+}
+
+// SeenOrAdd reports whether a hash was seen, as Seen does, and adds it if
+// it wasn't. Checking and adding are done at once, so that of several
+// goroutines adding near-duplicates at the same time, only one finds it
+// unseen. The windows are searched from the newest, and the search stops
+// at the first hash within the distance.
+func (sf *SeenFilter) SeenOrAdd(h Hash, maxDistance int) (bool, error) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	if err := sf.check(h); err != nil {
+		return false, err
+	}
+	now := sf.opts.Now()
+	seen, err := sf.seen(h, maxDistance, now)
+	if err != nil || seen {
+		return seen, err
+	}
+	return false, sf.add(h, now)
+}
+
+// Len returns the number of hashes remembered, including the ones of
+// windows which rolled off but weren't dropped yet.
+func (sf *SeenFilter) Len() int {
+	sf.mu.RLock()
+	defer sf.mu.RUnlock()
+
+	n := 0
+	for _, w := range sf.windows {
+		n += w.index.Len()
+	}
+	return n
+}
+
+// Save writes the filter to a file, replacing it at once so that it is
+// never left half written. The new file is written to a temporary file of
+// its own in the same folder, and synced to disk before it replaces the
+// old one, so that concurrent Saves don't write over each other.
+func (sf *SeenFilter) Save(path string) error {
+	sf.mu.RLock()
+	file := seenFile{Version: seenFileVersion, Algorithm: sf.first.Algorithm, Pipeline: sf.first.Pipeline,
+		HashVersion: sf.first.Version, Windows: make([]seenFileWindow, 0, len(sf.windows))}
+	for _, w := range sf.windows {
+		fw := seenFileWindow{Start: w.start, End: w.end, Hashes: make([][]byte, w.index.Len())}
+		for i := range fw.Hashes {
+			fw.Hashes[i], _ = w.index.Get(strconv.Itoa(i))
+		}
+		file.Windows = append(file.Windows, fw)
+	}
+	sf.mu.RUnlock()
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0644) // TempFile creates the file readable by its owner only
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// check checks that a hash can be compared with the hashes of the filter.
+func (sf *SeenFilter) check(h Hash) error {
+	if h.Kind != BinaryKind {
+		return errors.New("cannot filter the float hashes of " + h.Algorithm)
+	}
+	if sf.first.Algorithm == "" {
+		return nil
+	}
+	if h.Algorithm != sf.first.Algorithm || h.Pipeline != sf.first.Pipeline {
+		return errors.New("cannot filter " + h.Algorithm + " hashes with " + sf.first.Algorithm + " hashes")
+	}
+	return CheckVersions(sf.first, h)
+}
+
+// add adds a hash to the window of 'now', rolling the windows over if
+// needed.
+func (sf *SeenFilter) add(h Hash, now time.Time) error {
+	sf.roll(now)
+	var w *seenWindow
+	if n := len(sf.windows); n > 0 && now.Before(sf.windows[n-1].end) {
+		w = sf.windows[n-1]
+	} else {
+		start := now.Truncate(sf.opts.Window)
+		w = &seenWindow{start: start, end: start.Add(sf.opts.Window), index: NewIndex()}
+		sf.windows = append(sf.windows, w)
+	}
+
+	if err := w.index.Add(strconv.Itoa(w.index.Len()), h.Value); err != nil {
+		return err
+	}
+	if sf.first.Algorithm == "" {
+		sf.first = Hash{Algorithm: h.Algorithm, Pipeline: h.Pipeline, Version: h.Version}
+	}
+	return nil
+}
+
+// seen searches the windows which haven't rolled off at 'now'.
+func (sf *SeenFilter) seen(h Hash, maxDistance int, now time.Time) (bool, error) {
+	for i := len(sf.windows) - 1; i >= 0; i-- {
+		w := sf.windows[i]
+		if sf.expired(w, now) {
+			break // The windows before are older
+		}
+		found, err := w.index.Within(Hash{Value: h.Value}, maxDistance)
+		if err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// rollExpired drops the windows which rolled off at 'now', only taking the
+// write lock when the oldest one did.
+func (sf *SeenFilter) rollExpired(now time.Time) {
+	sf.mu.RLock()
+	expired := len(sf.windows) > 0 && sf.expired(sf.windows[0], now)
+	sf.mu.RUnlock()
+
+	if expired {
+		sf.mu.Lock()
+		sf.roll(now)
+		sf.mu.Unlock()
+	}
+}
+
+// roll drops the windows which rolled off at 'now'.
+func (sf *SeenFilter) roll(now time.Time) {
+	n := 0
+	for n < len(sf.windows) && sf.expired(sf.windows[n], now) {
+		n++
+	}
+	sf.windows = append(sf.windows[:0], sf.windows[n:]...)
+}
+
+// expired reports whether a window rolled off at 'now'.
+func (sf *SeenFilter) expired(w *seenWindow, now time.Time) bool {
+	return !w.end.After(now.Add(-sf.opts.Retention))
+}
@@ -0,0 +1,251 @@
+/*
+
+Testing suite for SeenFilter.
+
+1. Test the hashes seen within a distance, and invalid hashes
+2. Test that the windows roll off after the retention period, and are
+   dropped by queries as well as additions
+3. Test saving a filter and loading it back, and invalid windows
+4. Test that concurrent near-duplicates are only found unseen once
+5. Test that concurrent Saves don't write over each other
+
+*/
+
+package imagehash
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClock is a clock which only moves when told to.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newTestClock returns a clock at the start of a day.
+func newTestClock() *testClock {
+	return &testClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+// Test which hashes are seen, and which can't be filtered
+func TestSeenFilter(t *testing.T) {
+	filter, err := NewSeenFilter(SeenOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen, err := filter.Seen(clusterHash(0x0f), 2); err != nil || seen {
+		t.Errorf("empty filter test [false] failed: [%v %v]", seen, err)
+	}
+	filter.Add(clusterHash(0x0f))
+
+	tests := []struct {
+		value uint64
+		exp   bool
+	}{
+		{0x0f, true},
+		{0x03, true},  // 2 bits away
+		{0x01, false}, // 3 bits away
+	}
+	for _, test := range tests {
+		if seen, err := filter.Seen(clusterHash(test.value), 2); err != nil || seen != test.exp {
+			t.Errorf("seen %x test [%v] failed: [%v %v]", test.value, test.exp, seen, err)
+		}
+	}
+
+	if seen, _ := filter.SeenOrAdd(clusterHash(0x01), 2); seen {
+		t.Errorf("unseen add test [false] failed: [true]")
+	}
+	if seen, _ := filter.Seen(clusterHash(0x01), 0); !seen || filter.Len() != 2 {
+		t.Errorf("seen after add test [true 2] failed: [%v %d]", seen, filter.Len())
+	}
+
+	mixed := clusterHash(0)
+	mixed.Algorithm = "ahash:8"
+	newer := clusterHash(0)
+	newer.Version++
+	for _, h := range []Hash{mixed, newer, {Algorithm: "colormoment", Kind: FloatKind}} {
+		if _, err := filter.SeenOrAdd(h, 2); err == nil {
+			t.Errorf("invalid %s hash test didn't fail", h.Algorithm)
+		}
+	}
+	if _, err := NewSeenFilter(SeenOptions{Window: -time.Hour}); err == nil {
+		t.Errorf("negative window test didn't fail")
+	}
+}
+
+// Test that hashes are remembered between the retention and the retention
+// plus a window
+func TestSeenFilterRollOff(t *testing.T) {
+	clock := newTestClock()
+	filter, _ := NewSeenFilter(SeenOptions{Retention: 2 * time.Hour, Window: time.Hour, Now: clock.Now})
+
+	clock.Advance(30 * time.Minute)
+	filter.Add(clusterHash(1)) // In the window from 0:00 to 1:00
+	clock.Advance(time.Hour)
+	filter.Add(clusterHash(2)) // In the window from 1:00 to 2:00
+
+	clock.Advance(89 * time.Minute) // 2:59
+	if seen, _ := filter.Seen(clusterHash(1), 0); !seen {
+		t.Errorf("remembered hash test [true] failed: [false]")
+	}
+	clock.Advance(time.Minute) // 3:00
+	if seen, _ := filter.Seen(clusterHash(1), 0); seen {
+		t.Errorf("rolled off hash test [false] failed: [true]")
+	}
+	if seen, _ := filter.Seen(clusterHash(2), 0); !seen {
+		t.Errorf("next window test [true] failed: [false]")
+	}
+
+	// Querying drops the windows which rolled off, as adding does
+	if filter.Len() != 1 || len(filter.windows) != 1 {
+		t.Errorf("dropped window on query test [1 1] failed: [%d %d]", filter.Len(), len(filter.windows))
+	}
+	filter.Add(clusterHash(3))
+	if filter.Len() != 2 || len(filter.windows) != 2 {
+		t.Errorf("dropped window test [2 2] failed: [%d %d]", filter.Len(), len(filter.windows))
+	}
+}
+
+// Test that a saved filter is loaded back, without its expired windows
+func TestSeenFilterSave(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "seen")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "seen.json")
+
+	clock := newTestClock()
+	opts := SeenOptions{Retention: 2 * time.Hour, Window: time.Hour, Now: clock.Now}
+	filter, err := LoadSeenFilter(path, opts)
+	if err != nil || filter.Len() != 0 {
+		t.Fatalf("missing file test [0] failed: [%v]", err)
+	}
+	filter.Add(clusterHash(1))
+	clock.Advance(time.Hour)
+	filter.Add(clusterHash(2))
+	filter.Add(clusterHash(3))
+	if err := filter.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadSeenFilter(path, opts)
+	if err != nil || loaded.Len() != 3 {
+		t.Fatalf("loaded filter test [3] failed: [%v]", err)
+	}
+	for v := uint64(1); v <= 3; v++ {
+		if seen, _ := loaded.Seen(clusterHash(v), 0); !seen {
+			t.Errorf("loaded hash %d test [true] failed: [false]", v)
+		}
+	}
+	mixed := clusterHash(0)
+	mixed.Algorithm = "ahash:8"
+	if _, err := loaded.Seen(mixed, 0); err == nil {
+		t.Errorf("loaded algorithm test didn't fail")
+	}
+
+	clock.Advance(2 * time.Hour)
+	if loaded, _ = LoadSeenFilter(path, opts); loaded.Len() != 2 {
+		t.Errorf("expired on load test [2] failed: [%d]", loaded.Len())
+	}
+
+	ioutil.WriteFile(path, []byte(`{"version": 2}`), 0644)
+	if _, err := LoadSeenFilter(path, opts); err == nil {
+		t.Errorf("unsupported version test didn't fail")
+	}
+
+	// Windows out of order are sorted, and overlapping ones rejected
+	clock = newTestClock()
+	opts.Now = clock.Now
+	window := func(start, end int, value uint64) seenFileWindow {
+		return seenFileWindow{Start: clock.now.Add(time.Duration(start) * time.Hour),
+			End: clock.now.Add(time.Duration(end) * time.Hour), Hashes: [][]byte{clusterHash(value).Value}}
+	}
+	file := seenFile{Version: seenFileVersion, Algorithm: "dhash-h:8", HashVersion: DhashVersion,
+		Windows: []seenFileWindow{window(1, 2, 2), window(0, 1, 1)}}
+	data, _ := json.Marshal(file)
+	ioutil.WriteFile(path, data, 0644)
+	loaded, err = LoadSeenFilter(path, opts)
+	if err != nil || loaded.Len() != 2 || !loaded.windows[0].start.Equal(clock.now) {
+		t.Errorf("unordered windows test [2] failed: [%v]", err)
+	}
+
+	file.Windows = append(file.Windows, window(0, 2, 3))
+	data, _ = json.Marshal(file)
+	ioutil.WriteFile(path, data, 0644)
+	if _, err := LoadSeenFilter(path, opts); err == nil {
+		t.Errorf("overlapping windows test didn't fail")
+	}
+}
+
+// Test that only one of many goroutines adding the same hash finds it unseen
+func TestSeenFilterConcurrent(t *testing.T) {
+	filter, _ := NewSeenFilter(SeenOptions{})
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	unseen := 0
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			seen, err := filter.SeenOrAdd(clusterHash(0xff00|uint64(i%2)), 1)
+			if err == nil && !seen {
+				mu.Lock()
+				unseen++
+				mu.Unlock()
+			}
+			filter.Seen(clusterHash(0xff00), 1)
+		}(i)
+	}
+	wg.Wait()
+
+	if unseen != 1 || filter.Len() != 1 {
+		t.Errorf("concurrent unseen test [1 1] failed: [%d %d]", unseen, filter.Len())
+	}
+}
+
+// Test that concurrent Saves each replace the file with a whole filter
+func TestSeenFilterConcurrentSave(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "seen")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "seen.json")
+
+	filter, _ := NewSeenFilter(SeenOptions{})
+	for v := uint64(0); v < 100; v++ {
+		filter.Add(clusterHash(v << 8))
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := filter.Save(path); err != nil {
+				t.Errorf("concurrent save failed with error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if loaded, err := LoadSeenFilter(path, SeenOptions{}); err != nil || loaded.Len() != 100 {
+		t.Errorf("concurrent save test [100] failed: [%v]", err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("concurrent save left temporary files: [%d files]", len(files))
+	}
+}
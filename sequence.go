@@ -0,0 +1,137 @@
+/*
+
+Implements a hash of a whole animation, made of the hashes of its
+keyframes, and a similarity between two animations.
+
+The keyframes are found by dropping the frames whose hash is within a
+distance of the last keyframe, and adding their delays to it, so that an
+animation played at twice the frame rate, where every frame is shown twice
+for half the time, has the same keyframes. The similarity aligns the
+keyframes of two sequences with dynamic time warping, which matches every
+keyframe with the closest ones at the same point of the other animation,
+so that frames which were dropped, duplicated or re-timed don't shift the
+comparison of the frames after them.
+
+Usage:
+  frames,err := imagehash.OpenFrames("animation.gif")
+  hashes,err := imagehash.HashFrames(frames, hasher)
+  seq,err := imagehash.NewSequence(hashes, 4)
+  sim,err := imagehash.SequenceSimilarity(seq, other)
+
+*/
+
+package imagehash
+
+import (
+	"errors"
+	"time"
+)
+
+// Sequence is the hash of an animation: the hashes of its keyframes, each
+// with the time it is displayed for, including the frames dropped after it.
+type Sequence struct {
+	Algorithm string
+	Keyframes []FrameHash
+}
+
+// Duration returns the total duration of a sequence.
+func (s Sequence) Duration() time.Duration {
+	var d time.Duration
+	for _, k := range s.Keyframes {
+		d += k.Delay
+	}
+	return d
+}
+
+// NewSequence returns the sequence of the frames, dropping every frame
+// within 'maxDistance' bits of the last keyframe. The hashes must be binary,
+// and computed by the same hasher.
+func NewSequence(frames []FrameHash, maxDistance int) (Sequence, error) {
+	if len(frames) == 0 {
+		return Sequence{}, errors.New("cannot make a sequence of no frames")
+	}
+
+	seq := Sequence{Algorithm: frames[0].Hash.Algorithm}
+	for _, f := range frames {
+		if f.Hash.Kind != BinaryKind {
+			return Sequence{}, errors.New("sequences need binary hashes, not " + f.Hash.Algorithm)
+		}
+		if f.Hash.Algorithm != seq.Algorithm {
+			return Sequence{}, errors.New("cannot make a sequence of " + seq.Algorithm + " and " +
+				f.Hash.Algorithm + " hashes")
+		}
+		if err := CheckVersions(frames[0].Hash, f.Hash); err != nil {
+			return Sequence{}, err
+		}
+
+		if n := len(seq.Keyframes); n > 0 {
+			last := &seq.Keyframes[n-1]
+			if GetBitDistance(last.Hash.Value, f.Hash.Value) <= maxDistance {
+				last.Delay += f.Delay
+				continue
+			}
+		}
+		seq.Keyframes = append(seq.Keyframes, f)
+	}
+	return seq, nil
+}
+
+// SequenceSimilarity returns the similarity of two sequences, between 0 and
+// 1, as the average Similarity of the keyframes matched by dynamic time
+// warping. It takes a time proportional to the product of the numbers of
+// keyframes.
+func SequenceSimilarity(a, b Sequence) (float64, error) {
+	if len(a.Keyframes) == 0 || len(b.Keyframes) == 0 {
+		return 0, errors.New("cannot compare empty sequences")
+	}
+	if a.Algorithm != b.Algorithm {
+		return 0, errors.New("cannot compare a " + a.Algorithm + " sequence with a " + b.Algorithm + " sequence")
+	}
+	if err := checkSequenceVersions(a, b); err != nil {
+		return 0, err
+	}
+
+	// cost[j] and steps[j] are the total dissimilarity and the number of
+	// matched pairs of the best alignment of a[:i+1] with b[:j+1]
+	numBits := algorithmBits(a.Algorithm)
+	m := len(b.Keyframes)
+	cost, prevCost := make([]float64, m), make([]float64, m)
+	steps, prevSteps := make([]int, m), make([]int, m)
+	for i, ka := range a.Keyframes {
+		for j, kb := range b.Keyframes {
+			c := 1 - SimilarityBits(ka.Hash.Value, kb.Hash.Value, numBits)
+
+			// Best of the alignments ending one keyframe before in a, in b,
+			// or in both
+			best, n := 0.0, 0
+			switch {
+			case i == 0 && j == 0:
+			case i == 0:
+				best, n = cost[j-1], steps[j-1]
+			case j == 0:
+				best, n = prevCost[j], prevSteps[j]
+			default:
+				best, n = prevCost[j-1], prevSteps[j-1]
+				if prevCost[j] < best {
+					best, n = prevCost[j], prevSteps[j]
+				}
+				if cost[j-1] < best {
+					best, n = cost[j-1], steps[j-1]
+				}
+			}
+			cost[j], steps[j] = best+c, n+1
+		}
+		cost, prevCost = prevCost, cost
+		steps, prevSteps = prevSteps, steps
+	}
+	return 1 - prevCost[m-1]/float64(prevSteps[m-1]), nil
+}
+
+// checkSequenceVersions checks the versions of the hashes of two sequences,
+// which NewSequence keeps the same within a sequence.
+func checkSequenceVersions(a, b Sequence) error {
+	if len(a.Keyframes) == 0 || len(b.Keyframes) == 0 {
+		return nil
+	}
+	return CheckVersions(a.Keyframes[0].Hash, b.Keyframes[0].Hash)
+}
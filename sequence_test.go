@@ -0,0 +1,160 @@
+/*
+
+Testing suite for the sequence hashes of animations.
+
+1. Test that near-identical consecutive frames are merged into keyframes
+2. Test that an animation at another frame rate is still identical
+3. Test that animations sharing a first frame aren't similar
+4. Test comparing GIFs end to end
+5. Test invalid sequences
+
+*/
+
+package imagehash
+
+import (
+	"bytes"
+	"image"
+	"testing"
+	"time"
+)
+
+// frameHashes hashes images as frames with the same delay.
+func frameHashes(t *testing.T, images []image.Image, delay time.Duration) []FrameHash {
+	hasher, _ := NewHasher("dhash:8")
+	var frames []Frame
+	for _, img := range images {
+		frames = append(frames, Frame{Image: img, Delay: delay})
+	}
+	hashes, err := HashFrames(frames, hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hashes
+}
+
+// Test that duplicated frames add up to a single keyframe
+func TestNewSequence(t *testing.T) {
+	lena, _ := OpenImg("./testdata/lena_512.png")
+	pan := panFrames(lena, 3, 64, 256)
+	images := []image.Image{pan[0], pan[0], pan[1], pan[1], pan[1], pan[2]}
+	hashes := frameHashes(t, images, 100*time.Millisecond)
+
+	seq, err := NewSequence(hashes, 4)
+	if err != nil || len(seq.Keyframes) != 3 || seq.Algorithm != "dhash:8" {
+		t.Fatalf("sequence test [3] failed: [%v %d]", err, len(seq.Keyframes))
+	}
+	for i, exp := range []time.Duration{200, 300, 100} {
+		if seq.Keyframes[i].Delay != exp*time.Millisecond {
+			t.Errorf("keyframe %d delay test [%v] failed: [%v]", i, exp*time.Millisecond, seq.Keyframes[i].Delay)
+		}
+	}
+	if seq.Duration() != 600*time.Millisecond {
+		t.Errorf("sequence duration test [600ms] failed: [%v]", seq.Duration())
+	}
+
+	seq, _ = NewSequence(hashes, 128)
+	if len(seq.Keyframes) != 1 || seq.Keyframes[0].Delay != 600*time.Millisecond {
+		t.Errorf("single keyframe test [1 600ms] failed: [%d]", len(seq.Keyframes))
+	}
+}
+
+// Test that doubling the frame rate doesn't change the sequence
+func TestSequenceFrameRate(t *testing.T) {
+	lena, _ := OpenImg("./testdata/lena_512.png")
+	pan := panFrames(lena, 4, 16, 256)
+	var doubled []image.Image
+	for _, img := range pan {
+		doubled = append(doubled, img, img)
+	}
+
+	seq1, _ := NewSequence(frameHashes(t, pan, 100*time.Millisecond), 4)
+	seq2, _ := NewSequence(frameHashes(t, doubled, 50*time.Millisecond), 4)
+	if sim, err := SequenceSimilarity(seq1, seq2); err != nil || sim != 1 {
+		t.Errorf("frame rate test [1] failed: [%v %f]", err, sim)
+	}
+
+	// Dropping every other frame only matches neighbouring frames together
+	seq3, _ := NewSequence(frameHashes(t, []image.Image{pan[0], pan[2]}, 200*time.Millisecond), 4)
+	if sim, err := SequenceSimilarity(seq1, seq3); err != nil || sim < 0.8 || sim == 1 {
+		t.Errorf("dropped frames test [>0.8 <1] failed: [%v %f]", err, sim)
+	}
+}
+
+// Test that a shared first frame doesn't make animations similar
+func TestSequenceSharedFirstFrame(t *testing.T) {
+	lena, _ := OpenImg("./testdata/lena_512.png")
+	random, _ := OpenImg("./testdata/rand_512.png")
+	pan := panFrames(lena, 4, 48, 256)
+	other := append([]image.Image{pan[0]}, panFrames(random, 3, 48, 256)...)
+
+	seq1, _ := NewSequence(frameHashes(t, pan, 100*time.Millisecond), 4)
+	seq2, _ := NewSequence(frameHashes(t, other, 100*time.Millisecond), 4)
+	if sim, _ := SequenceSimilarity(seq1, seq2); sim > 0.75 {
+		t.Errorf("shared first frame test [<0.75] failed: [%f]", sim)
+	}
+	if sim, _ := SequenceSimilarity(seq1, seq1); sim != 1 {
+		t.Errorf("same sequence test [1] failed: [%f]", sim)
+	}
+}
+
+// Test comparing a GIF with a re-timed copy, and with another GIF
+func TestSequenceGIF(t *testing.T) {
+	lena, _ := OpenImg("./testdata/lena_512.png")
+	random, _ := OpenImg("./testdata/rand_512.png")
+	pan := panFrames(lena, 3, 64, 256)
+	hasher, _ := NewHasher("dhash:8")
+
+	sequence := func(frames []image.Image, delays []int) Sequence {
+		decoded, err := DecodeFrames(bytes.NewReader(encodeGIF(t, frames, delays, nil)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes, _ := HashFrames(decoded, hasher)
+		seq, _ := NewSequence(hashes, 6)
+		return seq
+	}
+
+	original := sequence(pan, []int{10, 10, 10})
+	retimed := sequence([]image.Image{pan[0], pan[0], pan[1], pan[2], pan[2], pan[2]}, []int{5, 5, 20, 5, 5, 5})
+	randomPan := panFrames(random, 2, 64, 256)
+	other := sequence([]image.Image{pan[0], randomPan[0], randomPan[1]}, []int{10, 10, 10})
+
+	if sim, _ := SequenceSimilarity(original, retimed); sim < 0.95 {
+		t.Errorf("retimed gif test [>0.95] failed: [%f]", sim)
+	}
+	if sim, _ := SequenceSimilarity(original, other); sim > 0.8 {
+		t.Errorf("other gif test [<0.8] failed: [%f]", sim)
+	}
+}
+
+// Test that float hashes, mixed hashers and empty sequences are rejected
+func TestSequenceInvalid(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_256.png")
+	colormoment, _ := NewHasher("colormoment")
+	dhash, _ := NewHasher("dhash:8")
+	ahash, _ := NewHasher("ahash:8")
+
+	floats, _ := HashFrames([]Frame{{Image: src}}, colormoment)
+	if _, err := NewSequence(floats, 4); err == nil {
+		t.Errorf("float sequence test didn't fail")
+	}
+
+	h1, _ := HashFrames([]Frame{{Image: src}}, dhash)
+	h2, _ := HashFrames([]Frame{{Image: src}}, ahash)
+	if _, err := NewSequence(append(h1, h2...), 4); err == nil {
+		t.Errorf("mixed sequence test didn't fail")
+	}
+	if _, err := NewSequence(nil, 4); err == nil {
+		t.Errorf("empty sequence test didn't fail")
+	}
+
+	seq1, _ := NewSequence(h1, 4)
+	seq2, _ := NewSequence(h2, 4)
+	if _, err := SequenceSimilarity(seq1, seq2); err == nil {
+		t.Errorf("mixed similarity test didn't fail")
+	}
+	if _, err := SequenceSimilarity(seq1, Sequence{}); err == nil {
+		t.Errorf("empty similarity test didn't fail")
+	}
+}
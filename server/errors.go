@@ -0,0 +1,92 @@
+/*
+
+The structured errors of the service, and how the errors of reading,
+decoding and hashing images map to them.
+
+*/
+
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/devedge/imagehash/internal/service"
+)
+
+// The error codes of the service.
+const (
+	CodeBadRequest        = "bad_request"        // 400: missing or invalid parameter
+	CodeUnknownAlgorithm  = "unknown_algorithm"  // 400: hasher not registered
+	CodeForbidden         = "forbidden"          // 403: local file outside the Root, or no Root
+	CodeNotFound          = "not_found"          // 404: missing local file or index id
+	CodeMethodNotAllowed  = "method_not_allowed" // 405: not a POST request
+	CodeBodyTooLarge      = "body_too_large"     // 413: body or local file over MaxBodyBytes
+	CodeImageTooLarge     = "image_too_large"    // 413: image over MaxPixels
+	CodeUnsupportedFormat = "unsupported_format" // 415: not an image format the server decodes
+	CodeInvalidImage      = "invalid_image"      // 422: corrupt image, or one which can't be hashed
+	CodeInternal          = "internal"           // 500
+	CodeOverloaded        = "overloaded"         // 503: no slot freed up before the timeout
+	CodeTimeout           = "timeout"            // 503: decoding or hashing went over the timeout
+)
+
+// statuses maps the error codes to their HTTP status codes.
+var statuses = map[string]int{
+	CodeBadRequest:        http.StatusBadRequest,
+	CodeUnknownAlgorithm:  http.StatusBadRequest,
+	CodeForbidden:         http.StatusForbidden,
+	CodeNotFound:          http.StatusNotFound,
+	CodeMethodNotAllowed:  http.StatusMethodNotAllowed,
+	CodeBodyTooLarge:      http.StatusRequestEntityTooLarge,
+	CodeImageTooLarge:     http.StatusRequestEntityTooLarge,
+	CodeUnsupportedFormat: http.StatusUnsupportedMediaType,
+	CodeInvalidImage:      http.StatusUnprocessableEntity,
+	CodeInternal:          http.StatusInternalServerError,
+	CodeOverloaded:        http.StatusServiceUnavailable,
+	CodeTimeout:           http.StatusServiceUnavailable,
+}
+
+// Error is an error returned by the service.
+type Error struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// newError returns an error with the status code of its code.
+func newError(code, message string) *Error {
+	return &Error{Status: statuses[code], Code: code, Message: message}
+}
+
+// writeError writes an error response.
+func writeError(w http.ResponseWriter, e *Error) {
+	writeJSON(w, e.Status, struct {
+		Error *Error `json:"error"`
+	}{e})
+}
+
+// bodyError maps an error reading the body of a request.
+func bodyError(err error) *Error {
+	// http.MaxBytesReader's error has no type on older Go versions
+	if strings.Contains(err.Error(), "request body too large") {
+		return newError(CodeBodyTooLarge, err.Error())
+	}
+	return newError(CodeBadRequest, err.Error())
+}
+
+// hashError maps an error decoding or hashing an image.
+func hashError(err error) *Error {
+	switch service.Classify(err) {
+	case service.UnsupportedFormat:
+		return newError(CodeUnsupportedFormat, "unsupported image format")
+	case service.TooLarge:
+		return newError(CodeImageTooLarge, err.Error())
+	case service.Timeout, service.Canceled:
+		return newError(CodeTimeout, "the image couldn't be hashed in time")
+	}
+	return newError(CodeInvalidImage, err.Error())
+}
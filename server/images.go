@@ -0,0 +1,141 @@
+/*
+
+Reads the images of the requests, from their body, from a multipart file,
+or from a local file within the Root of the server, then decodes them.
+
+The whole image is read in memory first, which MaxBodyBytes bounds, and
+its dimensions are checked against MaxPixels before it is decoded, so that
+a small file can't expand into a huge image.
+
+*/
+
+package server
+
+import (
+	"context"
+	"image"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/devedge/imagehash/internal/service"
+)
+
+// maxMemory is the size of the multipart files kept in memory; larger
+// ones are stored in temporary files by net/http.
+const maxMemory = 8 << 20
+
+// image reads and decodes the image of a request, from the multipart file
+// 'fileField', from the local file named by the parameter 'pathField', or
+// when 'fileField' is "image", from the body.
+func (s *Server) image(ctx context.Context, r *http.Request, fileField, pathField string) (image.Image, *Error) {
+	data, herr := s.imageData(r, fileField, pathField)
+	if herr != nil {
+		return nil, herr
+	}
+	return s.decode(ctx, data)
+}
+
+// imageData reads the encoded image of a request.
+func (s *Server) imageData(r *http.Request, fileField, pathField string) ([]byte, *Error) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if path := r.URL.Query().Get(pathField); path != "" {
+			return s.readLocal(path)
+		}
+		if fileField != "image" {
+			return nil, newError(CodeBadRequest, "missing '"+pathField+"' parameter, or multipart file '"+fileField+"'")
+		}
+
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, bodyError(err)
+		}
+		if len(data) == 0 {
+			return nil, newError(CodeBadRequest, "missing image in the body, or 'path' parameter")
+		}
+		return data, nil
+	}
+
+	if r.MultipartForm == nil {
+		if err := r.ParseMultipartForm(maxMemory); err != nil {
+			return nil, bodyError(err)
+		}
+	}
+	if path := r.FormValue(pathField); path != "" {
+		return s.readLocal(path)
+	}
+	file, _, err := r.FormFile(fileField)
+	if err != nil {
+		return nil, newError(CodeBadRequest, "missing multipart file '"+fileField+"', or '"+pathField+"' parameter")
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, bodyError(err)
+	}
+	return data, nil
+}
+
+// readLocal reads a local file, given as a path or a file:// URL relative
+// to the Root of the server. The symbolic links of the path are resolved
+// before checking that it is within the Root, so that a link can't point
+// outside of it.
+func (s *Server) readLocal(path string) ([]byte, *Error) {
+	if s.cfg.Root == "" {
+		return nil, newError(CodeForbidden, "local files are disabled")
+	}
+
+	path = strings.TrimPrefix(path, "file://")
+	full := filepath.Join(s.cfg.Root, filepath.FromSlash(path))
+	if !within(s.cfg.Root, full) {
+		return nil, newError(CodeForbidden, "path outside of the root folder: "+path)
+	}
+	full, err := filepath.EvalSymlinks(full)
+	if os.IsNotExist(err) {
+		return nil, newError(CodeNotFound, "no such file: "+path)
+	}
+	root, rerr := filepath.EvalSymlinks(s.cfg.Root)
+	if err != nil || rerr != nil {
+		return nil, newError(CodeBadRequest, "not a readable file: "+path)
+	}
+	if !within(root, full) {
+		return nil, newError(CodeForbidden, "path outside of the root folder: "+path)
+	}
+
+	info, err := os.Stat(full)
+	if os.IsNotExist(err) {
+		return nil, newError(CodeNotFound, "no such file: "+path)
+	}
+	if err != nil || info.IsDir() {
+		return nil, newError(CodeBadRequest, "not a readable file: "+path)
+	}
+	if info.Size() > s.cfg.MaxBodyBytes {
+		return nil, newError(CodeBodyTooLarge, "file larger than "+strconv.FormatInt(s.cfg.MaxBodyBytes, 10)+
+			" bytes: "+path)
+	}
+
+	data, err := ioutil.ReadFile(full)
+	if err != nil {
+		return nil, newError(CodeBadRequest, "not a readable file: "+path)
+	}
+	return data, nil
+}
+
+// within reports whether 'path' is 'root' or within it, lexically.
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// decode decodes an image, after checking its format and dimensions.
+func (s *Server) decode(ctx context.Context, data []byte) (image.Image, *Error) {
+	img, err := service.Decode(ctx, data, s.cfg.MaxPixels)
+	if err != nil {
+		return nil, hashError(err)
+	}
+	return img, nil
+}
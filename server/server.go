@@ -0,0 +1,352 @@
+/*
+
+Package server is an HTTP service computing and comparing image hashes, for
+programs which can't link the imagehash package. It is embeddable as an
+http.Handler, and served by the imagehash-server command.
+
+Images are sent either as the body of the request, as a multipart file, or
+as the path of a local file in the 'path' parameter. Local files are only
+allowed when the server has a Root, and are resolved within it.
+
+Endpoints (every response is JSON):
+  POST /hash?algorithms=dhash:8,ahash:16
+    The hashes of an image, as hex for binary hashes, or floats, with at
+    most MaxAlgorithms algorithms.
+  POST /compare?algorithm=dhash:8
+    The distance and similarity of two images, sent as the multipart files
+    'image1' and 'image2', or the parameters 'path1' and 'path2'.
+  POST /index/add?id=photo-1
+    Adds the hash of an image to the in-memory index.
+  POST /index/search?max_distance=10
+    The ids of the indexed images within a distance of an image.
+  POST /index/remove?id=photo-1
+    Removes an id from the index.
+
+Errors are returned as {"error": {"code": "...", "message": "..."}}, with a
+status code matching the code (see Error).
+
+Usage:
+  srv,err := server.New(server.Config{Root: "/photos"})
+  http.ListenAndServe(":8080", srv)
+
+*/
+
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devedge/imagehash"
+	"github.com/devedge/imagehash/internal/service"
+)
+
+// Config configures a Server. Zero values are replaced by defaults.
+type Config struct {
+	MaxBodyBytes  int64         // Largest request body or local file; 32 MiB if zero
+	MaxPixels     int           // Largest width times height of an image; 50 million if zero
+	MaxConcurrent int           // Requests decoding and hashing at once; the number of CPUs if zero
+	Timeout       time.Duration // Longest time spent waiting for a slot and hashing; 30s if zero
+	SlotTimeout   time.Duration // Longest time spent waiting for a slot, within Timeout; Timeout if zero
+	Root          string        // Folder of the local files; local files are refused if empty
+	Algorithms    []string      // Hashers used when a request names none; "dhash:8" if empty
+	MaxAlgorithms int           // Largest number of hashers a request names; 8 if zero
+	IndexHasher   string        // Hasher of the in-memory index; "dhash:8" if empty
+}
+
+// Server is the http.Handler of the service.
+type Server struct {
+	cfg     Config
+	mux     *http.ServeMux
+	slots   chan struct{}
+	index   *imagehash.Index
+	indexer imagehash.Hasher
+}
+
+// New returns a Server, after checking that its hashers exist.
+func New(cfg Config) (*Server, error) {
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = 32 << 20
+	}
+	if cfg.MaxPixels <= 0 {
+		cfg.MaxPixels = 50000000
+	}
+	if cfg.MaxConcurrent <= 0 {
+		cfg.MaxConcurrent = runtime.NumCPU()
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if len(cfg.Algorithms) == 0 {
+		cfg.Algorithms = []string{"dhash:8"}
+	}
+	if cfg.MaxAlgorithms <= 0 {
+		cfg.MaxAlgorithms = 8
+	}
+	if cfg.IndexHasher == "" {
+		cfg.IndexHasher = "dhash:8"
+	}
+
+	indexer, err := service.CheckHashers(cfg.Algorithms, cfg.IndexHasher)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		cfg:     cfg,
+		mux:     http.NewServeMux(),
+		slots:   make(chan struct{}, cfg.MaxConcurrent),
+		index:   imagehash.NewIndex(),
+		indexer: indexer,
+	}
+	s.mux.HandleFunc("/hash", s.post(s.handleHash))
+	s.mux.HandleFunc("/compare", s.post(s.handleCompare))
+	s.mux.HandleFunc("/index/add", s.post(s.handleIndexAdd))
+	s.mux.HandleFunc("/index/search", s.post(s.handleIndexSearch))
+	s.mux.HandleFunc("/index/remove", s.post(s.handleIndexRemove))
+	return s, nil
+}
+
+// Index returns the in-memory index of the server, so that it can be
+// filled or inspected by the program embedding it.
+func (s *Server) Index() *imagehash.Index {
+	return s.index
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// handler is an endpoint returning either a response or an error.
+type handler func(ctx context.Context, r *http.Request) (interface{}, *Error)
+
+// post wraps a handler: it only accepts POST requests, limits the size of
+// the body, waits for a slot, and writes the response as JSON.
+func (s *Server) post(h handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, newError(CodeMethodNotAllowed, "only POST is allowed"))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes)
+
+		ctx, cancel := context.WithTimeout(r.Context(), s.cfg.Timeout)
+		defer cancel()
+
+		// Wait for a slot, so that at most MaxConcurrent images are in memory
+		wait := s.cfg.Timeout
+		if s.cfg.SlotTimeout > 0 && s.cfg.SlotTimeout < wait {
+			wait = s.cfg.SlotTimeout
+		}
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case s.slots <- struct{}{}:
+			defer func() { <-s.slots }()
+		case <-timer.C:
+			writeError(w, newError(CodeOverloaded, "too many concurrent requests"))
+			return
+		case <-ctx.Done():
+			writeError(w, newError(CodeOverloaded, "too many concurrent requests"))
+			return
+		}
+
+		res, herr := h(ctx, r)
+		if herr != nil {
+			writeError(w, herr)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+// hashJSON is a hash in a response.
+type hashJSON struct {
+	Algorithm string    `json:"algorithm"`
+	Hex       string    `json:"hex,omitempty"`    // Binary hashes
+	Vector    []float64 `json:"vector,omitempty"` // Float hashes
+	Pipeline  string    `json:"pipeline,omitempty"`
+	Version   int       `json:"version"` // Version of the algorithm; 0 if unknown
+}
+
+// toJSON converts a hash for a response.
+func toJSON(h imagehash.Hash) hashJSON {
+	if h.Kind == imagehash.FloatKind {
+		return hashJSON{Algorithm: h.Algorithm, Vector: h.Vector, Pipeline: h.Pipeline,
+			Version: h.Version}
+	}
+	return hashJSON{Algorithm: h.Algorithm, Hex: hex.EncodeToString(h.Value), Pipeline: h.Pipeline,
+		Version: h.Version}
+}
+
+// handleHash returns the hashes of an image.
+func (s *Server) handleHash(ctx context.Context, r *http.Request) (interface{}, *Error) {
+	names := s.cfg.Algorithms
+	if param := r.URL.Query().Get("algorithms"); param != "" {
+		// The image is hashed once per name, within a single slot
+		if names = strings.SplitN(param, ",", s.cfg.MaxAlgorithms+1); len(names) > s.cfg.MaxAlgorithms {
+			return nil, newError(CodeBadRequest, "at most "+strconv.Itoa(s.cfg.MaxAlgorithms)+
+				" algorithms can be named")
+		}
+	}
+	var hashers []imagehash.Hasher
+	for _, name := range names {
+		hasher, err := imagehash.NewHasher(strings.TrimSpace(name))
+		if err != nil {
+			return nil, newError(CodeUnknownAlgorithm, err.Error())
+		}
+		hashers = append(hashers, hasher)
+	}
+
+	img, herr := s.image(ctx, r, "image", "path")
+	if herr != nil {
+		return nil, herr
+	}
+
+	var res struct {
+		Hashes []hashJSON `json:"hashes"`
+	}
+	for _, hasher := range hashers {
+		h, err := imagehash.HashContext(ctx, hasher, img)
+		if err != nil {
+			return nil, hashError(err)
+		}
+		res.Hashes = append(res.Hashes, toJSON(h))
+	}
+	return res, nil
+}
+
+// handleCompare returns the distance between two images.
+func (s *Server) handleCompare(ctx context.Context, r *http.Request) (interface{}, *Error) {
+	hasher, herr := s.hasher(r.URL.Query().Get("algorithm"))
+	if herr != nil {
+		return nil, herr
+	}
+
+	var hashes [2]imagehash.Hash
+	for i := range hashes {
+		n := strconv.Itoa(i + 1)
+		img, herr := s.image(ctx, r, "image"+n, "path"+n)
+		if herr != nil {
+			return nil, herr
+		}
+		var err error
+		if hashes[i], err = imagehash.HashContext(ctx, hasher, img); err != nil {
+			return nil, hashError(err)
+		}
+	}
+
+	dist, _ := hashes[0].Distance(hashes[1])
+	res := struct {
+		Algorithm  string   `json:"algorithm"`
+		Distance   float64  `json:"distance"`
+		Similarity *float64 `json:"similarity,omitempty"` // Binary hashes only
+	}{Algorithm: hasher.Name(), Distance: dist}
+	if hashes[0].Kind == imagehash.BinaryKind {
+		sim := imagehash.SimilarityBits(hashes[0].Value, hashes[1].Value, hasher.Bits())
+		res.Similarity = &sim
+	}
+	return res, nil
+}
+
+// handleIndexAdd adds the hash of an image to the index.
+func (s *Server) handleIndexAdd(ctx context.Context, r *http.Request) (interface{}, *Error) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		return nil, newError(CodeBadRequest, "missing 'id' parameter")
+	}
+
+	h, herr := s.indexHash(ctx, r)
+	if herr != nil {
+		return nil, herr
+	}
+	if err := s.index.AddHash(id, h); err != nil {
+		return nil, newError(CodeInternal, err.Error())
+	}
+
+	return struct {
+		ID   string   `json:"id"`
+		Hash hashJSON `json:"hash"`
+		Size int      `json:"size"` // Number of indexed images
+	}{id, toJSON(h), s.index.Len()}, nil
+}
+
+// handleIndexSearch returns the indexed images close to an image.
+func (s *Server) handleIndexSearch(ctx context.Context, r *http.Request) (interface{}, *Error) {
+	maxDistance := s.indexer.Bits() / 8
+	if param := r.URL.Query().Get("max_distance"); param != "" {
+		var err error
+		if maxDistance, err = strconv.Atoi(param); err != nil || maxDistance < 0 {
+			return nil, newError(CodeBadRequest, "invalid 'max_distance' parameter: "+param)
+		}
+	}
+
+	h, herr := s.indexHash(ctx, r)
+	if herr != nil {
+		return nil, herr
+	}
+	matches, err := s.index.SearchHash(h, maxDistance)
+	if err != nil {
+		return nil, newError(CodeInternal, err.Error())
+	}
+	if matches == nil {
+		matches = []imagehash.Match{}
+	}
+
+	return struct {
+		Matches []imagehash.Match `json:"matches"`
+	}{matches}, nil
+}
+
+// handleIndexRemove removes an image from the index.
+func (s *Server) handleIndexRemove(ctx context.Context, r *http.Request) (interface{}, *Error) {
+	id := r.URL.Query().Get("id")
+	if !s.index.Remove(id) {
+		return nil, newError(CodeNotFound, "id not in the index: "+id)
+	}
+	return struct {
+		ID   string `json:"id"`
+		Size int    `json:"size"`
+	}{id, s.index.Len()}, nil
+}
+
+// hasher returns the hasher named by a parameter, or the first default one.
+func (s *Server) hasher(name string) (imagehash.Hasher, *Error) {
+	if name == "" {
+		name = s.cfg.Algorithms[0]
+	}
+	hasher, err := imagehash.NewHasher(name)
+	if err != nil {
+		return nil, newError(CodeUnknownAlgorithm, err.Error())
+	}
+	return hasher, nil
+}
+
+// indexHash returns the hash of the image of a request, computed by the
+// hasher of the index.
+func (s *Server) indexHash(ctx context.Context, r *http.Request) (imagehash.Hash, *Error) {
+	img, herr := s.image(ctx, r, "image", "path")
+	if herr != nil {
+		return imagehash.Hash{}, herr
+	}
+	h, err := imagehash.HashContext(ctx, s.indexer, img)
+	if err != nil {
+		return imagehash.Hash{}, hashError(err)
+	}
+	return h, nil
+}
+
+// writeJSON writes a JSON response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
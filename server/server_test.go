@@ -0,0 +1,327 @@
+/*
+
+Testing suite for the HTTP service.
+
+1. Test hashing an image sent as the body, a multipart file and a path
+2. Test comparing two images
+3. Test adding to, searching and removing from the index
+4. Test that the errors have the right codes and statuses
+5. Test that local files stay within the root folder, through symbolic
+   links too
+6. Test the size limits of bodies and images
+7. Test that requests wait for a slot, and time out
+8. Test invalid configurations
+
+*/
+
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devedge/imagehash"
+)
+
+// newTestServer returns a server with the testdata folder as its root.
+func newTestServer(t *testing.T, cfg Config) *Server {
+	if cfg.Root == "" {
+		cfg.Root = "../testdata"
+	}
+	srv, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+// do sends a request to a server, and decodes its JSON response.
+func do(srv http.Handler, method, url, contentType string, body []byte, res interface{}) int {
+	req := httptest.NewRequest(method, url, bytes.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	json.Unmarshal(rec.Body.Bytes(), res)
+	return rec.Code
+}
+
+// multipartBody encodes files as a multipart form.
+func multipartBody(files map[string][]byte) ([]byte, string) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for field, data := range files {
+		fw, _ := mw.CreateFormFile(field, field+".png")
+		fw.Write(data)
+	}
+	mw.Close()
+	return buf.Bytes(), mw.FormDataContentType()
+}
+
+// testImage reads a test image.
+func testImage(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile("../testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// errorResponse is the body of an error response.
+type errorResponse struct {
+	Error Error `json:"error"`
+}
+
+// hashResponse is the body of a /hash response.
+type hashResponse struct {
+	Hashes []hashJSON `json:"hashes"`
+}
+
+// Test that the three ways of sending an image give the same hashes
+func TestHash(t *testing.T) {
+	srv := newTestServer(t, Config{})
+	data := testImage(t, "lena_256.png")
+	img, _ := imagehash.OpenImg("../testdata/lena_256.png")
+	dhash, _ := imagehash.Dhash(img, 8)
+	ahash, _ := imagehash.Ahash(img, 16)
+
+	body, ct := multipartBody(map[string][]byte{"image": data})
+	requests := map[string]func(res *hashResponse) int{
+		"body": func(res *hashResponse) int {
+			return do(srv, "POST", "/hash?algorithms=dhash:8,ahash:16", "image/png", data, res)
+		},
+		"multipart": func(res *hashResponse) int {
+			return do(srv, "POST", "/hash?algorithms=dhash:8,ahash:16", ct, body, res)
+		},
+		"path": func(res *hashResponse) int {
+			return do(srv, "POST", "/hash?algorithms=dhash:8,ahash:16&path=lena_256.png", "", nil, res)
+		},
+	}
+
+	for name, request := range requests {
+		var res hashResponse
+		if status := request(&res); status != http.StatusOK || len(res.Hashes) != 2 {
+			t.Errorf("%s hash test [200 2] failed: [%d %d]", name, status, len(res.Hashes))
+			continue
+		}
+		if res.Hashes[0].Hex != hex.EncodeToString(dhash) || res.Hashes[1].Hex != hex.EncodeToString(ahash) {
+			t.Errorf("%s hash test [%x %x] failed: [%s %s]", name, dhash, ahash, res.Hashes[0].Hex, res.Hashes[1].Hex)
+		}
+	}
+
+	var res hashResponse
+	do(srv, "POST", "/hash?algorithms=colormoment&path=lena_256.png", "", nil, &res)
+	if len(res.Hashes) != 1 || len(res.Hashes[0].Vector) != imagehash.ColorMomentLen {
+		t.Errorf("float hash test failed: [%v]", res.Hashes)
+	}
+
+	res = hashResponse{}
+	do(srv, "POST", "/hash?algorithms=equalize%7Cdhash:8&path=lena_256.png", "", nil, &res)
+	if len(res.Hashes) != 1 || res.Hashes[0].Algorithm != "dhash:8" || res.Hashes[0].Pipeline != "equalize" ||
+		res.Hashes[0].Version != imagehash.DhashVersion {
+		t.Errorf("pipeline hash test [dhash:8 equalize %d] failed: [%v]", imagehash.DhashVersion, res.Hashes)
+	}
+}
+
+// Test comparing the same and different images
+func TestCompare(t *testing.T) {
+	srv := newTestServer(t, Config{})
+
+	var res struct {
+		Distance   float64  `json:"distance"`
+		Similarity *float64 `json:"similarity"`
+	}
+	status := do(srv, "POST", "/compare?path1=lena_256.png&path2=lena_512.png", "", nil, &res)
+	if status != http.StatusOK || res.Similarity == nil || *res.Similarity < 0.9 {
+		t.Errorf("compare similar test failed: [%d %v]", status, res)
+	}
+
+	body, ct := multipartBody(map[string][]byte{
+		"image1": testImage(t, "lena_512.png"),
+		"image2": testImage(t, "rand_512.png"),
+	})
+	status = do(srv, "POST", "/compare?algorithm=dhash:16", ct, body, &res)
+	if status != http.StatusOK || *res.Similarity > 0.7 {
+		t.Errorf("compare different test failed: [%d %v]", status, *res.Similarity)
+	}
+}
+
+// Test the index endpoints
+func TestIndex(t *testing.T) {
+	srv := newTestServer(t, Config{})
+
+	for _, name := range []string{"lena_512", "lena_inverted_512", "rand_512"} {
+		var res struct {
+			ID   string `json:"id"`
+			Size int    `json:"size"`
+		}
+		status := do(srv, "POST", "/index/add?id="+name+"&path="+name+".png", "", nil, &res)
+		if status != http.StatusOK || res.ID != name {
+			t.Errorf("index add test [200 %s] failed: [%d %s]", name, status, res.ID)
+		}
+	}
+	if srv.Index().Len() != 3 {
+		t.Errorf("index add test [3] failed: [%d]", srv.Index().Len())
+	}
+
+	var res struct {
+		Matches []imagehash.Match `json:"matches"`
+	}
+	status := do(srv, "POST", "/index/search?max_distance=10", "image/png", testImage(t, "lena_256.png"), &res)
+	if status != http.StatusOK || len(res.Matches) != 1 || res.Matches[0].ID != "lena_512" ||
+		res.Matches[0].Version != imagehash.DhashVersion {
+		t.Errorf("index search test [lena_512 %d] failed: [%d %v]", imagehash.DhashVersion, status, res.Matches)
+	}
+
+	var errRes errorResponse
+	if status := do(srv, "POST", "/index/remove?id=lena_512", "", nil, &errRes); status != http.StatusOK {
+		t.Errorf("index remove test [200] failed: [%d]", status)
+	}
+	if status := do(srv, "POST", "/index/remove?id=lena_512", "", nil, &errRes); errRes.Error.Code != CodeNotFound {
+		t.Errorf("index remove missing test [%s] failed: [%d %s]", CodeNotFound, status, errRes.Error.Code)
+	}
+}
+
+// Test the codes and statuses of the errors
+func TestErrors(t *testing.T) {
+	srv := newTestServer(t, Config{})
+
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4)))
+	truncated := buf.Bytes()[:buf.Len()/2]
+
+	tests := []struct {
+		method, url, contentType string
+		body                     []byte
+		status                   int
+		code                     string
+	}{
+		{"GET", "/hash", "", nil, http.StatusMethodNotAllowed, CodeMethodNotAllowed},
+		{"POST", "/hash", "", nil, http.StatusBadRequest, CodeBadRequest},
+		{"POST", "/hash?algorithms=phash", "", []byte("x"), http.StatusBadRequest, CodeUnknownAlgorithm},
+		{"POST", "/hash?algorithms=" + strings.Repeat("dhash:8,", 8) + "dhash:8&path=lena_256.png", "", nil,
+			http.StatusBadRequest, CodeBadRequest},
+		{"POST", "/hash", "text/plain", []byte("not an image"), http.StatusUnsupportedMediaType, CodeUnsupportedFormat},
+		{"POST", "/hash", "image/png", truncated, http.StatusUnprocessableEntity, CodeInvalidImage},
+		{"POST", "/hash?path=missing.png", "", nil, http.StatusNotFound, CodeNotFound},
+		{"POST", "/compare?path1=lena_256.png", "", nil, http.StatusBadRequest, CodeBadRequest},
+		{"POST", "/index/add?path=lena_256.png", "", nil, http.StatusBadRequest, CodeBadRequest},
+		{"POST", "/index/search?max_distance=-1&path=lena_256.png", "", nil, http.StatusBadRequest, CodeBadRequest},
+	}
+	for _, test := range tests {
+		var res errorResponse
+		status := do(srv, test.method, test.url, test.contentType, test.body, &res)
+		if status != test.status || res.Error.Code != test.code {
+			t.Errorf("%s %s error test [%d %s] failed: [%d %s]", test.method, test.url,
+				test.status, test.code, status, res.Error.Code)
+		}
+	}
+}
+
+// Test that paths and symbolic links can't escape the root, and that local
+// files can be disabled
+func TestLocalFiles(t *testing.T) {
+	srv := newTestServer(t, Config{})
+	for _, path := range []string{"../dhash.go", "/../../etc/passwd", "file://../README.md"} {
+		var res errorResponse
+		if status := do(srv, "POST", "/hash?path="+path, "", nil, &res); status != http.StatusForbidden {
+			t.Errorf("path %s test [403] failed: [%d %s]", path, status, res.Error.Code)
+		}
+	}
+
+	var res hashResponse
+	if status := do(srv, "POST", "/hash?path=file:///lena_256.png", "", nil, &res); status != http.StatusOK {
+		t.Errorf("file URL test [200] failed: [%d]", status)
+	}
+
+	srv, _ = New(Config{})
+	var errRes errorResponse
+	if status := do(srv, "POST", "/hash?path=lena_256.png", "", nil, &errRes); status != http.StatusForbidden {
+		t.Errorf("disabled local files test [403] failed: [%d]", status)
+	}
+
+	// Symbolic links are followed within the root only
+	dir, _ := ioutil.TempDir("", "server")
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+	os.Mkdir(root, 0755)
+	ioutil.WriteFile(filepath.Join(dir, "outside.png"), testImage(t, "lena_256.png"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "inside.png"), testImage(t, "lena_256.png"), 0644)
+	if err := os.Symlink(filepath.Join(dir, "outside.png"), filepath.Join(root, "escape.png")); err != nil {
+		t.Skip("symbolic links are unsupported: ", err)
+	}
+	os.Symlink("inside.png", filepath.Join(root, "link.png"))
+
+	srv = newTestServer(t, Config{Root: root})
+	if status := do(srv, "POST", "/hash?path=escape.png", "", nil, &errRes); status != http.StatusForbidden {
+		t.Errorf("symbolic link escape test [403] failed: [%d %s]", status, errRes.Error.Code)
+	}
+	if status := do(srv, "POST", "/hash?path=link.png", "", nil, &res); status != http.StatusOK {
+		t.Errorf("symbolic link within root test [200] failed: [%d]", status)
+	}
+}
+
+// Test the body and pixel limits
+func TestLimits(t *testing.T) {
+	data := testImage(t, "lena_512.png")
+
+	srv := newTestServer(t, Config{MaxBodyBytes: 1000})
+	var res errorResponse
+	if do(srv, "POST", "/hash", "image/png", data, &res); res.Error.Code != CodeBodyTooLarge {
+		t.Errorf("body limit test [%s] failed: [%s]", CodeBodyTooLarge, res.Error.Code)
+	}
+	body, ct := multipartBody(map[string][]byte{"image": data})
+	if do(srv, "POST", "/hash", ct, body, &res); res.Error.Code != CodeBodyTooLarge {
+		t.Errorf("multipart limit test [%s] failed: [%s]", CodeBodyTooLarge, res.Error.Code)
+	}
+	if do(srv, "POST", "/hash?path=lena_512.png", "", nil, &res); res.Error.Code != CodeBodyTooLarge {
+		t.Errorf("file limit test [%s] failed: [%s]", CodeBodyTooLarge, res.Error.Code)
+	}
+
+	srv = newTestServer(t, Config{MaxPixels: 256 * 256})
+	status := do(srv, "POST", "/hash", "image/png", data, &res)
+	if status != http.StatusRequestEntityTooLarge || res.Error.Code != CodeImageTooLarge {
+		t.Errorf("pixel limit test [413 %s] failed: [%d %s]", CodeImageTooLarge, status, res.Error.Code)
+	}
+}
+
+// Test that a request waiting too long for a slot is rejected
+func TestConcurrencyLimit(t *testing.T) {
+	// A short wait for the slot, and the default timeout for hashing
+	srv := newTestServer(t, Config{MaxConcurrent: 1, SlotTimeout: 50 * time.Millisecond})
+	srv.slots <- struct{}{} // Take the only slot
+
+	var res errorResponse
+	status := do(srv, "POST", "/hash?path=lena_256.png", "", nil, &res)
+	if status != http.StatusServiceUnavailable || res.Error.Code != CodeOverloaded {
+		t.Errorf("concurrency limit test [503 %s] failed: [%d %s]", CodeOverloaded, status, res.Error.Code)
+	}
+
+	<-srv.slots
+	if status := do(srv, "POST", "/hash?path=lena_256.png", "", nil, &res); status != http.StatusOK {
+		t.Errorf("freed slot test [200] failed: [%d]", status)
+	}
+}
+
+// Test that unknown hashers, and float index hashers, are rejected
+func TestInvalidConfig(t *testing.T) {
+	if _, err := New(Config{Algorithms: []string{"phash"}}); err == nil {
+		t.Errorf("unknown default hasher didn't fail")
+	}
+	if _, err := New(Config{IndexHasher: "colormoment"}); err == nil {
+		t.Errorf("float index hasher didn't fail")
+	}
+}
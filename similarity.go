@@ -0,0 +1,141 @@
+/*
+
+Implements a normalized similarity score between two hashes, and
+calibrated thresholds to decide whether two hashes are of the same image.
+
+The raw distances returned by GetDistance depend on the algorithm and on
+'hashLen', so a threshold which works for an 8 length Ahash is meaningless
+for a 16 length Dhash. Similarity divides the number of differing bits by
+the length of the hashes, and the presets give, for every algorithm and
+size, the similarity above which two images are considered duplicates.
+
+A hash whose number of bits isn't a multiple of 8, such as the 25 bits of
+a dhash-h with a 'hashLen' of 5, is padded to whole bytes with bits which
+are always the same. SimilarityBits divides by the number of bits of the
+hashes instead, such as the Bits of their Hasher, so that the padding
+doesn't count as matching bits.
+
+The presets were derived by hashing the images in testdata/ after applying
+mild transforms to them (resizing, blurring, gamma and contrast changes,
+JPEG compression, cropping, rotating, noise and watermarks), and comparing
+the hashes against those of the originals and of the other images. The
+experiment can be re-run with:
+  go test -run TestCalibratePresets -calibrate
+
+Usage:
+  preset,err := imagehash.GetPreset("dhash", 8, imagehash.PresetDefault)
+  dup := imagehash.IsDuplicate(hash1, hash2, preset)
+
+*/
+
+package imagehash
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Similarity returns the fraction of bits which are the same in two hashes,
+// between 0 for opposite hashes and 1 for identical ones. Hashes of
+// different lengths are compared as with GetBitDistance. Padding bits are
+// counted, and are always the same: see SimilarityBits.
+func Similarity(hash1, hash2 []byte) float64 {
+	return SimilarityBits(hash1, hash2, 0)
+}
+
+// SimilarityBits is the same as Similarity, for hashes of 'numBits' bits
+// padded to whole bytes, such as the ones of a Hasher with as many Bits.
+// The padding isn't counted. A 'numBits' of zero counts every bit of the
+// longest hash, as Similarity does.
+func SimilarityBits(hash1, hash2 []byte, numBits int) float64 {
+	if numBits <= 0 {
+		numBits = 8 * len(hash1)
+		if len(hash2) > len(hash1) {
+			numBits = 8 * len(hash2)
+		}
+	}
+	if numBits == 0 {
+		return 1
+	}
+
+	sim := 1 - float64(GetBitDistance(hash1, hash2))/float64(numBits)
+	if sim < 0 {
+		return 0 // Hashes longer than 'numBits'
+	}
+	return sim
+}
+
+// algorithmBits returns the number of bits of the hashes of a registered
+// hasher, picked by name, or 0 if there is no such hasher.
+func algorithmBits(name string) int {
+	hasher, err := NewHasher(name)
+	if err != nil {
+		return 0
+	}
+	return hasher.Bits()
+}
+
+// PresetLevel selects how close two hashes must be to be duplicates.
+type PresetLevel int
+
+// The preset levels. Strict only matches near-identical copies, Loose
+// matches as many transforms of the calibration experiment as it can while
+// rejecting every different image, and Default is halfway between the two.
+const (
+	PresetStrict PresetLevel = iota
+	PresetDefault
+	PresetLoose
+)
+
+// Preset is a similarity threshold for hashes computed with a given
+// algorithm and 'hashLen'.
+type Preset struct {
+	Algorithm string      // "dhash", "dhash-h", "dhash-v" or "ahash"
+	HashLen   int         // The 'hashLen' the hashes were computed with
+	Level     PresetLevel // The strictness of the threshold
+	Threshold float64     // The minimum Similarity of duplicates
+}
+
+// presetKey identifies the thresholds of an algorithm and size.
+type presetKey struct {
+	algorithm string
+	hashLen   int
+}
+
+// presets holds the strict, default and loose thresholds of every
+// calibrated algorithm and size.
+var presets = map[presetKey][3]float64{
+	{"dhash", 8}:    {0.99, 0.85, 0.71},
+	{"dhash", 16}:   {0.98, 0.83, 0.67},
+	{"dhash-h", 8}:  {0.98, 0.85, 0.71},
+	{"dhash-h", 16}: {0.99, 0.83, 0.67},
+	{"dhash-v", 8}:  {0.98, 0.84, 0.70},
+	{"dhash-v", 16}: {0.97, 0.82, 0.67},
+	{"ahash", 8}:    {0.98, 0.84, 0.69},
+	{"ahash", 16}:   {0.99, 0.83, 0.67},
+}
+
+// GetPreset returns the calibrated threshold of an algorithm and size, at
+// the given level.
+func GetPreset(algorithm string, hashLen int, level PresetLevel) (Preset, error) {
+	thresholds, ok := presets[presetKey{algorithm, hashLen}]
+	if !ok {
+		return Preset{}, errors.New("no preset for " + algorithm + " with a 'hashLen' of " + strconv.Itoa(hashLen))
+	}
+	if level < PresetStrict || level > PresetLoose {
+		return Preset{}, errors.New("invalid preset level: " + strconv.Itoa(int(level)))
+	}
+
+	return Preset{
+		Algorithm: algorithm,
+		HashLen:   hashLen,
+		Level:     level,
+		Threshold: thresholds[level],
+	}, nil
+}
+
+// IsDuplicate reports whether two hashes are similar enough to be of the
+// same image, according to a preset.
+func IsDuplicate(hash1, hash2 []byte, preset Preset) bool {
+	return Similarity(hash1, hash2) >= preset.Threshold
+}
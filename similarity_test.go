@@ -0,0 +1,263 @@
+/*
+
+Testing suite for the similarity score and the duplicate presets.
+
+1. Test the similarity of identical, opposite and partly different hashes
+2. Test the similarity of hashes of different lengths
+3. Test that the padding of hashes isn't counted with their number of bits
+4. Test getting a missing preset
+5. Test that the presets are ordered from strict to loose
+6. Test that the presets still separate the calibration images
+7. Re-run the calibration experiment, when the -calibrate flag is set
+
+*/
+
+package imagehash
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+var calibrate = flag.Bool("calibrate", false, "re-run the preset calibration experiment")
+
+// Test the similarity of identical, opposite and partly different hashes
+func TestSimilarity(t *testing.T) {
+	hash := []byte{0x0f, 0xf0}
+
+	if sim := Similarity(hash, hash); sim != 1 {
+		t.Errorf("identical similarity test [1] failed: [%f]", sim)
+	}
+	if sim := Similarity(hash, []byte{0xf0, 0x0f}); sim != 0 {
+		t.Errorf("opposite similarity test [0] failed: [%f]", sim)
+	}
+	if sim := Similarity(hash, []byte{0x0f, 0xff}); sim != 0.75 {
+		t.Errorf("partial similarity test [0.75] failed: [%f]", sim)
+	}
+}
+
+// Test that missing bytes count as different bits
+func TestSimilarityLengths(t *testing.T) {
+	if sim := Similarity([]byte{0xff}, []byte{0xff, 0x00}); sim != 0.5 {
+		t.Errorf("different lengths similarity test [0.5] failed: [%f]", sim)
+	}
+	if sim := Similarity(nil, nil); sim != 1 {
+		t.Errorf("empty similarity test [1] failed: [%f]", sim)
+	}
+}
+
+// Test that the padding bits of a 25 bit hash don't count as matching
+func TestSimilarityBits(t *testing.T) {
+	hasher, _ := NewHasher("dhash-h:5")
+	if hasher.Bits() != 25 {
+		t.Errorf("dhash-h:5 bits test [25] failed: [%d]", hasher.Bits())
+	}
+	a := []byte{0xff, 0xff, 0xff, 0x80}
+	b := []byte{0x00, 0x00, 0x00, 0x00}
+	if sim := SimilarityBits(a, b, hasher.Bits()); sim != 0 {
+		t.Errorf("opposite 25 bit similarity test [0] failed: [%f]", sim)
+	}
+	if sim := Similarity(a, b); sim != 7.0/32 {
+		t.Errorf("opposite padded similarity test [%f] failed: [%f]", 7.0/32, sim)
+	}
+	if sim := SimilarityBits(a, []byte{0xff, 0xff, 0xff, 0x00}, 25); sim != 24.0/25 {
+		t.Errorf("partial 25 bit similarity test [%f] failed: [%f]", 24.0/25, sim)
+	}
+	if sim := SimilarityBits(a, b, 0); sim != Similarity(a, b) {
+		t.Errorf("default bits similarity test [%f] failed: [%f]", Similarity(a, b), sim)
+	}
+}
+
+// Test that uncalibrated algorithms and sizes have no preset
+func TestMissingPreset(t *testing.T) {
+	if _, err := GetPreset("dhash", 9, PresetDefault); err == nil {
+		t.Errorf("missing preset size didn't fail")
+	}
+	if _, err := GetPreset("phash", 8, PresetDefault); err == nil {
+		t.Errorf("missing preset algorithm didn't fail")
+	}
+	if _, err := GetPreset("dhash", 8, PresetLevel(3)); err == nil {
+		t.Errorf("invalid preset level didn't fail")
+	}
+}
+
+// Test that strict presets require a higher similarity than loose ones
+func TestPresetOrder(t *testing.T) {
+	for key := range presets {
+		strict, _ := GetPreset(key.algorithm, key.hashLen, PresetStrict)
+		def, _ := GetPreset(key.algorithm, key.hashLen, PresetDefault)
+		loose, _ := GetPreset(key.algorithm, key.hashLen, PresetLoose)
+
+		if strict.Threshold < def.Threshold || def.Threshold < loose.Threshold {
+			t.Errorf("%s:%d presets aren't ordered: %v %v %v", key.algorithm, key.hashLen,
+				strict.Threshold, def.Threshold, loose.Threshold)
+		}
+	}
+}
+
+// Test that with the default presets, transformed images are still
+// duplicates of their originals and never of the other images
+func TestPresetsSeparateImages(t *testing.T) {
+	sources := calibrationSources()
+	for key := range presets {
+		preset, _ := GetPreset(key.algorithm, key.hashLen, PresetDefault)
+		pos, neg := calibrationScores(sources, calibrationAlgorithms[key.algorithm], key.hashLen)
+
+		accepted := 0
+		for _, sim := range pos {
+			if sim >= preset.Threshold {
+				accepted++
+			}
+		}
+		if accepted*5 < len(pos)*4 {
+			t.Errorf("%s:%d default preset only matches %d of %d transformed images",
+				key.algorithm, key.hashLen, accepted, len(pos))
+		}
+		for _, sim := range neg {
+			if sim >= preset.Threshold {
+				t.Errorf("%s:%d default preset matches different images: %f",
+					key.algorithm, key.hashLen, sim)
+			}
+		}
+	}
+}
+
+// Re-run the experiment the presets were derived from, and print
+// the similarity distributions along with the derived thresholds.
+// The strict threshold is the median similarity of the transformed
+// images, the loose one is the lowest similarity of a transformed
+// image (but always above every different image), and the default
+// one is halfway between them.
+func TestCalibratePresets(t *testing.T) {
+	if !*calibrate {
+		t.Skip("run with -calibrate to re-run the calibration experiment")
+	}
+
+	sources := calibrationSources()
+	names := make([]string, 0, len(calibrationAlgorithms))
+	for name := range calibrationAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, hashLen := range []int{8, 16} {
+			pos, neg := calibrationScores(sources, calibrationAlgorithms[name], hashLen)
+			sort.Float64s(pos)
+			sort.Float64s(neg)
+
+			maxNeg := neg[len(neg)-1]
+			strict := pos[len(pos)/2]
+			loose := pos[0]
+			if loose <= maxNeg {
+				loose = maxNeg + 0.01
+			}
+			def := (strict + loose) / 2
+
+			fmt.Printf("%-8s %2d  positives min %.3f median %.3f  negatives max %.3f"+
+				"  =>  {%.2f, %.2f, %.2f}\n", name, hashLen, pos[0], strict, maxNeg,
+				floor2(strict), floor2(def), floor2(loose))
+		}
+	}
+}
+
+// floor2 rounds a threshold down to two decimals.
+func floor2(v float64) float64 {
+	return float64(int(v*100)) / 100
+}
+
+// calibrationAlgorithms are the algorithms which have presets.
+var calibrationAlgorithms = map[string]HashFunc{
+	"dhash":   Dhash,
+	"dhash-h": DhashHorizontal,
+	"dhash-v": DhashVertical,
+	"ahash":   Ahash,
+}
+
+// calibrationSources returns the distinct images of the experiment: the
+// test images, and the four quadrants of lena_512.
+func calibrationSources() []image.Image {
+	var sources []image.Image
+	for _, name := range []string{"lena_512", "lena_inverted_512", "rand_512"} {
+		src, _ := OpenImg("./testdata/" + name + ".png")
+		sources = append(sources, src)
+	}
+
+	lena := sources[0]
+	for _, pt := range []image.Point{{0, 0}, {256, 0}, {0, 256}, {256, 256}} {
+		sources = append(sources, imaging.Crop(lena, image.Rect(pt.X, pt.Y, pt.X+256, pt.Y+256)))
+	}
+	return sources
+}
+
+// calibrationTransforms are the mild transforms applied to every source.
+var calibrationTransforms = []func(image.Image) image.Image{
+	func(img image.Image) image.Image {
+		return imaging.Resize(img, img.Bounds().Dx()/2, 0, imaging.Box)
+	},
+	func(img image.Image) image.Image { return imaging.Blur(img, 1.5) },
+	func(img image.Image) image.Image { return imaging.AdjustGamma(img, 1.3) },
+	func(img image.Image) image.Image { return imaging.AdjustContrast(img, 15) },
+	func(img image.Image) image.Image { return imaging.AdjustBrightness(img, 10) },
+	func(img image.Image) image.Image {
+		var buf bytes.Buffer
+		jpeg.Encode(&buf, img, &jpeg.Options{Quality: 40})
+		res, _ := jpeg.Decode(&buf)
+		return res
+	},
+	func(img image.Image) image.Image {
+		b := img.Bounds()
+		return imaging.CropCenter(img, b.Dx()*92/100, b.Dy()*92/100)
+	},
+	func(img image.Image) image.Image { return imaging.Rotate(img, 2, color.Black) },
+	func(img image.Image) image.Image {
+		res := imaging.Clone(img)
+		rnd := rand.New(rand.NewSource(1))
+		for i := 0; i < len(res.Pix); i += 4 {
+			n := rnd.Intn(21) - 10
+			for c := i; c < i+3; c++ {
+				res.Pix[c] = uint8(clamp(int(res.Pix[c])+n, 256))
+			}
+		}
+		return res
+	},
+	func(img image.Image) image.Image {
+		b := img.Bounds()
+		mark := imaging.New(b.Dx()/4, b.Dy()/12, color.White)
+		return imaging.Overlay(img, mark, image.Pt(b.Dx()/20, b.Dy()*17/20), 0.5)
+	},
+}
+
+// calibrationScores returns the similarities between every source and its
+// transforms (the positives), and between every transform and the other
+// sources (the negatives).
+func calibrationScores(sources []image.Image, hash HashFunc, hashLen int) ([]float64, []float64) {
+	originals := make([][]byte, len(sources))
+	for i, src := range sources {
+		originals[i], _ = hash(src, hashLen)
+	}
+
+	var pos, neg []float64
+	for i, src := range sources {
+		for _, transform := range calibrationTransforms {
+			h, _ := hash(transform(src), hashLen)
+			for j, orig := range originals {
+				if i == j {
+					pos = append(pos, Similarity(h, orig))
+				} else {
+					neg = append(neg, Similarity(h, orig))
+				}
+			}
+		}
+	}
+	return pos, neg
+}
@@ -0,0 +1,177 @@
+/*
+
+Traces the intermediate stages of the hash functions, to see why two images
+which look the same got different hashes.
+
+A context returned by WithTrace makes the *Context variants of the hash
+functions, and the HashContext method of the package's hashers, record into
+a Trace:
+  - the image after every stage (grayscale, blur, resize, the stages of a
+    Pipeline...), in the order they ran
+  - for every grid of bits, the matrix of values the bits were computed
+    from, the threshold they were compared with if any, and every bit in
+    the order it was appended
+
+Tracing is off by default: without a Trace in the context, the hash
+functions only look it up once per stage and grid, and record nothing.
+
+A Trace is serialised to JSON with the images of the stages encoded as
+PNGs. Every hash computed with the context is recorded into the same
+Trace, so a context should trace a single hash.
+
+Usage:
+  ctx,trace := imagehash.WithTrace(context.Background())
+  hash,err := imagehash.DhashContext(ctx, img, 8)
+  data,err := json.Marshal(trace)
+
+  hash,trace,err := imagehash.TraceHash(hasher, img)
+
+*/
+
+package imagehash
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"math"
+	"sync"
+)
+
+// Trace is the record of the stages of the hashes computed with a context
+// returned by WithTrace. It is safe for concurrent use.
+type Trace struct {
+	mu     sync.Mutex
+	Stages []TraceStage `json:"stages"`
+	Grids  []*TraceGrid `json:"grids"`
+}
+
+// TraceStage is the image returned by a stage of a hash.
+type TraceStage struct {
+	Name  string      // Name of the stage, such as "grayscale" or "resize"
+	Image image.Image // Image returned by the stage
+}
+
+// MarshalJSON encodes the image of a stage as a PNG, along with its size.
+func (s TraceStage) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	var width, height int
+	if s.Image != nil {
+		width, height = s.Image.Bounds().Dx(), s.Image.Bounds().Dy()
+		if err := png.Encode(&buf, s.Image); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(struct {
+		Name   string `json:"name"`
+		Width  int    `json:"width"`
+		Height int    `json:"height"`
+		PNG    []byte `json:"png"`
+	}{s.Name, width, height, buf.Bytes()})
+}
+
+// The thresholds the bits of a grid can be compared with.
+const (
+	NoThreshold      = ""        // Every value is compared with its neighbour, as in dhash
+	AverageThreshold = "average" // Every value is compared with the average of the grid or window
+)
+
+// TraceGrid is the record of a grid of bits of a hash.
+type TraceGrid struct {
+	Name      string      `json:"name"`      // Name of the grid, such as "horizontal" or "average"
+	Matrix    [][]float64 `json:"matrix"`    // Values the bits were computed from, indexed by [y][x], from 0 to 255 for pixels
+	Threshold string      `json:"threshold"` // NoThreshold or AverageThreshold
+	Bits      []TraceBit  `json:"bits"`      // Bits, in the order they were appended
+}
+
+// TraceBit is the decision of a bit of a hash. With NoThreshold, the bit is
+// 1 if Value is smaller than Ref, the value of the neighbour it was compared
+// with. Otherwise, the bit is 1 if Value is greater than Ref, the threshold.
+type TraceBit struct {
+	X     int     `json:"x"` // Position of the value in the matrix
+	Y     int     `json:"y"`
+	Value float64 `json:"value"`
+	Ref   float64 `json:"ref"`
+	Bit   int     `json:"bit"`
+}
+
+// traceKey is the key of the Trace of a context.
+type traceKey struct{}
+
+// WithTrace returns a context making the hash functions record their
+// stages into the returned Trace.
+func WithTrace(ctx context.Context) (context.Context, *Trace) {
+	tr := &Trace{}
+	return context.WithValue(ctx, traceKey{}, tr), tr
+}
+
+// TraceHash hashes an image with a hasher, and returns the Trace of the
+// hash.
+func TraceHash(hasher Hasher, img image.Image) (Hash, *Trace, error) {
+	ctx, tr := WithTrace(context.Background())
+	h, err := HashContext(ctx, hasher, img)
+	if err != nil {
+		return Hash{}, nil, err
+	}
+	return h, tr, nil
+}
+
+// traceFrom returns the Trace of a context, or nil if it has none.
+func traceFrom(ctx context.Context) *Trace {
+	tr, _ := ctx.Value(traceKey{}).(*Trace)
+	return tr
+}
+
+// addStage records the image returned by a stage.
+func (tr *Trace) addStage(name string, img image.Image) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.Stages = append(tr.Stages, TraceStage{Name: name, Image: img})
+}
+
+// newGrid records a grid of bits, computed from a matrix. The bits are
+// added to the grid as they are appended to the hash.
+func (tr *Trace) newGrid(name, threshold string, matrix [][]float64) *TraceGrid {
+	grid := &TraceGrid{Name: name, Matrix: matrix, Threshold: threshold}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.Grids = append(tr.Grids, grid)
+	return grid
+}
+
+// traceGrid returns a new grid of the Trace of a context, computed from the
+// matrix returned by 'matrix', or nil if the context has no Trace.
+func traceGrid(ctx context.Context, name, threshold string, matrix func() [][]float64) *TraceGrid {
+	tr := traceFrom(ctx)
+	if tr == nil {
+		return nil
+	}
+	return tr.newGrid(name, threshold, matrix())
+}
+
+// add records the decision of a bit, if the grid isn't nil.
+func (g *TraceGrid) add(x, y int, value, ref float64, bit int) {
+	if g == nil {
+		return
+	}
+	g.Bits = append(g.Bits, TraceBit{X: x, Y: y, Value: value, Ref: ref, Bit: bit})
+}
+
+// matrixImage returns a grayscale image of a matrix of values from 0 to
+// 255, indexed by [y][x].
+func matrixImage(matrix [][]float64) *image.Gray {
+	height, width := len(matrix), 0
+	if height > 0 {
+		width = len(matrix[0])
+	}
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y, row := range matrix {
+		for x, v := range row {
+			img.Pix[y*img.Stride+x] = uint8(math.Floor(math.Max(0, math.Min(255, v)) + 0.5))
+		}
+	}
+	return img
+}
@@ -0,0 +1,180 @@
+/*
+
+Testing suite for tracing the stages of the hashes.
+
+1. Test that tracing doesn't change the hashes
+2. Test the stages and grids of the dhash variants
+3. Test the threshold of ahash and mhhash
+4. Test tracing a hasher with a pipeline, and serialising the trace
+
+*/
+
+package imagehash
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image/png"
+	"reflect"
+	"testing"
+)
+
+// checkTraceBits checks that the bits of the grids of a trace are the bits
+// of a hash, and follow the rule of their threshold.
+func checkTraceBits(t *testing.T, name string, tr *Trace, hash []byte) {
+	i := 0
+	for _, grid := range tr.Grids {
+		if i%8 != 0 {
+			i += 8 - i%8 // The grids of a dhash start on a new byte
+		}
+		for _, b := range grid.Bits {
+			exp := 0
+			if (grid.Threshold == NoThreshold && b.Value < b.Ref) || (grid.Threshold != NoThreshold && b.Value > b.Ref) {
+				exp = 1
+			}
+			if b.Bit != exp || b.Bit != hashBit(hash, i) || grid.Matrix[b.Y][b.X] != b.Value {
+				t.Errorf("%s trace bit %d test [%d %d] failed: [%+v]", name, i, hashBit(hash, i), exp, b)
+				return
+			}
+			i++
+		}
+	}
+	if i > len(hash)*8 || len(hash)*8-i >= 8 {
+		t.Errorf("%s trace length test [%d] failed: [%d]", name, len(hash)*8, i)
+	}
+}
+
+// stageNames returns the names of the stages of a trace.
+func stageNames(tr *Trace) []string {
+	var names []string
+	for _, s := range tr.Stages {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+// Test that the hashes are the same with and without a trace
+func TestTraceSameHashes(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_256.png")
+	fns := map[string]HashFuncContext{
+		"dhash":   DhashContext,
+		"dhash-h": DhashHorizontalContext,
+		"dhash-v": DhashVerticalContext,
+		"dhash-d": DhashDiagonalContext,
+		"ahash":   AhashContext,
+	}
+	for name, fn := range fns {
+		exp, _ := fn(context.Background(), src, 8)
+		ctx, tr := WithTrace(context.Background())
+		got, err := fn(ctx, src, 8)
+		if err != nil || !reflect.DeepEqual(exp, got) {
+			t.Errorf("%s traced hash test [%x] failed: [%x %v]", name, exp, got, err)
+		}
+		checkTraceBits(t, name, tr, got)
+	}
+
+	if traceFrom(context.Background()) != nil {
+		t.Errorf("untraced context test [nil] failed")
+	}
+}
+
+// Test the stages and grids of the dhash variants
+func TestTraceDhash(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_256.png")
+	ctx, tr := WithTrace(context.Background())
+	DhashGridContext(ctx, src, 8, 4)
+
+	if names := stageNames(tr); !reflect.DeepEqual(names, []string{"grayscale", "resize", "resize"}) {
+		t.Errorf("dhash stages test [grayscale resize resize] failed: [%v]", names)
+	}
+	if size := tr.Stages[1].Image.Bounds().Size(); size.X != 9 || size.Y != 4 {
+		t.Errorf("horizontal resize test [9x4] failed: [%v]", size)
+	}
+	if len(tr.Grids) != 2 || tr.Grids[0].Name != "horizontal" || tr.Grids[1].Name != "vertical" {
+		t.Fatalf("dhash grids test [horizontal vertical] failed: [%d]", len(tr.Grids))
+	}
+
+	horiz, vert := tr.Grids[0], tr.Grids[1]
+	if len(horiz.Matrix) != 4 || len(horiz.Matrix[0]) != 9 || len(vert.Matrix) != 5 || len(vert.Matrix[0]) != 8 {
+		t.Errorf("dhash matrix sizes test [9x4 8x5] failed")
+	}
+	// Every bit compares a pixel with its neighbour
+	if b := horiz.Bits[9]; b.X != 1 || b.Y != 1 || b.Ref != horiz.Matrix[1][2] {
+		t.Errorf("horizontal neighbour test [(1,1)] failed: [%+v]", b)
+	}
+	if b := vert.Bits[5]; b.X != 1 || b.Y != 1 || b.Ref != vert.Matrix[2][1] {
+		t.Errorf("vertical neighbour test [(1,1)] failed: [%+v]", b)
+	}
+}
+
+// Test that ahash and mhhash compare their values with averages
+func TestTraceThresholds(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_256.png")
+
+	ctx, tr := WithTrace(context.Background())
+	AhashContext(ctx, src, 8)
+	grid := tr.Grids[0]
+	var sum float64
+	for _, row := range grid.Matrix {
+		for _, v := range row {
+			sum += v
+		}
+	}
+	if grid.Threshold != AverageThreshold || sum/64-grid.Bits[0].Ref > 1 || grid.Bits[0].Ref-sum/64 > 1 {
+		t.Errorf("ahash threshold test [%f] failed: [%s %f]", sum/64, grid.Threshold, grid.Bits[0].Ref)
+	}
+	// The bits are appended column by column
+	if b := grid.Bits[1]; b.X != 0 || b.Y != 1 {
+		t.Errorf("ahash order test [(0,1)] failed: [%+v]", b)
+	}
+
+	ctx, tr = WithTrace(context.Background())
+	hash, _ := MHhashContext(ctx, src)
+	checkTraceBits(t, "mhhash", tr, hash)
+	if names := stageNames(tr); !reflect.DeepEqual(names, []string{"grayscale", "blur", "resize", "equalize"}) {
+		t.Errorf("mhhash stages test failed: [%v]", names)
+	}
+	if len(tr.Grids[0].Matrix) != mhBlocks || len(tr.Grids[0].Bits) != MHBits {
+		t.Errorf("mhhash grid test [%d %d] failed: [%d %d]", mhBlocks, MHBits,
+			len(tr.Grids[0].Matrix), len(tr.Grids[0].Bits))
+	}
+}
+
+// Test tracing a hasher with a pipeline, and its JSON
+func TestTraceHasher(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_256.png")
+	hasher, _ := NewHasher("equalize|blur(1)|dhash-h:8")
+	exp, _ := hasher.Hash(src)
+	h, tr, err := TraceHash(hasher, src)
+	if err != nil || !reflect.DeepEqual(exp, h) {
+		t.Fatalf("traced hasher test [%x] failed: [%x %v]", exp.Value, h.Value, err)
+	}
+	if names := stageNames(tr); !reflect.DeepEqual(names, []string{"equalize", "blur(1)", "grayscale", "resize"}) {
+		t.Errorf("pipeline stages test failed: [%v]", names)
+	}
+
+	data, err := json.Marshal(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Stages []struct {
+			Name          string
+			Width, Height int
+			PNG           []byte
+		}
+		Grids []TraceGrid
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	resize := decoded.Stages[3]
+	img, err := png.Decode(bytes.NewReader(resize.PNG))
+	if err != nil || resize.Width != 9 || resize.Height != 8 || img.Bounds().Dx() != 9 {
+		t.Errorf("stage JSON test [9x8] failed: [%dx%d %v]", resize.Width, resize.Height, err)
+	}
+	if !reflect.DeepEqual(decoded.Grids[0], *tr.Grids[0]) {
+		t.Errorf("grid JSON test failed")
+	}
+}
@@ -0,0 +1,184 @@
+/*
+
+Trims the uniform borders of an image before hashing it: the black bars of
+letterboxed video stills, or the white margins of product photos. The
+hashes resize the whole image, so the same content with different borders
+is resized differently, and its hashes end up far apart.
+
+A border is a run of rows or columns, from an edge of the image, whose
+pixels are all within a tolerance of the colour of the corner they start
+from, so borders of any near-constant colour are trimmed, and every edge
+can have a different one. The top and bottom rows are trimmed first, then
+the left and right columns of the rows which are left.
+
+TrimHasher wraps any Hasher to trim the images it hashes, and HashTrimmed
+also returns the rectangle the hash was computed on, so that crops can be
+audited.
+
+Usage:
+  trimmed,rect := imagehash.TrimBorders(img, imagehash.TrimOptions{})
+
+  hasher,err := imagehash.NewHasher("dhash:8")
+  trimmer := imagehash.NewTrimHasher(hasher, imagehash.TrimOptions{Tolerance: 24})
+  hash,rect,err := trimmer.HashTrimmed(context.Background(), img)
+
+*/
+
+package imagehash
+
+import (
+	"context"
+	"image"
+	"image/color"
+
+	"github.com/disintegration/imaging"
+)
+
+// TrimOptions configures TrimBorders. Zero values are replaced by defaults.
+type TrimOptions struct {
+	Tolerance int // Largest difference of a channel from the colour of a border, from 1 to 255; 16 if zero
+}
+
+// TrimBorders crops the uniform borders of an image, and returns the
+// cropped image along with its rectangle in the bounds of 'img'. When there
+// are no borders, or the whole image is uniform, 'img' is returned as it is,
+// with its bounds.
+func TrimBorders(img image.Image, opts TrimOptions) (image.Image, image.Rectangle) {
+	rect := BorderRect(img, opts)
+	if rect == img.Bounds() {
+		return img, rect
+	}
+	return imaging.Crop(img, rect), rect
+}
+
+// BorderRect returns the rectangle of an image left once its uniform
+// borders are trimmed, without cropping it.
+func BorderRect(img image.Image, opts TrimOptions) image.Rectangle {
+	if opts.Tolerance == 0 {
+		opts.Tolerance = 16
+	}
+	b := img.Bounds()
+	if b.Empty() {
+		return b
+	}
+
+	// Rows, from the top and bottom corners
+	top, bottom := b.Min.Y, b.Max.Y
+	ref := nrgbaAt(img, b.Min.X, b.Min.Y)
+	for top < bottom && uniformLine(img, ref, opts.Tolerance, b.Min.X, top, b.Max.X, top+1) {
+		top++
+	}
+	if top == bottom {
+		return b // The whole image is uniform
+	}
+	ref = nrgbaAt(img, b.Max.X-1, b.Max.Y-1)
+	for bottom > top && uniformLine(img, ref, opts.Tolerance, b.Min.X, bottom-1, b.Max.X, bottom) {
+		bottom--
+	}
+
+	// Columns of the rows left, from the left and right corners
+	left, right := b.Min.X, b.Max.X
+	ref = nrgbaAt(img, b.Min.X, top)
+	for left < right && uniformLine(img, ref, opts.Tolerance, left, top, left+1, bottom) {
+		left++
+	}
+	ref = nrgbaAt(img, b.Max.X-1, bottom-1)
+	for right > left && uniformLine(img, ref, opts.Tolerance, right-1, top, right, bottom) {
+		right--
+	}
+	if left == right {
+		return b
+	}
+	return image.Rect(left, top, right, bottom)
+}
+
+// uniformLine reports whether all the pixels of a rectangle one pixel wide
+// or high are within 'tolerance' of a colour.
+func uniformLine(img image.Image, ref color.NRGBA, tolerance, x0, y0, x1, y1 int) bool {
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			c := nrgbaAt(img, x, y)
+			if channelDiff(c.R, ref.R) > tolerance || channelDiff(c.G, ref.G) > tolerance ||
+				channelDiff(c.B, ref.B) > tolerance || channelDiff(c.A, ref.A) > tolerance {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// nrgbaAt returns the non-premultiplied colour of a pixel.
+func nrgbaAt(img image.Image, x, y int) color.NRGBA {
+	return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+}
+
+// channelDiff returns the absolute difference of two channels.
+func channelDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+// TrimHasher is a Hasher which trims the uniform borders of images before
+// hashing them with another Hasher. It is named like a PipelineHasher
+// starting with the Trim stage, such as "trim|dhash:8", and its hashes have
+// the stage in their Pipeline, so they are only compared with other trimmed
+// hashes, and cached apart from the hashes of the other Hasher.
+type TrimHasher struct {
+	Hasher
+	Options TrimOptions
+}
+
+// NewTrimHasher returns a TrimHasher trimming images for a hasher.
+func NewTrimHasher(hasher Hasher, opts TrimOptions) *TrimHasher {
+	return &TrimHasher{Hasher: hasher, Options: opts}
+}
+
+// Hash trims the borders of an image and hashes it.
+func (th *TrimHasher) Hash(img image.Image) (Hash, error) {
+	h, _, err := th.HashTrimmed(context.Background(), img)
+	return h, err
+}
+
+// HashContext is the same as Hash, but returns ctx.Err() as soon as the
+// context is done.
+func (th *TrimHasher) HashContext(ctx context.Context, img image.Image) (Hash, error) {
+	h, _, err := th.HashTrimmed(ctx, img)
+	return h, err
+}
+
+// Name returns the name of the Trim stage followed by the name of the other
+// Hasher, such as "trim|dhash:8", which NewHasher creates back.
+func (th *TrimHasher) Name() string {
+	return th.stage() + "|" + th.Hasher.Name()
+}
+
+// stage returns the name of the Trim stage of the options.
+func (th *TrimHasher) stage() string {
+	return Trim(th.Options.Tolerance).Name
+}
+
+// Version returns the version of the other Hasher.
+func (th *TrimHasher) Version() int {
+	return HasherVersion(th.Hasher)
+}
+
+// HashTrimmed trims the borders of an image and hashes it, returning the
+// rectangle of the image which was hashed.
+func (th *TrimHasher) HashTrimmed(ctx context.Context, img image.Image) (Hash, image.Rectangle, error) {
+	if err := ctx.Err(); err != nil {
+		return Hash{}, image.Rectangle{}, err
+	}
+	trimmed, rect := TrimBorders(img, th.Options)
+	h, err := HashContext(ctx, th.Hasher, trimmed)
+	if err != nil {
+		return Hash{}, image.Rectangle{}, err
+	}
+	if h.Pipeline == "" {
+		h.Pipeline = th.stage()
+	} else {
+		h.Pipeline = th.stage() + "|" + h.Pipeline
+	}
+	return h, rect, nil
+}
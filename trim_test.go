@@ -0,0 +1,134 @@
+/*
+
+Testing suite for trimming the borders of images.
+
+1. Test trimming letterbox bars and white margins
+2. Test the tolerance of near-constant borders
+3. Test images without borders, and uniform images
+4. Test that a TrimHasher hashes bordered copies like the original, and
+   that its hashes are told apart from untrimmed ones
+
+*/
+
+package imagehash
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+// framed draws an image at 'at' in an image of 'size', filled with 'c'.
+func framed(img image.Image, size image.Rectangle, at image.Point, c color.Color) *image.RGBA {
+	dst := fill(size, c)
+	draw.Draw(dst, img.Bounds().Sub(img.Bounds().Min).Add(at), img, img.Bounds().Min, draw.Src)
+	return dst
+}
+
+// Test trimming black bars and white margins of different widths
+func TestTrimBorders(t *testing.T) {
+	lena, _ := OpenImg("./testdata/lena_256.png")
+
+	tests := []struct {
+		img image.Image
+		exp image.Rectangle
+	}{
+		{framed(lena, image.Rect(0, 0, 256, 360), image.Pt(0, 52), color.Black), image.Rect(0, 52, 256, 308)},
+		{framed(lena, image.Rect(0, 0, 400, 256), image.Pt(100, 0), color.White), image.Rect(100, 0, 356, 256)},
+		{framed(lena, image.Rect(0, 0, 300, 290), image.Pt(10, 30), color.RGBA{40, 120, 200, 255}), image.Rect(10, 30, 266, 286)},
+		{framed(lena, image.Rect(-20, -10, 300, 300), image.Pt(0, 0), color.White), image.Rect(0, 0, 256, 256)},
+	}
+	for i, test := range tests {
+		trimmed, rect := TrimBorders(test.img, TrimOptions{})
+		if rect != test.exp || trimmed.Bounds().Size() != test.exp.Size() {
+			t.Errorf("trim %d test [%v] failed: [%v %v]", i, test.exp, rect, trimmed.Bounds())
+		}
+	}
+
+	// Every edge has its own colour
+	img := framed(lena, image.Rect(0, 0, 300, 300), image.Pt(20, 20), color.Black)
+	draw.Draw(img, image.Rect(0, 276, 300, 300), image.NewUniform(color.White), image.Point{}, draw.Src)
+	if rect := BorderRect(img, TrimOptions{}); rect != image.Rect(20, 20, 276, 276) {
+		t.Errorf("two colour trim test [%v] failed: [%v]", image.Rect(20, 20, 276, 276), rect)
+	}
+}
+
+// Test that noisy borders are trimmed within the tolerance
+func TestTrimBordersTolerance(t *testing.T) {
+	lena, _ := OpenImg("./testdata/lena_256.png")
+	img := framed(lena, image.Rect(0, 0, 256, 320), image.Pt(0, 32), color.Black)
+
+	// Noise of up to 10 on the bars, as left by JPEG compression
+	rnd := rand.New(rand.NewSource(1))
+	for _, y := range []int{0, 10, 31, 288, 319} {
+		for x := 0; x < 256; x++ {
+			v := uint8(rnd.Intn(11))
+			img.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+
+	if rect := BorderRect(img, TrimOptions{}); rect != image.Rect(0, 32, 256, 288) {
+		t.Errorf("noisy trim test [%v] failed: [%v]", image.Rect(0, 32, 256, 288), rect)
+	}
+	if rect := BorderRect(img, TrimOptions{Tolerance: 4}); rect.Dy() < 300 {
+		t.Errorf("low tolerance trim test [>=300] failed: [%v]", rect)
+	}
+}
+
+// Test that images without borders are left as they are
+func TestTrimBordersNone(t *testing.T) {
+	lena, _ := OpenImg("./testdata/lena_256.png")
+	white, _ := OpenImg("./testdata/white_512.png")
+
+	for _, img := range []image.Image{lena, white, image.NewRGBA(image.Rectangle{})} {
+		trimmed, rect := TrimBorders(img, TrimOptions{})
+		if trimmed != img || rect != img.Bounds() {
+			t.Errorf("untrimmed test [%v] failed: [%v]", img.Bounds(), rect)
+		}
+	}
+}
+
+// Test that bordered copies hash like the original, and the rectangles
+func TestTrimHasher(t *testing.T) {
+	lena, _ := OpenImg("./testdata/lena_256.png")
+	hasher, _ := NewHasher("dhash:8")
+	trimmer := NewTrimHasher(hasher, TrimOptions{})
+	if trimmer.Name() != "trim|dhash:8" || trimmer.Bits() != 128 {
+		t.Errorf("trim hasher name test [trim|dhash:8 128] failed: [%s %d]", trimmer.Name(), trimmer.Bits())
+	}
+	pipelined, _ := NewHasher("equalize|dhash:8")
+	if name := NewTrimHasher(pipelined, TrimOptions{Tolerance: 24}).Name(); name != "trim(24)|equalize|dhash:8" {
+		t.Errorf("trim pipeline hasher name test [trim(24)|equalize|dhash:8] failed: [%s]", name)
+	}
+
+	exp, _ := hasher.Hash(lena)
+	letterbox := framed(lena, image.Rect(0, 0, 256, 360), image.Pt(0, 52), color.Black)
+	if untrimmed, _ := hasher.Hash(letterbox); GetBitDistance(exp.Value, untrimmed.Value) == 0 {
+		t.Errorf("untrimmed letterbox hash test didn't differ")
+	}
+	h, rect, err := trimmer.HashTrimmed(context.Background(), letterbox)
+	if err != nil || rect != image.Rect(0, 52, 256, 308) || GetBitDistance(exp.Value, h.Value) != 0 {
+		t.Errorf("trimmed letterbox hash test [%x %v] failed: [%v %x %v]", exp.Value, image.Rect(0, 52, 256, 308),
+			err, h.Value, rect)
+	}
+	if h.Pipeline != "trim" || h.Algorithm != "dhash:8" {
+		t.Errorf("trimmed hash pipeline test [trim dhash:8] failed: [%s %s]", h.Pipeline, h.Algorithm)
+	}
+	if _, err := h.Distance(exp); err == nil {
+		t.Errorf("trimmed and untrimmed distance test didn't fail")
+	}
+	if recreated, err := NewHasher(trimmer.Name()); err != nil {
+		t.Errorf("trim hasher from its name test failed: [%v]", err)
+	} else if h2, _ := recreated.Hash(letterbox); h2.Pipeline != h.Pipeline || GetBitDistance(h.Value, h2.Value) != 0 {
+		t.Errorf("trim hasher from its name test [%s %x] failed: [%s %x]", h.Pipeline, h.Value, h2.Pipeline, h2.Value)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := trimmer.HashContext(ctx, letterbox); err != context.Canceled {
+		t.Errorf("cancelled trim test [%v] failed: [%v]", context.Canceled, err)
+	}
+}
@@ -14,6 +14,7 @@ package imagehash
 import (
 	"github.com/disintegration/imaging"
 	"image"
+	"math"
 )
 
 // OpenImg is a wrapper aroung the Open function from 'imaging'.
@@ -22,3 +23,11 @@ import (
 func OpenImg(fp string) (image.Image, error) {
 	return imaging.Open(fp)
 }
+
+// round rounds half away from zero, as math.Round does from Go 1.10.
+func round(x float64) float64 {
+	if x < 0 {
+		return -math.Floor(-x + 0.5)
+	}
+	return math.Floor(x + 0.5)
+}
@@ -0,0 +1,197 @@
+/*
+
+Versions of the implementations of the algorithms, so that hashes which
+stopped matching because an implementation changed are noticed, instead of
+silently being far apart.
+
+Every hash computed by a Hasher carries the version of the implementation
+of its algorithm in its Version field. The version is incremented whenever
+a change makes the algorithm return different hashes for the same images:
+its resize filter, its grayscale formula, the order of its bits, or the
+preprocessing it does itself. Preprocessing added with a Pipeline is told
+by the Pipeline field of the hash instead, and the built-in stages get a
+new name when their output changes.
+
+Version 1 of the built-in algorithms:
+  dhash, dhash-h, dhash-v, dhash-d
+      Grayscaled with the Rec. 601 luma of 'imaging', resized with a
+      Lanczos filter, bits appended row by row for the horizontal and
+      diagonal gradients and column by column for the vertical one, the
+      first bit of a byte being its most significant one.
+  ahash
+      Grayscaled and resized as above, bits appended column by column.
+  mhhash
+      Grayscaled as above, blurred with a sigma of 1, resized to 512x512
+      with a Lanczos filter and equalised, bits appended block by block.
+  colormoment
+      Resized to 512x512 with a Lanczos filter, and blurred with a sigma
+      of 0.8.
+  radial
+      Grayscaled as above, blurred with a sigma of 1, projected along
+      'numAngles' lines, and the 40 DCT coefficients following the DC one
+      quantised to bytes.
+
+The hash functions, such as Dhash or RadialHash, return bare bytes, which
+EncodeVersioned prefixes with the version constant of their algorithm
+before they are stored. CheckVersioned checks the versions of two such
+hashes and returns their bare bytes, to be compared with GetDistance,
+GetBitDistance or RadialSimilarity. The Index keeps the version of the
+hashes added with AddHash, and its matches carry it.
+
+Comparing hashes of different versions, or a hash of an unknown version
+(0, such as a hash stored before versions existed) with a known one, is
+decided by the VersionPolicy: StrictVersions, the default, returns a
+VersionError, and WarnVersions reports the hashes and allows the
+comparison.
+
+Usage:
+  stored := imagehash.EncodeVersioned(imagehash.DhashVersion, hash)
+  hash1,hash2,err := imagehash.CheckVersioned("dhash", stored, other)
+  dist := imagehash.GetDistance(hash1, hash2)
+
+  imagehash.SetVersionPolicy(imagehash.WarnVersions(func(a, b imagehash.Hash) {
+    log.Printf("comparing %s hashes of versions %d and %d", a.Algorithm, a.Version, b.Version)
+  }))
+
+*/
+
+package imagehash
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// Versions of the implementations of the built-in algorithms.
+const (
+	DhashVersion       = 1 // Every variant of dhash
+	AhashVersion       = 1
+	MHhashVersion      = 1
+	ColorMomentVersion = 1
+	RadialVersion      = 1
+)
+
+// VersionError is returned when hashes computed by different versions of
+// an algorithm are compared.
+type VersionError struct {
+	Algorithm string
+	A, B      int // Versions of the two hashes; 0 if unknown
+}
+
+func (e *VersionError) Error() string {
+	return "cannot compare " + e.Algorithm + " hashes of versions " + versionString(e.A) +
+		" and " + versionString(e.B)
+}
+
+// versionString formats a version in an error.
+func versionString(v int) string {
+	if v == 0 {
+		return "unknown"
+	}
+	return strconv.Itoa(v)
+}
+
+// VersionPolicy decides whether hashes of different versions of their
+// algorithm can be compared: it returns an error to refuse the comparison,
+// or nil to allow it.
+type VersionPolicy func(a, b Hash) error
+
+// StrictVersions refuses to compare hashes of different versions, with a
+// VersionError.
+func StrictVersions(a, b Hash) error {
+	return &VersionError{Algorithm: a.Algorithm, A: a.Version, B: b.Version}
+}
+
+// WarnVersions returns a policy which calls 'warn' with the hashes of
+// different versions, then allows comparing them.
+func WarnVersions(warn func(a, b Hash)) VersionPolicy {
+	return func(a, b Hash) error {
+		warn(a, b)
+		return nil
+	}
+}
+
+var (
+	policyMu sync.RWMutex
+	policy   VersionPolicy = StrictVersions
+)
+
+// SetVersionPolicy replaces the policy of the package, and returns the
+// previous one. A nil policy restores StrictVersions.
+func SetVersionPolicy(p VersionPolicy) VersionPolicy {
+	if p == nil {
+		p = StrictVersions
+	}
+
+	policyMu.Lock()
+	defer policyMu.Unlock()
+
+	previous := policy
+	policy = p
+	return previous
+}
+
+// CheckVersions returns nil if two hashes have the same version, and the
+// error of the VersionPolicy otherwise.
+func CheckVersions(a, b Hash) error {
+	if a.Version == b.Version {
+		return nil
+	}
+
+	policyMu.RLock()
+	p := policy
+	policyMu.RUnlock()
+
+	return p(a, b)
+}
+
+// HasherVersion returns the version of the algorithm of a hasher, which is
+// the Version of its hashes, or 0 if the hasher doesn't tell it with a
+// Version method.
+func HasherVersion(hasher Hasher) int {
+	if v, ok := hasher.(interface{ Version() int }); ok {
+		return v.Version()
+	}
+	return 0
+}
+
+// EncodeVersioned returns a hash returned by a hash function, such as Dhash
+// or RadialHash, prefixed with the version of its algorithm, such as
+// DhashVersion, as a varint.
+func EncodeVersioned(version int, hash []byte) []byte {
+	buf := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(hash))
+	n := binary.PutUvarint(buf, uint64(version))
+	return append(buf[:n], hash...)
+}
+
+// DecodeVersioned returns the version and the bare bytes of a hash encoded
+// by EncodeVersioned.
+func DecodeVersioned(data []byte) (int, []byte, error) {
+	version, n := binary.Uvarint(data)
+	if n <= 0 || version > 1<<31-1 {
+		return 0, nil, errors.New("invalid version prefix of a versioned hash")
+	}
+	return int(version), data[n:], nil
+}
+
+// CheckVersioned decodes two hashes of an algorithm encoded by
+// EncodeVersioned, and returns their bare bytes if they have the same
+// version, or the error of the VersionPolicy if they don't and it refuses
+// to compare them.
+func CheckVersioned(algorithm string, a, b []byte) ([]byte, []byte, error) {
+	versionA, hashA, err := DecodeVersioned(a)
+	if err != nil {
+		return nil, nil, err
+	}
+	versionB, hashB, err := DecodeVersioned(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := CheckVersions(Hash{Algorithm: algorithm, Version: versionA},
+		Hash{Algorithm: algorithm, Version: versionB}); err != nil {
+		return nil, nil, err
+	}
+	return hashA, hashB, nil
+}
@@ -0,0 +1,153 @@
+/*
+
+Testing suite for the versions of the algorithms.
+
+1. Test that the hashes of every hasher carry its version
+2. Test that hashes of different versions aren't compared by default
+3. Test replacing the policy with one which warns
+4. Test that sequences and grids check the versions of their hashes
+5. Test that bare hashes carry their version once encoded
+
+*/
+
+package imagehash
+
+import (
+	"bytes"
+	"image"
+	"testing"
+	"time"
+)
+
+// Test the versions of the built-in hashers, and of the wrapping ones
+func TestHasherVersions(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_256.png")
+	exp := map[string]int{
+		"dhash:8":              DhashVersion,
+		"dhash-h:16":           DhashVersion,
+		"dhash-v":              DhashVersion,
+		"dhash-d":              DhashVersion,
+		"ahash:8":              AhashVersion,
+		"mhhash":               MHhashVersion,
+		"colormoment":          ColorMomentVersion,
+		"equalize|ahash:8":     AhashVersion,
+		"blur(1)|trim|dhash:8": DhashVersion,
+	}
+	for name, version := range exp {
+		hasher, err := NewHasher(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, _ := hasher.Hash(src)
+		if version == 0 || h.Version != version || HasherVersion(hasher) != version {
+			t.Errorf("%s version test [%d] failed: [%d %d]", name, version, h.Version, HasherVersion(hasher))
+		}
+	}
+
+	dhasher, _ := NewHasher("dhash:8")
+	if v := HasherVersion(NewTrimHasher(dhasher, TrimOptions{})); v != DhashVersion {
+		t.Errorf("trim hasher version test [%d] failed: [%d]", DhashVersion, v)
+	}
+	if v := HasherVersion(unversionedHasher{dhasher}); v != 0 {
+		t.Errorf("unversioned hasher test [0] failed: [%d]", v)
+	}
+}
+
+// unversionedHasher is a Hasher without a Version method.
+type unversionedHasher struct {
+	Hasher
+}
+
+// Test that the default policy refuses different and unknown versions
+func TestVersionMismatch(t *testing.T) {
+	a := Hash{Algorithm: "dhash:8", Value: []byte{0x0f}, Version: 1}
+	b := Hash{Algorithm: "dhash:8", Value: []byte{0xff}, Version: 2}
+	unknown := Hash{Algorithm: "dhash:8", Value: []byte{0xff}}
+
+	if dist, err := a.Distance(a); err != nil || dist != 0 {
+		t.Errorf("same version test [0] failed: [%v %f]", err, dist)
+	}
+	for _, o := range []Hash{b, unknown} {
+		_, err := a.Distance(o)
+		if verr, ok := err.(*VersionError); !ok || verr.A != 1 || verr.B != o.Version {
+			t.Errorf("version %d mismatch test [1 %d] failed: [%v]", o.Version, o.Version, err)
+		}
+	}
+	if _, err := a.Distance(unknown); err == nil || err.Error() != "cannot compare dhash:8 hashes of versions 1 and unknown" {
+		t.Errorf("version error message test failed: [%v]", err)
+	}
+}
+
+// Test a policy warning about mismatches, and restoring the strict one
+func TestVersionPolicy(t *testing.T) {
+	a := Hash{Algorithm: "dhash:8", Value: []byte{0x0f}, Version: 1}
+	b := Hash{Algorithm: "dhash:8", Value: []byte{0xff}, Version: 2}
+
+	var warned []int
+	previous := SetVersionPolicy(WarnVersions(func(a, b Hash) {
+		warned = append(warned, a.Version, b.Version)
+	}))
+	defer SetVersionPolicy(previous)
+
+	if dist, err := a.Distance(b); err != nil || dist != 4 || len(warned) != 2 || warned[1] != 2 {
+		t.Errorf("warning policy test [4 [1 2]] failed: [%v %f %v]", err, dist, warned)
+	}
+	if a.Distance(a); len(warned) != 2 {
+		t.Errorf("same version warning test [2] failed: [%d]", len(warned))
+	}
+
+	SetVersionPolicy(nil)
+	if _, err := a.Distance(b); err == nil {
+		t.Errorf("restored strict policy test didn't fail")
+	}
+}
+
+// Test that mixing versions in sequences and grids fails
+func TestVersionSequences(t *testing.T) {
+	v1 := FrameHash{Hash: Hash{Algorithm: "dhash:8", Value: []byte{0x0f}, Version: 1}, Delay: time.Second}
+	v2 := FrameHash{Hash: Hash{Algorithm: "dhash:8", Value: []byte{0xf0}, Version: 2}, Delay: time.Second}
+
+	if _, err := NewSequence([]FrameHash{v1, v2}, 0); err == nil {
+		t.Errorf("mixed versions sequence test didn't fail")
+	}
+	seq1, _ := NewSequence([]FrameHash{v1}, 0)
+	seq2, _ := NewSequence([]FrameHash{v2}, 0)
+	if _, err := SequenceSimilarity(seq1, seq2); err == nil {
+		t.Errorf("sequence similarity versions test didn't fail")
+	}
+	if _, err := AlignSequences(seq1, seq2, 8); err == nil {
+		t.Errorf("sequence alignment versions test didn't fail")
+	}
+
+	tile := func(h Hash) Tile { return Tile{Rect: image.Rect(0, 0, 8, 8), Hash: h} }
+	grid1 := GridHash{Algorithm: "dhash:8", Tiles: []Tile{tile(v1.Hash)}}
+	grid2 := GridHash{Algorithm: "dhash:8", Tiles: []Tile{tile(v2.Hash)}}
+	if _, err := MatchGrids(grid1, grid2, 8); err == nil {
+		t.Errorf("grid versions test didn't fail")
+	}
+}
+
+// Test encoding bare hashes with their version, and checking them
+func TestVersionEncoded(t *testing.T) {
+	src, _ := OpenImg("./testdata/lena_256.png")
+	hash, _ := Dhash(src, 8)
+	stored := EncodeVersioned(DhashVersion, hash)
+	if version, res, err := DecodeVersioned(stored); err != nil || version != DhashVersion || !bytes.Equal(res, hash) {
+		t.Errorf("versioned hash test [%d %x] failed: [%v %d %x]", DhashVersion, hash, err, version, res)
+	}
+
+	a, b, err := CheckVersioned("dhash", stored, EncodeVersioned(DhashVersion, hash))
+	if err != nil || GetDistance(a, b) != 0 {
+		t.Errorf("same version check test [0] failed: [%v]", err)
+	}
+	_, _, err = CheckVersioned("dhash", stored, EncodeVersioned(DhashVersion+1, hash))
+	if verr, ok := err.(*VersionError); !ok || verr.A != DhashVersion || verr.B != DhashVersion+1 {
+		t.Errorf("version mismatch check test [%d %d] failed: [%v]", DhashVersion, DhashVersion+1, err)
+	}
+	if _, _, err := DecodeVersioned(nil); err == nil {
+		t.Errorf("empty versioned hash test didn't fail")
+	}
+	if _, _, err := CheckVersioned("dhash", stored, []byte{0x80}); err == nil {
+		t.Errorf("truncated version prefix test didn't fail")
+	}
+}
@@ -0,0 +1,188 @@
+/*
+
+Hashes short video clips read from YUV4MPEG2 streams, for near-duplicate
+detection, and aligns the clips in time.
+
+Frames are sampled at a regular interval and hashed with any Hasher. The
+sampled frames are then grouped into scenes: a sampled frame further than
+a distance from the first frame of the current scene starts a new one.
+The result is a Sequence with one keyframe per scene, which lasts as long
+as its scene.
+
+Two clips are compared by aligning their keyframes with the longest common
+subsequence of keyframes within a distance of each other, so that a clip
+with scenes cut, added or re-timed still matches the scenes it shares, in
+the same order, with the other one.
+
+Usage:
+  seq1,err := imagehash.HashVideo(file1, hasher, imagehash.VideoOptions{})
+  seq2,err := imagehash.HashVideo(file2, hasher, imagehash.VideoOptions{})
+  sim,err := imagehash.ClipSimilarity(seq1, seq2, 16)
+
+*/
+
+package imagehash
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// VideoOptions configures HashVideo. Zero values are replaced by defaults.
+type VideoOptions struct {
+	SampleInterval time.Duration // Time between the hashed frames; 200ms if zero, every frame if shorter than a frame
+	SceneDistance  int           // Distance in bits from the first frame of a scene which starts a new one; Bits()/8 if zero
+}
+
+// KeyframeMatch is a pair of keyframes aligned by AlignSequences.
+type KeyframeMatch struct {
+	A, B     int // Indexes of the keyframes in the two sequences
+	Distance int // Number of differing bits
+}
+
+// HashVideo returns the sequence of the scenes of a YUV4MPEG2 stream.
+func HashVideo(r io.Reader, hasher Hasher, opts VideoOptions) (Sequence, error) {
+	return HashVideoContext(context.Background(), r, hasher, opts)
+}
+
+// HashVideoContext is the same as HashVideo, but returns ctx.Err() as soon
+// as the context is done.
+func HashVideoContext(ctx context.Context, r io.Reader, hasher Hasher, opts VideoOptions) (Sequence, error) {
+	if opts.SampleInterval == 0 {
+		opts.SampleInterval = 200 * time.Millisecond
+	}
+	if opts.SceneDistance == 0 {
+		opts.SceneDistance = hasher.Bits() / 8
+	}
+
+	y4m, err := NewY4MReader(r)
+	if err != nil {
+		return Sequence{}, err
+	}
+	frameDuration := y4m.FrameDuration()
+	step := 1
+	if frameDuration > 0 && opts.SampleInterval > frameDuration {
+		step = int((opts.SampleInterval + frameDuration/2) / frameDuration)
+	}
+
+	// Every sampled frame lasts until the next one
+	var hashes []FrameHash
+	for i := 0; ; i++ {
+		if err := ctx.Err(); err != nil {
+			return Sequence{}, err
+		}
+		if i%step != 0 {
+			if err := y4m.Skip(); err == io.EOF {
+				break
+			} else if err != nil {
+				return Sequence{}, err
+			}
+			hashes[len(hashes)-1].Delay += frameDuration
+			continue
+		}
+
+		frame, err := y4m.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Sequence{}, err
+		}
+		h, err := HashContext(ctx, hasher, frame)
+		if err != nil {
+			return Sequence{}, err
+		}
+		hashes = append(hashes, FrameHash{Hash: h, Delay: frameDuration})
+	}
+
+	if len(hashes) == 0 {
+		return Sequence{}, errors.New("y4m: stream without frames")
+	}
+	return NewSequence(hashes, opts.SceneDistance)
+}
+
+// AlignSequences returns the longest common subsequence of the keyframes
+// of two sequences, where two keyframes are the same if they are within
+// 'maxDistance' bits of each other. The matches are in the order of the
+// sequences.
+func AlignSequences(a, b Sequence, maxDistance int) ([]KeyframeMatch, error) {
+	if a.Algorithm != b.Algorithm {
+		return nil, errors.New("cannot align a " + a.Algorithm + " sequence with a " + b.Algorithm + " sequence")
+	}
+	if err := checkSequenceVersions(a, b); err != nil {
+		return nil, err
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	n, m := len(a.Keyframes), len(b.Keyframes)
+	dist := make([][]int, n)
+	lcs := make([][]int, n+1)
+	lcs[n] = make([]int, m+1)
+	for i := n - 1; i >= 0; i-- {
+		dist[i] = make([]int, m)
+		lcs[i] = make([]int, m+1)
+		for j := m - 1; j >= 0; j-- {
+			dist[i][j] = GetBitDistance(a.Keyframes[i].Hash.Value, b.Keyframes[j].Hash.Value)
+			switch {
+			case dist[i][j] <= maxDistance:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var matches []KeyframeMatch
+	for i, j := 0, 0; i < n && j < m; {
+		switch {
+		case dist[i][j] <= maxDistance:
+			matches = append(matches, KeyframeMatch{i, j, dist[i][j]})
+			i, j = i+1, j+1
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return matches, nil
+}
+
+// ClipSimilarity returns the fraction of the duration of two sequences
+// covered by the keyframes AlignSequences matches, averaged over the two
+// sequences, between 0 and 1. When the keyframes of a sequence have no
+// delays, they are all counted as lasting as long.
+func ClipSimilarity(a, b Sequence, maxDistance int) (float64, error) {
+	if len(a.Keyframes) == 0 || len(b.Keyframes) == 0 {
+		return 0, errors.New("cannot compare empty sequences")
+	}
+	matches, err := AlignSequences(a, b, maxDistance)
+	if err != nil {
+		return 0, err
+	}
+
+	aIndexes, bIndexes := make([]int, len(matches)), make([]int, len(matches))
+	for i, m := range matches {
+		aIndexes[i], bIndexes[i] = m.A, m.B
+	}
+	return (coveredFraction(a, aIndexes) + coveredFraction(b, bIndexes)) / 2, nil
+}
+
+// coveredFraction returns the fraction of the duration of a sequence taken
+// by some of its keyframes.
+func coveredFraction(s Sequence, keyframes []int) float64 {
+	total := s.Duration()
+	if total == 0 {
+		return float64(len(keyframes)) / float64(len(s.Keyframes))
+	}
+
+	var covered time.Duration
+	for _, i := range keyframes {
+		covered += s.Keyframes[i].Delay
+	}
+	return float64(covered) / float64(total)
+}
@@ -0,0 +1,145 @@
+/*
+
+Testing suite for hashing and aligning video clips.
+
+1. Test that the scenes of a clip become its keyframes
+2. Test that a clip at another frame rate is identical
+3. Test aligning clips with a cut, reordered and different scenes
+4. Test that hashing a clip stops on a cancelled context
+5. Test invalid clips and sequences
+
+*/
+
+package imagehash
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// testScenes returns three scenes of 'frames' frames each, slowly panning
+// over different images.
+func testScenes(t *testing.T, frames int) [][]image.Image {
+	var scenes [][]image.Image
+	for _, name := range []string{"lena_512", "rand_512", "lena_inverted_512"} {
+		src, err := OpenImg("./testdata/" + name + ".png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var scene []image.Image
+		for i := 0; i < frames; i++ {
+			crop := imaging.Crop(src, image.Rect(i*4/frames, 0, 256+i*4/frames, 256))
+			scene = append(scene, imaging.Resize(crop, 64, 64, imaging.Box))
+		}
+		scenes = append(scenes, scene)
+	}
+	return scenes
+}
+
+// clip encodes scenes as a Y4M stream, and hashes it with dhash:8.
+func clip(t *testing.T, scenes [][]image.Image, fps int, opts VideoOptions) Sequence {
+	var frames []image.Image
+	for _, scene := range scenes {
+		frames = append(frames, scene...)
+	}
+	hasher, _ := NewHasher("dhash:8")
+	seq, err := HashVideo(bytes.NewReader(encodeY4M(frames, fps)), hasher, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return seq
+}
+
+// Test that every scene is a keyframe, lasting as long as the scene
+func TestHashVideo(t *testing.T) {
+	scenes := testScenes(t, 10)
+
+	for _, interval := range []time.Duration{time.Millisecond, 0, 500 * time.Millisecond} {
+		seq := clip(t, scenes, 10, VideoOptions{SampleInterval: interval})
+		if len(seq.Keyframes) != 3 || seq.Algorithm != "dhash:8" {
+			t.Errorf("%v keyframes test [3] failed: [%d]", interval, len(seq.Keyframes))
+			continue
+		}
+		for i, k := range seq.Keyframes {
+			if k.Delay != time.Second {
+				t.Errorf("%v keyframe %d duration test [1s] failed: [%v]", interval, i, k.Delay)
+			}
+		}
+	}
+}
+
+// Test that the frame rate of a clip doesn't change its keyframes
+func TestHashVideoFrameRate(t *testing.T) {
+	seq10 := clip(t, testScenes(t, 10), 10, VideoOptions{})
+	seq25 := clip(t, testScenes(t, 25), 25, VideoOptions{})
+
+	if len(seq25.Keyframes) != 3 || seq25.Duration() != 3*time.Second {
+		t.Errorf("25 fps keyframes test [3 3s] failed: [%d %v]", len(seq25.Keyframes), seq25.Duration())
+	}
+	if sim, err := ClipSimilarity(seq10, seq25, 16); err != nil || sim != 1 {
+		t.Errorf("frame rate similarity test [1] failed: [%v %f]", err, sim)
+	}
+}
+
+// Test aligning a clip with edited copies of it, and another clip
+func TestAlignClips(t *testing.T) {
+	scenes := testScenes(t, 10)
+	original := clip(t, scenes, 10, VideoOptions{})
+	cut := clip(t, [][]image.Image{scenes[0], scenes[2]}, 10, VideoOptions{})
+	reordered := clip(t, [][]image.Image{scenes[2], scenes[0], scenes[1]}, 10, VideoOptions{})
+
+	matches, err := AlignSequences(original, cut, 16)
+	if err != nil || len(matches) != 2 || matches[0].A != 0 || matches[0].B != 0 ||
+		matches[1].A != 2 || matches[1].B != 1 {
+		t.Errorf("cut alignment test [0-0 2-1] failed: [%v %v]", err, matches)
+	}
+	if sim, _ := ClipSimilarity(original, cut, 16); sim < 0.83 || sim > 0.84 {
+		t.Errorf("cut similarity test [0.83] failed: [%f]", sim)
+	}
+
+	// Only two of the scenes are in the same order
+	if matches, _ := AlignSequences(original, reordered, 16); len(matches) != 2 {
+		t.Errorf("reordered alignment test [2] failed: [%v]", matches)
+	}
+
+	white, _ := OpenImg("./testdata/white_512.png")
+	other := clip(t, [][]image.Image{{imaging.Resize(white, 64, 64, imaging.Box)}}, 10, VideoOptions{})
+	if sim, _ := ClipSimilarity(original, other, 16); sim != 0 {
+		t.Errorf("other clip similarity test [0] failed: [%f]", sim)
+	}
+}
+
+// Test that hashing a clip stops on a cancelled context
+func TestHashVideoCancelled(t *testing.T) {
+	scenes := testScenes(t, 2)
+	hasher, _ := NewHasher("dhash:8")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := HashVideoContext(ctx, bytes.NewReader(encodeY4M(scenes[0], 10)), hasher, VideoOptions{})
+	if err != context.Canceled {
+		t.Errorf("cancelled video test [%v] failed: [%v]", context.Canceled, err)
+	}
+}
+
+// Test empty streams, and sequences of different hashers
+func TestVideoInvalid(t *testing.T) {
+	hasher, _ := NewHasher("dhash:8")
+	if _, err := HashVideo(bytes.NewReader([]byte("YUV4MPEG2 W4 H4\n")), hasher, VideoOptions{}); err == nil {
+		t.Errorf("empty stream test didn't fail")
+	}
+
+	seq := clip(t, testScenes(t, 2), 10, VideoOptions{})
+	other := Sequence{Algorithm: "ahash:8", Keyframes: []FrameHash{{Hash: Hash{Algorithm: "ahash:8"}}}}
+	if _, err := AlignSequences(seq, other, 16); err == nil {
+		t.Errorf("mixed alignment test didn't fail")
+	}
+	if _, err := ClipSimilarity(seq, Sequence{Algorithm: "dhash:8"}, 16); err == nil {
+		t.Errorf("empty similarity test didn't fail")
+	}
+}
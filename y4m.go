@@ -0,0 +1,223 @@
+/*
+
+Reads the frames of uncompressed YUV4MPEG2 (.y4m) video streams, which
+ffmpeg writes without any codec:
+  ffmpeg -i clip.mp4 -pix_fmt yuv420p clip.y4m
+
+A stream is a header line, "YUV4MPEG2" followed by parameters such as the
+width (W), the height (H), the frame rate (F) and the colorspace (C), then
+frames which are each a "FRAME" line followed by the raw planes of the
+frame. The 8-bit colorspaces are supported: 420 (and its 420jpeg, 420mpeg2
+and 420paldv siting variants, which only differ by where the chroma samples
+are), 422, 444, 444alpha and mono.
+
+Usage:
+  y4m,err := imagehash.NewY4MReader(file)
+  for {
+      frame,err := y4m.Next()
+      if err == io.EOF {
+          break
+      }
+      ...
+  }
+
+*/
+
+package imagehash
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// y4mMagic starts the header of every stream.
+const y4mMagic = "YUV4MPEG2"
+
+// maxY4MLine is the longest header or frame line read.
+const maxY4MLine = 4096
+
+// maxY4MPixels is the largest width times height of a frame.
+const maxY4MPixels = 1 << 28
+
+// Y4MReader reads the frames of a YUV4MPEG2 stream.
+type Y4MReader struct {
+	Width, Height    int
+	RateNum, RateDen int    // Frame rate, as frames per second RateNum/RateDen
+	Colorspace       string // Such as "420jpeg", "444" or "mono"
+
+	r         *bufio.Reader
+	frameSize int64 // Size of the planes of a frame, in bytes
+}
+
+// NewY4MReader reads the header of a stream.
+func NewY4MReader(r io.Reader) (*Y4MReader, error) {
+	y := &Y4MReader{r: bufio.NewReader(r), RateNum: 25, RateDen: 1, Colorspace: "420jpeg"}
+
+	line, err := y.readLine()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != y4mMagic {
+		return nil, errors.New("y4m: not a YUV4MPEG2 stream")
+	}
+
+	for _, field := range fields[1:] {
+		value := field[1:]
+		switch field[0] {
+		case 'W':
+			y.Width, err = strconv.Atoi(value)
+		case 'H':
+			y.Height, err = strconv.Atoi(value)
+		case 'F':
+			y.RateNum, y.RateDen, err = parseY4MRatio(value)
+		case 'C':
+			y.Colorspace = value
+		}
+		if err != nil {
+			return nil, errors.New("y4m: invalid header parameter " + field)
+		}
+	}
+	if y.Width <= 0 || y.Height <= 0 {
+		return nil, errors.New("y4m: missing or invalid width and height")
+	}
+	if int64(y.Width)*int64(y.Height) > maxY4MPixels {
+		return nil, errors.New("y4m: " + strconv.Itoa(y.Width) + "x" + strconv.Itoa(y.Height) + " frames are too large")
+	}
+	if y.RateNum <= 0 || y.RateDen <= 0 {
+		return nil, errors.New("y4m: invalid frame rate " + strconv.Itoa(y.RateNum) + ":" + strconv.Itoa(y.RateDen))
+	}
+
+	luma := int64(y.Width) * int64(y.Height)
+	cw, ch := int64((y.Width+1)/2), int64((y.Height+1)/2)
+	switch y.Colorspace {
+	case "420", "420jpeg", "420mpeg2", "420paldv":
+		y.frameSize = luma + 2*cw*ch
+	case "422":
+		y.frameSize = luma + 2*cw*int64(y.Height)
+	case "444":
+		y.frameSize = 3 * luma
+	case "444alpha":
+		y.frameSize = 4 * luma
+	case "mono":
+		y.frameSize = luma
+	default:
+		return nil, errors.New("y4m: unsupported colorspace " + y.Colorspace)
+	}
+	return y, nil
+}
+
+// FrameDuration returns the time every frame is displayed for.
+func (y *Y4MReader) FrameDuration() time.Duration {
+	return time.Duration(int64(time.Second) * int64(y.RateDen) / int64(y.RateNum))
+}
+
+// Next reads the next frame, as an *image.YCbCr, an *image.NYCbCrA for
+// 444alpha, or an *image.Gray for mono. It returns io.EOF after the last
+// frame, and io.ErrUnexpectedEOF for a truncated one.
+func (y *Y4MReader) Next() (image.Image, error) {
+	if err := y.readFrameLine(); err != nil {
+		return nil, err
+	}
+
+	var img image.Image
+	var planes [][]byte
+	rect := image.Rect(0, 0, y.Width, y.Height)
+	switch y.Colorspace {
+	case "mono":
+		gray := image.NewGray(rect)
+		img, planes = gray, [][]byte{gray.Pix}
+	case "444alpha":
+		yuva := image.NewNYCbCrA(rect, image.YCbCrSubsampleRatio444)
+		img, planes = yuva, [][]byte{yuva.Y, yuva.Cb, yuva.Cr, yuva.A}
+	default:
+		ratio := image.YCbCrSubsampleRatio420
+		switch y.Colorspace {
+		case "422":
+			ratio = image.YCbCrSubsampleRatio422
+		case "444":
+			ratio = image.YCbCrSubsampleRatio444
+		}
+		yuv := image.NewYCbCr(rect, ratio)
+		img, planes = yuv, [][]byte{yuv.Y, yuv.Cb, yuv.Cr}
+	}
+
+	for _, plane := range planes {
+		if _, err := io.ReadFull(y.r, plane); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+// Skip skips the next frame without decoding it. It returns io.EOF after
+// the last frame.
+func (y *Y4MReader) Skip() error {
+	if err := y.readFrameLine(); err != nil {
+		return err
+	}
+	n, err := io.CopyN(ioutil.Discard, y.r, y.frameSize)
+	if n < y.frameSize && err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readFrameLine reads the "FRAME" line starting a frame.
+func (y *Y4MReader) readFrameLine() error {
+	line, err := y.readLine()
+	if err != nil {
+		if err == io.EOF && line != "" {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if line != "FRAME" && !strings.HasPrefix(line, "FRAME ") {
+		return errors.New("y4m: expected a FRAME line, but found '" + line + "'")
+	}
+	return nil
+}
+
+// readLine reads a line, without its newline.
+func (y *Y4MReader) readLine() (string, error) {
+	var line []byte
+	for {
+		b, err := y.r.ReadByte()
+		if err != nil {
+			return string(line), err
+		}
+		if b == '\n' {
+			return string(line), nil
+		}
+		if len(line) == maxY4MLine {
+			return "", errors.New("y4m: line longer than " + strconv.Itoa(maxY4MLine) + " bytes")
+		}
+		line = append(line, b)
+	}
+}
+
+// parseY4MRatio parses a ratio such as "30000:1001".
+func parseY4MRatio(s string) (int, int, error) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return 0, 0, errors.New("missing colon")
+	}
+	num, err := strconv.Atoi(s[:i])
+	if err != nil {
+		return 0, 0, err
+	}
+	den, err := strconv.Atoi(s[i+1:])
+	return num, den, err
+}
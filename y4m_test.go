@@ -0,0 +1,198 @@
+/*
+
+Testing suite for reading YUV4MPEG2 streams.
+
+1. Test reading the header and frames of a 4:2:0 stream
+2. Test the images of the other colorspaces
+3. Test skipping frames
+4. Test invalid and truncated streams
+
+*/
+
+package imagehash
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// encodeY4M encodes frames as a 4:2:0 YUV4MPEG2 stream, at 'fps' frames
+// per second. The chroma of every 2x2 block is the one of its top left
+// pixel.
+func encodeY4M(frames []image.Image, fps int) []byte {
+	var buf bytes.Buffer
+	b := frames[0].Bounds()
+	buf.WriteString("YUV4MPEG2 W" + strconv.Itoa(b.Dx()) + " H" + strconv.Itoa(b.Dy()) +
+		" F" + strconv.Itoa(fps) + ":1 Ip A1:1 C420jpeg\n")
+	for _, frame := range frames {
+		yuv := image.NewYCbCr(b.Sub(b.Min), image.YCbCrSubsampleRatio420)
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < b.Dx(); x++ {
+				r, g, bl, _ := frame.At(b.Min.X+x, b.Min.Y+y).RGBA()
+				yy, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(bl>>8))
+				yuv.Y[yuv.YOffset(x, y)] = yy
+				if x%2 == 0 && y%2 == 0 {
+					yuv.Cb[yuv.COffset(x, y)], yuv.Cr[yuv.COffset(x, y)] = cb, cr
+				}
+			}
+		}
+		buf.WriteString("FRAME\n")
+		buf.Write(yuv.Y)
+		buf.Write(yuv.Cb)
+		buf.Write(yuv.Cr)
+	}
+	return buf.Bytes()
+}
+
+// Test the header and the pixels of a 4:2:0 stream
+func TestY4MReader(t *testing.T) {
+	red := fill(image.Rect(0, 0, 6, 5), color.RGBA{200, 30, 40, 255})
+	blue := fill(image.Rect(0, 0, 6, 5), color.RGBA{20, 40, 220, 255})
+	y4m, err := NewY4MReader(bytes.NewReader(encodeY4M([]image.Image{red, blue}, 30)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if y4m.Width != 6 || y4m.Height != 5 || y4m.RateNum != 30 || y4m.RateDen != 1 || y4m.Colorspace != "420jpeg" {
+		t.Errorf("y4m header test [6 5 30 1 420jpeg] failed: [%d %d %d %d %s]", y4m.Width, y4m.Height,
+			y4m.RateNum, y4m.RateDen, y4m.Colorspace)
+	}
+	if y4m.FrameDuration() != time.Second/30 {
+		t.Errorf("y4m frame duration test [%v] failed: [%v]", time.Second/30, y4m.FrameDuration())
+	}
+
+	for _, exp := range []color.RGBA{{200, 30, 40, 255}, {20, 40, 220, 255}} {
+		frame, err := y4m.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := frame.(*image.YCbCr); !ok || frame.Bounds() != image.Rect(0, 0, 6, 5) {
+			t.Fatalf("y4m frame test [*image.YCbCr 6x5] failed: [%T %v]", frame, frame.Bounds())
+		}
+		r, g, b, _ := frame.At(5, 4).RGBA()
+		if absDiff(r>>8, uint32(exp.R)) > 2 || absDiff(g>>8, uint32(exp.G)) > 2 || absDiff(b>>8, uint32(exp.B)) > 2 {
+			t.Errorf("y4m pixel test [%v] failed: [%d %d %d]", exp, r>>8, g>>8, b>>8)
+		}
+	}
+	if _, err := y4m.Next(); err != io.EOF {
+		t.Errorf("y4m end test [%v] failed: [%v]", io.EOF, err)
+	}
+}
+
+// absDiff returns the absolute difference of two numbers.
+func absDiff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// Test the image types and sizes of the other colorspaces
+func TestY4MColorspaces(t *testing.T) {
+	tests := []struct {
+		colorspace string
+		planes     int // Bytes of a 4x3 frame
+		check      func(image.Image) bool
+	}{
+		{"mono", 12, func(img image.Image) bool { _, ok := img.(*image.Gray); return ok }},
+		{"422", 12 + 2*6, func(img image.Image) bool {
+			yuv, ok := img.(*image.YCbCr)
+			return ok && yuv.SubsampleRatio == image.YCbCrSubsampleRatio422
+		}},
+		{"444", 3 * 12, func(img image.Image) bool {
+			yuv, ok := img.(*image.YCbCr)
+			return ok && yuv.SubsampleRatio == image.YCbCrSubsampleRatio444
+		}},
+		{"444alpha", 4 * 12, func(img image.Image) bool { _, ok := img.(*image.NYCbCrA); return ok }},
+		{"420mpeg2", 12 + 2*4, func(img image.Image) bool {
+			yuv, ok := img.(*image.YCbCr)
+			return ok && yuv.SubsampleRatio == image.YCbCrSubsampleRatio420
+		}},
+	}
+	for _, test := range tests {
+		stream := "YUV4MPEG2 W4 H3 F25:1 C" + test.colorspace + "\nFRAME\n" + strings.Repeat("\x80", test.planes)
+		y4m, err := NewY4MReader(strings.NewReader(stream))
+		if err != nil {
+			t.Errorf("%s header test failed: [%v]", test.colorspace, err)
+			continue
+		}
+		frame, err := y4m.Next()
+		if err != nil || !test.check(frame) {
+			t.Errorf("%s frame test failed: [%v %T]", test.colorspace, err, frame)
+			continue
+		}
+		if _, err := y4m.Next(); err != io.EOF {
+			t.Errorf("%s frame size test [%v] failed: [%v]", test.colorspace, io.EOF, err)
+		}
+	}
+}
+
+// Test that skipped frames aren't decoded, but are still consumed
+func TestY4MSkip(t *testing.T) {
+	frames := []image.Image{
+		fill(image.Rect(0, 0, 4, 4), color.Black),
+		fill(image.Rect(0, 0, 4, 4), color.Black),
+		fill(image.Rect(0, 0, 4, 4), color.White),
+	}
+	y4m, _ := NewY4MReader(bytes.NewReader(encodeY4M(frames, 25)))
+	if err := y4m.Skip(); err != nil {
+		t.Fatal(err)
+	}
+	if err := y4m.Skip(); err != nil {
+		t.Fatal(err)
+	}
+	frame, err := y4m.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _, _ := frame.At(1, 1).RGBA(); r>>8 < 250 {
+		t.Errorf("skip test [255] failed: [%d]", r>>8)
+	}
+	if err := y4m.Skip(); err != io.EOF {
+		t.Errorf("skip end test [%v] failed: [%v]", io.EOF, err)
+	}
+}
+
+// Test invalid headers, and truncated frames
+func TestY4MInvalid(t *testing.T) {
+	headers := []string{
+		"",
+		"RIFF W4 H4\n",
+		"YUV4MPEG2 H4\n",
+		"YUV4MPEG2 W4 H4 C420p10\n",
+		"YUV4MPEG2 W4 H4 F0:1\n",
+		"YUV4MPEG2 W4 H4 F25\n",
+		"YUV4MPEG2 Wx H4\n",
+		"YUV4MPEG2 W100000 H100000\n",
+		"YUV4MPEG2 W4 H4",
+	}
+	for _, header := range headers {
+		if _, err := NewY4MReader(strings.NewReader(header)); err == nil {
+			t.Errorf("invalid header %q test didn't fail", header)
+		}
+	}
+
+	streams := map[string]error{
+		"YUV4MPEG2 W4 H4 Cmono\nFRAME\n" + strings.Repeat("\x00", 10): io.ErrUnexpectedEOF,
+		"YUV4MPEG2 W4 H4 Cmono\nFRA":                                  io.ErrUnexpectedEOF,
+	}
+	for stream, exp := range streams {
+		y4m, err := NewY4MReader(strings.NewReader(stream))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := y4m.Next(); err != exp {
+			t.Errorf("truncated stream %q test [%v] failed: [%v]", stream, exp, err)
+		}
+	}
+
+	y4m, _ := NewY4MReader(strings.NewReader("YUV4MPEG2 W4 H4 Cmono\nFRAMES\n"))
+	if _, err := y4m.Next(); err == nil {
+		t.Errorf("invalid frame line test didn't fail")
+	}
+}